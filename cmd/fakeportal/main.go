@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"xjtuportal/component/fakeportal"
+)
+
+func main() {
+
+	listenFlag := flag.String("listen", "127.0.0.1:8080", "Listen address of the fake portal")
+	usernameFlag := flag.String("username", "3120123456@xjtu", "Accepted username with domain")
+	passwordFlag := flag.String("password", "zhangsan123456", "Accepted password")
+	macFlag := flag.String("mac", "00:11:22:33:44:55", "MAC address of the client machine")
+	ipFlag := flag.String("ip", "10.181.0.100", "IP address of the client machine")
+	concurrencyFlag := flag.Int("concurrency", 2, "Max number of concurrent sessions")
+	errorFlag := flag.Int("error", 0, "Force login to fail with given status code (21, 24, 27, 33, 36, 39, 43, 46, 49, 60)")
+	sessionsFlag := flag.Int("sessions", 0, "Number of sessions of other devices online at startup")
+	smtpFlag := flag.String("smtp", "", "Listen address of the fake SMTP relay receiving notifications, disabled if empty")
+
+	flag.Parse()
+
+	fakePortal := fakeportal.InitFakePortal(*usernameFlag, *passwordFlag, *macFlag, *ipFlag, *concurrencyFlag)
+	fakePortal.SetLoginError(*errorFlag)
+	for i := 0; i < *sessionsFlag; i++ {
+		fakePortal.Backend.AddSession(fmt.Sprintf("00:00:5e:00:53:%02x", i+1), fmt.Sprintf("10.181.1.%d", i+1))
+	}
+
+	fakePortal.OnWebhook(func(body string) {
+		fmt.Printf("Webhook received:\n%s\n", strings.TrimSpace(body))
+	})
+	if *smtpFlag != "" {
+		smtpServer, err := fakeportal.StartSmtpServer(*smtpFlag)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer smtpServer.Close()
+		smtpServer.OnMessage(func(message *fakeportal.MailMessage) {
+			fmt.Printf("Mail received from %s to %s:\n%s\n", message.From, strings.Join(message.To, ", "),
+				strings.TrimSpace(message.Data))
+		})
+		fmt.Printf("Fake SMTP relay listening on %s\n", smtpServer.Addr())
+	}
+
+	serverUrl := fmt.Sprintf("http://%s", *listenFlag)
+	fmt.Printf("Fake portal listening on %s, point program-settings.yaml to it:\n", serverUrl)
+	fmt.Printf("  connectivity.http.internet: %s%s\n", serverUrl, fakeportal.InternetPath)
+	fmt.Printf("  connectivity.http.intranet: %s%s\n", serverUrl, fakeportal.PortalPath)
+	fmt.Printf("  online.bootstrap_url: %s%s\n", serverUrl, fakeportal.InternetPath)
+	fmt.Printf("  online.portal_server.hostname: %s\n", *listenFlag)
+	fmt.Printf("  session.portal_server.hostname: %s\n", serverUrl)
+	fmt.Printf("  session.speed_check_server.hostname: %s\n", serverUrl)
+	fmt.Printf("Notification webhook: %s%s\n", serverUrl, fakeportal.NotifyPath)
+
+	if err := http.ListenAndServe(*listenFlag, fakePortal); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,320 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	stdhttp "net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/http"
+)
+
+const (
+	defaultDaemonInterval   = 60
+	defaultDaemonMaxBackoff = 600
+)
+
+// daemonModules are replaced as a whole when config is reloaded
+type daemonModules struct {
+	loggerHelper        *basic.LoggerHelper
+	connectivityChecker http.HttpChecker
+	dnsChecker          http.DnsChecker // Nil if metrics are not served, DNS servers are only checked for metrics
+	portal              *PortalShellHelper
+	intruderDetector    *IntruderDetector // Nil if intruder detection is disabled
+
+	interval   time.Duration
+	maxBackoff time.Duration
+}
+
+type DaemonHelper struct {
+	modules       atomic.Value // *daemonModules
+	roundMutex    sync.Mutex   // Held while a round of check uses the modules
+	metricsListen string
+	stopChan      chan os.Signal
+	waitFunc      func(ctx context.Context, duration time.Duration) bool
+}
+
+func InitDaemonHelper(
+	configHelper *basic.ConfigHelper,
+	loggerHelper *basic.LoggerHelper,
+	connectivityChecker http.HttpChecker,
+	portal *PortalShellHelper,
+) (*DaemonHelper, error) {
+
+	if configHelper == nil {
+		err := errors.New("app/daemon: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("app/daemon: logger is invalid")
+		return nil, err
+	}
+
+	if connectivityChecker == nil {
+		err := errors.New("app/daemon: connectivityChecker is invalid")
+		return nil, err
+	}
+
+	if portal == nil {
+		err := errors.New("app/daemon: PortalShellHelper is invalid")
+		return nil, err
+	}
+
+	userDaemonSettings := &configHelper.UserSettings.UserAppSettings.UserDaemonSettings
+
+	interval := userDaemonSettings.Interval
+	if interval <= 0 {
+		interval = defaultDaemonInterval
+	}
+	maxBackoff := userDaemonSettings.MaxBackoff
+	if maxBackoff < interval {
+		maxBackoff = defaultDaemonMaxBackoff
+		if maxBackoff < interval {
+			maxBackoff = interval
+		}
+	}
+
+	var intruderDetector *IntruderDetector
+	if configHelper.UserSettings.UserAppSettings.UserIntruderSettings.Enabled {
+		var err error
+		if intruderDetector, err = InitIntruderDetector(configHelper, loggerHelper, portal); err != nil {
+			return nil, err
+		}
+	}
+
+	daemonHelper := &DaemonHelper{
+		metricsListen: configHelper.UserSettings.UserUISettings.UserMetricsSettings.Listen,
+		stopChan:      make(chan os.Signal, 1),
+	}
+	daemonHelper.waitFunc = daemonHelper.wait
+	modules := &daemonModules{
+		loggerHelper:        loggerHelper,
+		connectivityChecker: connectivityChecker,
+		portal:              portal,
+		intruderDetector:    intruderDetector,
+		interval:            time.Duration(interval) * time.Second,
+		maxBackoff:          time.Duration(maxBackoff) * time.Second,
+	}
+	if dnsChecker, ok := connectivityChecker.(http.DnsChecker); ok && daemonHelper.metricsListen != "" {
+		modules.dnsChecker = dnsChecker
+	}
+	daemonHelper.modules.Store(modules)
+
+	return daemonHelper, nil
+}
+
+func (daemon *DaemonHelper) current() *daemonModules {
+	return daemon.modules.Load().(*daemonModules)
+}
+
+// Reload replaces modules and settings with those of newDaemon built from the reloaded config.
+// The running round of check is not affected, the new ones are used from the next round. Reload returns after the
+// running round, so the former modules are no longer used and can be released.
+func (daemon *DaemonHelper) Reload(newDaemon *DaemonHelper) {
+	daemon.roundMutex.Lock()
+	defer daemon.roundMutex.Unlock()
+	modules := newDaemon.current()
+	if newDaemon.metricsListen != daemon.metricsListen {
+		modules.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+			"app/daemon: Metrics listen address changed to [%s], restart to apply", newDaemon.metricsListen))
+	}
+	if former := daemon.current().intruderDetector; former != nil && modules.intruderDetector != nil {
+		modules.intruderDetector.Inherit(former)
+	}
+	modules.portal.notifierHelper.Inherit(daemon.current().portal.notifierHelper)
+	modules.portal.hookRunner.Inherit(daemon.current().portal.hookRunner)
+	daemon.modules.Store(modules)
+	modules.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+		"app/daemon: Config reloaded, check interval [%v], max backoff [%v]", modules.interval, modules.maxBackoff))
+}
+
+// wait blocks for the given duration, returns false if the daemon is stopped
+func (daemon *DaemonHelper) wait(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// SetWait replaces how the daemon waits between checks, e.g. to record the intervals and backoff in tests.
+// wait returns false to stop the daemon.
+func (daemon *DaemonHelper) SetWait(wait func(ctx context.Context, duration time.Duration) bool) {
+	daemon.waitFunc = wait
+}
+
+// nextBackoff doubles the current backoff and caps it with the max backoff
+func (modules *daemonModules) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff < modules.interval {
+		return modules.interval
+	}
+	backoff *= 2
+	if backoff > modules.maxBackoff {
+		backoff = modules.maxBackoff
+	}
+	return backoff
+}
+
+// check runs a round of connectivity check, returns if the portal server is reachable
+func (modules *daemonModules) check(ctx context.Context) (portalReachable bool) {
+
+	statusCode, err := modules.connectivityChecker.InternetHttpCheck(ctx)
+	if err == nil { // Currently Internet is available
+		modules.loggerHelper.AddLog(basic.DEBUG, fmt.Sprintf("app/daemon: Internet available [%d]", statusCode))
+		modules.portal.notifierHelper.Recovered()
+		modules.observe(ctx, true, "Internet available")
+		return true
+	}
+	modules.loggerHelper.AddLog(basic.INFO, fmt.Sprintf("app/daemon: Internet check failed [%v]", err))
+
+	_, err = modules.connectivityChecker.IntranetHttpCheck(ctx)
+	if err != nil { // Currently portal server is unavailable
+		modules.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/daemon: Portal server unreachable [%v]", err))
+		if ctx.Err() != nil { // Stopped, not an outage
+			return false
+		}
+		modules.portal.notifierHelper.Outage(&Notification{
+			Event:   basic.NotifyOutage,
+			Account: modules.portal.account(),
+			Message: fmt.Sprintf("Portal server unreachable [%v]", err),
+		}, false)
+		modules.observe(ctx, false, fmt.Sprintf("Portal server unreachable [%v]", err))
+		return false
+	}
+
+	modules.loggerHelper.AddLog(basic.WARNING, "app/daemon: Currently offline, try to login")
+	result := modules.portal.DoLogin(ctx)
+	if ctx.Err() == nil {
+		modules.observe(ctx, result.Success(), result.Message)
+	}
+	return true
+
+}
+
+// observe runs hooks of online state and IP address changes, IP address is fetched only if on_ip_change is set
+func (modules *daemonModules) observe(ctx context.Context, online bool, message string) {
+	hookRunner := modules.portal.hookRunner
+	hookRunner.ObserveOnline(online, &HookEvent{
+		Account: modules.portal.account(),
+		Message: message,
+	})
+	if !online || !hookRunner.Enabled(basic.HookIpChange) {
+		return
+	}
+	ip, err := modules.portal.CurrentIp(ctx)
+	if err != nil {
+		modules.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/daemon: Cannot get IP address of current session [%v]", err))
+		return
+	}
+	hookRunner.ObserveIp(ip, &HookEvent{Account: modules.portal.account()})
+}
+
+// checkDns checks DNS servers to update their availability and latency in metrics if metrics are served
+func (modules *daemonModules) checkDns(ctx context.Context) {
+	if modules.dnsChecker == nil {
+		return
+	}
+	intranetAvailable, intranetUnavailable := modules.dnsChecker.IntranetDnsCheck(ctx)
+	internetAvailable, internetUnavailable := modules.dnsChecker.InternetDnsCheck(ctx)
+	modules.loggerHelper.AddLog(basic.DEBUG, fmt.Sprintf("app/daemon: DNS servers available %v, unavailable %v",
+		append(intranetAvailable, internetAvailable...), append(intranetUnavailable, internetUnavailable...)))
+}
+
+// detectIntruders checks sessions of unknown devices if intruder detection is enabled and due
+func (modules *daemonModules) detectIntruders(ctx context.Context) {
+	if modules.intruderDetector == nil || !modules.intruderDetector.Due() {
+		return
+	}
+	_, _ = modules.intruderDetector.Check(ctx)
+}
+
+// serveMetrics serves metrics in background if a listen address is set
+func (daemon *DaemonHelper) serveMetrics() (server *stdhttp.Server) {
+
+	if daemon.metricsListen == "" {
+		return nil
+	}
+
+	mux := stdhttp.NewServeMux()
+	mux.Handle("/metrics", basic.Metrics)
+	server = &stdhttp.Server{Addr: daemon.metricsListen, Handler: mux}
+	go func() {
+		daemon.current().loggerHelper.AddLog(basic.INFO, fmt.Sprintf("app/daemon: Serving metrics on [%s]", daemon.metricsListen))
+		if err := server.ListenAndServe(); err != nil && err != stdhttp.ErrServerClosed {
+			daemon.current().loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("app/daemon: Metrics server error [%v]", err))
+		}
+	}()
+	return server
+}
+
+// Run keeps the machine online until SIGINT or SIGTERM is received
+func (daemon *DaemonHelper) Run() {
+
+	// The running check is aborted once a stop signal is received
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signal.Notify(daemon.stopChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(daemon.stopChan)
+	go func() {
+		select {
+		case sig := <-daemon.stopChan:
+			daemon.current().loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/daemon: Received signal [%v], stopping", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	daemon.RunContext(ctx)
+}
+
+// RunContext keeps the machine online until ctx is done, the running check is aborted then
+func (daemon *DaemonHelper) RunContext(ctx context.Context) {
+
+	if metricsServer := daemon.serveMetrics(); metricsServer != nil {
+		defer func() {
+			_ = metricsServer.Close()
+		}()
+	}
+
+	modules := daemon.current()
+	modules.loggerHelper.AddLog(basic.WARNING,
+		fmt.Sprintf("app/daemon: Daemon started, check interval [%v], max backoff [%v]", modules.interval, modules.maxBackoff))
+	if modules.intruderDetector != nil {
+		modules.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/daemon: Unknown devices are checked every [%v], auto logout [%v]",
+			modules.intruderDetector.interval, modules.intruderDetector.autoLogout))
+	}
+
+	backoff := time.Duration(0)
+	for {
+		// Modules are fixed in a round, config reloaded takes effect from the next round
+		daemon.roundMutex.Lock()
+		modules = daemon.current()
+		wait := modules.interval
+		if modules.check(ctx) {
+			backoff = 0
+			modules.detectIntruders(ctx)
+		} else {
+			backoff = modules.nextBackoff(backoff)
+			wait = backoff
+			modules.loggerHelper.AddLog(basic.INFO, fmt.Sprintf("app/daemon: Retry after [%v]", backoff))
+		}
+		modules.checkDns(ctx)
+		daemon.roundMutex.Unlock()
+		if !daemon.waitFunc(ctx, wait) {
+			break
+		}
+	}
+
+	daemon.current().loggerHelper.AddLog(basic.WARNING, "app/daemon: Daemon stopped")
+}
@@ -1,14 +1,69 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 	"xjtuportal/component/basic"
 	"xjtuportal/component/device"
 	"xjtuportal/component/http"
 )
 
+const (
+	defaultDiagnosisTimeout = 30 // Seconds
+)
+
+type CheckResult struct {
+	StatusCode int    `json:"status_code" yaml:"status_code"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type DnsCheckResult struct {
+	Available   []string `json:"available" yaml:"available"`
+	Unavailable []string `json:"unavailable" yaml:"unavailable"`
+}
+
+type ProxyCheckResult struct {
+	Proxy     string `json:"proxy" yaml:"proxy"`
+	Programs  string `json:"programs" yaml:"programs"`
+	Available bool   `json:"available" yaml:"available"`
+}
+
+// DiagnosisReport records results of every check in a diagnosis
+type DiagnosisReport struct {
+	IpList        []string           `json:"ip_list" yaml:"ip_list"`
+	Error         string             `json:"error,omitempty" yaml:"error,omitempty"`
+	InternetHttp  *CheckResult       `json:"internet_http,omitempty" yaml:"internet_http,omitempty"`
+	IntranetHttp  *CheckResult       `json:"intranet_http,omitempty" yaml:"intranet_http,omitempty"`
+	SystemResolve *CheckResult       `json:"system_resolve,omitempty" yaml:"system_resolve,omitempty"`
+	InternetDns   *DnsCheckResult    `json:"internet_dns,omitempty" yaml:"internet_dns,omitempty"`
+	IntranetDns   *DnsCheckResult    `json:"intranet_dns,omitempty" yaml:"intranet_dns,omitempty"`
+	Proxies       []ProxyCheckResult `json:"proxies" yaml:"proxies"`
+}
+
+// Success returns true if no basic check in the report failed
+func (report *DiagnosisReport) Success() bool {
+	if report.Error != "" {
+		return false
+	}
+	for _, checkResult := range []*CheckResult{report.InternetHttp, report.IntranetHttp, report.SystemResolve} {
+		if checkResult == nil || checkResult.Error != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func newCheckResult(statusCode int, err error) *CheckResult {
+	checkResult := &CheckResult{StatusCode: statusCode}
+	if err != nil {
+		checkResult.Error = err.Error()
+	}
+	return checkResult
+}
+
 type DiagnosisShellHelper struct {
 	loggerHelper             *basic.LoggerHelper
 	connectivityChecker      *http.ConnectivityChecker
@@ -17,6 +72,7 @@ type DiagnosisShellHelper struct {
 	programDiagnosisSettings *basic.ProgramDiagnosisSettings
 	programShellSettings     *basic.ProgramShellSettings
 	printHint                bool
+	notifierHelper           *NotifierHelper // Nil if failures are not notified
 }
 
 func InitDiagnosisHelper(
@@ -59,6 +115,36 @@ func InitDiagnosisHelper(
 
 }
 
+// SetPrintHint overrides if hints are printed, e.g. hints are disabled for machine-readable output
+func (diagnosis *DiagnosisShellHelper) SetPrintHint(printHint bool) {
+	diagnosis.printHint = printHint
+}
+
+// SetNotifier sets the helper notifying failed diagnoses, usually the one of PortalShellHelper
+func (diagnosis *DiagnosisShellHelper) SetNotifier(notifierHelper *NotifierHelper) {
+	diagnosis.notifierHelper = notifierHelper
+}
+
+// notifyFailure notifies the failed checks if the diagnosis fails
+func (diagnosis *DiagnosisShellHelper) notifyFailure(report *DiagnosisReport) {
+	if diagnosis.notifierHelper == nil || report.Success() {
+		return
+	}
+	failures := make([]string, 0)
+	if report.Error != "" {
+		failures = append(failures, report.Error)
+	}
+	for _, checkResult := range []*CheckResult{report.InternetHttp, report.IntranetHttp, report.SystemResolve} {
+		if checkResult != nil && checkResult.Error != "" {
+			failures = append(failures, checkResult.Error)
+		}
+	}
+	diagnosis.notifierHelper.Notify(&Notification{
+		Event:   basic.NotifyDiagnosis,
+		Message: strings.Join(failures, "\n"),
+	})
+}
+
 func (diagnosis *DiagnosisShellHelper) errorHandle(
 	errorHandleMap map[int]basic.ErrorHandler,
 	statusCode int,
@@ -71,7 +157,34 @@ func (diagnosis *DiagnosisShellHelper) errorHandle(
 
 }
 
-func (diagnosis *DiagnosisShellHelper) DoDiagnosis() {
+// aborted returns true if the diagnosis is cancelled or timed out, the reason is recorded in report
+func (diagnosis *DiagnosisShellHelper) aborted(ctx context.Context, report *DiagnosisReport) bool {
+	if ctx.Err() == nil {
+		return false
+	}
+	if report.Error == "" {
+		report.Error = fmt.Sprintf("app/diagnosis: Diagnosis aborted [%v]", ctx.Err())
+		diagnosis.loggerHelper.AddLog(basic.WARNING, report.Error)
+	}
+	return true
+}
+
+// DoDiagnosis checks network connectivity step by step, all checks are aborted together after the overall deadline
+func (diagnosis *DiagnosisShellHelper) DoDiagnosis(ctx context.Context) (report *DiagnosisReport) {
+
+	report = &DiagnosisReport{
+		IpList:  make([]string, 0),
+		Proxies: make([]ProxyCheckResult, 0),
+	}
+
+	timeout := diagnosis.programDiagnosisSettings.Timeout
+	if timeout <= 0 {
+		timeout = defaultDiagnosisTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	defer diagnosis.notifyFailure(report)
+	defer diagnosis.aborted(ctx, report)
 
 	if diagnosis.printHint {
 		fmt.Println(diagnosis.programShellSettings.InteractHint.Diagnosis.Banner)
@@ -83,14 +196,17 @@ func (diagnosis *DiagnosisShellHelper) DoDiagnosis() {
 		if diagnosis.printHint {
 			fmt.Println(diagnosis.programShellSettings.InteractHint.BasicHint.Failed)
 		}
+		report.Error = err.Error()
 		return
 	}
+	report.IpList = ipList
 	diagnosis.loggerHelper.AddLog(basic.INFO, fmt.Sprint("app/diagnosis: All vaild IPv4 address(es):\n", strings.Join(ipList, "\n")))
 	if len(ipList) == 0 {
 		diagnosis.loggerHelper.AddLog(basic.ERROR, fmt.Sprint("app/diagnosis: Cannot get any interface with valid IP"))
 		if diagnosis.printHint {
 			fmt.Println(diagnosis.programShellSettings.InteractHint.Diagnosis.NoIp)
 		}
+		report.Error = "app/diagnosis: Cannot get any interface with valid IP"
 		return
 	}
 
@@ -100,34 +216,47 @@ func (diagnosis *DiagnosisShellHelper) DoDiagnosis() {
 
 	// =============== Internet Check (baidu.com) ================
 	diagnosis.loggerHelper.AddLog(basic.INFO, "app/diagnosis: Start internet connectivity check")
-	statusCode, err := diagnosis.connectivityChecker.InternetHttpCheck()
+	statusCode, err := diagnosis.connectivityChecker.InternetHttpCheck(ctx)
 	if err != nil {
 		diagnosis.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("%v", err))
 	}
 	diagnosis.errorHandle(diagnosis.programDiagnosisSettings.ErrorHandle[basic.InternetErrors], statusCode)
+	report.InternetHttp = newCheckResult(statusCode, err)
+	if diagnosis.aborted(ctx, report) {
+		return
+	}
 
 	// =============== Intranet Check (p.xjtu.edu.cn) ================
 	diagnosis.loggerHelper.AddLog(basic.INFO, "app/diagnosis: Start intranet connectivity check")
-	statusCode, err = diagnosis.connectivityChecker.IntranetHttpCheck()
+	statusCode, err = diagnosis.connectivityChecker.IntranetHttpCheck(ctx)
 	if err != nil {
 		diagnosis.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("%v", err))
 	}
 	diagnosis.errorHandle(diagnosis.programDiagnosisSettings.ErrorHandle[basic.IntranetErrors], statusCode)
+	report.IntranetHttp = newCheckResult(statusCode, err)
+	if diagnosis.aborted(ctx, report) {
+		return
+	}
 
 	diagnosis.loggerHelper.AddLog(basic.INFO, "app/diagnosis: Start DNS check")
 
 	// =============== System DNS resolve Check ================
 	diagnosis.loggerHelper.AddLog(basic.INFO, "app/diagnosis: Start system DNS check")
-	statusCode, err = diagnosis.connectivityChecker.SystemResolveCheck()
+	statusCode, err = diagnosis.connectivityChecker.SystemResolveCheck(ctx)
 	if err != nil {
 		diagnosis.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
 	}
 	diagnosis.errorHandle(diagnosis.programDiagnosisSettings.ErrorHandle[basic.ResolverErrors], statusCode)
+	report.SystemResolve = newCheckResult(statusCode, err)
+	if diagnosis.aborted(ctx, report) {
+		return
+	}
 
 	// =============== Internet DNS Check (aliDNS, 114DNS, ...) ================
 	diagnosis.loggerHelper.AddLog(basic.INFO, "app/diagnosis: Start internet DNS check")
 
-	available, unavailable := diagnosis.connectivityChecker.InternetDnsCheck()
+	available, unavailable := diagnosis.connectivityChecker.InternetDnsCheck(ctx)
+	report.InternetDns = &DnsCheckResult{Available: available, Unavailable: unavailable}
 	if len(available) > 0 {
 		diagnosis.loggerHelper.AddLog(basic.INFO,
 			fmt.Sprintf("app/diagnosis: The following Internet DNS is available:\n%s", strings.Join(available, ", ")))
@@ -145,9 +274,14 @@ func (diagnosis *DiagnosisShellHelper) DoDiagnosis() {
 			fmt.Sprintf("app/diagnosis: The following Internet DNS is unavailable:\n%s", strings.Join(unavailable, ", ")))
 	}
 
+	if diagnosis.aborted(ctx, report) {
+		return
+	}
+
 	// =============== Intranet DNS Check (10.6.39.2, 202.117.0.20, ...) ================
 	diagnosis.loggerHelper.AddLog(basic.INFO, "app/diagnosis: Start intranet DNS check")
-	available, unavailable = diagnosis.connectivityChecker.IntranetDnsCheck()
+	available, unavailable = diagnosis.connectivityChecker.IntranetDnsCheck(ctx)
+	report.IntranetDns = &DnsCheckResult{Available: available, Unavailable: unavailable}
 	if len(available) > 0 {
 		diagnosis.loggerHelper.AddLog(basic.INFO,
 			fmt.Sprintf("app/diagnosis: The following Intranet DNS is available:\n%s", strings.Join(available, ", ")))
@@ -165,9 +299,13 @@ func (diagnosis *DiagnosisShellHelper) DoDiagnosis() {
 			fmt.Sprintf("app/diagnosis: The following Intranet DNS is unavailable:\n%s", strings.Join(unavailable, ", ")))
 	}
 
+	if diagnosis.aborted(ctx, report) {
+		return
+	}
+
 	// =============== Proxy check ================
 	diagnosis.loggerHelper.AddLog(basic.INFO, "app/diagnosis: Start local proxy detecting")
-	proxies, programs, proxyAvailable := diagnosis.proxyChecker.ProxyCheck()
+	proxies, programs, proxyAvailable := diagnosis.proxyChecker.ProxyCheck(ctx)
 	noAvail := true
 	if len(proxies) > 0 {
 		var proxyList []string
@@ -178,6 +316,11 @@ func (diagnosis *DiagnosisShellHelper) DoDiagnosis() {
 				noAvail = false
 			}
 			proxyList = append(proxyList, fmt.Sprintf("%s (%s) %s", proxies[i], programs[i], avail))
+			report.Proxies = append(report.Proxies, ProxyCheckResult{
+				Proxy:     proxies[i],
+				Programs:  programs[i],
+				Available: proxyAvailable[i],
+			})
 		}
 
 		diagnosis.loggerHelper.AddLog(basic.INFO, fmt.Sprint("app/diagnosis: Proxies found:", "\n", strings.Join(proxyList, "\n")))
@@ -194,4 +337,5 @@ func (diagnosis *DiagnosisShellHelper) DoDiagnosis() {
 
 	}
 
+	return
 }
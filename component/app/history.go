@@ -0,0 +1,402 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/device"
+	"xjtuportal/component/http"
+)
+
+const (
+	// Events in session history
+	SessionEvent  = "session" // A session appears in session list for the first time
+	LoginEvent    = "login"
+	LogoutEvent   = "logout"
+	IntruderEvent = "intruder" // A session of unknown device is found in daemon mode
+
+	// Reasons of logout events
+	ManualLogout   = "manual"      // Logged out by logout command, interactive menu or control API
+	AutoLogout     = "auto_logout" // Logged out by logout policy when device number is overload
+	ExternalLogout = "external"    // Disappeared from session list without being logged out by this program
+	IntruderLogout = "intruder"    // Logged out as an unknown device in daemon mode
+
+	defaultHistoryFile = "history.jsonl"
+	compactInterval    = 24 * time.Hour
+)
+
+// HistoryRecord is a line in session history file
+type HistoryRecord struct {
+	Time       time.Time `json:"time" yaml:"time"`
+	Event      string    `json:"event" yaml:"event"`
+	Account    string    `json:"account,omitempty" yaml:"account,omitempty"`
+	Mac        string    `json:"mac,omitempty" yaml:"mac,omitempty"`
+	Ip         string    `json:"ip,omitempty" yaml:"ip,omitempty"`
+	UniqueId   string    `json:"unique_id,omitempty" yaml:"unique_id,omitempty"`
+	DeviceType string    `json:"device_type,omitempty" yaml:"device_type,omitempty"`
+	StartTime  string    `json:"start_time,omitempty" yaml:"start_time,omitempty"` // Login time given by the portal
+	Duration   int64     `json:"duration,omitempty" yaml:"duration,omitempty"`     // Seconds online, for logout events
+	Reason     string    `json:"reason,omitempty" yaml:"reason,omitempty"`
+	Detail     string    `json:"detail,omitempty" yaml:"detail,omitempty"` // e.g. the rule of logout policy
+	StatusCode int       `json:"status_code,omitempty" yaml:"status_code,omitempty"`
+	Message    string    `json:"message,omitempty" yaml:"message,omitempty"`
+	Success    bool      `json:"success" yaml:"success"`
+	Error      string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// HistoryQuery selects records in session history, empty fields match all records
+type HistoryQuery struct {
+	Mac   string
+	Ip    string
+	Event string
+	Since time.Time
+	Until time.Time
+	Limit int // Only the latest records are returned if it is positive
+}
+
+func (query *HistoryQuery) match(record *HistoryRecord) bool {
+	return (query.Mac == "" || record.Mac == query.Mac) &&
+		(query.Ip == "" || record.Ip == query.Ip) &&
+		(query.Event == "" || record.Event == query.Event) &&
+		(query.Since.IsZero() || !record.Time.Before(query.Since)) &&
+		(query.Until.IsZero() || record.Time.Before(query.Until))
+}
+
+// HistoryStore records observed sessions, logins and logouts in an append-only JSON lines file. Several processes
+// (e.g. daemon and logout command) can share the file, writes are serialized by a lock file beside it. Recording
+// failures are logged and never fail the operation recorded.
+type HistoryStore struct {
+	mutex        sync.Mutex
+	loggerHelper *basic.LoggerHelper
+	file         string // Empty if history is disabled
+	retention    time.Duration
+	lastCompact  time.Time
+}
+
+func InitHistoryStore(configHelper *basic.ConfigHelper, loggerHelper *basic.LoggerHelper) (*HistoryStore, error) {
+
+	if configHelper == nil {
+		err := errors.New("app/history: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("app/history: logger is invalid")
+		return nil, err
+	}
+
+	historySettings := &configHelper.UserSettings.UserAppSettings.UserHistorySettings
+	historyStore := &HistoryStore{
+		loggerHelper: loggerHelper,
+		retention:    time.Duration(historySettings.Retention) * 24 * time.Hour,
+	}
+	if historySettings.IsEnabled() {
+		file := historySettings.File
+		if file == "" {
+			file = defaultHistoryFile
+		}
+		historyStore.file = basic.ResolvePath(configHelper.ConfigDir, file)
+	}
+	return historyStore, nil
+}
+
+// Enabled returns true if history is recorded
+func (historyStore *HistoryStore) Enabled() bool {
+	return historyStore.file != ""
+}
+
+// File returns the path of history file
+func (historyStore *HistoryStore) File() string {
+	return historyStore.file
+}
+
+// read returns all records in history file, invalid lines are skipped
+func (historyStore *HistoryStore) read() ([]*HistoryRecord, error) {
+
+	records := make([]*HistoryRecord, 0)
+	file, err := os.Open(historyStore.file)
+	if os.IsNotExist(err) {
+		return records, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := &HistoryRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), record); err != nil {
+			historyStore.loggerHelper.AddLog(basic.WARNING,
+				fmt.Sprintf("app/history: Invalid record at line [%d] of [%s] [%v]", line, historyStore.file, err))
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// append writes records to the end of history file
+func (historyStore *HistoryStore) append(records ...*HistoryRecord) {
+	historyStore.update(false, func([]*HistoryRecord) []*HistoryRecord {
+		return records
+	})
+}
+
+// update writes the records made by build to the end of history file, build is given the records in history file if
+// read is true. The lock is held from reading to writing, so processes cannot make the same records from the same
+// history. Old records are dropped once a day if retention is set.
+func (historyStore *HistoryStore) update(read bool, build func(records []*HistoryRecord) []*HistoryRecord) {
+
+	if !historyStore.Enabled() {
+		return
+	}
+
+	historyStore.mutex.Lock()
+	defer historyStore.mutex.Unlock()
+
+	unlock, err := basic.LockFile(historyStore.file)
+	if err != nil {
+		historyStore.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/history: Cannot lock history [%v]", err))
+		return
+	}
+	defer unlock()
+
+	var records []*HistoryRecord
+	if read {
+		if records, err = historyStore.read(); err != nil {
+			historyStore.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/history: Cannot read history [%v]", err))
+			return
+		}
+	}
+	newRecords := build(records)
+	if len(newRecords) == 0 {
+		return
+	}
+	content := make([]byte, 0)
+	for _, record := range newRecords {
+		line, err := json.Marshal(record)
+		if err != nil {
+			historyStore.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/history: Cannot encode record [%v]", err))
+			return
+		}
+		content = append(append(content, line...), '\n')
+	}
+
+	if historyStore.retention > 0 && time.Since(historyStore.lastCompact) > compactInterval {
+		historyStore.lastCompact = time.Now()
+		if err := historyStore.compact(); err != nil {
+			historyStore.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/history: Cannot drop old records [%v]", err))
+		}
+	}
+
+	file, err := os.OpenFile(historyStore.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err == nil {
+		_, err = file.Write(content)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		historyStore.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/history: Cannot write history [%v]", err))
+	}
+}
+
+// compact rewrites history file without records older than retention, sessions still online are kept. The lock must
+// be held, so records appended by other processes are not lost by the rename.
+func (historyStore *HistoryStore) compact() error {
+
+	records, err := historyStore.read()
+	if err != nil {
+		return err
+	}
+	online := onlineSessions(records, "")
+	cutoff := time.Now().Add(-historyStore.retention)
+	kept := make([]*HistoryRecord, 0, len(records))
+	for _, record := range records {
+		if !record.Time.Before(cutoff) || (record.Event == SessionEvent && online[record.UniqueId] == record) {
+			kept = append(kept, record)
+		}
+	}
+	if len(kept) == len(records) {
+		return nil
+	}
+
+	content := make([]byte, 0)
+	for _, record := range kept {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		content = append(append(content, line...), '\n')
+	}
+	tempFile := filepath.Join(filepath.Dir(historyStore.file), "."+filepath.Base(historyStore.file)+".tmp")
+	if err = ioutil.WriteFile(tempFile, content, 0600); err != nil {
+		return err
+	}
+	historyStore.loggerHelper.AddLog(basic.INFO,
+		fmt.Sprintf("app/history: Dropped [%d] records older than [%s]", len(records)-len(kept), cutoff.Format(time.RFC3339)))
+	return os.Rename(tempFile, historyStore.file)
+}
+
+// onlineSessions returns the session records of sessions not logged out yet by unique id, of all accounts if account
+// is empty
+func onlineSessions(records []*HistoryRecord, account string) map[string]*HistoryRecord {
+	online := make(map[string]*HistoryRecord)
+	for _, record := range records {
+		if account != "" && record.Account != account {
+			continue
+		}
+		switch {
+		case record.Event == SessionEvent:
+			online[record.UniqueId] = record
+		case record.Event == LogoutEvent && record.Success:
+			delete(online, record.UniqueId)
+		}
+	}
+	return online
+}
+
+// Query returns records matched by query in time order
+func (historyStore *HistoryStore) Query(query *HistoryQuery) ([]*HistoryRecord, error) {
+
+	if !historyStore.Enabled() {
+		return nil, errors.New("app/history: Session history is disabled")
+	}
+	if query.Mac != "" {
+		standardMac, err := device.MacStandardize(query.Mac)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("app/history: Invalid MAC address [%s]", query.Mac))
+		}
+		query.Mac = standardMac
+	}
+
+	records, err := historyStore.read()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("app/history: Cannot read history [%v]", err))
+	}
+	matched := make([]*HistoryRecord, 0)
+	for _, record := range records {
+		if query.match(record) {
+			matched = append(matched, record)
+		}
+	}
+	if query.Limit > 0 && len(matched) > query.Limit {
+		matched = matched[len(matched)-query.Limit:]
+	}
+	return matched, nil
+}
+
+// ObserveSessions records sessions of account appearing for the first time, and sessions recorded before but missing
+// in sessions as logged out externally
+func (historyStore *HistoryStore) ObserveSessions(account string, sessions []*http.Session) {
+	historyStore.update(true, func(records []*HistoryRecord) []*HistoryRecord {
+
+		online := onlineSessions(records, account)
+		now := time.Now()
+		newRecords := make([]*HistoryRecord, 0)
+		observed := make(map[string]struct{})
+		for _, session := range sessions {
+			observed[session.UniqueId] = struct{}{}
+			if _, ok := online[session.UniqueId]; ok {
+				continue
+			}
+			record := sessionRecord(now, SessionEvent, account, session)
+			record.Success = true
+			newRecords = append(newRecords, record)
+		}
+		for _, record := range records { // In the order of appearance
+			if _, ok := observed[record.UniqueId]; ok || online[record.UniqueId] != record {
+				continue
+			}
+			logoutRecord := *record
+			logoutRecord.Time, logoutRecord.Event, logoutRecord.Reason, logoutRecord.Success = now, LogoutEvent, ExternalLogout, true
+			logoutRecord.Duration = int64(now.Sub(record.Time) / time.Second)
+			newRecords = append(newRecords, &logoutRecord)
+		}
+		return newRecords
+	})
+}
+
+// RecordLogin records the result of a login attempt
+func (historyStore *HistoryStore) RecordLogin(account string, result *LoginResult) {
+	historyStore.append(&HistoryRecord{
+		Time:       time.Now(),
+		Event:      LoginEvent,
+		Account:    account,
+		StatusCode: result.StatusCode,
+		Message:    result.Message,
+		Success:    result.Success(),
+		Error:      result.Error,
+	})
+}
+
+// RecordLogout records logging out session for reason, the duration is counted from the first time it is observed
+func (historyStore *HistoryStore) RecordLogout(account string, session *http.Session, reason string, detail string, err error) {
+	historyStore.update(true, func(records []*HistoryRecord) []*HistoryRecord {
+
+		now := time.Now()
+		record := sessionRecord(now, LogoutEvent, account, session)
+		record.Reason, record.Detail, record.Success = reason, detail, err == nil
+		if err != nil {
+			record.Error = err.Error()
+		}
+		if seen, ok := onlineSessions(records, account)[session.UniqueId]; ok {
+			record.Duration = int64(now.Sub(seen.Time) / time.Second)
+		}
+		if startTime, ok := parseSessionTime(session.StartTime); record.Duration == 0 && ok && startTime.Before(now) {
+			record.Duration = int64(now.Sub(startTime) / time.Second)
+		}
+		return []*HistoryRecord{record}
+	})
+}
+
+// RecordIntruder records finding a session of unknown device
+func (historyStore *HistoryStore) RecordIntruder(account string, session *http.Session, detail string) {
+	record := sessionRecord(time.Now(), IntruderEvent, account, session)
+	record.Detail, record.Success = detail, true
+	historyStore.append(record)
+}
+
+// reportedIntruders returns unique ids of sessions recorded as intruders, empty if history is disabled
+func (historyStore *HistoryStore) reportedIntruders() map[string]struct{} {
+
+	reported := make(map[string]struct{})
+	if !historyStore.Enabled() {
+		return reported
+	}
+	records, err := historyStore.read()
+	if err != nil {
+		historyStore.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/history: Cannot read history [%v]", err))
+		return reported
+	}
+	for _, record := range records {
+		if record.Event == IntruderEvent {
+			reported[record.UniqueId] = struct{}{}
+		}
+	}
+	return reported
+}
+
+func sessionRecord(now time.Time, event string, account string, session *http.Session) *HistoryRecord {
+	return &HistoryRecord{
+		Time:       now,
+		Event:      event,
+		Account:    account,
+		Mac:        session.UserMacAddr,
+		Ip:         session.UserIpAddr,
+		UniqueId:   session.UniqueId,
+		DeviceType: session.DeviceType,
+		StartTime:  session.StartTime,
+	}
+}
@@ -0,0 +1,177 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"xjtuportal/component/basic"
+)
+
+const (
+	defaultHookTimeout = 30 // Seconds
+)
+
+// HookEvent describes a state transition, given to hooks in environment variables like XJTUPORTAL_HOOK and in JSON
+// on stdin
+type HookEvent struct {
+	Hook       string    `json:"hook"`
+	Time       time.Time `json:"time"`
+	Account    string    `json:"account,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"` // Mapped status code, e.g. 27 for account suspended
+	Message    string    `json:"message,omitempty"`
+	Mac        string    `json:"mac,omitempty"`
+	Ip         string    `json:"ip,omitempty"`
+	FormerIp   string    `json:"former_ip,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+func (event *HookEvent) environ() []string {
+	return []string{
+		"XJTUPORTAL_HOOK=" + event.Hook,
+		"XJTUPORTAL_TIME=" + event.Time.Format(time.RFC3339),
+		"XJTUPORTAL_ACCOUNT=" + event.Account,
+		fmt.Sprintf("XJTUPORTAL_STATUS_CODE=%d", event.StatusCode),
+		"XJTUPORTAL_MESSAGE=" + event.Message,
+		"XJTUPORTAL_MAC=" + event.Mac,
+		"XJTUPORTAL_IP=" + event.Ip,
+		"XJTUPORTAL_FORMER_IP=" + event.FormerIp,
+		"XJTUPORTAL_REASON=" + event.Reason,
+	}
+}
+
+// HookRunner runs user scripts on state transitions. Online state and IP address are tracked to run on_online,
+// on_offline and on_ip_change on changes only, the first state observed is not a change.
+type HookRunner struct {
+	mutex        sync.Mutex
+	loggerHelper *basic.LoggerHelper
+	hooks        map[string]*basic.UserHook // Hooks with command only
+
+	online *bool  // Nil if not observed yet
+	ip     string // Empty if not observed yet
+}
+
+func InitHookRunner(configHelper *basic.ConfigHelper, loggerHelper *basic.LoggerHelper) (*HookRunner, error) {
+
+	if configHelper == nil {
+		err := errors.New("app/hook: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("app/hook: logger is invalid")
+		return nil, err
+	}
+
+	hookRunner := &HookRunner{
+		loggerHelper: loggerHelper,
+		hooks:        make(map[string]*basic.UserHook),
+	}
+	for name, hook := range configHelper.UserSettings.UserHookSettings.Hooks() {
+		if strings.TrimSpace(hook.Command) != "" {
+			hookRunner.hooks[name] = hook
+		}
+	}
+	return hookRunner, nil
+}
+
+// Inherit takes over the online state and IP address from the runner replaced on config reload
+func (hookRunner *HookRunner) Inherit(former *HookRunner) {
+	if former == hookRunner {
+		return
+	}
+	former.mutex.Lock()
+	defer former.mutex.Unlock()
+	hookRunner.mutex.Lock()
+	defer hookRunner.mutex.Unlock()
+	hookRunner.online = former.online
+	hookRunner.ip = former.ip
+}
+
+// Enabled returns true if the hook is set
+func (hookRunner *HookRunner) Enabled(name string) bool {
+	_, ok := hookRunner.hooks[name]
+	return ok
+}
+
+// Run runs the hook of event if it is set and waits until it exits or times out. The hook is given its own timeout,
+// so it still runs if the operation triggering it is aborted. Failures are logged only.
+func (hookRunner *HookRunner) Run(event *HookEvent) {
+
+	hook, ok := hookRunner.hooks[event.Hook]
+	if !ok {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	input, err := json.Marshal(event)
+	if err != nil {
+		hookRunner.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("app/hook: Cannot encode event of [%s] [%v]", event.Hook, err))
+		return
+	}
+	cmd := basic.ShellCommand(ctx, hook.Command)
+	cmd.Env = append(os.Environ(), event.environ()...)
+	cmd.Stdin = bytes.NewReader(append(input, '\n'))
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	hookRunner.loggerHelper.AddLog(basic.INFO, fmt.Sprintf("app/hook: Run hook [%s]", event.Hook))
+	err = basic.RunShell(ctx, cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		hookRunner.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+			"app/hook: Hook [%s] is killed after timeout of %ds", event.Hook, timeout))
+	} else if err != nil {
+		hookRunner.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+			"app/hook: Hook [%s] failed [%v] %s", event.Hook, err, strings.TrimSpace(output.String())))
+	} else if output.Len() > 0 {
+		hookRunner.loggerHelper.AddLog(basic.DEBUG, fmt.Sprintf(
+			"app/hook: Output of hook [%s]:\n%s", event.Hook, strings.TrimSpace(output.String())))
+	}
+}
+
+// ObserveOnline records the online state observed, on_online or on_offline is run if the state changes
+func (hookRunner *HookRunner) ObserveOnline(online bool, event *HookEvent) {
+	hookRunner.mutex.Lock()
+	changed := hookRunner.online != nil && *hookRunner.online != online
+	hookRunner.online = &online
+	hookRunner.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	event.Hook = basic.HookOffline
+	if online {
+		event.Hook = basic.HookOnline
+	}
+	hookRunner.Run(event)
+}
+
+// ObserveIp records the IP address of current session observed, on_ip_change is run if it changes
+func (hookRunner *HookRunner) ObserveIp(ip string, event *HookEvent) {
+	hookRunner.mutex.Lock()
+	formerIp := hookRunner.ip
+	hookRunner.ip = ip
+	hookRunner.mutex.Unlock()
+
+	if formerIp == "" || formerIp == ip {
+		return
+	}
+	event.Hook = basic.HookIpChange
+	event.Ip = ip
+	event.FormerIp = formerIp
+	hookRunner.Run(event)
+}
@@ -0,0 +1,161 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/http"
+)
+
+const (
+	defaultIntruderInterval = 300
+)
+
+// IntruderDetector finds sessions of unknown devices using the account in daemon mode, which may mean a leaked
+// password. Devices are known if they are in known MAC list, protected MAC list or the current device. Each session
+// is reported once, also across restarts if session history is enabled.
+type IntruderDetector struct {
+	mutex        sync.Mutex
+	loggerHelper *basic.LoggerHelper
+	portal       *PortalShellHelper
+
+	interval   time.Duration
+	autoLogout bool
+	lastCheck  time.Time
+	reported   map[string]struct{} // Unique ids of sessions reported
+}
+
+func InitIntruderDetector(
+	configHelper *basic.ConfigHelper,
+	loggerHelper *basic.LoggerHelper,
+	portal *PortalShellHelper,
+) (*IntruderDetector, error) {
+
+	if configHelper == nil {
+		err := errors.New("app/intruder: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("app/intruder: logger is invalid")
+		return nil, err
+	}
+
+	if portal == nil {
+		err := errors.New("app/intruder: PortalShellHelper is invalid")
+		return nil, err
+	}
+
+	intruderSettings := &configHelper.UserSettings.UserAppSettings.UserIntruderSettings
+	interval := intruderSettings.Interval
+	if interval <= 0 {
+		interval = defaultIntruderInterval
+	}
+
+	return &IntruderDetector{
+		loggerHelper: loggerHelper,
+		portal:       portal,
+		interval:     time.Duration(interval) * time.Second,
+		autoLogout:   intruderSettings.AutoLogout,
+		reported:     portal.historyStore.reportedIntruders(),
+	}, nil
+}
+
+// Inherit takes over sessions reported and the time of last check from the detector replaced on config reload
+func (detector *IntruderDetector) Inherit(former *IntruderDetector) {
+	former.mutex.Lock()
+	defer former.mutex.Unlock()
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	for uniqueId := range former.reported {
+		detector.reported[uniqueId] = struct{}{}
+	}
+	detector.lastCheck = former.lastCheck
+}
+
+// Due returns true if the interval has passed since the last check
+func (detector *IntruderDetector) Due() bool {
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	return time.Since(detector.lastCheck) >= detector.interval
+}
+
+// isKnown returns true if the session belongs to a device of the account owner
+func (detector *IntruderDetector) isKnown(session *http.Session) bool {
+	if session.IsCurrentSession {
+		return true
+	}
+	if _, ok := detector.portal.interfaceHelper.KnownMacMap[session.UserMacAddr]; ok {
+		return true
+	}
+	_, ok := detector.portal.policyHelper.protectedMacs[session.UserMacAddr]
+	return ok
+}
+
+// Check fetches session list and reports sessions of unknown devices not reported before, which are logged out if
+// auto logout is set and the current session is identified. Returns the sessions reported in this check.
+func (detector *IntruderDetector) Check(ctx context.Context) (intruders []*http.Session, err error) {
+
+	detector.mutex.Lock()
+	defer detector.mutex.Unlock()
+	detector.lastCheck = time.Now()
+
+	sessions, err := detector.portal.ListSession(ctx)
+	if err != nil {
+		detector.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("app/intruder: Cannot check sessions [%v]", err))
+		return nil, err
+	}
+
+	// The own session of this device would be taken as an unknown device if the current session cannot be identified
+	autoLogout := detector.autoLogout
+	if autoLogout && !hasCurrentSession(sessions) {
+		autoLogout = false
+		detector.loggerHelper.AddLog(basic.WARNING,
+			"app/intruder: Cannot identify current session, unknown devices are reported but not logged out")
+	}
+
+	intruders = make([]*http.Session, 0)
+	account := detector.portal.account()
+	for _, session := range sessions {
+		if _, ok := detector.reported[session.UniqueId]; ok || detector.isKnown(session) {
+			continue
+		}
+		detector.reported[session.UniqueId] = struct{}{}
+		intruders = append(intruders, session)
+		basic.Metrics.AddCounter(basic.MetricIntruders, 1)
+		detector.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+			"app/intruder: Unknown device [%s] (IP = %s, device type %s, online since %s) is using account [%s]",
+			session.UserMacAddr, session.UserIpAddr, session.DeviceType, session.StartTime, account))
+
+		detector.portal.historyStore.RecordIntruder(account, session, "not in known MAC list")
+		notification := &Notification{
+			Event:   basic.NotifyIntruder,
+			Account: account,
+			Mac:     session.UserMacAddr,
+			Ip:      session.UserIpAddr,
+			Message: fmt.Sprintf("Unknown device [%s] (IP = %s, device type %s, online since %s) is using the account",
+				session.UserMacAddr, session.UserIpAddr, session.DeviceType, session.StartTime),
+		}
+		if autoLogout {
+			if logoutErr := detector.portal.logout(ctx, session.UserMacAddr, IntruderLogout, "unknown device"); logoutErr != nil {
+				detector.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf(
+					"app/intruder: Cannot logout unknown device [%s] [%v]", session.UserMacAddr, logoutErr))
+				notification.Message += ", cannot logout it"
+			} else {
+				detector.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+					"app/intruder: Unknown device [%s] is logged out", session.UserMacAddr))
+				notification.Message += ", it is logged out"
+			}
+		} else if detector.autoLogout {
+			notification.Message += ", it is not logged out as the current session cannot be identified"
+		}
+		detector.portal.notifierHelper.Notify(notification)
+	}
+	if len(intruders) == 0 {
+		detector.loggerHelper.AddLog(basic.DEBUG, "app/intruder: No unknown device found")
+	}
+	return intruders, nil
+}
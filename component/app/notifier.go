@@ -0,0 +1,404 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	stdhttp "net/http"
+	"net/smtp"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"xjtuportal/component/basic"
+)
+
+const (
+	defaultNotifierTimeout = 10 // Seconds
+	defaultSmtpPort        = "25"
+)
+
+var (
+	notificationTitles = map[string]string{
+		basic.NotifyLoginFailed: "Login failed",
+		basic.NotifyOutage:      "Network outage",
+		basic.NotifyRecovered:   "Network recovered",
+		basic.NotifyAutoLogout:  "Device logged out automatically",
+		basic.NotifyDiagnosis:   "Network diagnosis failed",
+		basic.NotifyIntruder:    "Unknown device using the account",
+	}
+)
+
+// Notification is sent to notifiers, also the data of webhook body templates
+type Notification struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Title      string    `json:"title"`
+	Message    string    `json:"message"`
+	Host       string    `json:"host"` // Host name of current machine
+	Account    string    `json:"account,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Mac        string    `json:"mac,omitempty"`
+	Ip         string    `json:"ip,omitempty"`
+}
+
+// notifyChannel sends notifications of the events subscribed through a notifier
+type notifyChannel struct {
+	settings     *basic.UserNotifierSettings
+	events       map[string]struct{} // All events if empty
+	bodyTemplate *template.Template
+	timeout      time.Duration
+	client       *stdhttp.Client // Webhook client without system proxy
+	rootCAs      *x509.CertPool  // Nil to use system certificates for STARTTLS
+}
+
+func (channel *notifyChannel) subscribes(event string) bool {
+	if len(channel.events) == 0 {
+		return true
+	}
+	_, ok := channel.events[event]
+	return ok
+}
+
+func (channel *notifyChannel) send(ctx context.Context, notification *Notification) error {
+	ctx, cancel := context.WithTimeout(ctx, channel.timeout)
+	defer cancel()
+	switch channel.settings.Type {
+	case basic.WebhookNotifier:
+		return channel.sendWebhook(ctx, notification)
+	case basic.SmtpNotifier:
+		return channel.sendMail(ctx, notification)
+	case basic.CommandNotifier:
+		return channel.runCommand(ctx, notification)
+	case basic.DesktopNotifier:
+		return channel.notifyDesktop(ctx, notification)
+	default:
+		return errors.New(fmt.Sprintf("unknown notifier type [%s]", channel.settings.Type))
+	}
+}
+
+// sendWebhook posts the notification in JSON, or the body given by template, without system proxy
+func (channel *notifyChannel) sendWebhook(ctx context.Context, notification *Notification) error {
+
+	body := &bytes.Buffer{}
+	if channel.bodyTemplate != nil {
+		if err := channel.bodyTemplate.Execute(body, notification); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(body).Encode(notification); err != nil {
+		return err
+	}
+
+	request, err := stdhttp.NewRequestWithContext(ctx, stdhttp.MethodPost, channel.settings.Url, body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for name, value := range channel.settings.Headers {
+		request.Header.Set(name, value)
+	}
+	response, err := channel.client.Do(request)
+	if err != nil {
+		return err
+	}
+	_ = response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return errors.New(fmt.Sprintf("webhook returns status code [%d]", response.StatusCode))
+	}
+	return nil
+}
+
+// sendMail sends the notification through SMTP relay, STARTTLS is used if the relay supports it
+func (channel *notifyChannel) sendMail(ctx context.Context, notification *Notification) error {
+
+	address := channel.settings.Host
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultSmtpPort)
+	}
+	host, _, _ := net.SplitHostPort(address)
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host, RootCAs: channel.rootCAs}); err != nil {
+			return err
+		}
+	}
+	if channel.settings.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", channel.settings.Username, channel.settings.Password, host)); err != nil {
+			return err
+		}
+	}
+	if err = client.Mail(channel.settings.From); err != nil {
+		return err
+	}
+	for _, to := range channel.settings.To {
+		if err = client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n"+
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n\r\nEvent: %s\r\nHost: %s\r\nTime: %s\r\n",
+		channel.settings.From, strings.Join(channel.settings.To, ", "),
+		mime.QEncoding.Encode("UTF-8", "[xjtuportal] "+notification.Title), notification.Time.Format(time.RFC1123Z),
+		strings.ReplaceAll(notification.Message, "\n", "\r\n"), notification.Event, notification.Host,
+		notification.Time.Format(time.RFC3339))
+	if _, err = writer.Write([]byte(message)); err != nil {
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// runCommand runs the shell command with the notification in environment variables, e.g. XJTUPORTAL_EVENT
+func (channel *notifyChannel) runCommand(ctx context.Context, notification *Notification) error {
+	cmd := basic.ShellCommand(ctx, channel.settings.Command)
+	cmd.Env = append(os.Environ(),
+		"XJTUPORTAL_EVENT="+notification.Event,
+		"XJTUPORTAL_TITLE="+notification.Title,
+		"XJTUPORTAL_MESSAGE="+notification.Message,
+		"XJTUPORTAL_HOST="+notification.Host,
+		"XJTUPORTAL_ACCOUNT="+notification.Account,
+		fmt.Sprintf("XJTUPORTAL_STATUS_CODE=%d", notification.StatusCode),
+		"XJTUPORTAL_MAC="+notification.Mac,
+		"XJTUPORTAL_IP="+notification.Ip,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := basic.RunShell(ctx, cmd); err != nil {
+		return errors.New(fmt.Sprintf("command failed [%v] %s", err, strings.TrimSpace(stderr.String())))
+	}
+	return nil
+}
+
+// notifyDesktop shows the notification on Linux desktop by notify-send
+func (channel *notifyChannel) notifyDesktop(ctx context.Context, notification *Notification) error {
+	if runtime.GOOS != basic.Linux {
+		return errors.New("desktop notifications are only supported on Linux")
+	}
+	urgency := "normal"
+	if notification.Event == basic.NotifyLoginFailed || notification.Event == basic.NotifyIntruder {
+		urgency = "critical"
+	}
+	output, err := exec.CommandContext(ctx, "notify-send", "-a", "xjtuportal", "-u", urgency,
+		notification.Title, notification.Message).CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("notify-send failed [%v] %s", err, strings.TrimSpace(string(output))))
+	}
+	return nil
+}
+
+// NotifierHelper sends notifications to all notifiers subscribing the event. Transient outages are notified only if
+// they last longer than the grace period, each event is notified once in an outage and recovery is notified after.
+// Sending failures are logged and never fail the operation notified. The outage is only kept in memory, so an outage
+// lasting over a restart is notified again, and the recovery of an outage notified before a restart is not notified.
+type NotifierHelper struct {
+	mutex        sync.Mutex
+	loggerHelper *basic.LoggerHelper
+	channels     []*notifyChannel
+	gracePeriod  time.Duration
+	hostname     string
+
+	outageSince    time.Time           // Zero if not in an outage
+	outageNotified map[string]struct{} // Events notified in current outage
+}
+
+func InitNotifierHelper(configHelper *basic.ConfigHelper, loggerHelper *basic.LoggerHelper) (*NotifierHelper, error) {
+
+	if configHelper == nil {
+		err := errors.New("app/notifier: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("app/notifier: logger is invalid")
+		return nil, err
+	}
+
+	notifySettings := &configHelper.UserSettings.UserAppSettings.UserNotifySettings
+	notifierHelper := &NotifierHelper{
+		loggerHelper:   loggerHelper,
+		channels:       make([]*notifyChannel, 0, len(notifySettings.Notifiers)),
+		gracePeriod:    time.Duration(notifySettings.GracePeriod) * time.Second,
+		outageNotified: make(map[string]struct{}),
+	}
+	notifierHelper.hostname, _ = os.Hostname()
+
+	for index := range notifySettings.Notifiers {
+		notifierSettings := &notifySettings.Notifiers[index]
+		bodyTemplate, err := notifierSettings.BodyTemplate()
+		if err != nil {
+			err = errors.New(fmt.Sprintf("app/notifier: Invalid body template of notifier [%d] [%v]", index, err))
+			return nil, err
+		}
+		timeout := notifierSettings.Timeout
+		if timeout <= 0 {
+			timeout = defaultNotifierTimeout
+		}
+		channel := &notifyChannel{
+			settings:     notifierSettings,
+			events:       make(map[string]struct{}),
+			bodyTemplate: bodyTemplate,
+			timeout:      time.Duration(timeout) * time.Second,
+			// Notifications are rare, so connections are not kept alive for the next one
+			client: &stdhttp.Client{Transport: &stdhttp.Transport{DisableKeepAlives: true}},
+		}
+		if notifierSettings.CaFile != "" {
+			if channel.rootCAs, err = loadCertificates(basic.ResolvePath(configHelper.ConfigDir, notifierSettings.CaFile)); err != nil {
+				err = errors.New(fmt.Sprintf("app/notifier: Invalid CA file of notifier [%d] [%v]", index, err))
+				return nil, err
+			}
+		}
+		for _, event := range notifierSettings.Events {
+			channel.events[event] = struct{}{}
+		}
+		notifierHelper.channels = append(notifierHelper.channels, channel)
+	}
+	return notifierHelper, nil
+}
+
+// loadCertificates returns system certificates with the PEM certificates in file
+func loadCertificates(file string) (*x509.CertPool, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(content) {
+		return nil, errors.New(fmt.Sprintf("no PEM certificate in [%s]", file))
+	}
+	return pool, nil
+}
+
+// Inherit takes over the current outage from the helper replaced on config reload
+func (notifierHelper *NotifierHelper) Inherit(former *NotifierHelper) {
+	if former == notifierHelper {
+		return
+	}
+	former.mutex.Lock()
+	defer former.mutex.Unlock()
+	notifierHelper.mutex.Lock()
+	defer notifierHelper.mutex.Unlock()
+	notifierHelper.outageSince = former.outageSince
+	for event := range former.outageNotified {
+		notifierHelper.outageNotified[event] = struct{}{}
+	}
+}
+
+// Enabled returns true if any notifier is set
+func (notifierHelper *NotifierHelper) Enabled() bool {
+	return len(notifierHelper.channels) > 0
+}
+
+// Notify sends notification of event to subscribed notifiers immediately, and waits until all of them finish. Each
+// notifier is given its own timeout, so notifications are still sent if the operation notified is aborted.
+func (notifierHelper *NotifierHelper) Notify(notification *Notification) {
+
+	if !notifierHelper.Enabled() {
+		return
+	}
+	if notification.Time.IsZero() {
+		notification.Time = time.Now()
+	}
+	if notification.Title == "" {
+		notification.Title = notificationTitles[notification.Event]
+	}
+	notification.Host = notifierHelper.hostname
+
+	waitGroup := sync.WaitGroup{}
+	for index, channel := range notifierHelper.channels {
+		if !channel.subscribes(notification.Event) {
+			continue
+		}
+		waitGroup.Add(1)
+		go func(index int, channel *notifyChannel) {
+			defer waitGroup.Done()
+			if err := channel.send(context.Background(), notification); err != nil {
+				notifierHelper.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+					"app/notifier: Cannot send [%s] through %s notifier [%d] [%v]", notification.Event,
+					channel.settings.Type, index, err))
+				return
+			}
+			notifierHelper.loggerHelper.AddLog(basic.INFO, fmt.Sprintf(
+				"app/notifier: Sent [%s] through %s notifier [%d]", notification.Event, channel.settings.Type, index))
+		}(index, channel)
+	}
+	waitGroup.Wait()
+}
+
+// Outage records a failure of an outage, the notification is sent if the outage lasts longer than the grace period
+// or immediate is true. Each event is notified once in an outage.
+func (notifierHelper *NotifierHelper) Outage(notification *Notification, immediate bool) {
+
+	notifierHelper.mutex.Lock()
+	now := time.Now()
+	if notifierHelper.outageSince.IsZero() {
+		notifierHelper.outageSince = now
+	}
+	since := notifierHelper.outageSince
+	_, notified := notifierHelper.outageNotified[notification.Event]
+	due := immediate || now.Sub(since) >= notifierHelper.gracePeriod
+	if !notified && due {
+		notifierHelper.outageNotified[notification.Event] = struct{}{}
+	}
+	notifierHelper.mutex.Unlock()
+
+	if notified || !due {
+		return
+	}
+	if !immediate && notifierHelper.gracePeriod > 0 {
+		notification.Message = fmt.Sprintf("%s, offline since %s", notification.Message, since.Format(time.RFC3339))
+	}
+	notifierHelper.Notify(notification)
+}
+
+// Recovered ends the current outage, recovery is notified if any failure of the outage is notified
+func (notifierHelper *NotifierHelper) Recovered() {
+
+	notifierHelper.mutex.Lock()
+	since := notifierHelper.outageSince
+	notified := len(notifierHelper.outageNotified) > 0
+	notifierHelper.outageSince = time.Time{}
+	notifierHelper.outageNotified = make(map[string]struct{})
+	notifierHelper.mutex.Unlock()
+
+	if !notified {
+		return
+	}
+	duration := time.Since(since).Round(time.Second)
+	notifierHelper.Notify(&Notification{
+		Event:   basic.NotifyRecovered,
+		Message: fmt.Sprintf("Online again after an outage of %v", duration),
+	})
+}
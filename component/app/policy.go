@@ -0,0 +1,257 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/device"
+	"xjtuportal/component/http"
+)
+
+const (
+	// Logout policy rules
+	KnownMacRule   = basic.KnownMacRule
+	UnknownMacRule = basic.UnknownMacRule
+	OldestRule     = basic.OldestRule
+	NewestRule     = basic.NewestRule
+	DeviceTypeRule = basic.DeviceTypeRule
+	CidrRule       = basic.CidrRule
+
+	sessionTimeLayout = "2006-01-02 15:04:05"
+)
+
+type LogoutPolicyHelper struct {
+	loggerHelper    *basic.LoggerHelper
+	interfaceHelper *device.InterfaceHelper
+	policySettings  *basic.UserLogoutPolicySettings
+
+	rules          []string
+	protectedMacs  map[string]struct{}
+	deviceTypes    map[string]struct{}
+	cidrList       []*net.IPNet
+	ruleSelectFunc map[string]func(sessions []*http.Session) (*http.Session, string)
+}
+
+func InitLogoutPolicyHelper(
+	configHelper *basic.ConfigHelper,
+	loggerHelper *basic.LoggerHelper,
+	interfaceHelper *device.InterfaceHelper,
+) (*LogoutPolicyHelper, error) {
+
+	if configHelper == nil {
+		err := errors.New("app/policy: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("app/policy: logger is invalid")
+		return nil, err
+	}
+
+	if interfaceHelper == nil {
+		err := errors.New("app/policy: InterfaceHelper is invalid")
+		return nil, err
+	}
+
+	policySettings := &configHelper.UserSettings.UserAppSettings.UserPortalSettings.LogoutPolicy
+	policyHelper := &LogoutPolicyHelper{
+		loggerHelper:    loggerHelper,
+		interfaceHelper: interfaceHelper,
+		policySettings:  policySettings,
+		rules:           policySettings.Rules,
+		protectedMacs:   make(map[string]struct{}),
+		deviceTypes:     make(map[string]struct{}),
+		cidrList:        make([]*net.IPNet, 0, len(policySettings.CidrList)),
+	}
+	policyHelper.ruleSelectFunc = map[string]func(sessions []*http.Session) (*http.Session, string){
+		KnownMacRule:   policyHelper.selectByKnownMac,
+		UnknownMacRule: policyHelper.selectUnknownMac,
+		OldestRule:     policyHelper.selectOldest,
+		NewestRule:     policyHelper.selectNewest,
+		DeviceTypeRule: policyHelper.selectByDeviceType,
+		CidrRule:       policyHelper.selectByCidr,
+	}
+
+	if len(policyHelper.rules) == 0 {
+		policyHelper.rules = []string{KnownMacRule}
+	}
+	for _, rule := range policyHelper.rules {
+		if _, ok := policyHelper.ruleSelectFunc[rule]; !ok {
+			err := errors.New(fmt.Sprintf("app/policy: Unknown logout rule [%s]", rule))
+			return nil, err
+		}
+	}
+
+	protectedMacList, errorMacList := interfaceHelper.MacListStandardize(policySettings.ProtectedMacList)
+	if len(errorMacList) > 0 {
+		loggerHelper.AddLog(basic.WARNING,
+			fmt.Sprintf("app/policy: Protected MAC address(es) with invalid format:\n%s", strings.Join(errorMacList, ",\n")))
+	}
+	for _, mac := range protectedMacList {
+		policyHelper.protectedMacs[mac] = struct{}{}
+	}
+	for mac, knownDevice := range interfaceHelper.KnownDevices {
+		if knownDevice.Protected {
+			policyHelper.protectedMacs[mac] = struct{}{}
+		}
+	}
+
+	for _, deviceType := range policySettings.DeviceTypeList {
+		policyHelper.deviceTypes[strings.ToLower(deviceType)] = struct{}{}
+	}
+
+	for _, cidr := range policySettings.CidrList {
+		_, ipNet := device.ParseCidr(cidr)
+		if ipNet == nil {
+			err := errors.New(fmt.Sprintf("app/policy: Invalid CIDR [%s]", cidr))
+			return nil, err
+		}
+		policyHelper.cidrList = append(policyHelper.cidrList, ipNet)
+	}
+
+	return policyHelper, nil
+}
+
+func parseSessionTime(startTime string) (time.Time, bool) {
+	for _, layout := range []string{sessionTimeLayout, time.RFC3339} {
+		if parsed, err := time.ParseInLocation(layout, startTime, time.Local); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// sessionBefore compares start time of sessions, falls back to string comparison if the time cannot be parsed
+func sessionBefore(a *http.Session, b *http.Session) bool {
+	timeA, okA := parseSessionTime(a.StartTime)
+	timeB, okB := parseSessionTime(b.StartTime)
+	if okA && okB {
+		return timeA.Before(timeB)
+	}
+	return a.StartTime < b.StartTime
+}
+
+func (policyHelper *LogoutPolicyHelper) selectByKnownMac(sessions []*http.Session) (*http.Session, string) {
+	macList := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		macList = append(macList, session.UserMacAddr)
+	}
+	mac := policyHelper.interfaceHelper.FindLogoutMac(macList)
+	for _, session := range sessions {
+		if session.UserMacAddr == mac {
+			if _, ok := policyHelper.interfaceHelper.KnownMacMap[mac]; ok {
+				return session, "all MAC addresses are known, chosen by the order of known MAC list"
+			}
+			return session, "MAC address is not in known MAC list"
+		}
+	}
+	return nil, ""
+}
+
+func (policyHelper *LogoutPolicyHelper) selectUnknownMac(sessions []*http.Session) (*http.Session, string) {
+	for _, session := range sessions {
+		if _, ok := policyHelper.interfaceHelper.KnownMacMap[session.UserMacAddr]; !ok {
+			return session, "MAC address is not in known MAC list"
+		}
+	}
+	return nil, ""
+}
+
+func (policyHelper *LogoutPolicyHelper) selectOldest(sessions []*http.Session) (*http.Session, string) {
+	var selected *http.Session
+	for _, session := range sessions {
+		if selected == nil || sessionBefore(session, selected) {
+			selected = session
+		}
+	}
+	if selected == nil {
+		return nil, ""
+	}
+	return selected, fmt.Sprintf("oldest session started at [%s]", selected.StartTime)
+}
+
+func (policyHelper *LogoutPolicyHelper) selectNewest(sessions []*http.Session) (*http.Session, string) {
+	var selected *http.Session
+	for _, session := range sessions {
+		if selected == nil || sessionBefore(selected, session) {
+			selected = session
+		}
+	}
+	if selected == nil {
+		return nil, ""
+	}
+	return selected, fmt.Sprintf("newest session started at [%s]", selected.StartTime)
+}
+
+func (policyHelper *LogoutPolicyHelper) selectByDeviceType(sessions []*http.Session) (*http.Session, string) {
+	for _, session := range sessions {
+		if _, ok := policyHelper.deviceTypes[strings.ToLower(session.DeviceType)]; ok {
+			return session, fmt.Sprintf("device type [%s] is in device type list", session.DeviceType)
+		}
+	}
+	return nil, ""
+}
+
+func (policyHelper *LogoutPolicyHelper) selectByCidr(sessions []*http.Session) (*http.Session, string) {
+	for _, session := range sessions {
+		ip := net.ParseIP(session.UserIpAddr)
+		if ip == nil {
+			continue
+		}
+		for _, ipNet := range policyHelper.cidrList {
+			if ipNet.Contains(ip) {
+				return session, fmt.Sprintf("IP address is in [%s]", ipNet.String())
+			}
+		}
+	}
+	return nil, ""
+}
+
+// SelectLogoutSession chooses a session to logout by rules in order, returns nil if nothing should be logged out
+func (policyHelper *LogoutPolicyHelper) SelectLogoutSession(sessions []*http.Session) (selected *http.Session, reason string) {
+
+	// Protected sessions are never logged out
+	candidates := make([]*http.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if _, ok := policyHelper.protectedMacs[session.UserMacAddr]; ok {
+			policyHelper.loggerHelper.AddLog(basic.INFO,
+				fmt.Sprintf("app/policy: Session [%s] is protected, skipped",
+					policyHelper.interfaceHelper.DeviceName(session.UserMacAddr)))
+			continue
+		}
+		candidates = append(candidates, session)
+	}
+	if len(candidates) == 0 {
+		policyHelper.loggerHelper.AddLog(basic.WARNING, "app/policy: No session can be logged out, all sessions are protected")
+		return nil, ""
+	}
+
+	for _, rule := range policyHelper.rules {
+		selected, reason = policyHelper.ruleSelectFunc[rule](candidates)
+		if selected == nil {
+			policyHelper.loggerHelper.AddLog(basic.INFO, fmt.Sprintf("app/policy: Rule [%s] matches no session", rule))
+			continue
+		}
+		reason = fmt.Sprintf("rule [%s]: %s", rule, reason)
+		break
+	}
+	if selected == nil {
+		policyHelper.loggerHelper.AddLog(basic.WARNING, "app/policy: No session matches any logout rule")
+		return nil, ""
+	}
+
+	if policyHelper.policySettings.ReportOnly {
+		policyHelper.loggerHelper.AddLog(basic.WARNING,
+			fmt.Sprintf("app/policy: Report only, session [%s] (IP = %s) would be logged out by %s",
+				policyHelper.interfaceHelper.DeviceName(selected.UserMacAddr), selected.UserIpAddr, reason))
+		return nil, reason
+	}
+
+	policyHelper.loggerHelper.AddLog(basic.WARNING,
+		fmt.Sprintf("app/policy: Session [%s] (IP = %s) is selected to logout by %s",
+			policyHelper.interfaceHelper.DeviceName(selected.UserMacAddr), selected.UserIpAddr, reason))
+	return selected, reason
+}
@@ -1,32 +1,71 @@
 package app
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"xjtuportal/component/basic"
 	"xjtuportal/component/device"
 	"xjtuportal/component/http"
 )
 
+// LoginResult records the mapped result of a login operation
+type LoginResult struct {
+	StatusCode    int    `json:"status_code" yaml:"status_code"`
+	Message       string `json:"message" yaml:"message"`
+	Description   string `json:"description,omitempty" yaml:"description,omitempty"`
+	AlreadyOnline bool   `json:"already_online" yaml:"already_online"`
+	LoggedOutMac  string `json:"logged_out_mac,omitempty" yaml:"logged_out_mac,omitempty"`
+	LogoutReason  string `json:"logout_reason,omitempty" yaml:"logout_reason,omitempty"`
+	Profile       string `json:"profile" yaml:"profile"`
+	Error         string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func (result *LoginResult) setAttempt(statusCode int, online bool, err error, onlineResponse *http.OnlineResponse) {
+	result.StatusCode = statusCode
+	result.AlreadyOnline = online
+	result.Description = ""
+	result.Error = ""
+	if err != nil {
+		result.Error = err.Error()
+	} else if !online && onlineResponse != nil {
+		result.Description = onlineResponse.Description
+	}
+}
+
+// Success returns true if the machine is online after login
+func (result *LoginResult) Success() bool {
+	return result.Error == "" && (result.AlreadyOnline || result.StatusCode == 200)
+}
+
 type PortalShellHelper struct {
 	loggerHelper        *basic.LoggerHelper
-	connectivityChecker *http.ConnectivityChecker
+	connectivityChecker http.HttpChecker
 	sessionListHelper   *http.SessionListHelper
 	interfaceHelper     *device.InterfaceHelper
+	policyHelper        *LogoutPolicyHelper
+	historyStore        *HistoryStore
+	notifierHelper      *NotifierHelper
+	hookRunner          *HookRunner
 
+	userOnlineSettings       *basic.UserOnlineSettings
 	userPortalSettings       *basic.UserPortalSettings
 	userUiSettings           *basic.UserUISettings
 	programPortalSettings    *basic.ProgramPortalSettings
 	programDiagnosisSettings *basic.ProgramDiagnosisSettings
 	programShellSettings     *basic.ProgramShellSettings
 	printHint                bool
+	configDir                string
+
+	onlineResponse *http.OnlineResponse // Response of the last online request
 }
 
 func InitPortalShellHelper(
 	configHelper *basic.ConfigHelper,
 	loggerHelper *basic.LoggerHelper,
-	connectivityChecker *http.ConnectivityChecker,
+	connectivityChecker http.HttpChecker,
 	sessionListHelper *http.SessionListHelper,
 	interfaceHelper *device.InterfaceHelper,
 ) (*PortalShellHelper, error) {
@@ -56,23 +95,84 @@ func InitPortalShellHelper(
 		return nil, err
 	}
 
+	policyHelper, err := InitLogoutPolicyHelper(configHelper, loggerHelper, interfaceHelper)
+	if err != nil {
+		return nil, err
+	}
+
+	historyStore, err := InitHistoryStore(configHelper, loggerHelper)
+	if err != nil {
+		return nil, err
+	}
+
+	notifierHelper, err := InitNotifierHelper(configHelper, loggerHelper)
+	if err != nil {
+		return nil, err
+	}
+
+	hookRunner, err := InitHookRunner(configHelper, loggerHelper)
+	if err != nil {
+		return nil, err
+	}
+
 	portalHelper := &PortalShellHelper{
 		loggerHelper:        loggerHelper,
 		connectivityChecker: connectivityChecker,
 		sessionListHelper:   sessionListHelper,
 		interfaceHelper:     interfaceHelper,
+		policyHelper:        policyHelper,
+		historyStore:        historyStore,
+		notifierHelper:      notifierHelper,
+		hookRunner:          hookRunner,
 
+		userOnlineSettings:       &configHelper.UserSettings.UserOnlineSettings,
 		userPortalSettings:       &configHelper.UserSettings.UserAppSettings.UserPortalSettings,
 		userUiSettings:           &configHelper.UserSettings.UserUISettings,
 		programPortalSettings:    &configHelper.ProgramSettings.ProgramAppSettings.ProgramPortalSettings,
 		programDiagnosisSettings: &configHelper.ProgramSettings.ProgramAppSettings.ProgramDiagnosisSettings,
 		programShellSettings:     &configHelper.ProgramSettings.ProgramUiSettings.ProgramShellSettings,
 		printHint:                configHelper.UserSettings.UserUISettings.Mode == basic.InteractMode,
+		configDir:                configHelper.ConfigDir,
 	}
 
 	return portalHelper, nil
 }
 
+// History returns the store of session history
+func (portal *PortalShellHelper) History() *HistoryStore {
+	return portal.historyStore
+}
+
+// Notifier returns the helper sending notifications of login failures and outages
+func (portal *PortalShellHelper) Notifier() *NotifierHelper {
+	return portal.notifierHelper
+}
+
+// Hooks returns the runner of user scripts on state transitions
+func (portal *PortalShellHelper) Hooks() *HookRunner {
+	return portal.hookRunner
+}
+
+// CurrentIp returns IP address of current session given by the speed test server
+func (portal *PortalShellHelper) CurrentIp(ctx context.Context) (string, error) {
+	return portal.sessionListHelper.Backend.CurrentIp(ctx)
+}
+
+// DeviceName returns MAC address with labels of the known device, see device.InterfaceHelper.DeviceName
+func (portal *PortalShellHelper) DeviceName(mac string) string {
+	return portal.interfaceHelper.DeviceName(mac)
+}
+
+// account returns the account in use, i.e. username with domain
+func (portal *PortalShellHelper) account() string {
+	return portal.userOnlineSettings.ActiveAuthData().Account()
+}
+
+// SetPrintHint overrides if hints are printed, e.g. hints are disabled for machine-readable output
+func (portal *PortalShellHelper) SetPrintHint(printHint bool) {
+	portal.printHint = printHint
+}
+
 func (portal *PortalShellHelper) errorHandle(
 	errorHandleMap map[int]basic.ErrorHandler,
 	statusCode int,
@@ -95,16 +195,21 @@ func (portal *PortalShellHelper) loginErrorCodeMapping(errorCode int, errorDescr
 	return -1
 }
 
-func (portal *PortalShellHelper) login() (statusCode int, err error) {
+// login logs in once, the online request is never retried since the portal may have accepted a request that timed
+// out. Requests to check connectivity and get the redirect URL are retried by the request helper.
+func (portal *PortalShellHelper) login(ctx context.Context) (statusCode int, online bool, err error) {
+
+	portal.onlineResponse = nil
 
-	statusCode, err = portal.connectivityChecker.InternetHttpCheck()
+	statusCode, err = portal.connectivityChecker.InternetHttpCheck(ctx)
 	portal.errorHandle(portal.programDiagnosisSettings.ErrorHandle[basic.InternetErrors], statusCode)
 	if err == nil { // Currently Internet is available
+		online = true
 		return
 	}
 	portal.loggerHelper.AddLog(basic.INFO, fmt.Sprintf("%v", err))
 
-	statusCode, err = portal.connectivityChecker.IntranetHttpCheck()
+	statusCode, err = portal.connectivityChecker.IntranetHttpCheck(ctx)
 	portal.errorHandle(portal.programDiagnosisSettings.ErrorHandle[basic.IntranetErrors], statusCode)
 	if err != nil { // Currently portal server is unavailable
 		return
@@ -112,43 +217,66 @@ func (portal *PortalShellHelper) login() (statusCode int, err error) {
 
 	portal.loggerHelper.AddLog(basic.INFO, "app/portal: Try to login")
 
-	statusCode, err = portal.sessionListHelper.OnlineHelper.GetRedirectUrl()
+	redirectUrl, statusCode, err := portal.sessionListHelper.Backend.RedirectUrl(ctx)
 	if err != nil { // Cannot get redirect URL
 		return
 	}
 
-	statusCode, err = portal.sessionListHelper.OnlineHelper.OnlinePost(portal.sessionListHelper.OnlineHelper.RedirectUrl)
+	onlineResponse, statusCode, err := portal.sessionListHelper.Backend.Online(ctx, redirectUrl)
 	if err != nil { // Cannot get online response
 		return
 	}
-	statusCode = portal.loginErrorCodeMapping(portal.sessionListHelper.OnlineHelper.OnlineResponse.ErrorCode,
-		portal.sessionListHelper.OnlineHelper.OnlineResponse.Description)
+	portal.onlineResponse = onlineResponse
+	statusCode = portal.loginErrorCodeMapping(onlineResponse.ErrorCode, onlineResponse.Description)
+	basic.Metrics.AddCounter(basic.MetricLoginAttempts, 1, "status_code", strconv.Itoa(statusCode))
 	portal.errorHandle(portal.programPortalSettings.ErrorHandle[basic.LoginErrors], statusCode)
 	return
 }
 
-func (portal *PortalShellHelper) getSessionList() (err error) {
+func (portal *PortalShellHelper) getSessionList(ctx context.Context) (err error) {
 
 	portal.loggerHelper.AddLog(basic.INFO, "app/portal: Try to get session list")
 
-	statusCode, err := portal.sessionListHelper.InitSessionListByPortal()
+	statusCode, err := portal.sessionListHelper.InitSessionListByPortal(ctx)
 	portal.errorHandle(portal.programPortalSettings.ErrorHandle[basic.GetSessionErrors], statusCode)
+	if err == nil {
+		sessions := make([]*http.Session, 0, len(portal.sessionListHelper.SessionMacList))
+		for _, mac := range portal.sessionListHelper.SessionMacList {
+			session := portal.sessionListHelper.MacSessionMap[mac]
+			session.Device = portal.interfaceHelper.KnownDevice(mac)
+			sessions = append(sessions, session)
+		}
+		portal.historyStore.ObserveSessions(portal.account(), sessions)
+	}
 	return err
 
 }
 
-func (portal *PortalShellHelper) logout(macAddr string) (err error) {
+// logout logs out the session with given MAC address, reason and detail are recorded in session history
+func (portal *PortalShellHelper) logout(ctx context.Context, macAddr string, reason string, detail string) (err error) {
 
-	statusCode, err := portal.connectivityChecker.IntranetHttpCheck()
+	statusCode, err := portal.connectivityChecker.IntranetHttpCheck(ctx)
 	portal.errorHandle(portal.programPortalSettings.ErrorHandle[basic.IntranetErrors], statusCode)
 	if err != nil { // Currently portal server is unavailable
 		return
 	}
 
 	if session, ok := portal.sessionListHelper.MacSessionMap[macAddr]; ok {
-		portal.loggerHelper.AddLog(basic.INFO, fmt.Sprintf("app/portal: Try to logout session with MAC address [%s]", macAddr))
-		statusCode, err = portal.sessionListHelper.LogoutDelete(session.UniqueId)
+		portal.loggerHelper.AddLog(basic.INFO, fmt.Sprintf("app/portal: Try to logout session with MAC address [%s]",
+			portal.interfaceHelper.DeviceName(macAddr)))
+		statusCode, err = portal.sessionListHelper.LogoutDelete(ctx, session.UniqueId)
 		portal.errorHandle(portal.programPortalSettings.ErrorHandle[basic.LogoutErrors], statusCode)
+		portal.historyStore.RecordLogout(portal.account(), session, reason, detail, err)
+		if err == nil {
+			portal.hookRunner.Run(&HookEvent{
+				Hook:    basic.HookLogout,
+				Account: portal.account(),
+				Message: detail,
+				Mac:     session.UserMacAddr,
+				Ip:      session.UserIpAddr,
+				Reason:  reason,
+			})
+		}
 		return
 	} else {
 		err = errors.New(fmt.Sprintf("app/portal: There is no session with MAC address [%s]", macAddr))
@@ -157,50 +285,219 @@ func (portal *PortalShellHelper) logout(macAddr string) (err error) {
 
 }
 
-func (portal *PortalShellHelper) DoLogin() {
-	statusCode, err := portal.login()
+func (portal *PortalShellHelper) logoutByUniqueId(ctx context.Context, uniqueId string) (err error) {
+	for _, session := range portal.sessionListHelper.MacSessionMap {
+		if session.UniqueId == uniqueId {
+			return portal.logout(ctx, session.UserMacAddr, ManualLogout, "")
+		}
+	}
+	err = errors.New(fmt.Sprintf("app/portal: There is no session with unique id [%s]", uniqueId))
+	return
+}
+
+// StatusMessage returns the log message of a mapped status code in the given error handle group
+func (portal *PortalShellHelper) StatusMessage(errorHandleGroup string, statusCode int) string {
+	errorHandleMap, ok := portal.programPortalSettings.ErrorHandle[errorHandleGroup]
+	if !ok {
+		return ""
+	}
+	errorHandler, ok := errorHandleMap[statusCode]
+	if !ok {
+		errorHandler = errorHandleMap[-1]
+	}
+	return errorHandler.LogMessage
+}
+
+// accountErrors are login errors specific to the account, login fails over to other profiles on them
+var accountErrors = map[int]bool{
+	basic.AccountSuspended: true,
+	basic.AccountFrozen:    true,
+	basic.NoSubscription:   true,
+	basic.SessionOverload:  true,
+}
+
+// UseProfile switches the account used to login and manage sessions
+func (portal *PortalShellHelper) UseProfile(name string) error {
+	authData, err := portal.userOnlineSettings.ProfileAuthData(name)
+	if err != nil {
+		return err
+	}
+	credentialProvider, err := basic.InitProfileCredentialProvider(portal.configDir, authData)
+	if err != nil {
+		return err
+	}
+	if err = portal.sessionListHelper.UseAccount(authData, credentialProvider); err != nil {
+		return err
+	}
+	return portal.userOnlineSettings.UseProfile(name)
+}
+
+// DoLogin logs in (with auto logout if enabled), fails over to profiles in failover order on account errors,
+// returns the mapped result of the last login attempt
+func (portal *PortalShellHelper) DoLogin(ctx context.Context) (result *LoginResult) {
+
+	result = portal.doLogin(ctx)
+	portal.recordLogin(result)
+	for _, name := range portal.userOnlineSettings.Failover {
+		if result.Success() || !accountErrors[result.StatusCode] || ctx.Err() != nil {
+			break
+		}
+		current := portal.userOnlineSettings.ActiveProfileName()
+		if name == current {
+			continue
+		}
+		portal.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+			"app/portal: Login with profile [%s] failed with error %d, fail over to profile [%s]",
+			current, result.StatusCode, name))
+		if err := portal.UseProfile(name); err != nil {
+			portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+			continue
+		}
+		result = portal.doLogin(ctx)
+		portal.recordLogin(result)
+	}
+	result.Profile = portal.userOnlineSettings.ActiveProfileName()
+	if ctx.Err() == nil { // Aborted logins are neither notified nor given to hooks
+		portal.notifyLogin(result)
+		portal.runLoginHook(ctx, result)
+	}
+	return
+}
+
+// runLoginHook runs on_login_success or on_login_failure, nothing is run if already online
+func (portal *PortalShellHelper) runLoginHook(ctx context.Context, result *LoginResult) {
+	if result.AlreadyOnline {
+		return
+	}
+	event := &HookEvent{
+		Hook:       basic.HookLoginFailure,
+		Account:    portal.account(),
+		StatusCode: result.StatusCode,
+		Message:    result.Message,
+		Reason:     result.Error,
+	}
+	if result.Success() {
+		event.Hook = basic.HookLoginSuccess
+		if !portal.hookRunner.Enabled(event.Hook) {
+			return
+		}
+		if ip, err := portal.CurrentIp(ctx); err == nil {
+			event.Ip = ip
+		}
+	} else if result.Description != "" {
+		event.Message = fmt.Sprintf("%s (%s)", event.Message, result.Description)
+	}
+	portal.hookRunner.Run(event)
+}
+
+// notifyLogin notifies login errors returned by the portal immediately, e.g. account suspended, and other failures
+// as an outage after the grace period. A successful login ends the outage.
+func (portal *PortalShellHelper) notifyLogin(result *LoginResult) {
+	if result.Success() {
+		portal.notifierHelper.Recovered()
+		return
+	}
+	notification := &Notification{
+		Event:      basic.NotifyOutage,
+		Account:    portal.account(),
+		StatusCode: result.StatusCode,
+		Message:    result.Message,
+	}
+	_, rejected := portal.programPortalSettings.ErrorHandle[basic.LoginErrors][result.StatusCode]
+	if rejected && result.StatusCode != 200 && result.StatusCode != -1 {
+		notification.Event = basic.NotifyLoginFailed
+	}
+	if result.Description != "" {
+		notification.Message = fmt.Sprintf("%s (%s)", notification.Message, result.Description)
+	}
+	if result.Error != "" {
+		notification.Message = fmt.Sprintf("%s [%s]", notification.Message, result.Error)
+	}
+	portal.notifierHelper.Outage(notification, notification.Event == basic.NotifyLoginFailed)
+}
+
+// recordLogin records login result in session history, nothing is recorded if already online
+func (portal *PortalShellHelper) recordLogin(result *LoginResult) {
+	if !result.AlreadyOnline {
+		portal.historyStore.RecordLogin(portal.account(), result)
+	}
+}
+
+func (portal *PortalShellHelper) doLogin(ctx context.Context) (result *LoginResult) {
+
+	result = &LoginResult{}
+	defer func() {
+		result.Message = portal.StatusMessage(basic.LoginErrors, result.StatusCode)
+		if result.AlreadyOnline {
+			result.Message = "Already online"
+		}
+	}()
+
+	statusCode, online, err := portal.login(ctx)
 	if err != nil {
 		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
 	}
+	result.setAttempt(statusCode, online, err, portal.onlineResponse)
 
 	if portal.userPortalSettings.IsAutoLogout && statusCode == basic.SessionOverload {
-		err = portal.getSessionList()
+		err = portal.getSessionList(ctx)
 		if err != nil {
 			portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+			result.Error = err.Error()
+			return
+		}
+		sessions := make([]*http.Session, 0, len(portal.sessionListHelper.SessionMacList))
+		for _, mac := range portal.sessionListHelper.SessionMacList {
+			sessions = append(sessions, portal.sessionListHelper.MacSessionMap[mac])
+		}
+		logoutSession, reason := portal.policyHelper.SelectLogoutSession(sessions)
+		result.LogoutReason = reason
+		if logoutSession == nil {
+			result.Error = "app/portal: No session is logged out by logout policy"
 			return
 		}
-		logoutMacAddr := portal.interfaceHelper.FindLogoutMac(portal.sessionListHelper.SessionMacList)
-		err = portal.logout(logoutMacAddr)
+		logoutMacAddr := logoutSession.UserMacAddr
+		err = portal.logout(ctx, logoutMacAddr, AutoLogout, reason)
 		if err != nil {
 			portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+			result.Error = err.Error()
 		} else {
-			_, err = portal.login()
+			basic.Metrics.AddCounter(basic.MetricAutoLogouts, 1)
+			result.LoggedOutMac = logoutMacAddr
+			portal.notifierHelper.Notify(&Notification{
+				Event:   basic.NotifyAutoLogout,
+				Account: portal.account(),
+				Mac:     logoutMacAddr,
+				Ip:      logoutSession.UserIpAddr,
+				Message: fmt.Sprintf("Device [%s] is logged out automatically, %s",
+					portal.interfaceHelper.DeviceName(logoutMacAddr), reason),
+			})
+			statusCode, online, err = portal.login(ctx)
 			if err != nil {
 				portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
 			}
+			result.setAttempt(statusCode, online, err, portal.onlineResponse)
 		}
 	}
 
+	return
 }
 
-func (portal *PortalShellHelper) DoListSession() (err error) {
+// SessionConcurrency returns the max number of sessions given by the last session list
+func (portal *PortalShellHelper) SessionConcurrency() int {
+	return portal.sessionListHelper.Concurrency
+}
 
-	err = portal.getSessionList()
+// ListSession gets session list from portal and marks the current session
+func (portal *PortalShellHelper) ListSession(ctx context.Context) (sessions []*http.Session, err error) {
 
+	err = portal.getSessionList(ctx)
 	if err != nil {
-		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
-		return
-	}
-
-	// Get session number
-	sessionNumber := len(portal.sessionListHelper.SessionMacList)
-	if portal.printHint {
-		fmt.Println(fmt.Sprintf(portal.programShellSettings.InteractHint.SessionList.Banner, sessionNumber))
+		return nil, err
 	}
-	sessionStrList := make([]string, 0, len(portal.sessionListHelper.SessionMacList))
 
 	// Try to get current session
-	err = portal.sessionListHelper.FindCurrentSessionBySpeedTestApp()
+	err = portal.sessionListHelper.FindCurrentSessionBySpeedTestApp(ctx)
 	if err != nil {
 		portal.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("%v", err))
 		err = portal.sessionListHelper.FindCurrentSessionByLocalMacList(portal.interfaceHelper.LocalMacList)
@@ -209,21 +506,44 @@ func (portal *PortalShellHelper) DoListSession() (err error) {
 		}
 	}
 
-	for index, mac := range portal.sessionListHelper.SessionMacList {
+	sessions = make([]*http.Session, 0, len(portal.sessionListHelper.SessionMacList))
+	for _, mac := range portal.sessionListHelper.SessionMacList {
 		if session, ok := portal.sessionListHelper.MacSessionMap[mac]; ok {
-			currentSession := ""
-			if session.IsCurrentSession {
-				currentSession = portal.programPortalSettings.SessionList.CurrentSession
-			}
-			sessionStr := fmt.Sprintf(portal.programPortalSettings.SessionList.SessionRecord, index,
-				fmt.Sprintf(portal.programPortalSettings.SessionList.SessionInfo,
-					session.UserMacAddr,
-					session.UserIpAddr,
-					session.StartTime,
-					currentSession,
-				))
-			sessionStrList = append(sessionStrList, sessionStr)
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (portal *PortalShellHelper) DoListSession(ctx context.Context) (err error) {
+
+	sessions, err := portal.ListSession(ctx)
+
+	if err != nil {
+		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+		return
+	}
+
+	// Get session number
+	sessionNumber := len(sessions)
+	if portal.printHint {
+		fmt.Println(fmt.Sprintf(portal.programShellSettings.InteractHint.SessionList.Banner, sessionNumber))
+	}
+	sessionStrList := make([]string, 0, len(sessions))
+
+	for index, session := range sessions {
+		currentSession := ""
+		if session.IsCurrentSession {
+			currentSession = portal.programPortalSettings.SessionList.CurrentSession
 		}
+		sessionStr := fmt.Sprintf(portal.programPortalSettings.SessionList.SessionRecord, index,
+			fmt.Sprintf(portal.programPortalSettings.SessionList.SessionInfo,
+				portal.interfaceHelper.DeviceName(session.UserMacAddr),
+				session.UserIpAddr,
+				session.StartTime,
+				currentSession,
+			))
+		sessionStrList = append(sessionStrList, sessionStr)
 	}
 
 	if sessionNumber > 0 {
@@ -240,10 +560,12 @@ func (portal *PortalShellHelper) DoListSession() (err error) {
 
 }
 
-func (portal *PortalShellHelper) DoLogout(sessionIndex int) {
+// DoLogout logs out the session with given index, the session list should be fetched in advance
+func (portal *PortalShellHelper) DoLogout(ctx context.Context, sessionIndex int) (err error) {
 
-	if sessionIndex >= len(portal.sessionListHelper.SessionMacList) {
-		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("app/portal: No session [%d] exists", sessionIndex))
+	if sessionIndex < 0 || sessionIndex >= len(portal.sessionListHelper.SessionMacList) {
+		err = errors.New(fmt.Sprintf("app/portal: No session [%d] exists", sessionIndex))
+		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
 		if portal.printHint {
 			fmt.Println(portal.programShellSettings.InteractHint.BasicHint.SelectError)
 		}
@@ -251,8 +573,151 @@ func (portal *PortalShellHelper) DoLogout(sessionIndex int) {
 	}
 
 	logoutMacAddr := portal.sessionListHelper.SessionMacList[sessionIndex]
-	err := portal.logout(logoutMacAddr)
+	err = portal.logout(ctx, logoutMacAddr, ManualLogout, "")
+	if err != nil {
+		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+	}
+	return
+}
+
+// DoLogoutByMac logs out the session with given MAC address, the session list should be fetched in advance
+func (portal *PortalShellHelper) DoLogoutByMac(ctx context.Context, macAddr string) (err error) {
+
+	standardMac, err := device.MacStandardize(macAddr)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("app/portal: Invalid MAC address [%s]", macAddr))
+		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+		return
+	}
+
+	err = portal.logout(ctx, standardMac, ManualLogout, "")
+	if err != nil {
+		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+	}
+	return
+}
+
+// DoLogoutByUniqueId logs out the session with given unique id, the session list should be fetched in advance
+func (portal *PortalShellHelper) DoLogoutByUniqueId(ctx context.Context, uniqueId string) (err error) {
+
+	err = portal.logoutByUniqueId(ctx, uniqueId)
 	if err != nil {
 		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
 	}
+	return
+}
+
+// hasCurrentSession returns true if any session is marked as the current session
+func hasCurrentSession(sessions []*http.Session) bool {
+	for _, session := range sessions {
+		if session.IsCurrentSession {
+			return true
+		}
+	}
+	return false
+}
+
+// LogoutSelector describes the sessions to logout, targets are resolved against the current session list
+type LogoutSelector struct {
+	MacList          []string
+	IpList           []string
+	UniqueIdList     []string
+	AllExceptCurrent bool
+	Unknown          bool
+}
+
+// Empty returns true if no target is given
+func (selector *LogoutSelector) Empty() bool {
+	return selector == nil || (len(selector.MacList) == 0 &&
+		len(selector.IpList) == 0 &&
+		len(selector.UniqueIdList) == 0 &&
+		!selector.AllExceptCurrent &&
+		!selector.Unknown)
+}
+
+// SelectSessions fetches session list and returns the sessions matched by selector in the order of session list
+func (portal *PortalShellHelper) SelectSessions(ctx context.Context, selector *LogoutSelector) (selected []*http.Session, err error) {
+
+	sessions, err := portal.ListSession(ctx)
+	if err != nil {
+		portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+		return nil, err
+	}
+
+	selectedMacs := make(map[string]struct{})
+
+	for _, macAddr := range selector.MacList {
+		standardMac, err := device.MacStandardize(macAddr)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("app/portal: Invalid MAC address [%s]", macAddr))
+			portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+			return nil, err
+		}
+		if _, ok := portal.sessionListHelper.MacSessionMap[standardMac]; !ok {
+			err = errors.New(fmt.Sprintf("app/portal: There is no session with MAC address [%s]", standardMac))
+			portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+			return nil, err
+		}
+		selectedMacs[standardMac] = struct{}{}
+	}
+
+	for _, ipAddr := range selector.IpList {
+		found := false
+		for _, session := range sessions {
+			if session.UserIpAddr == ipAddr {
+				selectedMacs[session.UserMacAddr] = struct{}{}
+				found = true
+			}
+		}
+		if !found {
+			err = errors.New(fmt.Sprintf("app/portal: There is no session with IP address [%s]", ipAddr))
+			portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+			return nil, err
+		}
+	}
+
+	for _, uniqueId := range selector.UniqueIdList {
+		found := false
+		for _, session := range sessions {
+			if session.UniqueId == uniqueId {
+				selectedMacs[session.UserMacAddr] = struct{}{}
+				found = true
+			}
+		}
+		if !found {
+			err = errors.New(fmt.Sprintf("app/portal: There is no session with unique id [%s]", uniqueId))
+			portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+			return nil, err
+		}
+	}
+
+	if selector.AllExceptCurrent {
+		// Refuse to logout everything if the current session cannot be identified
+		if !hasCurrentSession(sessions) {
+			err = errors.New("app/portal: Cannot identify current session, refuse to logout all other sessions")
+			portal.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+			return nil, err
+		}
+		for _, session := range sessions {
+			if !session.IsCurrentSession {
+				selectedMacs[session.UserMacAddr] = struct{}{}
+			}
+		}
+	}
+
+	if selector.Unknown {
+		for _, session := range sessions {
+			if _, ok := portal.interfaceHelper.KnownMacMap[session.UserMacAddr]; !ok && !session.IsCurrentSession {
+				selectedMacs[session.UserMacAddr] = struct{}{}
+			}
+		}
+	}
+
+	selected = make([]*http.Session, 0, len(selectedMacs))
+	for _, session := range sessions {
+		if _, ok := selectedMacs[session.UserMacAddr]; ok {
+			selected = append(selected, session)
+		}
+	}
+	return selected, nil
 }
@@ -20,3 +20,17 @@ func ProgramInfo() string {
 		contact,
 	)
 }
+
+type VersionInfo struct {
+	Version string `json:"version" yaml:"version"`
+	Release string `json:"release" yaml:"release"`
+	License string `json:"license" yaml:"license"`
+}
+
+func ProgramVersion() *VersionInfo {
+	return &VersionInfo{
+		Version: version,
+		Release: release,
+		License: license,
+	}
+}
@@ -1,10 +1,13 @@
 package basic
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"gopkg.in/yaml.v3"
-	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"text/template"
 )
 
 const (
@@ -25,68 +28,360 @@ const (
 	ResolverErrors   = "resolve_check_errors"
 
 	// Login error return codes
-	SessionOverload = 39
+	AccountSuspended = 27
+	AccountFrozen    = 33
+	NoSubscription   = 36
+	SessionOverload  = 39
+
+	// Name of the auth data outside profiles
+	DefaultProfile = "default"
 
 	// UI modes
 	InteractMode = "interact"
+	CommandMode  = "command"
+
+	// Logout policy rules
+	KnownMacRule   = "known_mac"
+	UnknownMacRule = "unknown_mac"
+	OldestRule     = "oldest"
+	NewestRule     = "newest"
+	DeviceTypeRule = "device_type"
+	CidrRule       = "cidr"
+
+	// Notifier types
+	WebhookNotifier = "webhook"
+	SmtpNotifier    = "smtp"
+	CommandNotifier = "command"
+	DesktopNotifier = "desktop"
+
+	// Notification events
+	NotifyLoginFailed = "login_failed"     // Login is rejected by the portal, e.g. error 27 or 33
+	NotifyOutage      = "outage"           // Offline or the portal is unreachable longer than the grace period
+	NotifyRecovered   = "recovered"        // Online again after an outage notified
+	NotifyAutoLogout  = "auto_logout"      // A session is logged out by logout policy
+	NotifyDiagnosis   = "diagnosis_failed" // Basic checks fail in a diagnosis
+	NotifyIntruder    = "intruder"         // A session of unknown device is found in daemon mode
+
+	// Hooks run on state transitions
+	HookLoginSuccess = "on_login_success"
+	HookLoginFailure = "on_login_failure"
+	HookLogout       = "on_logout"    // A session is logged out by this program
+	HookOffline      = "on_offline"   // Internet becomes unavailable in daemon mode
+	HookOnline       = "on_online"    // Internet becomes available again in daemon mode
+	HookIpChange     = "on_ip_change" // IP address of current session changes in daemon mode
 )
 
+type UserAuthData struct {
+	Domain          string `yaml:"domain"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password,omitempty"`
+	PasswordSource  string `yaml:"password_source,omitempty"`
+	PasswordEnv     string `yaml:"password_env,omitempty"`
+	PasswordCommand string `yaml:"password_command,omitempty"`
+	PasswordFile    string `yaml:"password_file,omitempty"`
+	VaultFile       string `yaml:"vault_file,omitempty"`
+}
+
+// Account returns the username with domain used to login, e.g. 3120123456@xjtu
+func (authData *UserAuthData) Account() string {
+	return fmt.Sprintf("%s@%s", authData.Username, authData.Domain)
+}
+
+type UserProfile struct {
+	Name     string       `yaml:"name"`
+	AuthData UserAuthData `yaml:"auth_data"`
+}
+
 type UserOnlineSettings struct {
-	AuthData struct {
-		Domain   string `yaml:"domain"`
-		Username string `yaml:"username"`
-		Password string `yaml:"password"`
-	} `yaml:"auth_data"`
+	AuthData UserAuthData  `yaml:"auth_data"` // Auth data of the default profile
+	Profiles []UserProfile `yaml:"profiles,omitempty"`
+	Failover []string      `yaml:"failover,omitempty,flow"`
+
+	ActiveProfile string `yaml:"-"` // Name of the profile in use, empty for the default profile
+}
+
+// ProfileAuthData returns auth data of the named profile, the auth data outside profiles is the default profile
+func (onlineSettings *UserOnlineSettings) ProfileAuthData(name string) (*UserAuthData, error) {
+	if name == "" || name == DefaultProfile {
+		return &onlineSettings.AuthData, nil
+	}
+	for index := range onlineSettings.Profiles {
+		if onlineSettings.Profiles[index].Name == name {
+			return &onlineSettings.Profiles[index].AuthData, nil
+		}
+	}
+	return nil, errors.New(fmt.Sprintf("basic/config: Unknown profile [%s]", name))
+}
+
+// ActiveAuthData returns auth data of the profile in use
+func (onlineSettings *UserOnlineSettings) ActiveAuthData() *UserAuthData {
+	authData, err := onlineSettings.ProfileAuthData(onlineSettings.ActiveProfile)
+	if err != nil {
+		return &onlineSettings.AuthData
+	}
+	return authData
+}
+
+// ActiveProfileName returns the name of the profile in use
+func (onlineSettings *UserOnlineSettings) ActiveProfileName() string {
+	if onlineSettings.ActiveProfile == "" {
+		return DefaultProfile
+	}
+	return onlineSettings.ActiveProfile
+}
+
+// UseProfile switches the profile in use
+func (onlineSettings *UserOnlineSettings) UseProfile(name string) error {
+	if _, err := onlineSettings.ProfileAuthData(name); err != nil {
+		return err
+	}
+	if name == DefaultProfile {
+		name = ""
+	}
+	onlineSettings.ActiveProfile = name
+	return nil
 }
 
 type UserDeviceSettings struct {
-	KnownMacList []string `yaml:"known_mac_list,flow"`
-	UseInterface bool     `yaml:"use_interface"`
+	KnownMacList []KnownDevice `yaml:"known_mac_list"`
+	UseInterface bool          `yaml:"use_interface"`
+}
+
+// MacList returns MAC addresses of known devices in order
+func (deviceSettings *UserDeviceSettings) MacList() []string {
+	macList := make([]string, 0, len(deviceSettings.KnownMacList))
+	for _, knownDevice := range deviceSettings.KnownMacList {
+		macList = append(macList, knownDevice.Mac)
+	}
+	return macList
+}
+
+// KnownDevice is an item of known MAC list, given as a MAC address or a mapping with labels, e.g.
+// {mac: "11:22:33:44:55:66", name: Laptop, owner: Alice, protected: true}
+type KnownDevice struct {
+	Mac       string `json:"mac" yaml:"mac"`
+	Name      string `json:"name,omitempty" yaml:"name,omitempty"`
+	Owner     string `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Protected bool   `json:"protected,omitempty" yaml:"protected,omitempty"`
+}
+
+func (knownDevice *KnownDevice) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*knownDevice = KnownDevice{}
+		return node.Decode(&knownDevice.Mac)
+	}
+	type plainDevice KnownDevice
+	return node.Decode((*plainDevice)(knownDevice))
+}
+
+// MarshalYAML writes devices without labels as plain MAC addresses, the same as former versions
+func (knownDevice KnownDevice) MarshalYAML() (interface{}, error) {
+	if knownDevice.Name == "" && knownDevice.Owner == "" && !knownDevice.Protected {
+		return knownDevice.Mac, nil
+	}
+	type plainDevice KnownDevice
+	return plainDevice(knownDevice), nil
+}
+
+// Label returns name and owner of the device, e.g. "Laptop, Alice", empty if neither is given
+func (knownDevice *KnownDevice) Label() string {
+	labels := make([]string, 0, 2)
+	for _, label := range []string{knownDevice.Name, knownDevice.Owner} {
+		if label != "" {
+			labels = append(labels, label)
+		}
+	}
+	return strings.Join(labels, ", ")
+}
+
+type UserLogoutPolicySettings struct {
+	Rules            []string `yaml:"rules,flow"`
+	ProtectedMacList []string `yaml:"protected_mac_list,flow"`
+	DeviceTypeList   []string `yaml:"device_type_list,flow"`
+	CidrList         []string `yaml:"cidr_list,flow"`
+	ReportOnly       bool     `yaml:"report_only"`
+}
+
+type UserTokenSettings struct {
+	Lifetime  int    `yaml:"lifetime"`
+	StateFile string `yaml:"state_file"`
 }
 
 type UserPortalSettings struct {
-	IsAutoLogout bool `yaml:"auto_logout"`
+	IsAutoLogout bool                     `yaml:"auto_logout"`
+	LogoutPolicy UserLogoutPolicySettings `yaml:"logout_policy"`
+	Token        UserTokenSettings        `yaml:"token"`
+}
+
+type UserDaemonSettings struct {
+	Interval   int `yaml:"interval"`
+	MaxBackoff int `yaml:"max_backoff"`
+}
+
+type UserIntruderSettings struct {
+	Enabled    bool `yaml:"enabled"`
+	Interval   int  `yaml:"interval"` // Seconds between checks of session list
+	AutoLogout bool `yaml:"auto_logout"`
+}
+
+type UserNotifierSettings struct {
+	Type    string   `yaml:"type"`
+	Events  []string `yaml:"events,omitempty,flow"` // Events to notify, all events if empty
+	Timeout int      `yaml:"timeout,omitempty"`     // Seconds
+
+	// Webhook, the body is a Go template of the notification, e.g. {"text": {{json .Message}}}
+	Url     string            `yaml:"url,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Body    string            `yaml:"body,omitempty"`
+
+	// SMTP relay, e.g. localhost:25
+	Host     string   `yaml:"host,omitempty"`
+	Username string   `yaml:"username,omitempty"`
+	Password string   `yaml:"password,omitempty"`
+	From     string   `yaml:"from,omitempty"`
+	To       []string `yaml:"to,omitempty,flow"`
+	CaFile   string   `yaml:"ca_file,omitempty"` // PEM certificates trusted for STARTTLS besides system ones
+
+	// Shell command, the notification is given in environment variables
+	Command string `yaml:"command,omitempty"`
+}
+
+// BodyTemplate parses the body of webhook, nil if it is not given. Function json encodes a value in JSON, e.g.
+// {{json .Message}} gives a quoted string.
+func (notifierSettings *UserNotifierSettings) BodyTemplate() (*template.Template, error) {
+	if notifierSettings.Body == "" {
+		return nil, nil
+	}
+	return template.New("body").Funcs(template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			content, err := json.Marshal(value)
+			return string(content), err
+		},
+	}).Parse(notifierSettings.Body)
+}
+
+type UserNotifySettings struct {
+	GracePeriod int                    `yaml:"grace_period"` // Seconds an outage lasts before it is notified
+	Notifiers   []UserNotifierSettings `yaml:"notifiers"`
+}
+
+type UserHook struct {
+	Command string `yaml:"command"`
+	Timeout int    `yaml:"timeout"` // Seconds
+}
+
+// UserHookSettings are user scripts run on state transitions, hooks without command are disabled
+type UserHookSettings struct {
+	OnLoginSuccess UserHook `yaml:"on_login_success"`
+	OnLoginFailure UserHook `yaml:"on_login_failure"`
+	OnLogout       UserHook `yaml:"on_logout"`
+	OnOffline      UserHook `yaml:"on_offline"`
+	OnOnline       UserHook `yaml:"on_online"`
+	OnIpChange     UserHook `yaml:"on_ip_change"`
+}
+
+// Hooks returns all hooks by name, e.g. on_login_success
+func (hookSettings *UserHookSettings) Hooks() map[string]*UserHook {
+	return map[string]*UserHook{
+		HookLoginSuccess: &hookSettings.OnLoginSuccess,
+		HookLoginFailure: &hookSettings.OnLoginFailure,
+		HookLogout:       &hookSettings.OnLogout,
+		HookOffline:      &hookSettings.OnOffline,
+		HookOnline:       &hookSettings.OnOnline,
+		HookIpChange:     &hookSettings.OnIpChange,
+	}
+}
+
+type UserHistorySettings struct {
+	Enabled   *bool  `yaml:"enabled,omitempty"` // Enabled if not set
+	File      string `yaml:"file"`
+	Retention int    `yaml:"retention"` // Days, 0 to keep all records
+}
+
+// IsEnabled returns true unless session history is disabled explicitly
+func (historySettings *UserHistorySettings) IsEnabled() bool {
+	return historySettings.Enabled == nil || *historySettings.Enabled
+}
+
+type UserRedactSettings struct {
+	Password      *bool `yaml:"password,omitempty"`
+	Authorization *bool `yaml:"authorization,omitempty"`
+	Token         *bool `yaml:"token,omitempty"`
+	Mac           *bool `yaml:"mac,omitempty"`
+	Ip            *bool `yaml:"ip,omitempty"`
 }
 
 type UserLoggerSettings struct {
-	OutputWriter []string `yaml:"output_writer,flow"`
-	Level        string   `yaml:"level"`
-	FilePath     string   `yaml:"file_path"`
-	UseColor     bool     `yaml:"color"`
+	OutputWriter []string           `yaml:"output_writer,flow"`
+	Level        string             `yaml:"level"`
+	FilePath     string             `yaml:"file_path"`
+	UseColor     bool               `yaml:"color"`
+	Redact       UserRedactSettings `yaml:"redact"`
+}
+
+type UserApiSettings struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"`
+}
+
+type UserMetricsSettings struct {
+	Listen string `yaml:"listen"`
 }
 
 type UserUISettings struct {
-	Mode string `yaml:"mode"`
+	Mode                string              `yaml:"mode"`
+	UserApiSettings     UserApiSettings     `yaml:"api"`
+	UserMetricsSettings UserMetricsSettings `yaml:"metrics"`
 }
 
 type UserSettings struct {
+	Version            int                `yaml:"version"` // Version of config format, see ConfigVersion
 	UserOnlineSettings UserOnlineSettings `yaml:"online"`
 	UserDeviceSettings UserDeviceSettings `yaml:"device"`
 	UserAppSettings    struct {
-		UserPortalSettings UserPortalSettings `yaml:"portal"`
+		UserPortalSettings   UserPortalSettings   `yaml:"portal"`
+		UserDaemonSettings   UserDaemonSettings   `yaml:"daemon"`
+		UserHistorySettings  UserHistorySettings  `yaml:"history"`
+		UserIntruderSettings UserIntruderSettings `yaml:"intruder"`
+		UserNotifySettings   UserNotifySettings   `yaml:"notify"`
 	} `yaml:"app"`
+	UserHookSettings   UserHookSettings   `yaml:"hooks"`
 	UserLoggerSettings UserLoggerSettings `yaml:"logger"`
 	UserUISettings     UserUISettings     `yaml:"ui"`
 }
 
 func InitUserSettings(confPath string) (*UserSettings, error) {
-	userSettings := &UserSettings{}
-	userSettingFile, err := ioutil.ReadFile(confPath)
+	userSettings, _, err := initUserSettings(confPath)
+	return userSettings, err
+}
+
+// initUserSettings reads user settings, fails on issues other than warnings, which are returned
+func initUserSettings(confPath string) (*UserSettings, []*ConfigIssue, error) {
+	userSettings, validator, err := decodeUserSettings(confPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	err = yaml.Unmarshal(userSettingFile, userSettings)
-	if err != nil {
-		return nil, err
+	issues := validator.sortedIssues()
+	if errorIssues := ConfigErrors(issues); len(errorIssues) > 0 {
+		return nil, nil, configIssuesError(errorIssues)
 	}
-	return userSettings, nil
+	return userSettings, issues, nil
+}
+
+type ProgramRetrySettings struct {
+	Attempts   int     `yaml:"attempts"`
+	Backoff    int     `yaml:"backoff"`     // Milliseconds
+	MaxBackoff int     `yaml:"max_backoff"` // Milliseconds
+	Jitter     float64 `yaml:"jitter"`
 }
 
 type ProgramRequestSettings struct {
 	Header  map[string]string `yaml:"header"`
+	Timeout int               `yaml:"timeout"`
 	Connect struct {
 		Timeout int `yaml:"timeout"`
 	} `yaml:"connect"`
+	Retry ProgramRetrySettings `yaml:"retry"`
 }
 
 type ProgramDnsSettings struct {
@@ -178,9 +473,15 @@ type ProgramPortalSettings struct {
 }
 
 type ProgramDiagnosisSettings struct {
+	Timeout     int                             `yaml:"timeout"`
 	ErrorHandle map[string]map[int]ErrorHandler `yaml:"error_handle"`
 }
 
+type ProgramReloadSettings struct {
+	PollInterval int `yaml:"poll_interval"` // Seconds
+	Debounce     int `yaml:"debounce"`      // Milliseconds
+}
+
 type ProgramShellSettings struct {
 	InteractHint struct {
 		BasicHint struct {
@@ -191,16 +492,22 @@ type ProgramShellSettings struct {
 			Pause         string `yaml:"pause"`
 			Success       string `yaml:"success"`
 			Failed        string `yaml:"failed"`
+			Passphrase    string `yaml:"passphrase"`
+			Aborted       string `yaml:"aborted"`
 		} `yaml:"basic_hint"`
 		MainMenu struct {
 			Banner string `yaml:"banner"`
 		} `yaml:"main_menu"`
 		QuickSetting struct {
-			Banner     string `yaml:"banner"`
-			Username   string `yaml:"username"`
-			Password   string `yaml:"password"`
-			AutoLogout string `yaml:"auto_logout"`
-			Confirm    string `yaml:"confirm"`
+			Banner             string `yaml:"banner"`
+			Username           string `yaml:"username"`
+			Password           string `yaml:"password"`
+			AutoLogout         string `yaml:"auto_logout"`
+			Passphrase         string `yaml:"passphrase"`
+			PassphraseConfirm  string `yaml:"passphrase_confirm"`
+			PassphraseMismatch string `yaml:"passphrase_mismatch"`
+			PlainPassword      string `yaml:"plain_password"`
+			Confirm            string `yaml:"confirm"`
 		} `yaml:"quick_setting"`
 		SessionList struct {
 			Banner string `yaml:"banner"`
@@ -219,6 +526,7 @@ type ProgramShellSettings struct {
 }
 
 type ProgramSettings struct {
+	Version                     int                         `yaml:"version"` // Version of config format, see ConfigVersion
 	ProgramRequestSettings      ProgramRequestSettings      `yaml:"request"`
 	ProgramDnsSettings          ProgramDnsSettings          `yaml:"dns"`
 	ProgramConnectivitySettings ProgramConnectivitySettings `yaml:"connectivity"`
@@ -228,6 +536,7 @@ type ProgramSettings struct {
 	ProgramAppSettings          struct {
 		ProgramPortalSettings    ProgramPortalSettings    `yaml:"portal"`
 		ProgramDiagnosisSettings ProgramDiagnosisSettings `yaml:"diagnosis"`
+		ProgramReloadSettings    ProgramReloadSettings    `yaml:"reload"`
 	} `yaml:"app"`
 	ProgramUiSettings struct {
 		ProgramShellSettings ProgramShellSettings `yaml:"shell"`
@@ -235,21 +544,28 @@ type ProgramSettings struct {
 }
 
 func InitProgramSettings(confPath string) (*ProgramSettings, error) {
-	programSettings := &ProgramSettings{}
-	programSettingFile, err := ioutil.ReadFile(confPath)
+	programSettings, _, err := initProgramSettings(confPath)
+	return programSettings, err
+}
+
+// initProgramSettings reads program settings, fails on issues other than warnings, which are returned
+func initProgramSettings(confPath string) (*ProgramSettings, []*ConfigIssue, error) {
+	programSettings, validator, err := decodeProgramSettings(confPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	err = yaml.Unmarshal(programSettingFile, programSettings)
-	if err != nil {
-		return nil, err
+	issues := validator.sortedIssues()
+	if errorIssues := ConfigErrors(issues); len(errorIssues) > 0 {
+		return nil, nil, configIssuesError(errorIssues)
 	}
-	return programSettings, nil
+	return programSettings, issues, nil
 }
 
 type ConfigHelper struct {
 	UserSettings    *UserSettings
 	ProgramSettings *ProgramSettings
+	ConfigDir       string
+	Warnings        []*ConfigIssue // Warnings found when reading config files, e.g. ignored fields of former versions
 }
 
 func InitConfigHelper(
@@ -259,12 +575,12 @@ func InitConfigHelper(
 
 	configHelper := &ConfigHelper{}
 
-	userSettings, err := InitUserSettings(userSettingsFile)
+	userSettings, userWarnings, err := initUserSettings(userSettingsFile)
 	if err != nil {
 		err = errors.New(fmt.Sprintf("basic/config: Read User Settings config error [%v]", err))
 		return nil, err
 	}
-	programSettings, err := InitProgramSettings(programSettingsFile)
+	programSettings, programWarnings, err := initProgramSettings(programSettingsFile)
 	if err != nil {
 		err = errors.New(fmt.Sprintf("basic/config: Read Program Settings config error [%v]", err))
 		return nil, err
@@ -272,6 +588,8 @@ func InitConfigHelper(
 
 	configHelper.UserSettings = userSettings
 	configHelper.ProgramSettings = programSettings
+	configHelper.ConfigDir = filepath.Dir(userSettingsFile)
+	configHelper.Warnings = append(userWarnings, programWarnings...)
 
 	return configHelper, nil
 
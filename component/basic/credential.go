@@ -0,0 +1,356 @@
+package basic
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// Password sources
+	PlainSource   = "plain"
+	VaultSource   = "vault"
+	EnvSource     = "env"
+	CommandSource = "command"
+	FileSource    = "file"
+
+	// Environment variables
+	DefaultPasswordEnv = "XJTUPORTAL_PASSWORD"
+	VaultPassphraseEnv = "XJTUPORTAL_VAULT_PASSPHRASE"
+
+	DefaultVaultFile = "credentials.vault"
+
+	// Password commands are killed after timeout, so that a hanging command does not block login forever
+	passwordCommandTimeout = 30 * time.Second
+
+	// scrypt parameters recommended for interactive logins, vault files with weaker parameters are rejected.
+	// Maximums keep an edited file from exhausting memory or CPU.
+	vaultScryptN    = 32768
+	vaultScryptR    = 8
+	vaultScryptP    = 1
+	vaultScryptMaxN = 1 << 20
+	vaultScryptMaxR = 32
+	vaultScryptMaxP = 16
+	vaultKeyLen     = 32
+	// Version 2 authenticates the key derivation parameters, version 1 files are still read
+	vaultVersion       = 2
+	legacyVaultVersion = 1
+)
+
+var (
+	// PassphraseReader asks the user for the vault passphrase if it is not given in environment
+	PassphraseReader func() (string, error)
+)
+
+// CredentialProvider provides the password of an account
+type CredentialProvider interface {
+	Password(username string) (string, error)
+	Source() string
+}
+
+type plainCredentialProvider struct {
+	password string
+}
+
+func (provider *plainCredentialProvider) Password(_ string) (string, error) {
+	return provider.password, nil
+}
+
+func (provider *plainCredentialProvider) Source() string {
+	return PlainSource
+}
+
+type envCredentialProvider struct {
+	env string
+}
+
+func (provider *envCredentialProvider) Password(_ string) (string, error) {
+	password, ok := os.LookupEnv(provider.env)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("basic/credential: Environment variable [%s] is not set", provider.env))
+	}
+	return password, nil
+}
+
+func (provider *envCredentialProvider) Source() string {
+	return EnvSource
+}
+
+type commandCredentialProvider struct {
+	command string
+}
+
+func (provider *commandCredentialProvider) Password(_ string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordCommandTimeout)
+	defer cancel()
+	cmd := ShellCommand(ctx, provider.command)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := RunShell(ctx, cmd)
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", errors.New(fmt.Sprintf("basic/credential: Password command is killed after timeout of %v",
+			passwordCommandTimeout))
+	} else if err != nil {
+		return "", errors.New(fmt.Sprintf("basic/credential: Password command failed [%v] %s",
+			err, strings.TrimSpace(stderr.String())))
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+func (provider *commandCredentialProvider) Source() string {
+	return CommandSource
+}
+
+type fileCredentialProvider struct {
+	file string
+}
+
+func (provider *fileCredentialProvider) Password(_ string) (string, error) {
+	content, err := ioutil.ReadFile(provider.file)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("basic/credential: Cannot read password file [%v]", err))
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+func (provider *fileCredentialProvider) Source() string {
+	return FileSource
+}
+
+type vaultCredentialProvider struct {
+	vaultFile string
+}
+
+func (provider *vaultCredentialProvider) Password(username string) (string, error) {
+	passphrase, err := ReadVaultPassphrase()
+	if err != nil {
+		return "", err
+	}
+	secrets, err := LoadVault(provider.vaultFile, passphrase)
+	if err != nil {
+		return "", err
+	}
+	password, ok := secrets[username]
+	if !ok {
+		return "", errors.New(fmt.Sprintf("basic/credential: No password of [%s] in vault", username))
+	}
+	return password, nil
+}
+
+func (provider *vaultCredentialProvider) Source() string {
+	return VaultSource
+}
+
+// ResolvePath resolves a path relative to the config directory
+func ResolvePath(configDir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(configDir, path)
+}
+
+// VaultFilePath returns the path of vault file of auth data, relative paths are relative to configDir
+func VaultFilePath(configDir string, authData *UserAuthData) string {
+	vaultFile := authData.VaultFile
+	if vaultFile == "" {
+		vaultFile = DefaultVaultFile
+	}
+	return ResolvePath(configDir, vaultFile)
+}
+
+// InitCredentialProvider returns the credential provider of the profile in use
+func InitCredentialProvider(configHelper *ConfigHelper) (CredentialProvider, error) {
+
+	if configHelper == nil {
+		err := errors.New("basic/credential: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	return InitProfileCredentialProvider(configHelper.ConfigDir, configHelper.UserSettings.UserOnlineSettings.ActiveAuthData())
+}
+
+// InitProfileCredentialProvider returns the credential provider of given auth data, paths are relative to configDir
+func InitProfileCredentialProvider(configDir string, authData *UserAuthData) (CredentialProvider, error) {
+
+	if authData == nil {
+		err := errors.New("basic/credential: auth data is invalid")
+		return nil, err
+	}
+
+	switch authData.PasswordSource {
+	case "", PlainSource:
+		return &plainCredentialProvider{password: authData.Password}, nil
+	case VaultSource:
+		return &vaultCredentialProvider{vaultFile: VaultFilePath(configDir, authData)}, nil
+	case EnvSource:
+		env := authData.PasswordEnv
+		if env == "" {
+			env = DefaultPasswordEnv
+		}
+		return &envCredentialProvider{env: env}, nil
+	case CommandSource:
+		if authData.PasswordCommand == "" {
+			return nil, errors.New("basic/credential: password_command is empty")
+		}
+		return &commandCredentialProvider{command: authData.PasswordCommand}, nil
+	case FileSource:
+		if authData.PasswordFile == "" {
+			return nil, errors.New("basic/credential: password_file is empty")
+		}
+		return &fileCredentialProvider{file: ResolvePath(configDir, authData.PasswordFile)}, nil
+	default:
+		return nil, errors.New(fmt.Sprintf("basic/credential: Unknown password source [%s]", authData.PasswordSource))
+	}
+}
+
+// ReadVaultPassphrase reads passphrase from environment, or asks the user if possible
+func ReadVaultPassphrase() (string, error) {
+	if passphrase, ok := os.LookupEnv(VaultPassphraseEnv); ok {
+		return passphrase, nil
+	}
+	if PassphraseReader != nil {
+		return PassphraseReader()
+	}
+	return "", errors.New(fmt.Sprintf("basic/credential: Vault passphrase is not given, set [%s]", VaultPassphraseEnv))
+}
+
+type vaultFile struct {
+	Version    int    `json:"version"`
+	Kdf        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// additionalData returns the data authenticated along with the ciphertext, so that the key derivation parameters
+// cannot be modified without the passphrase
+func (vault *vaultFile) additionalData() []byte {
+	if vault.Version == legacyVaultVersion {
+		return nil
+	}
+	return []byte(fmt.Sprintf("xjtuportal-vault:%d:%s:%d:%d:%d", vault.Version, vault.Kdf, vault.N, vault.R, vault.P))
+}
+
+// checkParameters rejects key derivation parameters weaker than the built-in ones or too expensive to compute
+func (vault *vaultFile) checkParameters() error {
+	if vault.N < vaultScryptN || vault.N > vaultScryptMaxN || vault.R < vaultScryptR || vault.R > vaultScryptMaxR ||
+		vault.P < vaultScryptP || vault.P > vaultScryptMaxP {
+		return errors.New(fmt.Sprintf("basic/credential: Invalid scrypt parameters of vault N=%d r=%d p=%d",
+			vault.N, vault.R, vault.P))
+	}
+	return nil
+}
+
+func vaultCipher(passphrase string, salt []byte, n int, r int, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, vaultKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadVault decrypts the vault file, returns passwords keyed by username
+func LoadVault(path string, passphrase string) (secrets map[string]string, err error) {
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("basic/credential: Cannot read vault [%v]", err))
+	}
+
+	vault := &vaultFile{}
+	if err = json.Unmarshal(content, vault); err != nil {
+		return nil, errors.New(fmt.Sprintf("basic/credential: Invalid vault format [%v]", err))
+	}
+	if (vault.Version != vaultVersion && vault.Version != legacyVaultVersion) || vault.Kdf != "scrypt" {
+		return nil, errors.New(fmt.Sprintf("basic/credential: Unsupported vault version [%d]", vault.Version))
+	}
+	if err = vault.checkParameters(); err != nil {
+		return nil, err
+	}
+
+	aead, err := vaultCipher(passphrase, vault.Salt, vault.N, vault.R, vault.P)
+	if err != nil {
+		return nil, err
+	}
+	if len(vault.Nonce) != aead.NonceSize() {
+		return nil, errors.New("basic/credential: Invalid vault nonce")
+	}
+	plaintext, err := aead.Open(nil, vault.Nonce, vault.Ciphertext, vault.additionalData())
+	if err != nil {
+		return nil, errors.New("basic/credential: Wrong passphrase or corrupted vault")
+	}
+
+	secrets = make(map[string]string)
+	if err = json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, errors.New(fmt.Sprintf("basic/credential: Invalid vault content [%v]", err))
+	}
+	return secrets, nil
+}
+
+// SaveVault encrypts passwords keyed by username and writes them to the vault file
+func SaveVault(path string, passphrase string, secrets map[string]string) (err error) {
+
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	vault := &vaultFile{
+		Version: vaultVersion,
+		Kdf:     "scrypt",
+		N:       vaultScryptN,
+		R:       vaultScryptR,
+		P:       vaultScryptP,
+		Salt:    make([]byte, 16),
+	}
+	if _, err = rand.Read(vault.Salt); err != nil {
+		return err
+	}
+	aead, err := vaultCipher(passphrase, vault.Salt, vault.N, vault.R, vault.P)
+	if err != nil {
+		return err
+	}
+	vault.Nonce = make([]byte, aead.NonceSize())
+	if _, err = rand.Read(vault.Nonce); err != nil {
+		return err
+	}
+	vault.Ciphertext = aead.Seal(nil, vault.Nonce, plaintext, vault.additionalData())
+
+	content, err := json.MarshalIndent(vault, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0600)
+}
+
+// StoreVaultPassword adds or updates the password of an account in the vault file
+func StoreVaultPassword(path string, passphrase string, username string, password string) (err error) {
+	secrets := make(map[string]string)
+	if _, statErr := os.Stat(path); statErr == nil {
+		secrets, err = LoadVault(path, passphrase)
+		if err != nil {
+			return err
+		}
+	}
+	secrets[username] = password
+	return SaveVault(path, passphrase, secrets)
+}
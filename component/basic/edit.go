@@ -0,0 +1,69 @@
+package basic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+)
+
+// EditConfigFile sets values keyed by dotted path (e.g. online.auth_data.username) and removes fields of removed paths
+// in config file. Other fields and comments are kept as they are in the file, missing mappings are created.
+func EditConfigFile(confPath string, values map[string]interface{}, removed []string) error {
+
+	info, err := os.Stat(confPath)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		return err
+	}
+	root := &yaml.Node{}
+	if err = yaml.Unmarshal(content, root); err != nil {
+		return errors.New(fmt.Sprintf("basic/edit: Cannot parse [%s] [%v]", confPath, err))
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return errors.New(fmt.Sprintf("basic/edit: [%s] is not a mapping of settings", confPath))
+	}
+
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		value := &yaml.Node{}
+		if err = value.Encode(values[path]); err != nil {
+			return err
+		}
+		if parent, index := lookupNode(root.Content[0], path); parent != nil {
+			former := parent.Content[index+1]
+			value.HeadComment, value.LineComment, value.FootComment =
+				former.HeadComment, former.LineComment, former.FootComment
+		}
+		root.Content[0] = setNode(root.Content[0], strings.Split(path, "."), value)
+	}
+	for _, path := range removed {
+		if parent, index := lookupNode(root.Content[0], path); parent != nil {
+			parent.Content = append(parent.Content[:index], parent.Content[index+2:]...)
+		}
+	}
+
+	buffer := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buffer)
+	encoder.SetIndent(detectIndent(content))
+	if err = encoder.Encode(root); err != nil {
+		return err
+	}
+	_ = encoder.Close()
+	edited := buffer.Bytes()
+	if bytes.Contains(content, []byte("\n\n")) {
+		edited = spaceTopLevel(edited)
+	}
+	return ioutil.WriteFile(confPath, edited, info.Mode().Perm())
+}
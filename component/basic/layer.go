@@ -0,0 +1,317 @@
+package basic
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"xjtuportal/config"
+)
+
+const (
+	// Prefix of environment variables overriding user settings, e.g. XJTUPORTAL_ONLINE_AUTH_DATA_USERNAME
+	EnvPrefix = "XJTUPORTAL_"
+
+	// Origin of values given by embedded defaults or zero values
+	DefaultOrigin = "default"
+)
+
+// ConfigValue is a value in settings with where it comes from
+type ConfigValue struct {
+	File   string      `json:"file" yaml:"file"`
+	Path   string      `json:"path" yaml:"path"`
+	Value  interface{} `json:"value" yaml:"value"`
+	Origin string      `json:"origin" yaml:"origin"`
+}
+
+// decodeUserSettings decodes user settings file, overridden by environment variables
+func decodeUserSettings(confPath string) (*UserSettings, *configValidator, error) {
+	userSettings := &UserSettings{}
+	validator, err := decodeLayers(confPath, nil, true, userMigrations, userSettings)
+	if err != nil {
+		return nil, nil, err
+	}
+	if validator.parsed {
+		validateUserSettings(validator, userSettings)
+	}
+	if userSettings.Version == 0 { // Files without version are version 1
+		userSettings.Version = 1
+	}
+	return userSettings, validator, nil
+}
+
+// decodeProgramSettings decodes embedded default program settings, overridden by program settings file if it exists
+func decodeProgramSettings(confPath string) (*ProgramSettings, *configValidator, error) {
+	programSettings := &ProgramSettings{}
+	validator, err := decodeLayers(confPath, config.ProgramSettings, false, programMigrations, programSettings)
+	if err != nil {
+		return nil, nil, err
+	}
+	if validator.parsed {
+		validateProgramSettings(validator, programSettings)
+	}
+	return programSettings, validator, nil
+}
+
+// decodeLayers decodes settings from layers, each overriding the former: defaults (optional), the YAML file (optional
+// if defaults are given, upgraded by migrations in memory if it is outdated) and environment variables (if withEnv).
+// Unknown fields, syntax and type errors are reported as issues.
+func decodeLayers(confPath string, defaults []byte, withEnv bool, migrations []configMigration,
+	settings interface{}) (*configValidator, error) {
+
+	content, err := ioutil.ReadFile(confPath)
+	if err != nil && !(defaults != nil && os.IsNotExist(err)) {
+		return nil, err
+	}
+
+	settingsType := reflect.TypeOf(settings).Elem()
+	validator := newConfigValidator(confPath, settingsType)
+	for _, migration := range migrations {
+		validator.legacyPaths = append(validator.legacyPaths, migration.legacyPaths...)
+	}
+
+	fileRoot := &yaml.Node{}
+	if err = yaml.Unmarshal(content, fileRoot); err != nil {
+		validator.addYamlError(err)
+		return validator, nil
+	}
+	validator.parsed = true
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if defaults != nil {
+		defaultsRoot := &yaml.Node{}
+		if err = yaml.Unmarshal(defaults, defaultsRoot); err != nil || len(defaultsRoot.Content) == 0 {
+			return nil, errors.New(fmt.Sprintf("basic/layer: Invalid embedded defaults [%v]", err))
+		}
+		merged = defaultsRoot.Content[0]
+		validator.recordOrigins(merged, "", func(*yaml.Node) string {
+			return DefaultOrigin
+		})
+	}
+	if len(fileRoot.Content) > 0 {
+		migrateNode(fileRoot.Content[0], migrations)
+		validator.checkFields(fileRoot.Content[0], settingsType, "")
+		validator.recordOrigins(fileRoot.Content[0], "", func(key *yaml.Node) string {
+			return fmt.Sprintf("%s:%d", confPath, key.Line)
+		})
+		merged = mergeNode(merged, fileRoot.Content[0])
+	}
+	if withEnv {
+		merged = validator.applyEnv(merged)
+	}
+
+	if err = merged.Decode(settings); err != nil {
+		_, validator.parsed = err.(*yaml.TypeError)
+		validator.addYamlError(err)
+	}
+	return validator, nil
+}
+
+// mergeNode merges override into base recursively, mappings are merged by keys while other values are replaced
+func mergeNode(base *yaml.Node, override *yaml.Node) *yaml.Node {
+	if override.Tag == "!!null" {
+		return base
+	}
+	if base.Kind != yaml.MappingNode || override.Kind != yaml.MappingNode {
+		return override
+	}
+	for index := 0; index+1 < len(override.Content); index += 2 {
+		key, value := override.Content[index], override.Content[index+1]
+		found := false
+		for baseIndex := 0; baseIndex+1 < len(base.Content); baseIndex += 2 {
+			if base.Content[baseIndex].Value == key.Value {
+				base.Content[baseIndex+1] = mergeNode(base.Content[baseIndex+1], value)
+				found = true
+				break
+			}
+		}
+		if !found {
+			base.Content = append(base.Content, key, value)
+		}
+	}
+	return base
+}
+
+// recordOrigins records origin of each value in mapping node, lists are recorded as a whole
+func (validator *configValidator) recordOrigins(node *yaml.Node, path string, origin func(key *yaml.Node) string) {
+	for index := 0; node.Kind == yaml.MappingNode && index+1 < len(node.Content); index += 2 {
+		key, value := node.Content[index], node.Content[index+1]
+		switch {
+		case value.Tag == "!!null":
+		case value.Kind == yaml.MappingNode:
+			validator.recordOrigins(value, joinPath(path, key.Value), origin)
+		default:
+			validator.origins[joinPath(path, key.Value)] = origin(key)
+		}
+	}
+}
+
+// EnvName returns the environment variable overriding the path of user settings, e.g. XJTUPORTAL_APP_PORTAL_AUTO_LOGOUT
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(path))
+}
+
+// applyEnv overrides fields in root by environment variables, e.g. XJTUPORTAL_APP_PORTAL_AUTO_LOGOUT=true.
+// Lists are given as comma separated values or YAML flow sequences. Unknown variables are ignored.
+func (validator *configValidator) applyEnv(root *yaml.Node) *yaml.Node {
+
+	fieldPaths := make(map[string]string)
+	for path := range validator.knownFields {
+		fieldPaths[EnvName(path)] = path
+	}
+
+	environ := os.Environ()
+	sort.Strings(environ)
+	for _, env := range environ {
+		pair := strings.SplitN(env, "=", 2)
+		path, ok := fieldPaths[pair[0]]
+		if !ok || len(pair) != 2 {
+			continue
+		}
+		validator.envNames[path] = pair[0]
+
+		fieldType := validator.knownFields[path]
+		value, err := envValueNode(pair[1], fieldType)
+		if err == nil {
+			err = value.Decode(reflect.New(fieldType).Interface())
+		}
+		if err != nil {
+			message := strings.TrimPrefix(err.Error(), "yaml: ")
+			if typeError, ok := err.(*yaml.TypeError); ok {
+				message = yamlLineRegex.ReplaceAllString(typeError.Errors[0], "")
+			}
+			validator.add(path, message, fmt.Sprintf("check the value of environment variable %s", pair[0]))
+			continue
+		}
+
+		root = setNode(root, strings.Split(path, "."), value)
+		validator.origins[path] = "$" + pair[0]
+	}
+	return root
+}
+
+func envValueNode(value string, fieldType reflect.Type) (*yaml.Node, error) {
+	trimmed := strings.TrimSpace(value)
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		root := &yaml.Node{}
+		if err := yaml.Unmarshal([]byte(trimmed), root); err != nil {
+			return nil, err
+		}
+		return root.Content[0], nil
+	}
+	if fieldType.Kind() == reflect.Slice {
+		node := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: item})
+			}
+		}
+		return node, nil
+	}
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	if fieldType.Kind() == reflect.String {
+		node.Tag = "!!str"
+	}
+	return node, nil
+}
+
+// setNode sets value at path in mapping node, missing mappings are created
+func setNode(node *yaml.Node, path []string, value *yaml.Node) *yaml.Node {
+	if len(path) == 0 {
+		return value
+	}
+	if node == nil || node.Kind != yaml.MappingNode {
+		node = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	}
+	for index := 0; index+1 < len(node.Content); index += 2 {
+		if node.Content[index].Value == path[0] {
+			node.Content[index+1] = setNode(node.Content[index+1], path[1:], value)
+			return node
+		}
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: path[0]}
+	node.Content = append(node.Content, key, setNode(nil, path[1:], value))
+	return node
+}
+
+// DumpConfig returns values in settings with their origins, values not given by config files or environment variables
+// are included only if effective is true. Passwords, tokens and headers of webhooks are masked.
+func DumpConfig(userSettingsFile string, programSettingsFile string, effective bool) ([]*ConfigValue, error) {
+
+	userSettings, userValidator, err := decodeUserSettings(userSettingsFile)
+	if err != nil {
+		return nil, err
+	}
+	programSettings, programValidator, err := decodeProgramSettings(programSettingsFile)
+	if err != nil {
+		return nil, err
+	}
+	if issues := ConfigErrors(append(userValidator.sortedIssues(), programValidator.sortedIssues()...)); len(issues) > 0 {
+		return nil, configIssuesError(issues)
+	}
+
+	values := make([]*ConfigValue, 0)
+	for _, layer := range []struct {
+		validator *configValidator
+		settings  interface{}
+	}{{userValidator, userSettings}, {programValidator, programSettings}} {
+		node := &yaml.Node{}
+		if err = node.Encode(layer.settings); err != nil {
+			return nil, err
+		}
+		values = append(values, layer.validator.values(node, "", effective)...)
+	}
+	return values, nil
+}
+
+func (validator *configValidator) values(node *yaml.Node, path string, effective bool) []*ConfigValue {
+
+	values := make([]*ConfigValue, 0)
+	switch node.Kind {
+	case yaml.MappingNode:
+		for index := 0; index+1 < len(node.Content); index += 2 {
+			keyPath := joinPath(path, node.Content[index].Value)
+			values = append(values, validator.values(node.Content[index+1], keyPath, effective)...)
+		}
+		return values
+	case yaml.SequenceNode:
+		if !scalarSequence(node) {
+			for index, item := range node.Content {
+				values = append(values, validator.values(item, fmt.Sprintf("%s[%d]", path, index), effective)...)
+			}
+			return values
+		}
+	}
+
+	origin := validator.origin(path)
+	if !effective && origin == DefaultOrigin {
+		return values
+	}
+	var value interface{}
+	_ = node.Decode(&value)
+	if text, ok := value.(string); ok && text != "" && secretPath(path) {
+		value = redactMask
+	}
+	return append(values, &ConfigValue{File: validator.file, Path: path, Value: value, Origin: origin})
+}
+
+// secretPath returns true if the value at path is a password or token, e.g. online.auth_data.password or headers of
+// webhooks like app.notify.notifiers[0].headers.Authorization
+func secretPath(path string) bool {
+	return strings.HasSuffix(path, ".password") || path == "ui.api.token" ||
+		(strings.HasPrefix(path, "app.notify.notifiers[") && strings.Contains(path, ".headers."))
+}
+
+// scalarSequence returns true if node is a list of scalars, which is dumped as a whole
+func scalarSequence(node *yaml.Node) bool {
+	for _, item := range node.Content {
+		if item.Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,18 @@
+package basic
+
+import "os"
+
+// LockFile blocks until the lock shared by processes using path is held, and returns the function releasing it. The
+// lock is taken on a separate path+".lock" file, so path itself can be replaced while the lock is held.
+func LockFile(path string) (unlock func(), err error) {
+
+	file, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return func() { file.Close() }, nil
+}
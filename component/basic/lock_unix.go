@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package basic
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile blocks until an exclusive lock on file is held, the lock is released when file is closed
+func lockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+}
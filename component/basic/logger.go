@@ -9,6 +9,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"time"
 	"xjtuportal/component/utils"
 )
@@ -34,6 +35,9 @@ type logLevelHelper struct {
 
 type LoggerHelper struct {
 	logger                *log.Logger
+	logFile               io.Writer
+	isStdout              bool
+	redactor              *Redactor
 	userLoggerSettings    *UserLoggerSettings
 	programLoggerSettings *ProgramLoggerSettings
 }
@@ -56,7 +60,8 @@ var (
 		MUTE:    {},
 	}
 	LoggerTemp = &LoggerHelper{
-		logger: log.New(os.Stdout, "", 0),
+		logger:   log.New(os.Stdout, "", 0),
+		redactor: InitRedactor(nil),
 		userLoggerSettings: &UserLoggerSettings{
 			Level:    "FATAL",
 			UseColor: true,
@@ -86,6 +91,7 @@ func InitLoggerHelper(configHelper *ConfigHelper) (*LoggerHelper, error) {
 	log.SetOutput(ioutil.Discard)
 
 	loggerHelper := &LoggerHelper{
+		redactor:              InitRedactor(&configHelper.UserSettings.UserLoggerSettings.Redact),
 		userLoggerSettings:    &configHelper.UserSettings.UserLoggerSettings,
 		programLoggerSettings: &configHelper.ProgramSettings.ProgramLoggerSettings,
 	}
@@ -107,15 +113,12 @@ func InitLoggerHelper(configHelper *ConfigHelper) (*LoggerHelper, error) {
 		if err != nil {
 			return nil, err
 		}
-		if isStdout {
-			logWriter := io.MultiWriter(logFile, os.Stdout)
-			loggerHelper.logger = log.New(logWriter, "", 0)
-		} else {
-			loggerHelper.logger = log.New(logFile, "", 0)
-		}
+		loggerHelper.logFile = logFile
+		loggerHelper.isStdout = isStdout
 	} else {
-		loggerHelper.logger = log.New(os.Stdout, "", 0)
+		loggerHelper.isStdout = true
 	}
+	loggerHelper.SetConsoleWriter(os.Stdout)
 
 	// Set log level
 	if val, ok := logLevelNumbers[loggerHelper.userLoggerSettings.Level]; ok {
@@ -128,6 +131,35 @@ func InitLoggerHelper(configHelper *ConfigHelper) (*LoggerHelper, error) {
 
 }
 
+// SameSettings returns true if a logger initialized with configHelper would be the same, so this one can be kept when
+// config is reloaded
+func (loggerHelper *LoggerHelper) SameSettings(configHelper *ConfigHelper) bool {
+	programLoggerSettings := configHelper.ProgramSettings.ProgramLoggerSettings
+	programLoggerSettings.LogLevelNumber = loggerHelper.programLoggerSettings.LogLevelNumber // Set from user settings
+	return reflect.DeepEqual(*loggerHelper.userLoggerSettings, configHelper.UserSettings.UserLoggerSettings) &&
+		reflect.DeepEqual(*loggerHelper.programLoggerSettings, programLoggerSettings)
+}
+
+// Close closes the log file, logs added after are lost
+func (loggerHelper *LoggerHelper) Close() error {
+	if closer, ok := loggerHelper.logFile.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// SetConsoleWriter replaces the console output (stdout by default) of the logger
+func (loggerHelper *LoggerHelper) SetConsoleWriter(consoleWriter io.Writer) {
+	switch {
+	case loggerHelper.logFile != nil && loggerHelper.isStdout:
+		loggerHelper.logger = log.New(io.MultiWriter(loggerHelper.logFile, consoleWriter), "", 0)
+	case loggerHelper.logFile != nil:
+		loggerHelper.logger = log.New(loggerHelper.logFile, "", 0)
+	default:
+		loggerHelper.logger = log.New(consoleWriter, "", 0)
+	}
+}
+
 func (loggerHelper *LoggerHelper) SetLogLevel(loglevel int) {
 	loggerHelper.programLoggerSettings.LogLevelNumber = loglevel
 }
@@ -142,6 +174,10 @@ func (loggerHelper *LoggerHelper) AddLog(logLevel int, logInfo string) {
 	if logLevel >= loggerHelper.programLoggerSettings.LogLevelNumber {
 
 		datetime := time.Now().Format(loggerHelper.programLoggerSettings.OutputFormat.Datetime)
+		// Mask secrets before truncation, so no partial secret is left
+		if loggerHelper.redactor != nil {
+			logInfo = loggerHelper.redactor.Redact(logInfo)
+		}
 		infoRune := []rune(logInfo)
 		totalLen := len(infoRune)
 		if totalLen > loggerHelper.programLoggerSettings.MaxInfoLength {
@@ -0,0 +1,156 @@
+package basic
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	GaugeMetric   = "gauge"
+	CounterMetric = "counter"
+)
+
+// Metric names
+const (
+	MetricHttpCheckUp         = "xjtuportal_http_check_up"
+	MetricHttpCheckStatusCode = "xjtuportal_http_check_status_code"
+	MetricDnsServerUp         = "xjtuportal_dns_server_up"
+	MetricDnsServerLatency    = "xjtuportal_dns_server_latency_seconds"
+	MetricSessions            = "xjtuportal_sessions"
+	MetricSessionConcurrency  = "xjtuportal_session_concurrency_limit"
+	MetricLoginAttempts       = "xjtuportal_login_attempts_total"
+	MetricAutoLogouts         = "xjtuportal_auto_logouts_total"
+	MetricIntruders           = "xjtuportal_intruders_total"
+)
+
+type metricFamily struct {
+	help       string
+	metricType string
+	values     map[string]float64 // Formatted labels -> value
+}
+
+// MetricsHelper is a minimal metrics registry exposed in Prometheus text format
+type MetricsHelper struct {
+	mutex    sync.Mutex
+	families map[string]*metricFamily
+}
+
+var (
+	// Metrics is the registry shared by all components
+	Metrics = InitMetricsHelper()
+)
+
+func InitMetricsHelper() *MetricsHelper {
+	metricsHelper := &MetricsHelper{
+		families: make(map[string]*metricFamily),
+	}
+	metricsHelper.register(MetricHttpCheckUp, GaugeMetric,
+		"Whether the last HTTP connectivity check succeeded (1) or not (0).")
+	metricsHelper.register(MetricHttpCheckStatusCode, GaugeMetric,
+		"HTTP status code of the last connectivity check, -1 if the request failed.")
+	metricsHelper.register(MetricDnsServerUp, GaugeMetric,
+		"Whether the DNS server answered all test queries in the last check.")
+	metricsHelper.register(MetricDnsServerLatency, GaugeMetric,
+		"Average query latency of the DNS server in the last check.")
+	metricsHelper.register(MetricSessions, GaugeMetric,
+		"Number of online sessions of the account.")
+	metricsHelper.register(MetricSessionConcurrency, GaugeMetric,
+		"Max number of concurrent sessions allowed by the portal.")
+	metricsHelper.register(MetricLoginAttempts, CounterMetric,
+		"Login attempts by mapped status code.")
+	metricsHelper.register(MetricAutoLogouts, CounterMetric,
+		"Sessions logged out automatically due to session overload.")
+	metricsHelper.register(MetricIntruders, CounterMetric,
+		"Sessions of unknown devices found in daemon mode.")
+	return metricsHelper
+}
+
+func (metricsHelper *MetricsHelper) register(name string, metricType string, help string) {
+	metricsHelper.families[name] = &metricFamily{
+		help:       help,
+		metricType: metricType,
+		values:     make(map[string]float64),
+	}
+}
+
+// formatLabels formats labels given in key-value pairs, sorted by key
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(labels[i+1])))
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+// SetGauge sets the value of a gauge, labels are given in key-value pairs
+func (metricsHelper *MetricsHelper) SetGauge(name string, value float64, labels ...string) {
+	metricsHelper.mutex.Lock()
+	defer metricsHelper.mutex.Unlock()
+	if family, ok := metricsHelper.families[name]; ok {
+		family.values[formatLabels(labels)] = value
+	}
+}
+
+// AddCounter increases the value of a counter, labels are given in key-value pairs
+func (metricsHelper *MetricsHelper) AddCounter(name string, delta float64, labels ...string) {
+	metricsHelper.mutex.Lock()
+	defer metricsHelper.mutex.Unlock()
+	if family, ok := metricsHelper.families[name]; ok {
+		family.values[formatLabels(labels)] += delta
+	}
+}
+
+// Value returns the current value of a metric, labels are given in key-value pairs
+func (metricsHelper *MetricsHelper) Value(name string, labels ...string) (value float64, ok bool) {
+	metricsHelper.mutex.Lock()
+	defer metricsHelper.mutex.Unlock()
+	family, ok := metricsHelper.families[name]
+	if !ok {
+		return 0, false
+	}
+	value, ok = family.values[formatLabels(labels)]
+	return
+}
+
+// Expose writes all metrics in Prometheus text format
+func (metricsHelper *MetricsHelper) Expose() string {
+	metricsHelper.mutex.Lock()
+	defer metricsHelper.mutex.Unlock()
+
+	names := make([]string, 0, len(metricsHelper.families))
+	for name := range metricsHelper.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		family := metricsHelper.families[name]
+		buf.WriteString(fmt.Sprintf("# HELP %s %s\n", name, family.help))
+		buf.WriteString(fmt.Sprintf("# TYPE %s %s\n", name, family.metricType))
+		labelsList := make([]string, 0, len(family.values))
+		for labels := range family.values {
+			labelsList = append(labelsList, labels)
+		}
+		sort.Strings(labelsList)
+		for _, labels := range labelsList {
+			buf.WriteString(fmt.Sprintf("%s%s %s\n", name, labels,
+				strconv.FormatFloat(family.values[labels], 'g', -1, 64)))
+		}
+	}
+	return buf.String()
+}
+
+func (metricsHelper *MetricsHelper) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = writer.Write([]byte(metricsHelper.Expose()))
+}
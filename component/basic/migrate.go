@@ -0,0 +1,302 @@
+package basic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ConfigVersion is the version of current config format, increased when fields are moved or removed.
+// Files without version are version 1.
+const ConfigVersion = 2
+
+// MigrationResult describes how a config file is upgraded to current version
+type MigrationResult struct {
+	File        string   `json:"file" yaml:"file"`
+	FromVersion int      `json:"from_version" yaml:"from_version"`
+	ToVersion   int      `json:"to_version" yaml:"to_version"`
+	Changes     []string `json:"changes" yaml:"changes"`
+	Backup      string   `json:"backup,omitempty" yaml:"backup,omitempty"`
+}
+
+// configMigration upgrades a config file from the former version to version
+type configMigration struct {
+	version     int
+	migrate     func(root *yaml.Node) []string
+	legacyPaths []string // Fields moved or removed by the migration
+}
+
+var (
+	userMigrations = []configMigration{
+		{version: 2, migrate: moveSessionToApp, legacyPaths: []string{"session"}},
+	}
+	programMigrations = []configMigration{
+		{version: 2, migrate: removeUpdateCheckHint, legacyPaths: []string{"ui.shell.interact_hint.update_check"}},
+		{version: 2, migrate: resetDnsRetryTimes},
+	}
+)
+
+// MigrateUserSettings upgrades user settings file to current version, see migrateFile
+func MigrateUserSettings(confPath string, dryRun bool) (*MigrationResult, error) {
+	return migrateFile(confPath, userMigrations, dryRun)
+}
+
+// MigrateProgramSettings upgrades program settings file to current version if it exists, see migrateFile
+func MigrateProgramSettings(confPath string, dryRun bool) (*MigrationResult, error) {
+	if _, err := os.Stat(confPath); os.IsNotExist(err) {
+		return &MigrationResult{File: confPath, FromVersion: ConfigVersion, ToVersion: ConfigVersion, Changes: []string{}}, nil
+	}
+	return migrateFile(confPath, programMigrations, dryRun)
+}
+
+// migrateFile rewrites config file in current format, comments are kept except those of removed fields. The original
+// file is copied to a backup file first. Nothing is written if the file is up to date or dryRun is true.
+func migrateFile(confPath string, migrations []configMigration, dryRun bool) (*MigrationResult, error) {
+
+	info, err := os.Stat(confPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(confPath)
+	if err != nil {
+		return nil, err
+	}
+	root := &yaml.Node{}
+	if err = yaml.Unmarshal(content, root); err != nil {
+		return nil, errors.New(fmt.Sprintf("basic/migrate: Cannot parse [%s] [%v]", confPath, err))
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, errors.New(fmt.Sprintf("basic/migrate: [%s] is not a mapping of settings", confPath))
+	}
+
+	result := &MigrationResult{File: confPath, ToVersion: ConfigVersion}
+	result.FromVersion, result.Changes = migrateNode(root.Content[0], migrations)
+	if result.FromVersion > ConfigVersion {
+		return nil, errors.New(fmt.Sprintf("basic/migrate: Version [%d] of [%s] is newer than supported version [%d]",
+			result.FromVersion, confPath, ConfigVersion))
+	}
+	if result.FromVersion == ConfigVersion {
+		return result, nil
+	}
+	setVersion(root.Content[0], ConfigVersion)
+	result.Changes = append(result.Changes, fmt.Sprintf("set version to %d", ConfigVersion))
+	if dryRun {
+		return result, nil
+	}
+
+	buffer := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buffer)
+	encoder.SetIndent(detectIndent(content))
+	if err = encoder.Encode(root); err != nil {
+		return nil, err
+	}
+	_ = encoder.Close()
+	migrated := buffer.Bytes()
+	if bytes.Contains(content, []byte("\n\n")) {
+		migrated = spaceTopLevel(migrated)
+	}
+
+	result.Backup = backupPath(confPath, result.FromVersion)
+	if err = ioutil.WriteFile(result.Backup, content, info.Mode().Perm()); err != nil {
+		return nil, errors.New(fmt.Sprintf("basic/migrate: Cannot write backup [%v]", err))
+	}
+	if err = ioutil.WriteFile(confPath, migrated, info.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// migrateNode applies migrations newer than the version of root in place, returns the original version and changes
+func migrateNode(root *yaml.Node, migrations []configMigration) (int, []string) {
+	version := nodeVersion(root)
+	changes := make([]string, 0)
+	for _, migration := range migrations {
+		if migration.version > version && migration.version <= ConfigVersion {
+			changes = append(changes, migration.migrate(root)...)
+		}
+	}
+	return version, changes
+}
+
+// nodeVersion returns the version given in root, 1 if not given. Invalid versions are reported by validator and
+// regarded as current version so that no migration is applied.
+func nodeVersion(root *yaml.Node) int {
+	index := mappingIndex(root, "version")
+	if index < 0 {
+		return 1
+	}
+	version, err := strconv.Atoi(root.Content[index+1].Value)
+	if err != nil || version < 1 {
+		return ConfigVersion
+	}
+	return version
+}
+
+func setVersion(root *yaml.Node, version int) {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+	if index := mappingIndex(root, "version"); index >= 0 {
+		root.Content[index+1] = value
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	key.LineComment = "Version of config format, do not modify"
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+// mappingIndex returns the index of key in mapping node, -1 if not found
+func mappingIndex(node *yaml.Node, key string) int {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return -1
+	}
+	for index := 0; index+1 < len(node.Content); index += 2 {
+		if node.Content[index].Value == key {
+			return index
+		}
+	}
+	return -1
+}
+
+// lookupNode returns the mapping containing path and the index of its key, nil and -1 if not found
+func lookupNode(root *yaml.Node, path string) (*yaml.Node, int) {
+	keys := strings.Split(path, ".")
+	node := root
+	for _, key := range keys[:len(keys)-1] {
+		index := mappingIndex(node, key)
+		if index < 0 {
+			return nil, -1
+		}
+		node = node.Content[index+1]
+	}
+	index := mappingIndex(node, keys[len(keys)-1])
+	if index < 0 {
+		return nil, -1
+	}
+	return node, index
+}
+
+// moveSessionToApp moves session block to app in user settings. Early samples put auto_logout in session.portal,
+// which was never read.
+func moveSessionToApp(root *yaml.Node) []string {
+	sessionIndex := mappingIndex(root, "session")
+	if sessionIndex < 0 {
+		return nil
+	}
+	session := root.Content[sessionIndex+1]
+	appIndex := mappingIndex(root, "app")
+	if appIndex < 0 || root.Content[appIndex+1].Kind != yaml.MappingNode {
+		if appIndex >= 0 {
+			root.Content[appIndex+1] = session
+			root.Content = append(root.Content[:sessionIndex], root.Content[sessionIndex+2:]...)
+		} else {
+			root.Content[sessionIndex].Value = "app"
+		}
+		return []string{"moved session to app"}
+	}
+	changes := moveFields(root.Content[appIndex+1], session, "session", "app")
+	root.Content = append(root.Content[:sessionIndex], root.Content[sessionIndex+2:]...)
+	return changes
+}
+
+// moveFields moves fields in mapping from into mapping to, fields given in both are kept in to
+func moveFields(to *yaml.Node, from *yaml.Node, fromPath string, toPath string) []string {
+	if from.Kind != yaml.MappingNode {
+		return []string{fmt.Sprintf("removed %s", fromPath)}
+	}
+	changes := make([]string, 0)
+	for index := 0; index+1 < len(from.Content); index += 2 {
+		key, value := from.Content[index], from.Content[index+1]
+		fromKeyPath, toKeyPath := joinPath(fromPath, key.Value), joinPath(toPath, key.Value)
+		toIndex := mappingIndex(to, key.Value)
+		switch {
+		case toIndex < 0:
+			to.Content = append(to.Content, key, value)
+			changes = append(changes, fmt.Sprintf("moved %s to %s", fromKeyPath, toKeyPath))
+		case to.Content[toIndex+1].Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			changes = append(changes, moveFields(to.Content[toIndex+1], value, fromKeyPath, toKeyPath)...)
+		default:
+			changes = append(changes, fmt.Sprintf("removed %s, %s is kept", fromKeyPath, toKeyPath))
+		}
+	}
+	return changes
+}
+
+// removeUpdateCheckHint removes hints of update check in program settings, which has been removed from the program
+func removeUpdateCheckHint(root *yaml.Node) []string {
+	parent, index := lookupNode(root, "ui.shell.interact_hint.update_check")
+	if parent == nil {
+		return nil
+	}
+	parent.Content = append(parent.Content[:index], parent.Content[index+2:]...)
+	return []string{"removed ui.shell.interact_hint.update_check"}
+}
+
+// resetDnsRetryTimes resets dns.testing.retry_times in program settings from the former sample value 10, which was
+// not used before version 2 and makes DNS tests too slow now
+func resetDnsRetryTimes(root *yaml.Node) []string {
+	parent, index := lookupNode(root, "dns.testing.retry_times")
+	if parent == nil || parent.Content[index+1].Value != "10" {
+		return nil
+	}
+	key := parent.Content[index]
+	parent.Content[index+1] = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: "2"}
+	if strings.Contains(key.HeadComment, "Not in used") {
+		key.HeadComment = "# Times to retry a DNS query after timeouts"
+	}
+	return []string{"reset dns.testing.retry_times to 2"}
+}
+
+// detectIndent returns the indent of the first indented key in content, 2 if not found
+func detectIndent(content []byte) int {
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if indent := len(line) - len(trimmed); indent > 0 && trimmed != "" && !strings.HasPrefix(trimmed, "#") &&
+			!strings.HasPrefix(trimmed, "-") {
+			return indent
+		}
+	}
+	return 2
+}
+
+// spaceTopLevel puts an empty line before each top level key and its comments, which are dropped by the encoder
+func spaceTopLevel(content []byte) []byte {
+	lines := strings.Split(string(content), "\n")
+	spaced := make([]string, 0, len(lines))
+	blockStart := -1 // Index of the first comment line before current line in spaced
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			if blockStart < 0 {
+				blockStart = len(spaced)
+			}
+		case line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "-"):
+			start := blockStart
+			if start < 0 {
+				start = len(spaced)
+			}
+			if start > 0 && spaced[start-1] != "" {
+				spaced = append(spaced[:start], append([]string{""}, spaced[start:]...)...)
+			}
+			blockStart = -1
+		default:
+			blockStart = -1
+		}
+		spaced = append(spaced, line)
+	}
+	return []byte(strings.Join(spaced, "\n"))
+}
+
+// backupPath returns a path not used yet to back up config file of version, e.g. user-settings.yaml.v1.bak
+func backupPath(confPath string, version int) string {
+	path := fmt.Sprintf("%s.v%d.bak", confPath, version)
+	for count := 1; ; count++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s.v%d.bak.%d", confPath, version, count)
+	}
+}
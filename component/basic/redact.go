@@ -0,0 +1,81 @@
+package basic
+
+import (
+	"regexp"
+)
+
+const (
+	redactMask = "******"
+)
+
+type redactRule struct {
+	regex       *regexp.Regexp
+	replacement string
+}
+
+var (
+	passwordRules = []redactRule{
+		{regexp.MustCompile(`(?i)("[a-z]*password"\s*:\s*")(?:[^"\\]|\\.)*(")`), "${1}" + redactMask + "${2}"},
+		{regexp.MustCompile(`(?i)(password=)[^&\s;]*`), "${1}" + redactMask},
+	}
+	authorizationRules = []redactRule{
+		{regexp.MustCompile(`(?i)(Authorization:\[)[^\]]*(\])`), "${1}" + redactMask + "${2}"},
+		{regexp.MustCompile(`(?i)(Authorization:\s*)[^\s\]]+`), "${1}" + redactMask},
+	}
+	tokenRules = []redactRule{
+		{regexp.MustCompile(`(?i)("token"\s*:\s*")(?:[^"\\]|\\.)*(")`), "${1}" + redactMask + "${2}"},
+		{regexp.MustCompile(`(?i)(\btoken=)[^;\s\]&]*`), "${1}" + redactMask},
+		{regexp.MustCompile(`(?i)(\btoken: \[)[^\]]*(\])`), "${1}" + redactMask + "${2}"},
+	}
+	macRules = []redactRule{
+		{regexp.MustCompile(`\b([0-9a-fA-F]{2}[:-][0-9a-fA-F]{2}[:-][0-9a-fA-F]{2})[:-][0-9a-fA-F]{2}[:-][0-9a-fA-F]{2}[:-][0-9a-fA-F]{2}\b`),
+			"${1}:**:**:**"},
+	}
+	ipRules = []redactRule{
+		{regexp.MustCompile(`\b((?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?))\.(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\.(?:25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)\b`),
+			"${1}.*.*"},
+	}
+)
+
+// Redactor masks secrets in log records
+type Redactor struct {
+	rules []redactRule
+}
+
+func isRedactEnabled(setting *bool, defaultValue bool) bool {
+	if setting == nil {
+		return defaultValue
+	}
+	return *setting
+}
+
+// InitRedactor creates a redactor by settings, passwords, authorization headers and tokens are masked by default
+func InitRedactor(redactSettings *UserRedactSettings) *Redactor {
+	if redactSettings == nil {
+		redactSettings = &UserRedactSettings{}
+	}
+	redactor := &Redactor{rules: make([]redactRule, 0)}
+	if isRedactEnabled(redactSettings.Password, true) {
+		redactor.rules = append(redactor.rules, passwordRules...)
+	}
+	if isRedactEnabled(redactSettings.Authorization, true) {
+		redactor.rules = append(redactor.rules, authorizationRules...)
+	}
+	if isRedactEnabled(redactSettings.Token, true) {
+		redactor.rules = append(redactor.rules, tokenRules...)
+	}
+	if isRedactEnabled(redactSettings.Mac, false) {
+		redactor.rules = append(redactor.rules, macRules...)
+	}
+	if isRedactEnabled(redactSettings.Ip, false) {
+		redactor.rules = append(redactor.rules, ipRules...)
+	}
+	return redactor
+}
+
+func (redactor *Redactor) Redact(info string) string {
+	for _, rule := range redactor.rules {
+		info = rule.regex.ReplaceAllString(info, rule.replacement)
+	}
+	return info
+}
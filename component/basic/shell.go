@@ -0,0 +1,34 @@
+package basic
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+)
+
+// ShellCommand runs command by sh, or cmd on Windows
+func ShellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == Windows {
+		return exec.CommandContext(ctx, "cmd", "/c", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// RunShell runs the command built by ShellCommand, the shell and all commands started by it are killed once ctx is
+// done. Otherwise commands left running keep the output open and block the wait.
+func RunShell(ctx context.Context, cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-finished:
+		}
+	}()
+	return cmd.Wait()
+}
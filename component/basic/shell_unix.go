@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package basic
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the shell in a new process group, so commands started by it can be killed together
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
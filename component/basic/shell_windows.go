@@ -0,0 +1,12 @@
+package basic
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills the shell only, commands started by it are left running
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,697 @@
+package basic
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	logoutPolicyRules = []string{KnownMacRule, UnknownMacRule, OldestRule, NewestRule, DeviceTypeRule, CidrRule}
+	passwordSources   = []string{PlainSource, VaultSource, EnvSource, CommandSource, FileSource}
+	uiModes           = []string{InteractMode, CommandMode}
+	outputWriters     = []string{STDOUT, FILE}
+	notifierTypes     = []string{WebhookNotifier, SmtpNotifier, CommandNotifier, DesktopNotifier}
+	notifyEvents      = []string{NotifyLoginFailed, NotifyOutage, NotifyRecovered, NotifyAutoLogout, NotifyDiagnosis, NotifyIntruder}
+
+	yamlLineRegex = regexp.MustCompile(`line (\d+): `)
+)
+
+// ConfigIssue is a problem found in a config file, with a suggested fix if possible
+type ConfigIssue struct {
+	File       string `json:"file" yaml:"file"`
+	Line       int    `json:"line,omitempty" yaml:"line,omitempty"`
+	Path       string `json:"path,omitempty" yaml:"path,omitempty"`
+	Message    string `json:"message" yaml:"message"`
+	Suggestion string `json:"suggestion,omitempty" yaml:"suggestion,omitempty"`
+	Warning    bool   `json:"warning,omitempty" yaml:"warning,omitempty"` // Warnings do not stop the program
+}
+
+func (issue *ConfigIssue) String() string {
+	location := issue.File
+	if issue.Line > 0 {
+		location = fmt.Sprintf("%s:%d", issue.File, issue.Line)
+	}
+	if issue.Warning {
+		location += ": warning"
+	}
+	text := fmt.Sprintf("%s: %s", location, issue.Message)
+	if issue.Path != "" {
+		text = fmt.Sprintf("%s: [%s] %s", location, issue.Path, issue.Message)
+	}
+	if issue.Suggestion != "" {
+		text = fmt.Sprintf("%s (%s)", text, issue.Suggestion)
+	}
+	return text
+}
+
+// configIssuesError joins issues into one error
+func configIssuesError(issues []*ConfigIssue) error {
+	lines := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		lines = append(lines, issue.String())
+	}
+	return errors.New(fmt.Sprintf("basic/validate: Invalid config\n%s", strings.Join(lines, "\n")))
+}
+
+// ConfigErrors returns issues except warnings
+func ConfigErrors(issues []*ConfigIssue) []*ConfigIssue {
+	errorIssues := make([]*ConfigIssue, 0, len(issues))
+	for _, issue := range issues {
+		if !issue.Warning {
+			errorIssues = append(errorIssues, issue)
+		}
+	}
+	return errorIssues
+}
+
+// ValidateConfig checks config files strictly, returns all issues found including warnings
+func ValidateConfig(userSettingsFile string, programSettingsFile string) (issues []*ConfigIssue, err error) {
+
+	_, userValidator, err := decodeUserSettings(userSettingsFile)
+	if err != nil {
+		return nil, err
+	}
+	_, programValidator, err := decodeProgramSettings(programSettingsFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(userValidator.sortedIssues(), programValidator.sortedIssues()...), nil
+}
+
+type configValidator struct {
+	file        string
+	lines       map[string]int          // Line of each path, e.g. app.portal.auto_logout or device.known_mac_list[0]
+	origins     map[string]string       // Origin of each value, i.e. default, file:line or environment variable
+	envNames    map[string]string       // Environment variable overriding each path
+	knownFields map[string]reflect.Type // Paths of all fields in settings, fields of lists and maps are not expanded
+	legacyPaths []string                // Paths of fields in former versions, reported as warnings if given
+	parsed      bool                    // Values are checked only if the file is parsed
+	issues      []*ConfigIssue
+}
+
+func newConfigValidator(file string, settingsType reflect.Type) *configValidator {
+	return &configValidator{
+		file:        file,
+		lines:       make(map[string]int),
+		origins:     make(map[string]string),
+		envNames:    make(map[string]string),
+		knownFields: knownFields(settingsType, ""),
+	}
+}
+
+func (validator *configValidator) add(path string, message string, suggestion string) {
+	validator.addIssue(path, message, suggestion, false)
+}
+
+func (validator *configValidator) warn(path string, message string, suggestion string) {
+	validator.addIssue(path, message, suggestion, true)
+}
+
+func (validator *configValidator) addIssue(path string, message string, suggestion string, warning bool) {
+	issue := &ConfigIssue{
+		File:       validator.file,
+		Line:       validator.line(path),
+		Path:       path,
+		Message:    message,
+		Suggestion: suggestion,
+		Warning:    warning,
+	}
+	for envPath, envName := range validator.envNames {
+		if path == envPath || strings.HasPrefix(path, envPath+"[") {
+			issue.File, issue.Line = "$"+envName, 0
+		}
+	}
+	validator.issues = append(validator.issues, issue)
+}
+
+func (validator *configValidator) sortedIssues() []*ConfigIssue {
+	sort.SliceStable(validator.issues, func(i, j int) bool {
+		return validator.issues[i].Line < validator.issues[j].Line
+	})
+	return validator.issues
+}
+
+// legacy returns true if path is a field of former versions or a field in it
+func (validator *configValidator) legacy(path string) bool {
+	for _, legacyPath := range validator.legacyPaths {
+		if path == legacyPath || strings.HasPrefix(path, legacyPath+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// line returns the line of path, or the line of its nearest parent if it is not given in the file
+func (validator *configValidator) line(path string) int {
+	for ; path != ""; path = parentPath(path) {
+		if line, ok := validator.lines[path]; ok {
+			return line
+		}
+	}
+	return 0
+}
+
+// origin returns the origin of path, or the origin of its nearest parent if it is not given in any source
+func (validator *configValidator) origin(path string) string {
+	for ; path != ""; path = parentPath(path) {
+		if origin, ok := validator.origins[path]; ok {
+			return origin
+		}
+	}
+	return DefaultOrigin
+}
+
+// parentPath returns the parent of path, e.g. device for device.known_mac_list and device.known_mac_list for
+// device.known_mac_list[0], empty for top level paths
+func parentPath(path string) string {
+	index := strings.LastIndexAny(path, ".[")
+	if index < 0 {
+		return ""
+	}
+	return path[:index]
+}
+
+func (validator *configValidator) addYamlError(err error) {
+	messages := []string{err.Error()}
+	if typeError, ok := err.(*yaml.TypeError); ok {
+		messages = typeError.Errors
+	}
+	for _, message := range messages {
+		issue := &ConfigIssue{File: validator.file, Message: strings.TrimPrefix(message, "yaml: ")}
+		if match := yamlLineRegex.FindStringSubmatch(message); match != nil {
+			issue.Line, _ = strconv.Atoi(match[1])
+			issue.Message = strings.TrimPrefix(issue.Message, match[0])
+			issue.Path = validator.pathAt(issue.Line)
+		}
+		if strings.Contains(issue.Message, "cannot unmarshal") {
+			issue.Suggestion = "check the type of the value, e.g. quote strings and use lists for list fields"
+		}
+		validator.issues = append(validator.issues, issue)
+	}
+}
+
+// pathAt returns the deepest path given at line, empty if not found
+func (validator *configValidator) pathAt(line int) string {
+	found := ""
+	for path, pathLine := range validator.lines {
+		if pathLine == line && len(path) > len(found) {
+			found = path
+		}
+	}
+	return found
+}
+
+// yamlFieldName returns the YAML key of struct field, empty if it is ignored
+func yamlFieldName(field reflect.StructField) string {
+	name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if name == "-" || field.PkgPath != "" {
+		return ""
+	}
+	if name == "" {
+		name = strings.ToLower(field.Name)
+	}
+	return name
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// knownFields returns paths and types of all fields in settings type, fields of lists and maps are not expanded
+func knownFields(valueType reflect.Type, path string) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+	if valueType.Kind() != reflect.Struct {
+		fields[path] = valueType
+		return fields
+	}
+	for index := 0; index < valueType.NumField(); index++ {
+		name := yamlFieldName(valueType.Field(index))
+		if name == "" {
+			continue
+		}
+		for fieldPath, fieldType := range knownFields(valueType.Field(index).Type, joinPath(path, name)) {
+			fields[fieldPath] = fieldType
+		}
+	}
+	return fields
+}
+
+// leafPaths returns paths of all scalar values in node
+func leafPaths(node *yaml.Node, path string) []string {
+	if node.Kind != yaml.MappingNode {
+		return []string{path}
+	}
+	paths := make([]string, 0)
+	for index := 0; index+1 < len(node.Content); index += 2 {
+		paths = append(paths, leafPaths(node.Content[index+1], joinPath(path, node.Content[index].Value))...)
+	}
+	return paths
+}
+
+// checkFields records lines of paths and reports keys unknown to the settings type
+func (validator *configValidator) checkFields(node *yaml.Node, valueType reflect.Type, path string) {
+
+	for valueType.Kind() == reflect.Ptr {
+		valueType = valueType.Elem()
+	}
+
+	switch {
+	case node.Kind == yaml.MappingNode && valueType.Kind() == reflect.Struct:
+		fields := make(map[string]reflect.Type)
+		for index := 0; index < valueType.NumField(); index++ {
+			if name := yamlFieldName(valueType.Field(index)); name != "" {
+				fields[name] = valueType.Field(index).Type
+			}
+		}
+		for index := 0; index+1 < len(node.Content); index += 2 {
+			key, value := node.Content[index], node.Content[index+1]
+			keyPath := joinPath(path, key.Value)
+			validator.lines[keyPath] = key.Line
+			fieldType, ok := fields[key.Value]
+			if !ok && validator.legacy(keyPath) { // Given in a file of current version, migrations are not applied
+				validator.warn(keyPath, "field of former versions is ignored",
+					validator.suggestField(key.Value, value, path, fields))
+				continue
+			} else if !ok {
+				validator.add(keyPath, "unknown field", validator.suggestField(key.Value, value, path, fields))
+				continue
+			}
+			validator.checkFields(value, fieldType, keyPath)
+		}
+	case node.Kind == yaml.MappingNode && valueType.Kind() == reflect.Map:
+		for index := 0; index+1 < len(node.Content); index += 2 {
+			keyPath := joinPath(path, node.Content[index].Value)
+			validator.lines[keyPath] = node.Content[index].Line
+			validator.checkFields(node.Content[index+1], valueType.Elem(), keyPath)
+		}
+	case node.Kind == yaml.SequenceNode && valueType.Kind() == reflect.Slice:
+		for index, item := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, index)
+			validator.lines[itemPath] = item.Line
+			validator.checkFields(item, valueType.Elem(), itemPath)
+		}
+	}
+}
+
+// suggestField suggests the right place of a field misplaced (e.g. session.portal.auto_logout for
+// app.portal.auto_logout), or a field with similar name in the same place
+func (validator *configValidator) suggestField(key string, value *yaml.Node, path string, fields map[string]reflect.Type) string {
+
+	for _, leaf := range leafPaths(value, key) {
+		segments := strings.Split(leaf, ".")
+		for start := 0; start < len(segments); start++ {
+			suffix := strings.Join(segments[start:], ".")
+			matches := make([]string, 0)
+			for knownPath := range validator.knownFields {
+				if knownPath == suffix || strings.HasSuffix(knownPath, "."+suffix) {
+					matches = append(matches, knownPath)
+				}
+			}
+			if len(matches) == 1 && matches[0] != joinPath(path, leaf) {
+				return fmt.Sprintf("did you mean [%s]?", matches[0])
+			}
+			if len(matches) > 1 {
+				break
+			}
+		}
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if closest := closestName(key, names); closest != "" {
+		return fmt.Sprintf("did you mean [%s]?", closest)
+	}
+	if len(names) == 0 {
+		return "remove it"
+	}
+	return fmt.Sprintf("remove it or use one of: %s", strings.Join(names, ", "))
+}
+
+// closestName returns the name with the least edit distance to given name, empty if none is similar enough
+func closestName(name string, names []string) string {
+	closest, closestDistance := "", len(name)/3+1
+	for _, candidate := range names {
+		if distance := editDistance(strings.ToLower(name), strings.ToLower(candidate)); distance <= closestDistance &&
+			(closest == "" || distance < editDistance(strings.ToLower(name), strings.ToLower(closest))) {
+			closest = candidate
+		}
+	}
+	return closest
+}
+
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (validator *configValidator) checkRange(path string, value int, min int, max int) {
+	if value < min || value > max {
+		validator.add(path, fmt.Sprintf("value %d is out of range", value), fmt.Sprintf("use a value from %d to %d", min, max))
+	}
+}
+
+// checkVersion checks version of config format, 0 is allowed since files without version are version 1
+func (validator *configValidator) checkVersion(version int) {
+	if version > ConfigVersion {
+		validator.add("version", fmt.Sprintf("version %d is newer than supported version %d", version, ConfigVersion),
+			"upgrade xjtuportal or restore the backup of this file")
+	} else if version < 0 {
+		validator.add("version", fmt.Sprintf("value %d is out of range", version), fmt.Sprintf("use %d", ConfigVersion))
+	}
+}
+
+func (validator *configValidator) checkOneOf(path string, value string, allowed []string, allowEmpty bool) {
+	if value == "" && allowEmpty {
+		return
+	}
+	for _, name := range allowed {
+		if value == name {
+			return
+		}
+	}
+	suggestion := fmt.Sprintf("use one of: %s", strings.Join(allowed, ", "))
+	if closest := closestName(value, allowed); closest != "" {
+		suggestion = fmt.Sprintf("did you mean [%s]?", closest)
+	}
+	validator.add(path, fmt.Sprintf("unknown value [%s]", value), suggestion)
+}
+
+func (validator *configValidator) checkNotEmpty(path string, value string, suggestion string) {
+	if value == "" {
+		validator.add(path, "value is empty", suggestion)
+	}
+}
+
+func (validator *configValidator) checkUrl(path string, value string) {
+	parsedUrl, err := url.Parse(value)
+	if err != nil || (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") || parsedUrl.Host == "" {
+		validator.add(path, fmt.Sprintf("invalid URL [%s]", value), "use an absolute http(s) URL, e.g. http://10.184.6.32/")
+	}
+}
+
+func (validator *configValidator) checkUrlPath(path string, value string) {
+	if !strings.HasPrefix(value, "/") {
+		validator.add(path, fmt.Sprintf("invalid URL path [%s]", value), fmt.Sprintf("start it with /, e.g. /%s", value))
+	}
+}
+
+func (validator *configValidator) checkMac(path string, value string) {
+	if mac, err := net.ParseMAC(value); err != nil || len(mac) != 6 {
+		validator.add(path, fmt.Sprintf("invalid MAC address [%s]", value), "use format like aa:bb:cc:dd:ee:ff")
+	}
+}
+
+func (validator *configValidator) checkIpOrCidr(path string, value string) {
+	if strings.Contains(value, "/") {
+		if _, _, err := net.ParseCIDR(value); err == nil {
+			return
+		}
+	} else if net.ParseIP(value) != nil {
+		return
+	}
+	validator.add(path, fmt.Sprintf("invalid CIDR [%s]", value), "use format like 10.181.0.0/16 or a single IP address")
+}
+
+// checkHost checks host with optional port, e.g. 10.6.39.2 or 10.6.39.2:53
+func (validator *configValidator) checkHost(path string, value string, ipOnly bool) {
+	host := value
+	if strings.Contains(value, ":") && net.ParseIP(value) == nil {
+		var port string
+		var err error
+		if host, port, err = net.SplitHostPort(value); err != nil || !validPort(port) {
+			validator.add(path, fmt.Sprintf("invalid address [%s]", value), "use host or host:port with port from 1 to 65535")
+			return
+		}
+	}
+	if ipOnly && net.ParseIP(host) == nil {
+		validator.add(path, fmt.Sprintf("invalid IP address [%s]", value), "use format like 10.6.39.2 or 10.6.39.2:53")
+	} else if host == "" || strings.ContainsAny(host, "/ ") {
+		validator.add(path, fmt.Sprintf("invalid host [%s]", value), "use host name or IP address without scheme and path")
+	}
+}
+
+func validPort(port string) bool {
+	number, err := strconv.Atoi(port)
+	return err == nil && number >= 1 && number <= 65535
+}
+
+// checkListen checks listen address host:port, only loopback address or unix socket is allowed if loopbackOnly
+func (validator *configValidator) checkListen(path string, value string, loopbackOnly bool) {
+	if value == "" {
+		return
+	}
+	if loopbackOnly && strings.HasPrefix(value, "unix:") {
+		if strings.TrimPrefix(value, "unix:") == "" {
+			validator.add(path, "empty unix socket path", "use format like unix:/run/xjtuportal.sock")
+		}
+		return
+	}
+	host, port, err := net.SplitHostPort(value)
+	if err != nil || !validPort(port) {
+		validator.add(path, fmt.Sprintf("invalid listen address [%s]", value), "use format like 127.0.0.1:8350")
+		return
+	}
+	if ip := net.ParseIP(host); loopbackOnly && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		validator.add(path, fmt.Sprintf("non-loopback listen address [%s]", value),
+			"use a loopback address like 127.0.0.1 or a unix socket like unix:/run/xjtuportal.sock")
+	}
+}
+
+func (validator *configValidator) checkRetry(path string, retrySettings *ProgramRetrySettings) {
+	validator.checkRange(path+".attempts", retrySettings.Attempts, 0, 10)
+	validator.checkRange(path+".backoff", retrySettings.Backoff, 0, 60000)
+	validator.checkRange(path+".max_backoff", retrySettings.MaxBackoff, 0, 600000)
+	if retrySettings.Jitter < 0 || retrySettings.Jitter > 1 {
+		validator.add(path+".jitter", fmt.Sprintf("value %v is out of range", retrySettings.Jitter), "use a value from 0 to 1")
+	}
+}
+
+func (validator *configValidator) checkNotifier(path string, notifierSettings *UserNotifierSettings) {
+	validator.checkOneOf(path+".type", notifierSettings.Type, notifierTypes, false)
+	for index, event := range notifierSettings.Events {
+		validator.checkOneOf(fmt.Sprintf("%s.events[%d]", path, index), event, notifyEvents, false)
+	}
+	validator.checkRange(path+".timeout", notifierSettings.Timeout, 0, 300)
+	switch notifierSettings.Type {
+	case WebhookNotifier:
+		validator.checkUrl(path+".url", notifierSettings.Url)
+		if _, err := notifierSettings.BodyTemplate(); err != nil {
+			validator.add(path+".body", fmt.Sprintf("invalid template [%v]", err),
+				`use Go template, e.g. {"text": {{json .Message}}}`)
+		}
+	case SmtpNotifier:
+		validator.checkHost(path+".host", notifierSettings.Host, false)
+		validator.checkNotEmpty(path+".from", notifierSettings.From, "set the sender address")
+		if len(notifierSettings.To) == 0 {
+			validator.add(path+".to", "value is empty", "set the recipient addresses")
+		}
+	case CommandNotifier:
+		validator.checkNotEmpty(path+".command", notifierSettings.Command, "set the command run for notifications")
+	}
+}
+
+func (validator *configValidator) checkAuthData(path string, authData *UserAuthData) {
+	if authData.Username != "" {
+		validator.checkNotEmpty(path+".domain", authData.Domain, "set it to xjtu")
+	}
+	validator.checkOneOf(path+".password_source", authData.PasswordSource, passwordSources, true)
+	switch authData.PasswordSource {
+	case CommandSource:
+		validator.checkNotEmpty(path+".password_command", authData.PasswordCommand, "set the command printing the password")
+	case FileSource:
+		validator.checkNotEmpty(path+".password_file", authData.PasswordFile, "set the file containing the password")
+	}
+}
+
+func validateUserSettings(validator *configValidator, userSettings *UserSettings) {
+
+	validator.checkVersion(userSettings.Version)
+
+	onlineSettings := &userSettings.UserOnlineSettings
+	validator.checkAuthData("online.auth_data", &onlineSettings.AuthData)
+	profileNames := []string{DefaultProfile}
+	for index := range onlineSettings.Profiles {
+		profile := &onlineSettings.Profiles[index]
+		path := fmt.Sprintf("online.profiles[%d]", index)
+		for _, name := range profileNames {
+			if profile.Name == name {
+				validator.add(path+".name", fmt.Sprintf("duplicated profile name [%s]", name), "use another name")
+			}
+		}
+		validator.checkNotEmpty(path+".name", profile.Name, "set a name used by -profile flag")
+		profileNames = append(profileNames, profile.Name)
+		validator.checkAuthData(path+".auth_data", &profile.AuthData)
+	}
+	for index, name := range onlineSettings.Failover {
+		validator.checkOneOf(fmt.Sprintf("online.failover[%d]", index), name, profileNames, false)
+	}
+
+	for index, knownDevice := range userSettings.UserDeviceSettings.KnownMacList {
+		path := fmt.Sprintf("device.known_mac_list[%d]", index)
+		if _, ok := validator.lines[path+".mac"]; ok || knownDevice.Label() != "" || knownDevice.Protected { // Given as a mapping
+			path += ".mac"
+		}
+		validator.checkMac(path, knownDevice.Mac)
+	}
+
+	portalSettings := &userSettings.UserAppSettings.UserPortalSettings
+	for index, rule := range portalSettings.LogoutPolicy.Rules {
+		validator.checkOneOf(fmt.Sprintf("app.portal.logout_policy.rules[%d]", index), rule, logoutPolicyRules, false)
+	}
+	for index, mac := range portalSettings.LogoutPolicy.ProtectedMacList {
+		validator.checkMac(fmt.Sprintf("app.portal.logout_policy.protected_mac_list[%d]", index), mac)
+	}
+	for index, cidr := range portalSettings.LogoutPolicy.CidrList {
+		validator.checkIpOrCidr(fmt.Sprintf("app.portal.logout_policy.cidr_list[%d]", index), cidr)
+	}
+	validator.checkRange("app.portal.token.lifetime", portalSettings.Token.Lifetime, 0, 86400)
+
+	daemonSettings := &userSettings.UserAppSettings.UserDaemonSettings
+	validator.checkRange("app.daemon.interval", daemonSettings.Interval, 0, 86400)
+	validator.checkRange("app.daemon.max_backoff", daemonSettings.MaxBackoff, 0, 86400)
+	validator.checkRange("app.history.retention", userSettings.UserAppSettings.UserHistorySettings.Retention, 0, 3650)
+	validator.checkRange("app.intruder.interval", userSettings.UserAppSettings.UserIntruderSettings.Interval, 0, 86400)
+
+	notifySettings := &userSettings.UserAppSettings.UserNotifySettings
+	validator.checkRange("app.notify.grace_period", notifySettings.GracePeriod, 0, 86400)
+	for index := range notifySettings.Notifiers {
+		validator.checkNotifier(fmt.Sprintf("app.notify.notifiers[%d]", index), &notifySettings.Notifiers[index])
+	}
+
+	for name, hook := range userSettings.UserHookSettings.Hooks() {
+		validator.checkRange("hooks."+name+".timeout", hook.Timeout, 0, 3600)
+	}
+
+	loggerSettings := &userSettings.UserLoggerSettings
+	for index, writer := range loggerSettings.OutputWriter {
+		validator.checkOneOf(fmt.Sprintf("logger.output_writer[%d]", index), writer, outputWriters, false)
+	}
+	validator.checkOneOf("logger.level", loggerSettings.Level, logLevelNames(), true)
+
+	uiSettings := &userSettings.UserUISettings
+	validator.checkOneOf("ui.mode", uiSettings.Mode, uiModes, true)
+	validator.checkListen("ui.api.listen", uiSettings.UserApiSettings.Listen, true)
+	validator.checkListen("ui.metrics.listen", uiSettings.UserMetricsSettings.Listen, false)
+}
+
+func validateProgramSettings(validator *configValidator, programSettings *ProgramSettings) {
+
+	validator.checkVersion(programSettings.Version)
+
+	requestSettings := &programSettings.ProgramRequestSettings
+	validator.checkRange("request.timeout", requestSettings.Timeout, 0, 300)
+	validator.checkRange("request.connect.timeout", requestSettings.Connect.Timeout, 0, 300)
+	validator.checkRetry("request.retry", &requestSettings.Retry)
+
+	dnsSettings := &programSettings.ProgramDnsSettings
+	validator.checkRange("dns.connect.timeout", dnsSettings.Connect.Timeout, 0, 60)
+	validator.checkRange("dns.testing.retry_times", dnsSettings.Testing.RetryTimes, 0, 10)
+
+	connectivitySettings := &programSettings.ProgramConnectivitySettings
+	validator.checkUrl("connectivity.http.internet", connectivitySettings.Http.Internet)
+	validator.checkUrl("connectivity.http.intranet", connectivitySettings.Http.Intranet)
+	validator.checkNotEmpty("connectivity.dns.domain.internet", connectivitySettings.Dns.Domain.Internet, "e.g. baidu.com")
+	validator.checkNotEmpty("connectivity.dns.domain.intranet", connectivitySettings.Dns.Domain.Intranet, "e.g. p.xjtu.edu.cn")
+	for index, server := range connectivitySettings.Dns.Server.Internet {
+		validator.checkHost(fmt.Sprintf("connectivity.dns.server.internet[%d]", index), server, true)
+	}
+	for index, server := range connectivitySettings.Dns.Server.Intranet {
+		validator.checkHost(fmt.Sprintf("connectivity.dns.server.intranet[%d]", index), server, true)
+	}
+	validator.checkUrl("connectivity.proxy.test_url", connectivitySettings.Proxy.TestUrl)
+	validator.checkRange("connectivity.proxy.timeout", connectivitySettings.Proxy.Timeout, 0, 60)
+	for ports := range connectivitySettings.Proxy.Ports {
+		portRange := strings.SplitN(ports, ":", 2)
+		valid := validPort(portRange[0])
+		if len(portRange) == 2 {
+			start, _ := strconv.Atoi(portRange[0])
+			end, _ := strconv.Atoi(portRange[1])
+			valid = valid && validPort(portRange[1]) && start <= end
+		}
+		if !valid {
+			validator.add("connectivity.proxy.ports."+ports, fmt.Sprintf("invalid port range [%s]", ports),
+				"use a port like 3128 or a range like 1080:1083, ports are from 1 to 65535")
+		}
+	}
+
+	onlineSettings := &programSettings.ProgramOnlineSettings
+	validator.checkUrl("online.bootstrap_url", onlineSettings.BootStrapUrl)
+	validator.checkHost("online.portal_server.hostname", onlineSettings.PortalServer.Hostname, false)
+	validator.checkUrlPath("online.portal_server.fake_redirect_path", onlineSettings.PortalServer.FakeRedirectPath)
+	validator.checkUrlPath("online.portal_server.online_path", onlineSettings.PortalServer.OnlinePath)
+
+	sessionSettings := &programSettings.ProgramSessionSettings
+	validator.checkUrl("session.portal_server.hostname", sessionSettings.PortalServer.Hostname)
+	validator.checkUrlPath("session.portal_server.session_list_path", sessionSettings.PortalServer.SessionListPath)
+	validator.checkUrlPath("session.portal_server.logout_path", sessionSettings.PortalServer.LogoutPath)
+	validator.checkUrl("session.speed_check_server.hostname", sessionSettings.SpeedCheckServer.Hostname)
+	validator.checkUrlPath("session.speed_check_server.get_ip_path", sessionSettings.SpeedCheckServer.GetIpPath)
+
+	validator.checkRange("logger.max_info_length", programSettings.ProgramLoggerSettings.MaxInfoLength, 0, 1<<20)
+
+	appSettings := &programSettings.ProgramAppSettings
+	validator.checkRange("app.diagnosis.timeout", appSettings.ProgramDiagnosisSettings.Timeout, 0, 600)
+	validator.checkRange("app.reload.poll_interval", appSettings.ProgramReloadSettings.PollInterval, 1, 3600)
+	validator.checkRange("app.reload.debounce", appSettings.ProgramReloadSettings.Debounce, 0, 60000)
+	validator.checkErrorHandle("app.portal.error_handle", appSettings.ProgramPortalSettings.ErrorHandle)
+	validator.checkErrorHandle("app.diagnosis.error_handle", appSettings.ProgramDiagnosisSettings.ErrorHandle)
+}
+
+func (validator *configValidator) checkErrorHandle(path string, errorHandle map[string]map[int]ErrorHandler) {
+	for group, errorHandleMap := range errorHandle {
+		for statusCode, errorHandler := range errorHandleMap {
+			validator.checkOneOf(fmt.Sprintf("%s.%s.%d.log_level", path, group, statusCode),
+				errorHandler.LogLevel, logLevelNames(), false)
+		}
+	}
+}
+
+func logLevelNames() []string {
+	names := make([]string, 0, len(logLevelNumbers))
+	for name := range logLevelNumbers {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return logLevelNumbers[names[i]] < logLevelNumbers[names[j]]
+	})
+	return names
+}
@@ -0,0 +1,158 @@
+package basic
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ConfigWatcher notifies changes of config files, by inotify on Linux or by polling if inotify is unavailable
+type ConfigWatcher struct {
+	loggerHelper *LoggerHelper
+	configDir    string
+	files        []string
+	pollInterval time.Duration
+	debounce     time.Duration
+
+	changeChan chan struct{}
+	stopChan   chan struct{}
+	stopOnce   sync.Once
+}
+
+func InitConfigWatcher(configHelper *ConfigHelper, loggerHelper *LoggerHelper) (*ConfigWatcher, error) {
+
+	if configHelper == nil {
+		err := errors.New("basic/watch: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("basic/watch: logger is invalid")
+		return nil, err
+	}
+
+	reloadSettings := &configHelper.ProgramSettings.ProgramAppSettings.ProgramReloadSettings
+	pollInterval := reloadSettings.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = 5
+	}
+
+	configWatcher := &ConfigWatcher{
+		loggerHelper: loggerHelper,
+		configDir:    configHelper.ConfigDir,
+		files:        []string{UserConfigFile, ProgramConfigFile},
+		pollInterval: time.Duration(pollInterval) * time.Second,
+		debounce:     time.Duration(reloadSettings.Debounce) * time.Millisecond,
+		changeChan:   make(chan struct{}, 1),
+		stopChan:     make(chan struct{}),
+	}
+	return configWatcher, nil
+}
+
+// Changes returns the channel receiving a value after config files are changed, changes in a row are merged
+func (configWatcher *ConfigWatcher) Changes() <-chan struct{} {
+	return configWatcher.changeChan
+}
+
+// Start watches config files in background until Stop is called
+func (configWatcher *ConfigWatcher) Start() {
+	go func() {
+		err := configWatcher.watchNotify()
+		if err == nil {
+			return
+		}
+		configWatcher.loggerHelper.AddLog(INFO, fmt.Sprintf(
+			"basic/watch: Cannot watch [%s] by inotify [%v], poll every [%v]", configWatcher.configDir, err, configWatcher.pollInterval))
+		configWatcher.watchPoll()
+	}()
+}
+
+func (configWatcher *ConfigWatcher) Stop() {
+	configWatcher.stopOnce.Do(func() {
+		close(configWatcher.stopChan)
+	})
+}
+
+// isConfigFile returns true if name is one of the config files watched
+func (configWatcher *ConfigWatcher) isConfigFile(name string) bool {
+	for _, file := range configWatcher.files {
+		if filepath.Base(name) == file {
+			return true
+		}
+	}
+	return false
+}
+
+// notify sends a change after no more changes are found within debounce time, returns false if stopped
+func (configWatcher *ConfigWatcher) notify(moreChanges <-chan struct{}) bool {
+	timer := time.NewTimer(configWatcher.debounce)
+	defer timer.Stop()
+	for {
+		select {
+		case <-configWatcher.stopChan:
+			return false
+		case _, ok := <-moreChanges:
+			if !ok { // No more changes will be sent
+				moreChanges = nil
+				continue
+			}
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(configWatcher.debounce)
+		case <-timer.C:
+			configWatcher.loggerHelper.AddLog(INFO, fmt.Sprintf("basic/watch: Config files in [%s] changed", configWatcher.configDir))
+			select {
+			case configWatcher.changeChan <- struct{}{}:
+			default: // A change is pending already
+			}
+			return true
+		}
+	}
+}
+
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime time.Time
+}
+
+func (configWatcher *ConfigWatcher) fileStates() map[string]fileState {
+	states := make(map[string]fileState)
+	for _, file := range configWatcher.files {
+		if info, err := os.Stat(filepath.Join(configWatcher.configDir, file)); err == nil {
+			states[file] = fileState{exists: true, size: info.Size(), modTime: info.ModTime()}
+		} else {
+			states[file] = fileState{}
+		}
+	}
+	return states
+}
+
+// watchPoll checks sizes and modification time of config files periodically
+func (configWatcher *ConfigWatcher) watchPoll() {
+	ticker := time.NewTicker(configWatcher.pollInterval)
+	defer ticker.Stop()
+	states := configWatcher.fileStates()
+	for {
+		select {
+		case <-configWatcher.stopChan:
+			return
+		case <-ticker.C:
+			newStates := configWatcher.fileStates()
+			changed := false
+			for file, state := range newStates {
+				oldState := states[file]
+				changed = changed || state.exists != oldState.exists || state.size != oldState.size ||
+					!state.modTime.Equal(oldState.modTime)
+			}
+			states = newStates
+			if changed && !configWatcher.notify(nil) {
+				return
+			}
+		}
+	}
+}
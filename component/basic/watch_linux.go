@@ -0,0 +1,73 @@
+package basic
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_DELETE
+
+// watchNotify watches the config folder by inotify until stopped, returns error if inotify is unavailable.
+// The folder is watched rather than the files, since editors often save files by replacing them.
+func (configWatcher *ConfigWatcher) watchNotify() error {
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	// Non-blocking file is read through the runtime poller, so that reading is interrupted by closing
+	inotifyFile := os.NewFile(uintptr(fd), "inotify")
+	if _, err = syscall.InotifyAddWatch(fd, configWatcher.configDir, inotifyMask); err != nil {
+		_ = inotifyFile.Close()
+		return err
+	}
+
+	changeChan := make(chan struct{}, 1)
+	go func() {
+		<-configWatcher.stopChan
+		_ = inotifyFile.Close()
+	}()
+	go func() {
+		defer close(changeChan)
+		buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := inotifyFile.Read(buffer)
+			if err != nil {
+				return
+			}
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				nameEnd := nameStart + int(event.Len)
+				offset = nameEnd
+				if nameEnd > n {
+					break
+				}
+				if configWatcher.isConfigFile(cString(buffer[nameStart:nameEnd])) {
+					select {
+					case changeChan <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	for range changeChan {
+		if !configWatcher.notify(changeChan) {
+			break
+		}
+	}
+	return nil
+}
+
+// cString returns the string before the first NUL byte
+func cString(bytes []byte) string {
+	for index, b := range bytes {
+		if b == 0 {
+			return string(bytes[:index])
+		}
+	}
+	return string(bytes)
+}
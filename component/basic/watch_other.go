@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package basic
+
+import (
+	"errors"
+)
+
+// watchNotify is only supported on Linux, config files are polled on other systems
+func (configWatcher *ConfigWatcher) watchNotify() error {
+	return errors.New("inotify is not supported")
+}
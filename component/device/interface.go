@@ -119,12 +119,13 @@ func GetLocalInterfaceInfo() (ifList []*InterfaceInfo, macList, ipList []string,
 		ipList = append(ipList, filteredIp...)
 		macList = append(macList, macStr)
 		ifInfo := &InterfaceInfo{
-			name: i.Name,
-			mac:  macStr,
+			Name:   i.Name,
+			Mac:    macStr,
+			IpList: make([]string, 0),
 		}
 
 		if len(filteredIp) > 0 {
-			ifInfo.ipList = curIp
+			ifInfo.IpList = curIp
 		}
 
 		ifList = append(ifList, ifInfo)
@@ -135,13 +136,13 @@ func GetLocalInterfaceInfo() (ifList []*InterfaceInfo, macList, ipList []string,
 }
 
 type InterfaceInfo struct {
-	name   string
-	mac    string
-	ipList []string
+	Name   string   `json:"name" yaml:"name"`
+	Mac    string   `json:"mac" yaml:"mac"`
+	IpList []string `json:"ip_list" yaml:"ip_list"`
 }
 
 func (i *InterfaceInfo) String() string {
-	return utils.Sprint(i.name, "\n======================\n", "Mac: ", i.mac, "\n", "Address(es): ", i.ipList, "\n======================\n")
+	return utils.Sprint(i.Name, "\n======================\n", "Mac: ", i.Mac, "\n", "Address(es): ", i.IpList, "\n======================\n")
 }
 
 type InterfaceHelper struct {
@@ -149,6 +150,7 @@ type InterfaceHelper struct {
 	userDeviceSettings *basic.UserDeviceSettings
 	KnownMacMap        map[string]struct{}
 	KnownMacList       []string
+	KnownDevices       map[string]*basic.KnownDevice // Known devices with labels by standardized MAC address
 	LocalMacList       []string
 	LocalIpList        []string
 }
@@ -185,7 +187,8 @@ func InitInterfaceHelper(configHelper *basic.ConfigHelper, loggerHelper *basic.L
 	interfaceHelper := &InterfaceHelper{
 		loggerHelper:       loggerHelper,
 		userDeviceSettings: &configHelper.UserSettings.UserDeviceSettings,
-		KnownMacList:       configHelper.UserSettings.UserDeviceSettings.KnownMacList,
+		KnownMacList:       configHelper.UserSettings.UserDeviceSettings.MacList(),
+		KnownDevices:       make(map[string]*basic.KnownDevice),
 	}
 
 	// Standardize the MAC addresses from config
@@ -198,6 +201,15 @@ func InitInterfaceHelper(configHelper *basic.ConfigHelper, loggerHelper *basic.L
 			))
 	}
 
+	for index := range interfaceHelper.userDeviceSettings.KnownMacList {
+		knownDevice := &interfaceHelper.userDeviceSettings.KnownMacList[index]
+		if standardMac, err := MacStandardize(knownDevice.Mac); err == nil {
+			if _, ok := interfaceHelper.KnownDevices[standardMac]; !ok {
+				interfaceHelper.KnownDevices[standardMac] = knownDevice
+			}
+		}
+	}
+
 	// Get MAC addresses & IP addresses from local interfaces
 	_, localMacList, localIpList, err := GetLocalInterfaceInfo()
 	interfaceHelper.LocalMacList = localMacList
@@ -231,6 +243,19 @@ func InitInterfaceHelper(configHelper *basic.ConfigHelper, loggerHelper *basic.L
 	return interfaceHelper, nil
 }
 
+// KnownDevice returns the known device with MAC address in standard format, nil if it is not in known MAC list
+func (ifHelper *InterfaceHelper) KnownDevice(mac string) *basic.KnownDevice {
+	return ifHelper.KnownDevices[mac]
+}
+
+// DeviceName returns MAC address with labels of the known device, e.g. "11:22:33:44:55:66 (Laptop, Alice)"
+func (ifHelper *InterfaceHelper) DeviceName(mac string) string {
+	if knownDevice := ifHelper.KnownDevice(mac); knownDevice != nil && knownDevice.Label() != "" {
+		return fmt.Sprintf("%s (%s)", mac, knownDevice.Label())
+	}
+	return mac
+}
+
 func (ifHelper *InterfaceHelper) FindLogoutMac(sessionMacList []string) (mac string) {
 
 	if len(sessionMacList) == 0 {
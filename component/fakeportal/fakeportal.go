@@ -0,0 +1,303 @@
+package fakeportal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"xjtuportal/component/basic"
+	portalhttp "xjtuportal/component/http"
+	"xjtuportal/component/http/httpfake"
+)
+
+const (
+	InternetPath    = "/generate_204"
+	PortalPath      = "/"
+	OnlinePath      = "/portal/api/v2/online"
+	SessionListPath = "/portal/api/v2/session/list"
+	LogoutPath      = "/portal/api/v2/session/acctUniqueId"
+	GetIpPath       = "/backend/getIP"
+	NotifyPath      = "/notify" // Webhook receiving notifications
+
+	portalErrorCode = 81
+)
+
+var (
+	// LoginErrorDescriptions are error descriptions returned by the portal, keyed by mapped status code
+	LoginErrorDescriptions = map[int]string{
+		21: "You are dialed up outside your allowed timespan",
+		24: "authentication rejected",
+		27: "You account has been suspended",
+		33: "You account has been froze, please contact service support",
+		36: "No billing plan subscription, please subscribe",
+		39: "You are already have %d sessions",
+		43: "the account can only be used in student zone",
+		46: "the account can only be used in office zone",
+		49: "the account can only be used in visitor zone",
+		60: "invalid username or password",
+	}
+)
+
+// FakePortal emulates the portal server, the bootstrap server and the speed test server over HTTP
+type FakePortal struct {
+	Backend  *httpfake.FakePortalBackend
+	Username string // Username with domain, e.g. 3120123456@xjtu
+	Password string
+
+	mutex      sync.Mutex
+	loginError int
+	tokens     map[string]struct{}
+	tokenCount int
+	tokenAge   time.Duration // Age of issued tokens reported in createdAt
+	webhooks   []string      // Bodies posted to the notify webhook
+	onWebhook  func(body string)
+	mux        *http.ServeMux
+}
+
+func InitFakePortal(username string, password string, localMac string, localIp string, concurrency int) *FakePortal {
+
+	fakePortal := &FakePortal{
+		Backend:  httpfake.InitFakePortalBackend(localMac, localIp, concurrency),
+		Username: username,
+		Password: password,
+		tokens:   make(map[string]struct{}),
+		mux:      http.NewServeMux(),
+	}
+
+	fakePortal.mux.HandleFunc(InternetPath, fakePortal.internet)
+	fakePortal.mux.HandleFunc(PortalPath, fakePortal.portal)
+	fakePortal.mux.HandleFunc(OnlinePath, fakePortal.online)
+	fakePortal.mux.HandleFunc(SessionListPath, fakePortal.sessionList)
+	fakePortal.mux.HandleFunc(LogoutPath+"/", fakePortal.logout)
+	fakePortal.mux.HandleFunc(GetIpPath, fakePortal.getIp)
+	fakePortal.mux.HandleFunc(NotifyPath, fakePortal.notify)
+
+	return fakePortal
+}
+
+// StartServer starts the fake portal on a random local port
+func StartServer(fakePortal *FakePortal) *httptest.Server {
+	return httptest.NewServer(fakePortal)
+}
+
+// PointSettingsTo rewrites program settings to use the fake portal at given URL, e.g. http://127.0.0.1:8080
+func PointSettingsTo(programSettings *basic.ProgramSettings, serverUrl string) {
+	serverUrl = strings.TrimSuffix(serverUrl, "/")
+	host := strings.TrimPrefix(serverUrl, "http://")
+
+	programSettings.ProgramConnectivitySettings.Http.Internet = serverUrl + InternetPath
+	programSettings.ProgramConnectivitySettings.Http.Intranet = serverUrl + PortalPath
+	programSettings.ProgramOnlineSettings.BootStrapUrl = serverUrl + InternetPath
+	programSettings.ProgramOnlineSettings.PortalServer.Hostname = host
+	programSettings.ProgramOnlineSettings.PortalServer.OnlinePath = OnlinePath
+	programSettings.ProgramSessionSettings.PortalServer.Hostname = serverUrl
+	programSettings.ProgramSessionSettings.PortalServer.SessionListPath = SessionListPath
+	programSettings.ProgramSessionSettings.PortalServer.LogoutPath = LogoutPath
+	programSettings.ProgramSessionSettings.SpeedCheckServer.Hostname = serverUrl
+	programSettings.ProgramSessionSettings.SpeedCheckServer.GetIpPath = GetIpPath
+}
+
+// SetLoginError forces login requests to fail with given status code, 0 to disable
+func (fakePortal *FakePortal) SetLoginError(statusCode int) {
+	fakePortal.mutex.Lock()
+	defer fakePortal.mutex.Unlock()
+	fakePortal.loginError = statusCode
+}
+
+// RevokeTokens invalidates all tokens issued before
+func (fakePortal *FakePortal) RevokeTokens() {
+	fakePortal.mutex.Lock()
+	defer fakePortal.mutex.Unlock()
+	fakePortal.tokens = make(map[string]struct{})
+}
+
+// SetTokenAge makes the portal report tokens as created age ago, e.g. to expire cached tokens at once
+func (fakePortal *FakePortal) SetTokenAge(age time.Duration) {
+	fakePortal.mutex.Lock()
+	defer fakePortal.mutex.Unlock()
+	fakePortal.tokenAge = age
+}
+
+// TokenCount returns the number of tokens issued
+func (fakePortal *FakePortal) TokenCount() int {
+	fakePortal.mutex.Lock()
+	defer fakePortal.mutex.Unlock()
+	return fakePortal.tokenCount
+}
+
+// Webhooks returns bodies posted to the notify webhook in order
+func (fakePortal *FakePortal) Webhooks() []string {
+	fakePortal.mutex.Lock()
+	defer fakePortal.mutex.Unlock()
+	return append([]string{}, fakePortal.webhooks...)
+}
+
+// OnWebhook sets the function called on each body posted to the notify webhook, e.g. to print it
+func (fakePortal *FakePortal) OnWebhook(received func(body string)) {
+	fakePortal.mutex.Lock()
+	defer fakePortal.mutex.Unlock()
+	fakePortal.onWebhook = received
+}
+
+func (fakePortal *FakePortal) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	fakePortal.mux.ServeHTTP(writer, request)
+}
+
+func (fakePortal *FakePortal) issueToken() (token string, createdTs int64) {
+	fakePortal.mutex.Lock()
+	defer fakePortal.mutex.Unlock()
+	fakePortal.tokenCount++
+	token = fmt.Sprintf("fake-token-%d", fakePortal.tokenCount)
+	fakePortal.tokens[token] = struct{}{}
+	return token, time.Now().Add(-fakePortal.tokenAge).Unix()
+}
+
+func (fakePortal *FakePortal) validToken(request *http.Request) bool {
+	fakePortal.mutex.Lock()
+	defer fakePortal.mutex.Unlock()
+	_, ok := fakePortal.tokens[request.Header.Get("Authorization")]
+	return ok
+}
+
+func writeJson(writer http.ResponseWriter, statusCode int, data interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	_ = json.NewEncoder(writer).Encode(data)
+}
+
+// internet returns 204 if online, or redirects to the portal like the captive portal does
+func (fakePortal *FakePortal) internet(writer http.ResponseWriter, request *http.Request) {
+	if _, err := fakePortal.Backend.InternetHttpCheck(request.Context()); err == nil {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	redirectUrl, _, _ := fakePortal.Backend.RedirectUrl(request.Context())
+	redirectUrl = strings.Replace(redirectUrl, "http://fake.portal/", fmt.Sprintf("http://%s/", request.Host), 1)
+	http.Redirect(writer, request, redirectUrl, http.StatusFound)
+}
+
+func (fakePortal *FakePortal) portal(writer http.ResponseWriter, request *http.Request) {
+	if request.URL.Path != PortalPath {
+		http.NotFound(writer, request)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/html")
+	_, _ = writer.Write([]byte("<html><body>Fake portal</body></html>"))
+}
+
+func (fakePortal *FakePortal) online(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	authData := &portalhttp.AuthData{}
+	if err := json.NewDecoder(request.Body).Decode(authData); err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	response := &portalhttp.OnlineResponse{
+		ReturnCode: 200,
+		CreatedTs:  time.Now().Unix(),
+	}
+
+	if authData.Username != fakePortal.Username || authData.Password != fakePortal.Password {
+		response.ErrorCode = portalErrorCode
+		response.Description = LoginErrorDescriptions[60]
+		writeJson(writer, http.StatusOK, response)
+		return
+	}
+
+	fakePortal.mutex.Lock()
+	loginError := fakePortal.loginError
+	fakePortal.mutex.Unlock()
+	if description, ok := LoginErrorDescriptions[loginError]; ok {
+		response.ErrorCode = portalErrorCode
+		response.Description = description
+		if strings.Contains(description, "%d") {
+			response.Description = fmt.Sprintf(description, fakePortal.Backend.Concurrency)
+		}
+		writeJson(writer, http.StatusOK, response)
+		return
+	}
+
+	// Requests with a redirect URL of other devices are used to get token only
+	response.Token, response.CreatedTs = fakePortal.issueToken()
+	redirectUrl, err := url.Parse(authData.RedirectUrl)
+	if err != nil || redirectUrl.Query().Get("usermac") != fakePortal.Backend.LocalMac {
+		writeJson(writer, http.StatusOK, response)
+		return
+	}
+
+	onlineResponse, _, err := fakePortal.Backend.Online(request.Context(), authData.RedirectUrl)
+	if err != nil {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	response.ErrorCode = onlineResponse.ErrorCode
+	response.Description = onlineResponse.Description
+	writeJson(writer, http.StatusOK, response)
+}
+
+func (fakePortal *FakePortal) sessionList(writer http.ResponseWriter, request *http.Request) {
+
+	if !fakePortal.validToken(request) {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	token, _, _, _ := fakePortal.Backend.AuthToken(request.Context())
+	sessionListPortal, statusCode, err := fakePortal.Backend.SessionList(request.Context(), token)
+	if err != nil {
+		writer.WriteHeader(statusCode)
+		return
+	}
+	writeJson(writer, http.StatusOK, sessionListPortal)
+}
+
+func (fakePortal *FakePortal) logout(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != http.MethodDelete {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !fakePortal.validToken(request) {
+		writer.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	token, _, _, _ := fakePortal.Backend.AuthToken(request.Context())
+	statusCode, _ := fakePortal.Backend.Logout(request.Context(), token, strings.TrimPrefix(request.URL.Path, LogoutPath+"/"))
+	writer.WriteHeader(statusCode)
+}
+
+func (fakePortal *FakePortal) getIp(writer http.ResponseWriter, request *http.Request) {
+	ip, _ := fakePortal.Backend.CurrentIp(request.Context())
+	writeJson(writer, http.StatusOK, map[string]string{"ip": ip})
+}
+
+func (fakePortal *FakePortal) notify(writer http.ResponseWriter, request *http.Request) {
+
+	if request.Method != http.MethodPost {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	fakePortal.mutex.Lock()
+	fakePortal.webhooks = append(fakePortal.webhooks, string(body))
+	received := fakePortal.onWebhook
+	fakePortal.mutex.Unlock()
+	if received != nil {
+		received(string(body))
+	}
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,209 @@
+package fakeportal
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MailMessage is a mail received by the fake SMTP server
+type MailMessage struct {
+	From string
+	To   []string
+	Data string // Headers and body
+	Tls  bool   // Sent after STARTTLS
+}
+
+// FakeSmtpServer is a local SMTP relay accepting all mails without authentication, STARTTLS is offered if enabled
+type FakeSmtpServer struct {
+	listener  net.Listener
+	mutex     sync.Mutex
+	messages  []*MailMessage
+	received  func(message *MailMessage)
+	waiting   sync.WaitGroup
+	tlsConfig *tls.Config // Nil if STARTTLS is not offered
+}
+
+// StartSmtpServer starts the fake SMTP server on given address, e.g. 127.0.0.1:0 for a random local port
+func StartSmtpServer(address string) (*FakeSmtpServer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	server := &FakeSmtpServer{listener: listener}
+	server.waiting.Add(1)
+	go server.serve()
+	return server, nil
+}
+
+// Addr returns the listen address, e.g. 127.0.0.1:2525
+func (server *FakeSmtpServer) Addr() string {
+	return server.listener.Addr().String()
+}
+
+// Messages returns mails received in order
+func (server *FakeSmtpServer) Messages() []*MailMessage {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]*MailMessage{}, server.messages...)
+}
+
+// OnMessage sets the function called on each mail received, e.g. to print it
+func (server *FakeSmtpServer) OnMessage(received func(message *MailMessage)) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.received = received
+}
+
+// EnableStartTls offers STARTTLS with a self-signed certificate for 127.0.0.1 and localhost, the certificate is returned
+// in PEM for clients to trust
+func (server *FakeSmtpServer) EnableStartTls() ([]byte, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake.smtp"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.tlsConfig = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{certificate}, PrivateKey: key}}}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), nil
+}
+
+// Close stops accepting connections
+func (server *FakeSmtpServer) Close() error {
+	err := server.listener.Close()
+	server.waiting.Wait()
+	return err
+}
+
+func (server *FakeSmtpServer) serve() {
+	defer server.waiting.Done()
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+// handle talks the minimal subset of SMTP used by net/smtp
+func (server *FakeSmtpServer) handle(conn net.Conn) {
+
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) bool {
+		_, err := conn.Write([]byte(line + "\r\n"))
+		return err == nil
+	}
+
+	if !reply("220 fake.smtp ESMTP") {
+		return
+	}
+	server.mutex.Lock()
+	tlsConfig := server.tlsConfig
+	server.mutex.Unlock()
+	secure := false
+	message := &MailMessage{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250-fake.smtp")
+			if tlsConfig != nil && !secure {
+				reply("250-STARTTLS")
+			}
+			reply("250 8BITMIME")
+		case command == "STARTTLS" && tlsConfig != nil && !secure:
+			reply("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case strings.HasPrefix(command, "HELO"):
+			reply("250 fake.smtp")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = &MailMessage{From: mailAddress(line[len("MAIL FROM:"):]), Tls: secure}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			message.To = append(message.To, mailAddress(line[len("RCPT TO:"):]))
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data := &strings.Builder{}
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" || dataLine == ".\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			message.Data = data.String()
+			server.mutex.Lock()
+			server.messages = append(server.messages, message)
+			received := server.received
+			server.mutex.Unlock()
+			if received != nil {
+				received(message)
+			}
+			reply("250 OK")
+		case command == "RSET":
+			message = &MailMessage{Tls: secure}
+			reply("250 OK")
+		case command == "NOOP":
+			reply("250 OK")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// mailAddress returns the address in angle brackets of MAIL and RCPT parameters, e.g. <a@b.c> BODY=8BITMIME
+func mailAddress(parameters string) string {
+	parameters = strings.TrimSpace(parameters)
+	if start, end := strings.Index(parameters, "<"), strings.Index(parameters, ">"); start >= 0 && end > start {
+		return parameters[start+1 : end]
+	}
+	if fields := strings.Fields(parameters); len(fields) > 0 {
+		return fields[0]
+	}
+	return ""
+}
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+	"xjtuportal/component/basic"
+)
+
+// HttpChecker checks if the Internet and the portal server are reachable
+type HttpChecker interface {
+	InternetHttpCheck(ctx context.Context) (statusCode int, err error)
+	IntranetHttpCheck(ctx context.Context) (statusCode int, err error)
+}
+
+// DnsChecker checks if the DNS servers of the campus and the Internet are available
+type DnsChecker interface {
+	IntranetDnsCheck(ctx context.Context) (available []string, unavailable []string)
+	InternetDnsCheck(ctx context.Context) (available []string, unavailable []string)
+}
+
+// PortalBackend abstracts operations provided by the portal server
+type PortalBackend interface {
+	// RedirectUrl gets the URL that the portal redirects unauthenticated requests to
+	RedirectUrl(ctx context.Context) (redirectUrl string, statusCode int, err error)
+	// Online posts auth data with the redirect URL and returns the response of the portal
+	Online(ctx context.Context, redirectUrl string) (onlineResponse *OnlineResponse, statusCode int, err error)
+	// AuthToken gets a token used to manage sessions and the time the portal created it, zero if unknown
+	AuthToken(ctx context.Context) (token string, createdAt time.Time, statusCode int, err error)
+	// SessionList gets all sessions of the account
+	SessionList(ctx context.Context, token string) (sessionListPortal *SessionListPortal, statusCode int, err error)
+	// Logout deletes the session with given unique id
+	Logout(ctx context.Context, token string, uniqueId string) (statusCode int, err error)
+	// CurrentIp gets the IP address of current machine seen by the campus network
+	CurrentIp(ctx context.Context) (ip string, err error)
+	// UseAccount switches the account used by following operations
+	UseAccount(authData *basic.UserAuthData, credentialProvider basic.CredentialProvider) error
+}
+
+// HttpPortalBackend is the PortalBackend of iHarbor portal (/portal/api/v2)
+type HttpPortalBackend struct {
+	OnlineHelper  *OnlineHelper
+	loggerHelper  *basic.LoggerHelper
+	requestHelper *RequestHelper
+
+	sessionListUrl string
+	logoutUrl      string
+	getIpUrl       string
+}
+
+func InitHttpPortalBackend(
+	configHelper *basic.ConfigHelper,
+	loggerHelper *basic.LoggerHelper,
+	requestHelper *RequestHelper,
+) (*HttpPortalBackend, error) {
+
+	onlineHelper, err := InitOnlineHelper(configHelper, loggerHelper, requestHelper)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("http/backend: Error creating OnlineHelper [%v]", err))
+		return nil, err
+	}
+
+	sessionSettings := &configHelper.ProgramSettings.ProgramSessionSettings
+	httpPortalBackend := &HttpPortalBackend{
+		OnlineHelper:   onlineHelper,
+		loggerHelper:   loggerHelper,
+		requestHelper:  requestHelper,
+		sessionListUrl: sessionSettings.PortalServer.Hostname + sessionSettings.PortalServer.SessionListPath,
+		logoutUrl:      sessionSettings.PortalServer.Hostname + sessionSettings.PortalServer.LogoutPath,
+		getIpUrl:       sessionSettings.SpeedCheckServer.Hostname + sessionSettings.SpeedCheckServer.GetIpPath,
+	}
+
+	return httpPortalBackend, nil
+}
+
+func (backend *HttpPortalBackend) RedirectUrl(ctx context.Context) (redirectUrl string, statusCode int, err error) {
+	statusCode, err = backend.OnlineHelper.GetRedirectUrl(ctx)
+	return backend.OnlineHelper.RedirectUrl, statusCode, err
+}
+
+func (backend *HttpPortalBackend) Online(ctx context.Context, redirectUrl string) (onlineResponse *OnlineResponse, statusCode int, err error) {
+	statusCode, err = backend.OnlineHelper.OnlinePost(ctx, redirectUrl)
+	if err != nil {
+		return nil, statusCode, err
+	}
+	return backend.OnlineHelper.OnlineResponse, statusCode, nil
+}
+
+func (backend *HttpPortalBackend) AuthToken(ctx context.Context) (token string, createdAt time.Time, statusCode int, err error) {
+	statusCode, err = backend.OnlineHelper.GetAuthToken(ctx)
+	if err != nil {
+		return "", time.Time{}, statusCode, err
+	}
+	onlineResponse := backend.OnlineHelper.OnlineResponse
+	return onlineResponse.Token, onlineResponse.CreatedTime(), statusCode, nil
+}
+
+func (backend *HttpPortalBackend) authorize(token string) (header *http.Header, cookies []*http.Cookie) {
+	header = &http.Header{}
+	header.Set("Authorization", token)
+	cookies = make([]*http.Cookie, 0, 2)
+	cookies = append(cookies, &http.Cookie{
+		Name:  "token",
+		Value: token,
+	})
+	return
+}
+
+func (backend *HttpPortalBackend) SessionList(ctx context.Context, token string) (sessionListPortal *SessionListPortal, statusCode int, err error) {
+
+	header, cookies := backend.authorize(token)
+	_, body, statusCode, err := backend.requestHelper.SendRequest(
+		ctx,
+		backend.sessionListUrl,
+		"GET",
+		nil,
+		header,
+		cookies,
+	)
+	if err != nil {
+		return nil, statusCode, err
+	}
+
+	sessionListPortal = &SessionListPortal{}
+	err = json.Unmarshal(body, sessionListPortal)
+	if err != nil {
+		return nil, -1, err
+	}
+
+	return sessionListPortal, statusCode, nil
+}
+
+func (backend *HttpPortalBackend) Logout(ctx context.Context, token string, uniqueId string) (statusCode int, err error) {
+
+	header, cookies := backend.authorize(token)
+	_, _, statusCode, err = backend.requestHelper.SendRequest(
+		ctx,
+		fmt.Sprintf("%s/%s", backend.logoutUrl, uniqueId),
+		"DELETE",
+		nil,
+		header,
+		cookies,
+	)
+	return statusCode, err
+}
+
+func (backend *HttpPortalBackend) UseAccount(authData *basic.UserAuthData, credentialProvider basic.CredentialProvider) error {
+	if authData == nil || credentialProvider == nil {
+		return errors.New("http/backend: account is invalid")
+	}
+	backend.OnlineHelper.UseAccount(authData, credentialProvider)
+	return nil
+}
+
+func (backend *HttpPortalBackend) CurrentIp(ctx context.Context) (ip string, err error) {
+
+	_, body, _, err := backend.requestHelper.SendRequest(
+		ctx,
+		backend.getIpUrl,
+		"GET",
+		nil,
+		nil,
+		make([]*http.Cookie, 0, 0),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	ipList := ipv4Regex.FindAllString(string(body), -1)
+	if len(ipList) == 0 {
+		err = errors.New("http/backend: cannot find IP in response")
+		return "", err
+	}
+	return ipList[0], nil
+}
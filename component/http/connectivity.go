@@ -18,6 +18,7 @@ import (
 type DnsHelper struct {
 	loggerHelper *basic.LoggerHelper
 	DnsSettings  *basic.ProgramDnsSettings
+	retryPolicy  *RetryPolicy
 }
 
 func InitDnsHelper(
@@ -40,15 +41,24 @@ func InitDnsHelper(
 		DnsSettings:  &configHelper.ProgramSettings.ProgramDnsSettings,
 	}
 
+	// Queries timed out are retried immediately
+	retryPolicy, err := InitRetryPolicy(loggerHelper, &basic.ProgramRetrySettings{
+		Attempts: dnsHelper.DnsSettings.Testing.RetryTimes + 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	dnsHelper.retryPolicy = retryPolicy
+
 	return dnsHelper, nil
 
 }
 
-func (dnsHelper *DnsHelper) LookupCheck(domain string) (err error) {
+func (dnsHelper *DnsHelper) LookupCheck(ctx context.Context, domain string) (err error) {
 
 	timeout := time.Duration(dnsHelper.DnsSettings.Connect.Timeout) * time.Second
 
-	ctx, cancel := context.WithTimeout(context.TODO(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel() // important to avoid a resource leak
 
 	dnsHelper.loggerHelper.AddLog(basic.DEBUG,
@@ -71,7 +81,7 @@ func (dnsHelper *DnsHelper) LookupCheck(domain string) (err error) {
 
 }
 
-func (dnsHelper *DnsHelper) DnsCheck(domain string, server string) (err error) {
+func (dnsHelper *DnsHelper) DnsCheck(ctx context.Context, domain string, server string) (err error) {
 	query := new(dns.Msg)
 	query.Id = dns.Id()
 	query.RecursionDesired = true
@@ -91,7 +101,11 @@ func (dnsHelper *DnsHelper) DnsCheck(domain string, server string) (err error) {
 	if !regex.MatchString(server) {
 		server = fmt.Sprintf("%s:53", server)
 	}
-	in, _, err := client.Exchange(query, server)
+	var in *dns.Msg
+	_, err = dnsHelper.retryPolicy.Do(ctx, fmt.Sprintf("DNS query to %s", server), func() (int, error) {
+		in, err = dnsHelper.exchange(ctx, client, query, server)
+		return -1, err
+	})
 
 	if err != nil { // Query with error
 		return err
@@ -124,6 +138,25 @@ func (dnsHelper *DnsHelper) DnsCheck(domain string, server string) (err error) {
 
 }
 
+// exchange sends DNS query and returns immediately when ctx is done, the dns client does not support cancellation
+func (dnsHelper *DnsHelper) exchange(ctx context.Context, client *dns.Client, query *dns.Msg, server string) (*dns.Msg, error) {
+	type exchangeResult struct {
+		in  *dns.Msg
+		err error
+	}
+	resultChan := make(chan exchangeResult, 1)
+	go func() {
+		in, _, err := client.Exchange(query, server)
+		resultChan <- exchangeResult{in: in, err: err}
+	}()
+	select {
+	case result := <-resultChan:
+		return result.in, result.err
+	case <-ctx.Done():
+		return nil, errors.New(fmt.Sprintf("http/connectivity: Query to %s aborted [%v]", server, ctx.Err()))
+	}
+}
+
 type ConnectivityChecker struct {
 	loggerHelper         *basic.LoggerHelper
 	requestHelper        *RequestHelper
@@ -168,32 +201,42 @@ func InitConnectivityChecker(
 
 }
 
-func (connectivityChecker *ConnectivityChecker) httpCheck(url string) (statusCode int, err error) {
+func (connectivityChecker *ConnectivityChecker) httpCheck(ctx context.Context, url string, target string) (statusCode int, err error) {
+	defer func() {
+		up := 0.0
+		if err == nil {
+			up = 1
+		}
+		basic.Metrics.SetGauge(basic.MetricHttpCheckUp, up, "target", target)
+		basic.Metrics.SetGauge(basic.MetricHttpCheckStatusCode, float64(statusCode), "target", target)
+	}()
 	_, _, statusCode, err = connectivityChecker.requestHelper.SendRequest(
+		ctx,
 		url,
 		"GET",
 		nil,
 		nil,
 		make([]*http.Cookie, 0, 0),
 	)
-	if statusCode >= 300 {
+	if err == nil && statusCode >= 300 {
 		err = errors.New(fmt.Sprintf("response return error code [%d]", statusCode))
 		return
 	}
 	return
 }
 
-func (connectivityChecker *ConnectivityChecker) IntranetHttpCheck() (statusCode int, err error) {
-	statusCode, err = connectivityChecker.httpCheck(connectivityChecker.connectivitySettings.Http.Intranet)
+func (connectivityChecker *ConnectivityChecker) IntranetHttpCheck(ctx context.Context) (statusCode int, err error) {
+	statusCode, err = connectivityChecker.httpCheck(ctx, connectivityChecker.connectivitySettings.Http.Intranet, "intranet")
 	return
 }
 
-func (connectivityChecker *ConnectivityChecker) InternetHttpCheck() (statusCode int, err error) {
-	statusCode, err = connectivityChecker.httpCheck(connectivityChecker.connectivitySettings.Http.Internet)
+func (connectivityChecker *ConnectivityChecker) InternetHttpCheck(ctx context.Context) (statusCode int, err error) {
+	statusCode, err = connectivityChecker.httpCheck(ctx, connectivityChecker.connectivitySettings.Http.Internet, "internet")
 	return
 }
 
 func (connectivityChecker *ConnectivityChecker) DnsGroupCheck(
+	ctx context.Context,
 	serverGroup []string,
 	domainGroup []string,
 ) (
@@ -209,14 +252,25 @@ func (connectivityChecker *ConnectivityChecker) DnsGroupCheck(
 		wg.Add(1)
 		go func(threadId int, server string) {
 			isAvailable := true
+			latency := time.Duration(0)
 			for _, domain := range domainGroup {
-				err := connectivityChecker.dnsHelper.DnsCheck(domain, server)
+				start := time.Now()
+				err := connectivityChecker.dnsHelper.DnsCheck(ctx, domain, server)
+				latency += time.Since(start)
 				if err != nil {
 					connectivityChecker.loggerHelper.AddLog(basic.DEBUG, fmt.Sprintf("%v", err))
 					isAvailable = false
 					break
 				}
-				time.Sleep(time.Duration(connectivityChecker.dnsHelper.DnsSettings.Connect.Timeout) * time.Second)
+			}
+			if isAvailable {
+				basic.Metrics.SetGauge(basic.MetricDnsServerUp, 1, "server", server)
+				if len(domainGroup) > 0 {
+					basic.Metrics.SetGauge(basic.MetricDnsServerLatency,
+						latency.Seconds()/float64(len(domainGroup)), "server", server)
+				}
+			} else {
+				basic.Metrics.SetGauge(basic.MetricDnsServerUp, 0, "server", server)
 			}
 			if isAvailable {
 				available[threadId] = server
@@ -233,29 +287,29 @@ func (connectivityChecker *ConnectivityChecker) DnsGroupCheck(
 	return utils.DeleteEmptyString(available), utils.DeleteEmptyString(unavailable)
 }
 
-func (connectivityChecker *ConnectivityChecker) IntranetDnsCheck() (available []string, unavailable []string) {
+func (connectivityChecker *ConnectivityChecker) IntranetDnsCheck(ctx context.Context) (available []string, unavailable []string) {
 	domainList := []string{
 		connectivityChecker.connectivitySettings.Dns.Domain.Intranet,
 		connectivityChecker.connectivitySettings.Dns.Domain.Internet,
 	}
-	return connectivityChecker.DnsGroupCheck(connectivityChecker.connectivitySettings.Dns.Server.Intranet, domainList)
+	return connectivityChecker.DnsGroupCheck(ctx, connectivityChecker.connectivitySettings.Dns.Server.Intranet, domainList)
 }
 
-func (connectivityChecker *ConnectivityChecker) InternetDnsCheck() (available []string, unavailable []string) {
+func (connectivityChecker *ConnectivityChecker) InternetDnsCheck(ctx context.Context) (available []string, unavailable []string) {
 	domainList := []string{
 		connectivityChecker.connectivitySettings.Dns.Domain.Intranet,
 		connectivityChecker.connectivitySettings.Dns.Domain.Internet,
 	}
-	return connectivityChecker.DnsGroupCheck(connectivityChecker.connectivitySettings.Dns.Server.Internet, domainList)
+	return connectivityChecker.DnsGroupCheck(ctx, connectivityChecker.connectivitySettings.Dns.Server.Internet, domainList)
 }
 
-func (connectivityChecker *ConnectivityChecker) SystemResolveCheck() (statusCode int, err error) {
+func (connectivityChecker *ConnectivityChecker) SystemResolveCheck(ctx context.Context) (statusCode int, err error) {
 	domainGroup := []string{
 		connectivityChecker.connectivitySettings.Dns.Domain.Intranet,
 		connectivityChecker.connectivitySettings.Dns.Domain.Internet,
 	}
 	for _, domain := range domainGroup {
-		err = connectivityChecker.dnsHelper.LookupCheck(domain)
+		err = connectivityChecker.dnsHelper.LookupCheck(ctx, domain)
 		if err != nil {
 			return -1, err
 		}
@@ -0,0 +1,267 @@
+// Package httpfake provides an in-memory portal backend for offline tests, it is not used by the program
+package httpfake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"xjtuportal/component/basic"
+	portalhttp "xjtuportal/component/http"
+)
+
+const (
+	fakeToken        = "fake-token"
+	fakeRedirectUrl  = "http://fake.portal/?userip=%s&usermac=%s"
+	fakeErrorOnline  = 0
+	fakeErrorGeneral = 81
+)
+
+// FakePortalBackend is an in-memory PortalBackend and HttpChecker for offline tests
+type FakePortalBackend struct {
+	mutex sync.Mutex
+
+	// MAC and IP address of current machine
+	LocalMac string
+	LocalIp  string
+
+	Concurrency int
+	Sessions    []*portalhttp.Session
+
+	// Forced response of online requests, e.g. error 60 with "invalid username or password"
+	OnlineError *portalhttp.OnlineResponse
+	// Account in use (username with domain), switched by UseAccount
+	Account string
+	// Forced response of online requests by account, e.g. error 27 of a suspended account
+	AccountErrors map[string]*portalhttp.OnlineResponse
+	// Simulate the portal server being unreachable
+	PortalDown bool
+	// Simulate a busy portal server, the next online requests fail with 503
+	TransientFailures int
+	// Simulate a slow portal server, every call waits for Delay or until ctx is done
+	Delay time.Duration
+
+	// Operation records
+	OnlineCount int
+	TokenCount  int
+	LoggedOut   []string // Unique ids of sessions logged out
+	nextId      int
+}
+
+func InitFakePortalBackend(localMac string, localIp string, concurrency int) *FakePortalBackend {
+	return &FakePortalBackend{
+		LocalMac:    localMac,
+		LocalIp:     localIp,
+		Concurrency: concurrency,
+		Sessions:    make([]*portalhttp.Session, 0),
+		LoggedOut:   make([]string, 0),
+	}
+}
+
+// AddSession adds an online session of another device, returns its unique id
+func (fake *FakePortalBackend) AddSession(mac string, ip string) string {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return fake.addSession(mac, ip)
+}
+
+func (fake *FakePortalBackend) addSession(mac string, ip string) string {
+	fake.nextId++
+	session := &portalhttp.Session{
+		SessionId:   fmt.Sprintf("session-%d", fake.nextId),
+		NasIpAddr:   "10.6.0.1",
+		UserIpAddr:  ip,
+		UserMacAddr: mac,
+		StartTime:   time.Now().Add(time.Duration(fake.nextId) * time.Minute).Format("2006-01-02 15:04:05"),
+		UniqueId:    fmt.Sprintf("unique-%d", fake.nextId),
+		DeviceType:  "PC",
+	}
+	fake.Sessions = append(fake.Sessions, session)
+	return session.UniqueId
+}
+
+// wait simulates the latency of the portal server
+func (fake *FakePortalBackend) wait(ctx context.Context) error {
+	if fake.Delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(fake.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (fake *FakePortalBackend) isOnline() bool {
+	for _, session := range fake.Sessions {
+		if session.UserMacAddr == fake.LocalMac {
+			return true
+		}
+	}
+	return false
+}
+
+func (fake *FakePortalBackend) InternetHttpCheck(ctx context.Context) (statusCode int, err error) {
+	if err = fake.wait(ctx); err != nil {
+		return -1, err
+	}
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.isOnline() {
+		return 204, nil
+	}
+	return 302, errors.New("response return error code [302]")
+}
+
+func (fake *FakePortalBackend) IntranetHttpCheck(ctx context.Context) (statusCode int, err error) {
+	if err = fake.wait(ctx); err != nil {
+		return -1, err
+	}
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.PortalDown {
+		return -1, errors.New("httpfake/backend: portal server is down")
+	}
+	return 200, nil
+}
+
+func (fake *FakePortalBackend) RedirectUrl(ctx context.Context) (redirectUrl string, statusCode int, err error) {
+	if err = fake.wait(ctx); err != nil {
+		return "", -1, err
+	}
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.PortalDown {
+		return "", -1, errors.New("httpfake/backend: portal server is down")
+	}
+	return fmt.Sprintf(fakeRedirectUrl, fake.LocalIp, fake.LocalMac), 200, nil
+}
+
+func (fake *FakePortalBackend) Online(ctx context.Context, _ string) (onlineResponse *portalhttp.OnlineResponse, statusCode int, err error) {
+	if err = fake.wait(ctx); err != nil {
+		return nil, -1, err
+	}
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if fake.PortalDown {
+		return nil, -1, errors.New("httpfake/backend: portal server is down")
+	}
+	fake.OnlineCount++
+
+	if fake.TransientFailures > 0 {
+		fake.TransientFailures--
+		return nil, 503, errors.New("httpfake/backend: portal server is busy")
+	}
+
+	if accountError, ok := fake.AccountErrors[fake.Account]; ok {
+		response := *accountError
+		return &response, 200, nil
+	}
+	if fake.OnlineError != nil {
+		response := *fake.OnlineError
+		return &response, 200, nil
+	}
+
+	onlineResponse = &portalhttp.OnlineResponse{
+		ReturnCode: 200,
+		CreatedTs:  time.Now().Unix(),
+		ErrorCode:  fakeErrorOnline,
+		Token:      fakeToken,
+	}
+	if fake.isOnline() {
+		return onlineResponse, 200, nil
+	}
+	if len(fake.Sessions) >= fake.Concurrency {
+		onlineResponse.ErrorCode = fakeErrorGeneral
+		onlineResponse.Description = fmt.Sprintf("You are already have %d sessions online", len(fake.Sessions))
+		return onlineResponse, 200, nil
+	}
+	fake.addSession(fake.LocalMac, fake.LocalIp)
+	return onlineResponse, 200, nil
+}
+
+func (fake *FakePortalBackend) AuthToken(ctx context.Context) (token string, createdAt time.Time, statusCode int, err error) {
+	if err = fake.wait(ctx); err != nil {
+		return "", time.Time{}, -1, err
+	}
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	if fake.PortalDown {
+		return "", time.Time{}, -1, errors.New("httpfake/backend: portal server is down")
+	}
+	fake.TokenCount++
+	return fakeToken, time.Now(), 200, nil
+}
+
+func (fake *FakePortalBackend) SessionList(ctx context.Context, token string) (sessionListPortal *portalhttp.SessionListPortal, statusCode int, err error) {
+	if err = fake.wait(ctx); err != nil {
+		return nil, -1, err
+	}
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if fake.PortalDown {
+		return nil, -1, errors.New("httpfake/backend: portal server is down")
+	}
+	if token != fakeToken {
+		return nil, 401, errors.New("httpfake/backend: invalid token")
+	}
+
+	sessionListPortal = &portalhttp.SessionListPortal{Concurrency: strconv.Itoa(fake.Concurrency)}
+	for _, session := range fake.Sessions {
+		sessionListPortal.Sessions = append(sessionListPortal.Sessions, portalhttp.SessionPortal{
+			DeviceType:  session.DeviceType,
+			SessionId:   session.SessionId,
+			NasIpAddr:   session.NasIpAddr,
+			UserIpAddr:  session.UserIpAddr,
+			UserMacAddr: session.UserMacAddr,
+			StartTime:   session.StartTime,
+			UniqueId:    session.UniqueId,
+		})
+	}
+	return sessionListPortal, 200, nil
+}
+
+func (fake *FakePortalBackend) Logout(ctx context.Context, token string, uniqueId string) (statusCode int, err error) {
+	if err = fake.wait(ctx); err != nil {
+		return -1, err
+	}
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if fake.PortalDown {
+		return -1, errors.New("httpfake/backend: portal server is down")
+	}
+	if token != fakeToken {
+		return 401, errors.New("httpfake/backend: invalid token")
+	}
+
+	for index, session := range fake.Sessions {
+		if session.UniqueId == uniqueId {
+			fake.Sessions = append(fake.Sessions[:index], fake.Sessions[index+1:]...)
+			fake.LoggedOut = append(fake.LoggedOut, uniqueId)
+			return 200, nil
+		}
+	}
+	return 404, errors.New(fmt.Sprintf("httpfake/backend: no session [%s]", uniqueId))
+}
+
+func (fake *FakePortalBackend) UseAccount(authData *basic.UserAuthData, _ basic.CredentialProvider) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.Account = authData.Account()
+	return nil
+}
+
+func (fake *FakePortalBackend) CurrentIp(ctx context.Context) (ip string, err error) {
+	if err = fake.wait(ctx); err != nil {
+		return "", err
+	}
+	return fake.LocalIp, nil
+}
@@ -2,10 +2,12 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 	"xjtuportal/component/basic"
 )
 
@@ -26,17 +28,31 @@ type OnlineResponse struct {
 	Token       string `json:"token"`
 }
 
+// CreatedTime returns the time the portal created the response, zero if it is not given. The portal sends seconds or
+// milliseconds since epoch.
+func (onlineResponse *OnlineResponse) CreatedTime() time.Time {
+	switch {
+	case onlineResponse.CreatedTs <= 0:
+		return time.Time{}
+	case onlineResponse.CreatedTs > 1e12:
+		return time.Unix(0, onlineResponse.CreatedTs*int64(time.Millisecond))
+	default:
+		return time.Unix(onlineResponse.CreatedTs, 0)
+	}
+}
+
 type OnlineHelper struct {
 	loggerHelper          *basic.LoggerHelper
 	requestHelper         *RequestHelper
-	userOnlineSettings    *basic.UserOnlineSettings
 	programOnlineSettings *basic.ProgramOnlineSettings
 
-	onlineUrl       string
-	RedirectUrl     string
-	fakeRedirectUrl string
-	authData        *AuthData
-	OnlineResponse  *OnlineResponse
+	onlineUrl          string
+	RedirectUrl        string
+	fakeRedirectUrl    string
+	username           string // Username without domain, used as the key of credentials
+	authData           *AuthData
+	credentialProvider basic.CredentialProvider
+	OnlineResponse     *OnlineResponse
 }
 
 func InitOnlineHelper(configHelper *basic.ConfigHelper,
@@ -59,10 +75,15 @@ func InitOnlineHelper(configHelper *basic.ConfigHelper,
 		return nil, err
 	}
 
+	credentialProvider, err := basic.InitCredentialProvider(configHelper)
+	if err != nil {
+		return nil, err
+	}
+
+	activeAuthData := configHelper.UserSettings.UserOnlineSettings.ActiveAuthData()
 	onlineHelper := &OnlineHelper{
 		loggerHelper:          loggerHelper,
 		requestHelper:         requestHelper,
-		userOnlineSettings:    &configHelper.UserSettings.UserOnlineSettings,
 		programOnlineSettings: &configHelper.ProgramSettings.ProgramOnlineSettings,
 		onlineUrl: getUrl("http",
 			configHelper.ProgramSettings.ProgramOnlineSettings.PortalServer.Hostname,
@@ -73,22 +94,48 @@ func InitOnlineHelper(configHelper *basic.ConfigHelper,
 			configHelper.ProgramSettings.ProgramOnlineSettings.PortalServer.Hostname,
 			configHelper.ProgramSettings.ProgramOnlineSettings.PortalServer.FakeRedirectPath,
 		),
+		username: activeAuthData.Username,
 		authData: &AuthData{
 			DeviceType:  "PC",
 			RedirectUrl: "",
 			DataType:    "login",
-			Username: fmt.Sprintf("%s@%s",
-				configHelper.UserSettings.UserOnlineSettings.AuthData.Username,
-				configHelper.UserSettings.UserOnlineSettings.AuthData.Domain),
-			Password: configHelper.UserSettings.UserOnlineSettings.AuthData.Password,
+			Username:    activeAuthData.Account(),
+			Password:    "",
 		},
-		OnlineResponse: nil,
+		credentialProvider: credentialProvider,
+		OnlineResponse:     nil,
 	}
 
 	return onlineHelper, nil
 }
 
-func (onlineHelper *OnlineHelper) OnlinePost(redirectUrl string) (int, error) {
+// resolvePassword gets the password from credential provider on first use
+func (onlineHelper *OnlineHelper) resolvePassword() (err error) {
+	if onlineHelper.authData.Password != "" {
+		return nil
+	}
+	password, err := onlineHelper.credentialProvider.Password(onlineHelper.username)
+	if err != nil {
+		return errors.New(fmt.Sprintf("http/online: Cannot get password from [%s] source [%v]",
+			onlineHelper.credentialProvider.Source(), err))
+	}
+	onlineHelper.authData.Password = password
+	return nil
+}
+
+// UseAccount switches the account to login, the password is got from credentialProvider on next use
+func (onlineHelper *OnlineHelper) UseAccount(authData *basic.UserAuthData, credentialProvider basic.CredentialProvider) {
+	onlineHelper.username = authData.Username
+	onlineHelper.authData.Username = authData.Account()
+	onlineHelper.authData.Password = ""
+	onlineHelper.credentialProvider = credentialProvider
+}
+
+func (onlineHelper *OnlineHelper) OnlinePost(ctx context.Context, redirectUrl string) (int, error) {
+
+	if err := onlineHelper.resolvePassword(); err != nil {
+		return -1, err
+	}
 
 	onlineHelper.authData.RedirectUrl = redirectUrl
 
@@ -108,6 +155,7 @@ func (onlineHelper *OnlineHelper) OnlinePost(redirectUrl string) (int, error) {
 	cookies = append(cookies, &http.Cookie{Name: "redirectUrl", Value: redirectUrl})
 
 	_, body, statusCode, err := onlineHelper.requestHelper.SendRequest(
+		ctx,
 		onlineHelper.onlineUrl,
 		"POST",
 		&data,
@@ -131,9 +179,9 @@ func (onlineHelper *OnlineHelper) OnlinePost(redirectUrl string) (int, error) {
 
 }
 
-func (onlineHelper *OnlineHelper) GetAuthToken() (statusCode int, err error) {
+func (onlineHelper *OnlineHelper) GetAuthToken(ctx context.Context) (statusCode int, err error) {
 	// Request encountered error
-	statusCode, err = onlineHelper.OnlinePost(onlineHelper.fakeRedirectUrl)
+	statusCode, err = onlineHelper.OnlinePost(ctx, onlineHelper.fakeRedirectUrl)
 	if err != nil {
 		return statusCode, err
 	}
@@ -151,9 +199,10 @@ func (onlineHelper *OnlineHelper) GetAuthToken() (statusCode int, err error) {
 
 }
 
-func (onlineHelper *OnlineHelper) GetRedirectUrl() (statusCode int, err error) {
+func (onlineHelper *OnlineHelper) GetRedirectUrl(ctx context.Context) (statusCode int, err error) {
 	// TODO: implementation
 	response, _, statusCode, err := onlineHelper.requestHelper.SendRequest(
+		ctx,
 		onlineHelper.programOnlineSettings.BootStrapUrl,
 		"GET",
 		nil,
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
@@ -84,7 +85,7 @@ out:
 	}
 }
 
-func (ph *ProxyHelper) ProxyCheck() (proxies []string, programs []string, available []bool) {
+func (ph *ProxyHelper) ProxyCheck(ctx context.Context) (proxies []string, programs []string, available []bool) {
 	var wg sync.WaitGroup
 	i := 0
 	proxies = make([]string, len(ph.proxyPorts)*len(prefixes))
@@ -99,11 +100,11 @@ func (ph *ProxyHelper) ProxyCheck() (proxies []string, programs []string, availa
 			wg.Add(1)
 			go func(index int, proxyUrlStr string) {
 				defer wg.Done()
-				if !proxyExist(proxyUrlStr, ph.timeout) {
+				if !proxyExist(ctx, proxyUrlStr, ph.timeout) {
 					return
 				}
 				proxies[index] = proxyUrlStr
-				if UrlConnCheck(ph.testUrl, proxyUrlStr, ph.timeout) {
+				if UrlConnCheck(ctx, ph.testUrl, proxyUrlStr, ph.timeout) {
 					available[index] = true
 				}
 			}(checkIndex, fmt.Sprint(prefixes[j], proxy))
@@ -126,7 +127,7 @@ func (ph *ProxyHelper) ProxyCheck() (proxies []string, programs []string, availa
 
 }
 
-func proxyExist(proxyUrlStr string, timeout time.Duration) bool {
+func proxyExist(ctx context.Context, proxyUrlStr string, timeout time.Duration) bool {
 	proxyUrl, err := url.ParseRequestURI(proxyUrlStr)
 	if err != nil {
 		return false
@@ -138,18 +139,18 @@ func proxyExist(proxyUrlStr string, timeout time.Duration) bool {
 
 	switch strings.ToLower(proxyUrl.Scheme) {
 	case "socks5", "socks":
-		return socks5ProxyExist(proxyHost, timeout)
+		return socks5ProxyExist(ctx, proxyHost, timeout)
 	case "socks4":
-		return socks4ProxyExit(proxyHost, timeout)
+		return socks4ProxyExit(ctx, proxyHost, timeout)
 	case "http":
-		return httpProxyExist(proxyHost, timeout)
+		return httpProxyExist(ctx, proxyHost, timeout)
 	default:
 		return false
 	}
 }
 
-func httpProxyExist(proxyHost string, timeout time.Duration) bool {
-	hd, err := http.NewRequest(http.MethodConnect, fmt.Sprint("http://", proxyHost), nil)
+func httpProxyExist(ctx context.Context, proxyHost string, timeout time.Duration) bool {
+	hd, err := http.NewRequestWithContext(ctx, http.MethodConnect, fmt.Sprint("http://", proxyHost), nil)
 	if err != nil {
 		return false
 	}
@@ -164,24 +165,28 @@ func httpProxyExist(proxyHost string, timeout time.Duration) bool {
 	return true
 }
 
-func socks5ProxyExist(proxyHost string, timeout time.Duration) bool {
-	return socksProxyExist(5, proxyHost, timeout)
+func socks5ProxyExist(ctx context.Context, proxyHost string, timeout time.Duration) bool {
+	return socksProxyExist(ctx, 5, proxyHost, timeout)
 }
 
-func socks4ProxyExit(proxyHost string, timeout time.Duration) bool {
-	return socksProxyExist(4, proxyHost, timeout)
+func socks4ProxyExit(ctx context.Context, proxyHost string, timeout time.Duration) bool {
+	return socksProxyExist(ctx, 4, proxyHost, timeout)
 }
 
-func socksProxyExist(version int, proxyHost string, timeout time.Duration) bool {
+func socksProxyExist(ctx context.Context, version int, proxyHost string, timeout time.Duration) bool {
 	d := net.Dialer{Timeout: timeout}
-	conn, err := d.Dial("tcp", proxyHost)
+	conn, err := d.DialContext(ctx, "tcp", proxyHost)
 	if err != nil {
 		return false
 	}
 	defer func() {
 		_ = conn.Close()
 	}()
-	err = conn.SetReadDeadline(time.Now().Add(timeout * 2))
+	deadline := time.Now().Add(timeout * 2)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	err = conn.SetReadDeadline(deadline)
 	if err != nil {
 		return false
 	}
@@ -219,12 +224,12 @@ func socksProxyExist(version int, proxyHost string, timeout time.Duration) bool
 
 }
 
-func UrlConnCheck(tUrl string, proxyUrl string, timeout time.Duration) bool {
+func UrlConnCheck(ctx context.Context, tUrl string, proxyUrl string, timeout time.Duration) bool {
 
 	if _, err := url.ParseRequestURI(tUrl); err != nil {
 		return false
 	}
-	hd, err := http.NewRequest(http.MethodHead, tUrl, nil)
+	hd, err := http.NewRequestWithContext(ctx, http.MethodHead, tUrl, nil)
 	if err != nil {
 		return false
 	}
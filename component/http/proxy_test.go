@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"xjtuportal/component/basic"
@@ -25,6 +26,6 @@ func TestProxyCheck(t *testing.T) {
 		"10808:10810": {"v2rayN"},
 	}
 	p := InitProxyHelper(basic.LoggerTemp, conf)
-	fmt.Println(p.ProxyCheck())
+	fmt.Println(p.ProxyCheck(context.Background()))
 	//fmt.Println(UrlConnCheck(testUrl, "http://127.0.0.1:7890", 1*time.Second))
 }
@@ -1,16 +1,30 @@
 package http
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptrace"
+	"net/url"
+	"reflect"
+	"sync/atomic"
 	"time"
 	"xjtuportal/component/basic"
 )
 
+const (
+	defaultRequestTimeout = 10 // Seconds
+	idleConnTimeout       = 90 * time.Second
+	keepAliveInterval     = 30 * time.Second
+	maxIdleConnsPerHost   = 4
+)
+
 func getUrl(protocol string, hostname string, path string) (url string) {
 	url = fmt.Sprintf("%s://%s%s", protocol, hostname, path)
 	return
@@ -19,6 +33,9 @@ func getUrl(protocol string, hostname string, path string) (url string) {
 type RequestHelper struct {
 	loggerHelper    *basic.LoggerHelper
 	requestSettings *basic.ProgramRequestSettings
+	client          *http.Client // Shared by all requests to reuse connections
+	cookieJar       http.CookieJar
+	retryPolicy     *RetryPolicy
 }
 
 func InitRequestHelper(configHelper *basic.ConfigHelper, loggerHelper *basic.LoggerHelper) (httpHelper *RequestHelper, err error) {
@@ -38,6 +55,35 @@ func InitRequestHelper(configHelper *basic.ConfigHelper, loggerHelper *basic.Log
 		requestSettings: &configHelper.ProgramSettings.ProgramRequestSettings,
 	}
 
+	cookieJar, err := cookiejar.New(nil)
+	if err != nil {
+		err = errors.New(fmt.Sprintf("http/request: Cannot create cookie jar [%v]", err))
+		return nil, err
+	}
+	httpHelper.cookieJar = cookieJar
+
+	httpHelper.retryPolicy, err = InitRetryPolicy(loggerHelper, &httpHelper.requestSettings.Retry)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy: nil, // No proxy
+		DialContext: (&net.Dialer{
+			Timeout:   time.Duration(httpHelper.requestSettings.Connect.Timeout) * time.Second,
+			KeepAlive: keepAliveInterval,
+		}).DialContext,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     idleConnTimeout,
+		TLSHandshakeTimeout: time.Duration(httpHelper.requestSettings.Connect.Timeout) * time.Second,
+	}
+
+	httpHelper.client = &http.Client{
+		Transport:     transport,
+		CheckRedirect: httpHelper.redirectPolicy,
+		Jar:           cookieJar,
+	}
+
 	return httpHelper, nil
 }
 
@@ -45,14 +91,89 @@ func (requestHelper *RequestHelper) redirectPolicy(_ *http.Request, _ []*http.Re
 	return http.ErrUseLastResponse
 }
 
+// SameSettings returns true if a request helper initialized with configHelper would send requests the same way, so
+// this one and its connections can be kept when config is reloaded
+func (requestHelper *RequestHelper) SameSettings(configHelper *basic.ConfigHelper) bool {
+	return reflect.DeepEqual(*requestHelper.requestSettings, configHelper.ProgramSettings.ProgramRequestSettings)
+}
+
+// CloseIdleConnections closes the kept-alive connections, e.g. after network changes
+func (requestHelper *RequestHelper) CloseIdleConnections() {
+	requestHelper.client.CloseIdleConnections()
+}
+
+func (requestHelper *RequestHelper) requestTimeout() time.Duration {
+	if requestHelper.requestSettings.Timeout > 0 {
+		return time.Duration(requestHelper.requestSettings.Timeout) * time.Second
+	}
+	return defaultRequestTimeout * time.Second
+}
+
+// SendRequest sends request with the shared client, the request is cancelled when ctx is done or timed out.
+// Transient failures of GET and HEAD requests are retried by the retry policy. Requests of other methods are only
+// retried if no connection is made, e.g. dial timeouts, since the server may have acted on a request it received,
+// e.g. logged in or out.
 func (requestHelper *RequestHelper) SendRequest(
-	url string, method string, data io.Reader, header *http.Header, cookies []*http.Cookie,
+	ctx context.Context, requestUrl string, method string, data io.Reader, header *http.Header, cookies []*http.Cookie,
+) (
+	response *http.Response, body []byte, statusCode int, err error,
+) {
+
+	// Request data is read once to be sent again when retrying
+	var content []byte
+	if data != nil {
+		content, err = ioutil.ReadAll(data)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("http/request: Cannot read request data [%v]", err))
+			return nil, nil, -1, err
+		}
+	}
+
+	idempotent := method == http.MethodGet || method == http.MethodHead
+	var connected int32 // Set once a connection is got, i.e. the request may be sent
+	retryable := func(statusCode int, err error) bool {
+		if atomic.LoadInt32(&connected) == 0 {
+			return dialFailed(err) || Transient(statusCode, err)
+		}
+		return idempotent && Transient(statusCode, err)
+	}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(httptrace.GotConnInfo) {
+			atomic.StoreInt32(&connected, 1)
+		},
+	}
+
+	name := fmt.Sprintf("%s %s", method, requestUrl)
+	statusCode, err = requestHelper.retryPolicy.DoIf(ctx, name, retryable, func() (int, error) {
+		var attemptData io.Reader
+		if data != nil {
+			attemptData = bytes.NewReader(content)
+		}
+		atomic.StoreInt32(&connected, 0)
+		response, body, statusCode, err = requestHelper.send(httptrace.WithClientTrace(ctx, trace),
+			requestUrl, method, attemptData, header, cookies)
+		return statusCode, err
+	})
+	return response, body, statusCode, err
+}
+
+// dialFailed returns true if err is failing to make a connection, e.g. connection refused or no such host
+func dialFailed(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func (requestHelper *RequestHelper) send(
+	ctx context.Context, requestUrl string, method string, data io.Reader, header *http.Header, cookies []*http.Cookie,
 ) (
 	response *http.Response, body []byte, statusCode int, error error,
 ) {
 
+	ctx, cancel := context.WithTimeout(ctx, requestHelper.requestTimeout())
+	defer cancel()
+
 	// Create request
-	request, err := http.NewRequest(method, url, data)
+	request, err := http.NewRequestWithContext(ctx, method, requestUrl, data)
 	if err != nil {
 		err = errors.New(fmt.Sprintf("http/request: Cannot create request [%v]", err))
 		return nil, nil, -1, err
@@ -66,28 +187,13 @@ func (requestHelper *RequestHelper) SendRequest(
 		request.Header.Set(key, value)
 	}
 
-	// Set cookies
-	for _, cookie := range cookies {
-		request.AddCookie(cookie)
-	}
-
-	// Create http client
-	defaultTransport := &http.Transport{
-		Proxy: nil, // No proxy
-		DialContext: (&net.Dialer{
-			Timeout: time.Duration(requestHelper.requestSettings.Connect.Timeout) * time.Second,
-		}).DialContext,
-	}
-
-	client := &http.Client{
-		Transport:     defaultTransport,
-		CheckRedirect: requestHelper.redirectPolicy,
-	}
+	// Set cookies, they are stored in cookie jar to replace the cookies with the same name
+	requestHelper.setCookies(request.URL, cookies)
 
-	requestHelper.loggerHelper.AddLog(basic.DEBUG, fmt.Sprintf("http/request: Send [%s] request to [%s]", method, url))
+	requestHelper.loggerHelper.AddLog(basic.DEBUG, fmt.Sprintf("http/request: Send [%s] request to [%s]", method, requestUrl))
 
 	// Do request
-	response, err = client.Do(request)
+	response, err = requestHelper.client.Do(request)
 
 	// Request error
 	if err != nil { // Request error
@@ -105,9 +211,10 @@ func (requestHelper *RequestHelper) SendRequest(
 		return nil, nil, response.StatusCode, err
 	}
 
-	// Response not empty
+	// Response not empty, the body is kept since response is cleared when returning an error
+	responseBody := response.Body
 	defer func() {
-		err = response.Body.Close()
+		err = responseBody.Close()
 		if err != nil {
 			response = nil
 			body = nil
@@ -136,3 +243,18 @@ func (requestHelper *RequestHelper) SendRequest(
 
 	return response, content, response.StatusCode, nil
 }
+
+func (requestHelper *RequestHelper) setCookies(requestUrl *url.URL, cookies []*http.Cookie) {
+	if len(cookies) == 0 {
+		return
+	}
+	jarCookies := make([]*http.Cookie, 0, len(cookies))
+	for _, cookie := range cookies {
+		jarCookie := *cookie
+		if jarCookie.Path == "" {
+			jarCookie.Path = "/"
+		}
+		jarCookies = append(jarCookies, &jarCookie)
+	}
+	requestHelper.cookieJar.SetCookies(requestUrl, jarCookies)
+}
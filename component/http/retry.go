@@ -0,0 +1,121 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+	"xjtuportal/component/basic"
+)
+
+// Transient returns true if a failed operation may succeed when retried, i.e. timeouts, connection resets and 5xx
+// responses. Deterministic failures, e.g. 4xx responses or login errors returned by the portal, are never transient.
+func Transient(statusCode int, err error) bool {
+	if err == nil {
+		return false
+	}
+	if statusCode >= 500 {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// RetryPolicy retries transient failures with exponential backoff and jitter
+type RetryPolicy struct {
+	loggerHelper  *basic.LoggerHelper
+	retrySettings *basic.ProgramRetrySettings
+	mutex         sync.Mutex
+	random        *rand.Rand
+}
+
+func InitRetryPolicy(loggerHelper *basic.LoggerHelper, retrySettings *basic.ProgramRetrySettings) (*RetryPolicy, error) {
+
+	if loggerHelper == nil {
+		err := errors.New("http/retry: logger is invalid")
+		return nil, err
+	}
+
+	if retrySettings == nil {
+		err := errors.New("http/retry: retry settings are invalid")
+		return nil, err
+	}
+
+	retryPolicy := &RetryPolicy{
+		loggerHelper:  loggerHelper,
+		retrySettings: retrySettings,
+		random:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	return retryPolicy, nil
+}
+
+// Backoff returns the waiting time before the given retry (starting from 1), doubled each time with a random jitter
+func (retryPolicy *RetryPolicy) Backoff(retry int) time.Duration {
+
+	backoff := time.Duration(retryPolicy.retrySettings.Backoff) * time.Millisecond
+	maxBackoff := time.Duration(retryPolicy.retrySettings.MaxBackoff) * time.Millisecond
+	for i := 1; i < retry && (maxBackoff <= 0 || backoff < maxBackoff); i++ {
+		backoff *= 2
+	}
+	if maxBackoff > 0 && backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	if jitter := retryPolicy.retrySettings.Jitter; jitter > 0 && backoff > 0 {
+		retryPolicy.mutex.Lock()
+		backoff += time.Duration(retryPolicy.random.Float64() * jitter * float64(backoff))
+		retryPolicy.mutex.Unlock()
+	}
+	return backoff
+}
+
+// Do runs operation until it succeeds, fails with a non-transient error, runs out of attempts or ctx is done
+func (retryPolicy *RetryPolicy) Do(
+	ctx context.Context,
+	name string,
+	operation func() (statusCode int, err error),
+) (statusCode int, err error) {
+	return retryPolicy.DoIf(ctx, name, Transient, operation)
+}
+
+// DoIf runs operation like Do, failures are retried only if retryable returns true
+func (retryPolicy *RetryPolicy) DoIf(
+	ctx context.Context,
+	name string,
+	retryable func(statusCode int, err error) bool,
+	operation func() (statusCode int, err error),
+) (statusCode int, err error) {
+
+	attempts := retryPolicy.retrySettings.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		statusCode, err = operation()
+		if err == nil || attempt >= attempts || ctx.Err() != nil || !retryable(statusCode, err) {
+			return statusCode, err
+		}
+
+		backoff := retryPolicy.Backoff(attempt)
+		retryPolicy.loggerHelper.AddLog(basic.INFO, fmt.Sprintf(
+			"http/retry: [%s] failed with transient error [%v], retry %d/%d after [%v]",
+			name, err, attempt, attempts-1, backoff))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return statusCode, err
+		}
+	}
+}
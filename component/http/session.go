@@ -1,13 +1,14 @@
 package http
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"regexp"
 	"strconv"
+	"time"
 	"xjtuportal/component/basic"
 	"xjtuportal/component/device"
 )
@@ -16,66 +17,77 @@ var (
 	ipv4Regex = regexp.MustCompile(`(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)(\.(25[0-5]|2[0-4][0-9]|[01]?[0-9][0-9]?)){3}`)
 )
 
+type SessionPortal struct {
+	DeviceType        string `json:"deviceType"`
+	ExperienceEndTime int64  `json:"experienceEndTime"`
+	Username          string `json:"user_name"`
+	SessionId         string `json:"acct_session_id"`
+	NasIpAddr         string `json:"nas_ip_address"`
+	UserIpAddr        string `json:"framed_ip_address"`
+	UserMacAddr       string `json:"calling_station_id"`
+	StartTime         string `json:"acct_start_time"`
+	UniqueId          string `json:"acct_unique_id"`
+}
+
 type SessionListPortal struct {
-	Concurrency string `json:"concurrency"`
-	Sessions    []struct {
-		DeviceType        string `json:"deviceType"`
-		ExperienceEndTime int64  `json:"experienceEndTime"`
-		Username          string `json:"user_name"`
-		SessionId         string `json:"acct_session_id"`
-		NasIpAddr         string `json:"nas_ip_address"`
-		UserIpAddr        string `json:"framed_ip_address"`
-		UserMacAddr       string `json:"calling_station_id"`
-		StartTime         string `json:"acct_start_time"`
-		UniqueId          string `json:"acct_unique_id"`
-	} `json:"sessions"`
+	Concurrency string          `json:"concurrency"`
+	Sessions    []SessionPortal `json:"sessions"`
 }
 
 type Session struct {
-	SessionId        string
-	NasIpAddr        string
-	UserIpAddr       string
-	UserMacAddr      string
-	StartTime        string
-	UniqueId         string
-	IsCurrentSession bool
+	SessionId        string `json:"session_id" yaml:"session_id"`
+	NasIpAddr        string `json:"nas_ip_addr" yaml:"nas_ip_addr"`
+	UserIpAddr       string `json:"user_ip_addr" yaml:"user_ip_addr"`
+	UserMacAddr      string `json:"user_mac_addr" yaml:"user_mac_addr"`
+	StartTime        string `json:"start_time" yaml:"start_time"`
+	UniqueId         string `json:"unique_id" yaml:"unique_id"`
+	DeviceType       string `json:"device_type" yaml:"device_type"`
+	IsCurrentSession bool   `json:"is_current_session" yaml:"is_current_session"`
+
+	// Device is the known device with the same MAC address, set by the caller
+	Device *basic.KnownDevice `json:"device,omitempty" yaml:"device,omitempty"`
 }
 
 type SessionListHelper struct {
-	OnlineHelper    *OnlineHelper
-	loggerHelper    *basic.LoggerHelper
-	sessionSettings *basic.ProgramSessionSettings
-
-	sessionListUrl string
-	logoutUrl      string
-	getIpUrl       string
+	Backend      PortalBackend
+	loggerHelper *basic.LoggerHelper
+	tokenCache   *TokenCache
 
 	MacSessionMap  map[string]*Session
 	SessionMacList []string
+	Concurrency    int
 }
 
 func InitSessionListHelper(
 	configHelper *basic.ConfigHelper,
 	loggerHelper *basic.LoggerHelper,
-	requestHelper *RequestHelper,
+	backend PortalBackend,
 ) (*SessionListHelper, error) {
 
-	onlineHelper, err := InitOnlineHelper(configHelper, loggerHelper, requestHelper)
+	if configHelper == nil {
+		err := errors.New("http/session: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("http/session: logger is invalid")
+		return nil, err
+	}
+
+	if backend == nil {
+		err := errors.New("http/session: PortalBackend is invalid")
+		return nil, err
+	}
+
+	tokenCache, err := InitTokenCache(configHelper, loggerHelper)
 	if err != nil {
-		err = errors.New(fmt.Sprintf("http/session: Error creating OnlineHelper [%v]", err))
 		return nil, err
 	}
 
 	sessionListHelper := &SessionListHelper{
-		OnlineHelper:    onlineHelper,
-		loggerHelper:    loggerHelper,
-		sessionSettings: &configHelper.ProgramSettings.ProgramSessionSettings,
-		sessionListUrl: configHelper.ProgramSettings.ProgramSessionSettings.PortalServer.Hostname +
-			configHelper.ProgramSettings.ProgramSessionSettings.PortalServer.SessionListPath,
-		logoutUrl: configHelper.ProgramSettings.ProgramSessionSettings.PortalServer.Hostname +
-			configHelper.ProgramSettings.ProgramSessionSettings.PortalServer.LogoutPath,
-		getIpUrl: configHelper.ProgramSettings.ProgramSessionSettings.SpeedCheckServer.Hostname +
-			configHelper.ProgramSettings.ProgramSessionSettings.SpeedCheckServer.GetIpPath,
+		Backend:        backend,
+		loggerHelper:   loggerHelper,
+		tokenCache:     tokenCache,
 		MacSessionMap:  make(map[string]*Session),
 		SessionMacList: make([]string, 0),
 	}
@@ -84,36 +96,57 @@ func InitSessionListHelper(
 
 }
 
-func (sessionListHelper *SessionListHelper) sessionListPortalGet() (sessionListPortal *SessionListPortal, err error) {
-
-	_, err = sessionListHelper.OnlineHelper.GetAuthToken()
-	if err != nil {
-		err = errors.New(fmt.Sprintf("http/session: Cannot get token [%v]", err))
-		return nil, err
+// UseAccount switches the account used to login and manage sessions
+func (sessionListHelper *SessionListHelper) UseAccount(authData *basic.UserAuthData, credentialProvider basic.CredentialProvider) error {
+	if err := sessionListHelper.Backend.UseAccount(authData, credentialProvider); err != nil {
+		return err
 	}
+	sessionListHelper.tokenCache.UseAccount(authData.Account())
+	sessionListHelper.MacSessionMap = make(map[string]*Session)
+	sessionListHelper.SessionMacList = make([]string, 0)
+	sessionListHelper.Concurrency = 0
+	return nil
+}
 
-	header := &http.Header{}
-	header.Set("Authorization", sessionListHelper.OnlineHelper.OnlineResponse.Token)
-	cookies := make([]*http.Cookie, 0, 2)
-	cookies = append(cookies, &http.Cookie{
-		Name:  "token",
-		Value: sessionListHelper.OnlineHelper.OnlineResponse.Token,
-	})
+// authorized calls the portal with the cached token, the token is refreshed once if the portal rejects it
+func (sessionListHelper *SessionListHelper) authorized(
+	ctx context.Context,
+	call func(token string) (statusCode int, err error),
+) (statusCode int, err error) {
 
-	_, body, _, err := sessionListHelper.OnlineHelper.requestHelper.SendRequest(
-		sessionListHelper.sessionListUrl,
-		"GET",
-		nil,
-		header,
-		cookies,
-	)
+	var createdAt time.Time
+	token, cached := sessionListHelper.tokenCache.Get()
+	if !cached {
+		token, createdAt, statusCode, err = sessionListHelper.Backend.AuthToken(ctx)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("http/session: Cannot get token [%v]", err))
+			return statusCode, err
+		}
+		sessionListHelper.tokenCache.Set(token, createdAt)
+	}
 
-	if err != nil {
-		return nil, err
+	statusCode, err = call(token)
+	if cached && statusCode == http.StatusUnauthorized {
+		sessionListHelper.loggerHelper.AddLog(basic.INFO, "http/session: Cached token is rejected, authenticate again")
+		sessionListHelper.tokenCache.Invalidate()
+		token, createdAt, statusCode, err = sessionListHelper.Backend.AuthToken(ctx)
+		if err != nil {
+			err = errors.New(fmt.Sprintf("http/session: Cannot get token [%v]", err))
+			return statusCode, err
+		}
+		sessionListHelper.tokenCache.Set(token, createdAt)
+		statusCode, err = call(token)
 	}
+	return statusCode, err
+
+}
+
+func (sessionListHelper *SessionListHelper) sessionListPortalGet(ctx context.Context) (sessionListPortal *SessionListPortal, err error) {
 
-	sessionListPortal = &SessionListPortal{}
-	err = json.Unmarshal(body, sessionListPortal)
+	_, err = sessionListHelper.authorized(ctx, func(token string) (statusCode int, err error) {
+		sessionListPortal, statusCode, err = sessionListHelper.Backend.SessionList(ctx, token)
+		return statusCode, err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -122,21 +155,28 @@ func (sessionListHelper *SessionListHelper) sessionListPortalGet() (sessionListP
 
 }
 
-func (sessionListHelper *SessionListHelper) InitSessionListByPortal() (statusCode int, err error) {
+func (sessionListHelper *SessionListHelper) InitSessionListByPortal(ctx context.Context) (statusCode int, err error) {
 
 	sessionListHelper.SessionMacList = make([]string, 0)
 	sessionListHelper.MacSessionMap = make(map[string]*Session)
 
-	sessionListPortal, err := sessionListHelper.sessionListPortalGet()
+	sessionListPortal, err := sessionListHelper.sessionListPortalGet(ctx)
 
 	if err != nil {
 		return statusCode, err
 	}
 
-	if concurrency, err := strconv.Atoi(sessionListPortal.Concurrency); err != nil || concurrency == 0 {
+	concurrency, err := strconv.Atoi(sessionListPortal.Concurrency)
+	if err != nil || concurrency == 0 {
 		err = errors.New("http/session: error getting concurrency")
 		return -1, err
 	}
+	sessionListHelper.Concurrency = concurrency
+	basic.Metrics.SetGauge(basic.MetricSessionConcurrency, float64(concurrency))
+
+	defer func() {
+		basic.Metrics.SetGauge(basic.MetricSessions, float64(len(sessionListHelper.SessionMacList)))
+	}()
 
 	if len(sessionListPortal.Sessions) == 0 {
 		sessionListHelper.loggerHelper.AddLog(basic.WARNING, "http/session: No session")
@@ -186,6 +226,7 @@ func (sessionListHelper *SessionListHelper) InitSessionListByPortal() (statusCod
 			UserMacAddr:      sessionPortal.UserMacAddr,
 			StartTime:        sessionPortal.StartTime,
 			UniqueId:         sessionPortal.UniqueId,
+			DeviceType:       sessionPortal.DeviceType,
 			IsCurrentSession: false,
 		}
 
@@ -198,20 +239,14 @@ func (sessionListHelper *SessionListHelper) InitSessionListByPortal() (statusCod
 
 }
 
-func (sessionListHelper *SessionListHelper) FindCurrentSessionBySpeedTestApp() (err error) {
+func (sessionListHelper *SessionListHelper) FindCurrentSessionBySpeedTestApp(ctx context.Context) (err error) {
 
-	_, body, _, err := sessionListHelper.OnlineHelper.requestHelper.SendRequest(
-		sessionListHelper.getIpUrl,
-		"GET",
-		nil,
-		nil,
-		make([]*http.Cookie, 0, 0),
-	)
+	ip, err := sessionListHelper.Backend.CurrentIp(ctx)
 	if err != nil {
 		return err
 	}
 
-	currentIp := net.ParseIP(ipv4Regex.FindAllString(string(body), -1)[0])
+	currentIp := net.ParseIP(ip)
 	if currentIp == nil {
 		err = errors.New("http/session: cannot get a valid IP")
 		return err
@@ -247,30 +282,10 @@ func (sessionListHelper *SessionListHelper) FindCurrentSessionByLocalMacList(mac
 
 }
 
-func (sessionListHelper *SessionListHelper) LogoutDelete(uniqueId string) (statusCode int, err error) {
-
-	statusCode, err = sessionListHelper.OnlineHelper.GetAuthToken()
-	if err != nil {
-		err = errors.New(fmt.Sprintf("http/session: Cannot get token [%v]", err))
-		return statusCode, err
-	}
+func (sessionListHelper *SessionListHelper) LogoutDelete(ctx context.Context, uniqueId string) (statusCode int, err error) {
 
-	header := &http.Header{}
-	header.Set("Authorization", sessionListHelper.OnlineHelper.OnlineResponse.Token)
-	cookies := make([]*http.Cookie, 0, 2)
-	cookies = append(cookies, &http.Cookie{
-		Name:  "token",
-		Value: sessionListHelper.OnlineHelper.OnlineResponse.Token,
+	return sessionListHelper.authorized(ctx, func(token string) (int, error) {
+		return sessionListHelper.Backend.Logout(ctx, token, uniqueId)
 	})
 
-	_, _, statusCode, err = sessionListHelper.OnlineHelper.requestHelper.SendRequest(
-		fmt.Sprintf("%s/%s", sessionListHelper.logoutUrl, uniqueId),
-		"DELETE",
-		nil,
-		header,
-		cookies,
-	)
-
-	return statusCode, err
-
 }
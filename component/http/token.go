@@ -0,0 +1,173 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+	"xjtuportal/component/basic"
+)
+
+const (
+	defaultTokenLifetime = 300 // Seconds
+)
+
+type tokenState struct {
+	Account   string    `json:"account"`
+	Token     string    `json:"token"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenCache caches the auth token of the portal, optionally persisted to a state file between invocations. A token
+// expires lifetime after the portal created it, and the state file is locked while it is read or written, so processes
+// sharing it do not see a partly written file.
+type TokenCache struct {
+	mutex        sync.Mutex
+	loggerHelper *basic.LoggerHelper
+	account      string
+	lifetime     time.Duration
+	stateFile    string
+	state        tokenState
+}
+
+func InitTokenCache(configHelper *basic.ConfigHelper, loggerHelper *basic.LoggerHelper) (*TokenCache, error) {
+
+	if configHelper == nil {
+		err := errors.New("http/token: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("http/token: logger is invalid")
+		return nil, err
+	}
+
+	tokenSettings := &configHelper.UserSettings.UserAppSettings.UserPortalSettings.Token
+	lifetime := tokenSettings.Lifetime
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+
+	tokenCache := &TokenCache{
+		loggerHelper: loggerHelper,
+		account:      configHelper.UserSettings.UserOnlineSettings.ActiveAuthData().Account(),
+		lifetime:     time.Duration(lifetime) * time.Second,
+		stateFile:    basic.ResolvePath(configHelper.ConfigDir, tokenSettings.StateFile),
+	}
+	tokenCache.load()
+
+	return tokenCache, nil
+}
+
+// load reads token from state file, a missing or broken state file is ignored
+func (tokenCache *TokenCache) load() {
+
+	if tokenCache.stateFile == "" {
+		return
+	}
+
+	unlock, err := basic.LockFile(tokenCache.stateFile)
+	if err != nil {
+		tokenCache.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("http/token: Cannot lock state file [%v]", err))
+		return
+	}
+	content, err := ioutil.ReadFile(tokenCache.stateFile)
+	unlock()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			tokenCache.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("http/token: Cannot read state file [%v]", err))
+		}
+		return
+	}
+
+	state := tokenState{}
+	if err = json.Unmarshal(content, &state); err != nil {
+		tokenCache.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("http/token: Invalid state file [%v]", err))
+		return
+	}
+	if state.Account != tokenCache.account {
+		tokenCache.loggerHelper.AddLog(basic.DEBUG, "http/token: Token in state file belongs to another account, ignored")
+		return
+	}
+	tokenCache.state = state
+}
+
+// save writes token to state file if it is set
+func (tokenCache *TokenCache) save() {
+
+	if tokenCache.stateFile == "" {
+		return
+	}
+
+	content, err := json.MarshalIndent(tokenCache.state, "", "  ")
+	if err == nil {
+		var unlock func()
+		if unlock, err = basic.LockFile(tokenCache.stateFile); err == nil {
+			err = ioutil.WriteFile(tokenCache.stateFile, content, 0600)
+			unlock()
+		}
+	}
+	if err != nil {
+		tokenCache.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("http/token: Cannot write state file [%v]", err))
+	}
+}
+
+// Get returns the cached token if it has not expired
+func (tokenCache *TokenCache) Get() (token string, ok bool) {
+	tokenCache.mutex.Lock()
+	defer tokenCache.mutex.Unlock()
+
+	if tokenCache.state.Token == "" || !time.Now().Before(tokenCache.state.ExpiresAt) {
+		return "", false
+	}
+	tokenCache.loggerHelper.AddLog(basic.DEBUG,
+		fmt.Sprintf("http/token: Reuse token created at [%s]", tokenCache.state.CreatedAt.Format(time.RFC3339)))
+	return tokenCache.state.Token, true
+}
+
+// Set caches a new token created by the portal at createdAt, the current time is used if createdAt is zero or ahead of
+// the local clock
+func (tokenCache *TokenCache) Set(token string, createdAt time.Time) {
+	tokenCache.mutex.Lock()
+	defer tokenCache.mutex.Unlock()
+
+	if now := time.Now(); createdAt.IsZero() || createdAt.After(now) {
+		createdAt = now
+	}
+	tokenCache.state = tokenState{
+		Account:   tokenCache.account,
+		Token:     token,
+		CreatedAt: createdAt,
+		ExpiresAt: createdAt.Add(tokenCache.lifetime),
+	}
+	tokenCache.save()
+}
+
+// UseAccount switches the account of cached token, the token of the new account is loaded from state file if any
+func (tokenCache *TokenCache) UseAccount(account string) {
+	tokenCache.mutex.Lock()
+	defer tokenCache.mutex.Unlock()
+
+	if tokenCache.account == account {
+		return
+	}
+	tokenCache.account = account
+	tokenCache.state = tokenState{}
+	tokenCache.load()
+}
+
+// Invalidate drops the cached token, e.g. after the portal rejects it
+func (tokenCache *TokenCache) Invalidate() {
+	tokenCache.mutex.Lock()
+	defer tokenCache.mutex.Unlock()
+
+	if tokenCache.state.Token == "" {
+		return
+	}
+	tokenCache.state = tokenState{Account: tokenCache.account}
+	tokenCache.save()
+}
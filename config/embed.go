@@ -0,0 +1,9 @@
+// Package config embeds default config files into the binary
+package config
+
+import (
+	_ "embed"
+)
+
+//go:embed program-settings.yaml
+var ProgramSettings []byte // Default program settings, overridden by program-settings.yaml in config folder
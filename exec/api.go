@@ -0,0 +1,388 @@
+package exec
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"xjtuportal/component/app"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/device"
+	portalhttp "xjtuportal/component/http"
+)
+
+const (
+	unixSocketPrefix = "unix:"
+	defaultApiListen = "127.0.0.1:8350"
+)
+
+type apiResponse struct {
+	Ok    bool        `json:"ok"`
+	Error string      `json:"error,omitempty"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// apiError is an error with the HTTP status returned for it, other errors are returned with 500
+type apiError struct {
+	status int
+	err    error
+}
+
+func (err *apiError) Error() string {
+	return err.err.Error()
+}
+
+func badRequest(message string) error {
+	return &apiError{status: http.StatusBadRequest, err: errors.New(message)}
+}
+
+func notFound(message string) error {
+	return &apiError{status: http.StatusNotFound, err: errors.New(message)}
+}
+
+type logoutRequest struct {
+	Index    *int   `json:"index"`
+	Mac      string `json:"mac"`
+	UniqueId string `json:"unique_id"`
+}
+
+// apiModules are replaced as a whole when config is reloaded
+type apiModules struct {
+	portal          *app.PortalShellHelper
+	diagnosis       *app.DiagnosisShellHelper
+	loggerHelper    *basic.LoggerHelper
+	userApiSettings *basic.UserApiSettings
+}
+
+type ApiServer struct {
+	modules atomic.Value // *apiModules
+
+	// Portal helpers are stateful, only one operation is allowed at a time
+	mutex  sync.Mutex
+	server *http.Server
+}
+
+func InitApiServer(
+	configHelper *basic.ConfigHelper,
+	loggerHelper *basic.LoggerHelper,
+	portal *app.PortalShellHelper,
+	diagnosis *app.DiagnosisShellHelper,
+) (*ApiServer, error) {
+
+	if configHelper == nil {
+		err := errors.New("exec/api: ConfigHelper is invalid")
+		return nil, err
+	}
+
+	if loggerHelper == nil {
+		err := errors.New("exec/api: logger is invalid")
+		return nil, err
+	}
+
+	if portal == nil {
+		err := errors.New("exec/api: PortalShellHelper is invalid")
+		return nil, err
+	}
+
+	if diagnosis == nil {
+		err := errors.New("exec/api: DiagnosisShellHelper is invalid")
+		return nil, err
+	}
+
+	apiServer := &ApiServer{}
+	apiServer.modules.Store(&apiModules{
+		portal:          portal,
+		diagnosis:       diagnosis,
+		loggerHelper:    loggerHelper,
+		userApiSettings: &configHelper.UserSettings.UserUISettings.UserApiSettings,
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/login", apiServer.handle(http.MethodPost, apiServer.login))
+	mux.HandleFunc("/api/v1/sessions", apiServer.handle(http.MethodGet, apiServer.sessions))
+	mux.HandleFunc("/api/v1/logout", apiServer.handle(http.MethodPost, apiServer.logout))
+	mux.HandleFunc("/api/v1/diagnosis", apiServer.handle(http.MethodPost, apiServer.doDiagnosis))
+	mux.HandleFunc("/metrics", func(writer http.ResponseWriter, request *http.Request) {
+		if httpStatus, err := apiServer.checkRequest(request, http.MethodGet); err != nil {
+			apiServer.writeResponse(writer, httpStatus, nil, err)
+			return
+		}
+		basic.Metrics.ServeHTTP(writer, request)
+	})
+	apiServer.server = &http.Server{Handler: mux}
+
+	return apiServer, nil
+}
+
+func (apiServer *ApiServer) current() *apiModules {
+	return apiServer.modules.Load().(*apiModules)
+}
+
+// Handler returns the handler of all API endpoints
+func (apiServer *ApiServer) Handler() http.Handler {
+	return apiServer.server.Handler
+}
+
+// Reload replaces modules and settings with those of newServer built from the reloaded config.
+// The running operation is not affected, the new modules are used from the next request. Reload returns after the
+// running operation, so the former modules are no longer used and can be released.
+func (apiServer *ApiServer) Reload(newServer *ApiServer) {
+	apiServer.mutex.Lock()
+	defer apiServer.mutex.Unlock()
+	modules := newServer.current()
+	if modules.userApiSettings.Listen != apiServer.current().userApiSettings.Listen {
+		modules.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+			"exec/api: Listen address changed to [%s], restart to apply", modules.userApiSettings.Listen))
+	}
+	apiServer.modules.Store(modules)
+	modules.loggerHelper.AddLog(basic.WARNING, "exec/api: Config reloaded")
+}
+
+// listen creates a listener on a unix socket or a loopback TCP address
+func (apiServer *ApiServer) listen() (net.Listener, error) {
+
+	address := apiServer.current().userApiSettings.Listen
+	if address == "" {
+		address = defaultApiListen
+	}
+
+	if strings.HasPrefix(address, unixSocketPrefix) {
+		socketPath := strings.TrimPrefix(address, unixSocketPrefix)
+		// Remove stale socket left by last run, other files are never removed
+		if info, err := os.Lstat(socketPath); err == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				err = errors.New(fmt.Sprintf("exec/api: [%s] exists and is not a unix socket", socketPath))
+				return nil, err
+			}
+			_ = os.Remove(socketPath)
+		}
+		return listenUnix(socketPath)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		err = errors.New(fmt.Sprintf("exec/api: Refuse to listen on non-loopback address [%s]", address))
+		return nil, err
+	}
+	return net.Listen("tcp", address)
+}
+
+func (apiServer *ApiServer) authorized(request *http.Request) bool {
+	token := apiServer.current().userApiSettings.Token
+	if token == "" {
+		return true
+	}
+	expected := []byte("Bearer " + token)
+	return subtle.ConstantTimeCompare([]byte(request.Header.Get("Authorization")), expected) == 1
+}
+
+// loopbackHost returns true if Host of the request is a loopback name, so that pages of other sites cannot reach
+// the API by DNS rebinding. Requests over unix socket are not checked.
+func (apiServer *ApiServer) loopbackHost(request *http.Request) bool {
+	if strings.HasPrefix(apiServer.current().userApiSettings.Listen, unixSocketPrefix) {
+		return true
+	}
+	host := request.Host
+	if splitHost, _, err := net.SplitHostPort(host); err == nil {
+		host = splitHost
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	return strings.EqualFold(host, "localhost")
+}
+
+// checkRequest rejects requests from other sites, unauthorized requests and requests with wrong method. POST
+// requests must be sent in JSON, which pages of other sites cannot send without a CORS preflight.
+func (apiServer *ApiServer) checkRequest(request *http.Request, method string) (int, error) {
+	if !apiServer.loopbackHost(request) {
+		return http.StatusForbidden, errors.New(fmt.Sprintf("host [%s] not allowed", request.Host))
+	}
+	if !apiServer.authorized(request) {
+		return http.StatusUnauthorized, errors.New("unauthorized")
+	}
+	if request.Method != method {
+		return http.StatusMethodNotAllowed, errors.New(fmt.Sprintf("method [%s] not allowed", request.Method))
+	}
+	if method == http.MethodPost {
+		mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			return http.StatusUnsupportedMediaType, errors.New("content type must be application/json")
+		}
+	}
+	return http.StatusOK, nil
+}
+
+func (apiServer *ApiServer) writeResponse(writer http.ResponseWriter, httpStatus int, data interface{}, err error) {
+	response := &apiResponse{Ok: err == nil, Data: data}
+	if err != nil {
+		response.Error = err.Error()
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(httpStatus)
+	if err = json.NewEncoder(writer).Encode(response); err != nil {
+		apiServer.current().loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("exec/api: Cannot write response [%v]", err))
+	}
+}
+
+func (apiServer *ApiServer) handle(
+	method string,
+	handler func(modules *apiModules, request *http.Request) (interface{}, error),
+) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+
+		apiServer.current().loggerHelper.AddLog(basic.DEBUG,
+			fmt.Sprintf("exec/api: [%s] request to [%s]", request.Method, request.URL.Path))
+
+		if httpStatus, err := apiServer.checkRequest(request, method); err != nil {
+			apiServer.writeResponse(writer, httpStatus, nil, err)
+			return
+		}
+
+		apiServer.mutex.Lock()
+		data, err := handler(apiServer.current(), request)
+		apiServer.mutex.Unlock()
+
+		if err != nil {
+			httpStatus := http.StatusInternalServerError
+			if errApi, ok := err.(*apiError); ok {
+				httpStatus = errApi.status
+			}
+			apiServer.writeResponse(writer, httpStatus, data, err)
+			return
+		}
+		apiServer.writeResponse(writer, http.StatusOK, data, nil)
+	}
+}
+
+func (apiServer *ApiServer) login(modules *apiModules, request *http.Request) (interface{}, error) {
+	result := modules.portal.DoLogin(request.Context())
+	if !result.Success() {
+		return result, errors.New(result.Message)
+	}
+	return result, nil
+}
+
+func (apiServer *ApiServer) sessions(modules *apiModules, request *http.Request) (interface{}, error) {
+	return modules.portal.ListSession(request.Context())
+}
+
+func (apiServer *ApiServer) logout(modules *apiModules, request *http.Request) (interface{}, error) {
+
+	logoutReq := &logoutRequest{}
+	if err := json.NewDecoder(request.Body).Decode(logoutReq); err != nil {
+		return nil, badRequest(fmt.Sprintf("invalid request body [%v]", err))
+	}
+	if logoutReq.Index == nil && logoutReq.Mac == "" && logoutReq.UniqueId == "" {
+		return nil, badRequest("one of index, mac or unique_id is required")
+	}
+
+	// Refresh session list before logging out
+	sessions, err := modules.portal.ListSession(request.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case logoutReq.Index != nil:
+		if *logoutReq.Index < 0 || *logoutReq.Index >= len(sessions) {
+			return nil, notFound(fmt.Sprintf("no session [%d]", *logoutReq.Index))
+		}
+		return nil, modules.portal.DoLogout(request.Context(), *logoutReq.Index)
+	case logoutReq.Mac != "":
+		mac, err := device.MacStandardize(logoutReq.Mac)
+		if err != nil {
+			return nil, badRequest(fmt.Sprintf("invalid MAC address [%s]", logoutReq.Mac))
+		}
+		if findSession(sessions, func(session *portalhttp.Session) bool { return session.UserMacAddr == mac }) == nil {
+			return nil, notFound(fmt.Sprintf("no session with MAC address [%s]", logoutReq.Mac))
+		}
+		return nil, modules.portal.DoLogoutByMac(request.Context(), logoutReq.Mac)
+	default:
+		if findSession(sessions, func(session *portalhttp.Session) bool { return session.UniqueId == logoutReq.UniqueId }) == nil {
+			return nil, notFound(fmt.Sprintf("no session with unique id [%s]", logoutReq.UniqueId))
+		}
+		return nil, modules.portal.DoLogoutByUniqueId(request.Context(), logoutReq.UniqueId)
+	}
+}
+
+func findSession(sessions []*portalhttp.Session, match func(session *portalhttp.Session) bool) *portalhttp.Session {
+	for _, session := range sessions {
+		if match(session) {
+			return session
+		}
+	}
+	return nil
+}
+
+func (apiServer *ApiServer) doDiagnosis(modules *apiModules, request *http.Request) (interface{}, error) {
+	return modules.diagnosis.DoDiagnosis(request.Context()), nil
+}
+
+// Run serves the API until SIGINT or SIGTERM is received
+func (apiServer *ApiServer) Run() error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopChan := make(chan os.Signal, 1)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stopChan)
+	go func() {
+		select {
+		case sig := <-stopChan:
+			apiServer.current().loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("exec/api: Received signal [%v], stopping", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return apiServer.Serve(ctx)
+}
+
+// Serve serves the API until ctx is done, running requests are given 10 seconds to finish then
+func (apiServer *ApiServer) Serve(ctx context.Context) (err error) {
+
+	listener, err := apiServer.listen()
+	if err != nil {
+		apiServer.current().loggerHelper.AddLog(basic.FATAL, fmt.Sprintf("exec/api: Cannot listen [%v]", err))
+		return
+	}
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = apiServer.server.Shutdown(shutdownCtx)
+		case <-stopped:
+		}
+	}()
+
+	apiServer.current().loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("exec/api: API server listening on [%s]", listener.Addr()))
+	err = apiServer.server.Serve(listener)
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	if err != nil {
+		apiServer.current().loggerHelper.AddLog(basic.FATAL, fmt.Sprintf("exec/api: API server stopped with error [%v]", err))
+	}
+	return
+}
@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package exec
+
+import (
+	"net"
+	"os"
+)
+
+// listenUnix creates the unix socket with mode 0600, so that it is not connectable by other users. The umask is not
+// changed, since it applies to files created by other goroutines as well.
+func listenUnix(socketPath string) (net.Listener, error) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
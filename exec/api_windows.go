@@ -0,0 +1,10 @@
+package exec
+
+import (
+	"net"
+)
+
+// listenUnix creates the unix socket, access is controlled by the ACL of its folder on Windows
+func listenUnix(socketPath string) (net.Listener, error) {
+	return net.Listen("unix", socketPath)
+}
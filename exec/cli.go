@@ -0,0 +1,397 @@
+package exec
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"xjtuportal/component/app"
+)
+
+const (
+	// Subcommands
+	DefaultCommand  = ""
+	LoginCommand    = "login"
+	LogoutCommand   = "logout"
+	SessionsCommand = "sessions"
+	DiagnoseCommand = "diagnose"
+	AdaptersCommand = "adapters"
+	ConfigCommand   = "config"
+	DaemonCommand   = "daemon"
+	ApiCommand      = "api"
+	HistoryCommand  = "history"
+	VersionCommand  = "version"
+	HelpCommand     = "help"
+
+	// Actions of config subcommand
+	ConfigSetupAction    = "setup"
+	ConfigPathAction     = "path"
+	ConfigValidateAction = "validate"
+	ConfigDumpAction     = "dump"
+	ConfigMigrateAction  = "migrate"
+
+	programName = "xjtuportal"
+)
+
+// Options is the parsed command line
+type Options struct {
+	Command        string
+	ConfigDir      string
+	OutputFormat   string
+	Profile        string // Profile of auth data, empty for the default profile
+	LogoutIndex    int    // -1 if logout is not selected by index
+	LogoutSelector *app.LogoutSelector
+	DryRun         bool
+	ConfigAction   string
+	Effective      bool // Dump values given by embedded defaults too
+	HistoryQuery   *app.HistoryQuery
+}
+
+type subcommand struct {
+	name        string
+	usage       string
+	description string
+	// setup registers the flags of subcommand, validate checks options after parsing
+	setup    func(flagSet *flag.FlagSet, options *Options)
+	validate func(flagSet *flag.FlagSet, options *Options) error
+}
+
+var subcommandList = []*subcommand{
+	{
+		name:        LoginCommand,
+		usage:       "login [flags]",
+		description: "Login using auth data given in config file, auto logout is applied if enabled",
+	},
+	{
+		name:        LogoutCommand,
+		usage:       "logout [flags]",
+		description: "Logout sessions selected by index, MAC address, IP address or unique id",
+		setup:       setupLogoutFlags,
+		validate:    validateLogoutFlags,
+	},
+	{
+		name:        SessionsCommand,
+		usage:       "sessions [flags]",
+		description: "List current sessions",
+	},
+	{
+		name:        DiagnoseCommand,
+		usage:       "diagnose [flags]",
+		description: "Check http and DNS connectivity",
+	},
+	{
+		name:        AdaptersCommand,
+		usage:       "adapters [flags]",
+		description: "Check network adapter information",
+	},
+	{
+		name:        ConfigCommand,
+		usage:       "config <setup|path|validate|dump|migrate> [flags]",
+		description: "Manage config files\n  setup     Set username, password and auto logout interactively\n  path      Show paths of config files\n  validate  Check config files for unknown fields and invalid values\n  dump      Show values set by config files and environment variables with their origins\n  migrate   Upgrade config files of old versions to current format, originals are backed up",
+		setup:       setupConfigFlags,
+		validate:    validateConfigArgs,
+	},
+	{
+		name:        DaemonCommand,
+		usage:       "daemon [flags]",
+		description: "Run as a daemon that keeps the machine online",
+	},
+	{
+		name:        ApiCommand,
+		usage:       "api [flags]",
+		description: "Serve the local HTTP control API",
+	},
+	{
+		name:        HistoryCommand,
+		usage:       "history [flags]",
+		description: "Show recorded sessions, logins and logouts, filtered by MAC address, IP address and time",
+		setup:       setupHistoryFlags,
+		validate:    validateHistoryFlags,
+	},
+	{
+		name:        VersionCommand,
+		usage:       "version [flags]",
+		description: "Show current version",
+	},
+}
+
+func findSubcommand(name string) *subcommand {
+	for _, command := range subcommandList {
+		if command.name == name {
+			return command
+		}
+	}
+	return nil
+}
+
+func setupLogoutFlags(flagSet *flag.FlagSet, options *Options) {
+	selector := options.LogoutSelector
+	flagSet.IntVar(&options.LogoutIndex, "index", -1, "Logout with given index (shown by sessions)")
+	flagSet.Var((*StringListFlag)(&selector.MacList), "mac", "Logout sessions with given MAC addresses (repeatable or comma separated)")
+	flagSet.Var((*StringListFlag)(&selector.IpList), "ip", "Logout sessions with given IP addresses (repeatable or comma separated)")
+	flagSet.Var((*StringListFlag)(&selector.UniqueIdList), "id", "Logout sessions with given unique ids (repeatable or comma separated)")
+	flagSet.BoolVar(&selector.AllExceptCurrent, "all-except-current", false, "Logout all sessions except the current one")
+	flagSet.BoolVar(&selector.Unknown, "unknown", false, "Logout sessions whose MAC address is not in known MAC list")
+	flagSet.BoolVar(&options.DryRun, "dry-run", false, "Show the sessions that would be logged out without logging out")
+}
+
+func validateLogoutFlags(flagSet *flag.FlagSet, options *Options) error {
+	if options.LogoutIndex < 0 && options.LogoutSelector.Empty() {
+		return errors.New("no session to logout, use -index, -mac, -ip, -id, -all-except-current or -unknown")
+	}
+	if options.LogoutIndex >= 0 && !options.LogoutSelector.Empty() {
+		return errors.New("-index cannot be used with other logout targets")
+	}
+	if options.LogoutIndex >= 0 && options.DryRun {
+		return errors.New("-dry-run cannot be used with -index")
+	}
+	return nil
+}
+
+func setupHistoryFlags(flagSet *flag.FlagSet, options *Options) {
+	query := options.HistoryQuery
+	flagSet.StringVar(&query.Mac, "mac", "", "Show records of the given MAC address")
+	flagSet.StringVar(&query.Ip, "ip", "", "Show records of the given IP address")
+	flagSet.StringVar(&query.Event, "event", "", "Show records of the given event: session, login, logout or intruder")
+	flagSet.Var((*TimeFlag)(&query.Since), "since", "Show records since the given time, e.g. 2021-10-05, \"2021-10-05 09:00:00\", or 24h, 7d before now")
+	flagSet.Var((*TimeFlag)(&query.Until), "until", "Show records before the given time, in the same format as -since")
+	flagSet.IntVar(&query.Limit, "limit", 0, "Show only the latest records of the given number, 0 for all")
+}
+
+func validateHistoryFlags(flagSet *flag.FlagSet, options *Options) error {
+	query := options.HistoryQuery
+	if flagSet.NArg() > 0 {
+		return errors.New(fmt.Sprintf("unexpected arguments %v", flagSet.Args()))
+	}
+	switch query.Event {
+	case "", app.SessionEvent, app.LoginEvent, app.LogoutEvent, app.IntruderEvent:
+	default:
+		return errors.New(fmt.Sprintf("unknown event [%s], use session, login, logout or intruder", query.Event))
+	}
+	if query.Limit < 0 {
+		return errors.New("-limit cannot be negative")
+	}
+	if !query.Since.IsZero() && !query.Until.IsZero() && !query.Since.Before(query.Until) {
+		return errors.New("-since must be earlier than -until")
+	}
+	return nil
+}
+
+func setupConfigFlags(flagSet *flag.FlagSet, options *Options) {
+	flagSet.BoolVar(&options.Effective, "effective", false, "Dump the effective settings including embedded defaults")
+	flagSet.BoolVar(&options.DryRun, "dry-run", false, "Show the changes of migrate without writing config files")
+}
+
+func validateConfigArgs(flagSet *flag.FlagSet, options *Options) error {
+	if flagSet.NArg() == 0 {
+		return errors.New("missing action, use setup, path, validate, dump or migrate")
+	}
+	options.ConfigAction = flagSet.Arg(0)
+	switch options.ConfigAction {
+	case ConfigSetupAction, ConfigPathAction, ConfigValidateAction, ConfigDumpAction, ConfigMigrateAction:
+		// Flags may also follow the action, e.g. config dump --effective
+		if err := flagSet.Parse(flagSet.Args()[1:]); err != nil {
+			return err
+		}
+		if flagSet.NArg() > 0 {
+			return errors.New(fmt.Sprintf("unexpected arguments %v", flagSet.Args()))
+		}
+		if options.Effective && options.ConfigAction != ConfigDumpAction {
+			return errors.New("-effective can only be used with dump")
+		}
+		if options.DryRun && options.ConfigAction != ConfigMigrateAction {
+			return errors.New("-dry-run can only be used with migrate")
+		}
+		return nil
+	default:
+		return errors.New(fmt.Sprintf("unknown action [%s]", options.ConfigAction))
+	}
+}
+
+// addCommonFlags registers flags shared by all subcommands, defaults are inherited from global flags
+func addCommonFlags(flagSet *flag.FlagSet, options *Options) {
+	flagSet.StringVar(&options.ConfigDir, "c", options.ConfigDir, "The path of config folder")
+	flagSet.StringVar(&options.OutputFormat, "output", options.OutputFormat, "Output format of results: text, json or yaml")
+	flagSet.StringVar(&options.Profile, "profile", options.Profile, "The profile of auth data to use (default profile if not set)")
+}
+
+func subcommandUsage(output io.Writer, command *subcommand, flagSet *flag.FlagSet) func() {
+	return func() {
+		_, _ = fmt.Fprintf(output, "Usage: %s %s\n\n%s\n\nFlags:\n", programName, command.usage, command.description)
+		flagSet.PrintDefaults()
+	}
+}
+
+func globalUsage(output io.Writer, flagSet *flag.FlagSet) func() {
+	return func() {
+		_, _ = fmt.Fprintf(output, "Usage: %s [flags] <command> [command flags]\n\nCommands:\n", programName)
+		for _, command := range subcommandList {
+			_, _ = fmt.Fprintf(output, "  %-10s %s\n", command.name, strings.SplitN(command.description, "\n", 2)[0])
+		}
+		_, _ = fmt.Fprintf(output, "\nRun '%s help <command>' for flags of a command.\n", programName)
+		_, _ = fmt.Fprintf(output, "Without command, interactive mode or login is run according to ui.mode in user settings.\n\n")
+		_, _ = fmt.Fprintf(output, "Flags (single-letter action flags are deprecated, use commands instead):\n")
+		flagSet.PrintDefaults()
+	}
+}
+
+// legacyFlags are deprecated action flags kept as aliases of subcommands
+type legacyFlags struct {
+	version     bool
+	login       bool
+	logout      int
+	showSession bool
+	diagnosis   bool
+	adapter     bool
+	daemon      bool
+	api         bool
+	selector    app.LogoutSelector
+	dryRun      bool
+}
+
+// command maps the given legacy flags to a subcommand, more than one action is an error
+func (legacy *legacyFlags) command(flagSet *flag.FlagSet) (command string, err error) {
+	aliases := []struct {
+		set     bool
+		flag    string
+		command string
+	}{
+		{legacy.version, "-v", VersionCommand},
+		{legacy.login, "-i", LoginCommand},
+		{legacy.logout > -1, "-o", LogoutCommand},
+		{!legacy.selector.Empty(), "-logout-*", LogoutCommand},
+		{legacy.showSession, "-s", SessionsCommand},
+		{legacy.diagnosis, "-d", DiagnoseCommand},
+		{legacy.adapter, "-a", AdaptersCommand},
+		{legacy.daemon, "-D", DaemonCommand},
+		{legacy.api, "-A", ApiCommand},
+	}
+	usedFlags := make([]string, 0)
+	for _, alias := range aliases {
+		if !alias.set {
+			continue
+		}
+		if command != DefaultCommand && command != alias.command {
+			return "", errors.New(fmt.Sprintf("flags %s cannot be used together", strings.Join(append(usedFlags, alias.flag), ", ")))
+		}
+		command = alias.command
+		usedFlags = append(usedFlags, alias.flag)
+	}
+	if len(usedFlags) > 0 {
+		_, _ = fmt.Fprintf(flagSet.Output(), "Warning: flag %s is deprecated, use '%s %s' instead\n",
+			strings.Join(usedFlags, ", "), programName, command)
+	}
+	return command, nil
+}
+
+// ParseCommandLine parses subcommand and flags, errors are printed with usage
+func ParseCommandLine(args []string, defaultConfigDir string) (*Options, error) {
+
+	options := &Options{
+		ConfigDir:      defaultConfigDir,
+		OutputFormat:   TextOutput,
+		LogoutIndex:    -1,
+		LogoutSelector: &app.LogoutSelector{},
+		HistoryQuery:   &app.HistoryQuery{},
+	}
+
+	globalFlagSet := flag.NewFlagSet(programName, flag.ContinueOnError)
+	globalFlagSet.SetOutput(os.Stderr)
+	globalFlagSet.Usage = globalUsage(globalFlagSet.Output(), globalFlagSet)
+	addCommonFlags(globalFlagSet, options)
+
+	legacy := &legacyFlags{}
+	globalFlagSet.BoolVar(&legacy.version, "v", false, "Show current version (deprecated: version)")
+	globalFlagSet.BoolVar(&legacy.login, "i", false, "Login using auth data given in config file (deprecated: login)")
+	globalFlagSet.IntVar(&legacy.logout, "o", -1, "Logout with given index (deprecated: logout -index)")
+	globalFlagSet.BoolVar(&legacy.showSession, "s", false, "List current sessions (deprecated: sessions)")
+	globalFlagSet.BoolVar(&legacy.diagnosis, "d", false, "Check http and DNS connectivity (deprecated: diagnose)")
+	globalFlagSet.BoolVar(&legacy.adapter, "a", false, "Check network adapter information (deprecated: adapters)")
+	globalFlagSet.BoolVar(&legacy.daemon, "D", false, "Run as a daemon (deprecated: daemon)")
+	globalFlagSet.BoolVar(&legacy.api, "A", false, "Serve the local HTTP control API (deprecated: api)")
+	globalFlagSet.Var((*StringListFlag)(&legacy.selector.MacList), "logout-mac", "Logout sessions with given MAC addresses (deprecated: logout -mac)")
+	globalFlagSet.Var((*StringListFlag)(&legacy.selector.IpList), "logout-ip", "Logout sessions with given IP addresses (deprecated: logout -ip)")
+	globalFlagSet.Var((*StringListFlag)(&legacy.selector.UniqueIdList), "logout-id", "Logout sessions with given unique ids (deprecated: logout -id)")
+	globalFlagSet.BoolVar(&legacy.selector.AllExceptCurrent, "logout-all-except-current", false, "Logout all sessions except the current one (deprecated: logout -all-except-current)")
+	globalFlagSet.BoolVar(&legacy.selector.Unknown, "logout-unknown", false, "Logout sessions not in known MAC list (deprecated: logout -unknown)")
+	globalFlagSet.BoolVar(&legacy.dryRun, "dry-run", false, "Show the sessions that would be logged out (deprecated: logout -dry-run)")
+
+	if err := globalFlagSet.Parse(args); err != nil {
+		return nil, err
+	}
+
+	legacyCommand, err := legacy.command(globalFlagSet)
+	if err != nil {
+		_, _ = fmt.Fprintf(globalFlagSet.Output(), "Error: %v\n", err)
+		return nil, err
+	}
+
+	// Deprecated flags without subcommand
+	if globalFlagSet.NArg() == 0 {
+		options.Command = legacyCommand
+		options.LogoutIndex = legacy.logout
+		*options.LogoutSelector = legacy.selector
+		options.DryRun = legacy.dryRun
+		if options.DryRun && legacy.selector.Empty() {
+			err = errors.New("-dry-run can only be used with -logout-* flags")
+			_, _ = fmt.Fprintf(globalFlagSet.Output(), "Error: %v\n", err)
+			return nil, err
+		}
+		return options, nil
+	}
+	if legacyCommand != DefaultCommand {
+		err = errors.New("deprecated action flags cannot be used with commands")
+		_, _ = fmt.Fprintf(globalFlagSet.Output(), "Error: %v\n", err)
+		return nil, err
+	}
+
+	name := globalFlagSet.Arg(0)
+	if name == HelpCommand {
+		if command := findSubcommand(globalFlagSet.Arg(1)); command != nil {
+			subFlagSet := newSubcommandFlagSet(command, options)
+			subFlagSet.Usage()
+		} else {
+			globalFlagSet.Usage()
+		}
+		return nil, flag.ErrHelp
+	}
+
+	command := findSubcommand(name)
+	if command == nil {
+		err = errors.New(fmt.Sprintf("unknown command [%s]", name))
+		_, _ = fmt.Fprintf(globalFlagSet.Output(), "Error: %v\n\n", err)
+		globalFlagSet.Usage()
+		return nil, err
+	}
+
+	subFlagSet := newSubcommandFlagSet(command, options)
+	if err = subFlagSet.Parse(globalFlagSet.Args()[1:]); err != nil {
+		return nil, err
+	}
+	if command.validate != nil {
+		err = command.validate(subFlagSet, options)
+	} else if subFlagSet.NArg() > 0 {
+		err = errors.New(fmt.Sprintf("unexpected arguments %v", subFlagSet.Args()))
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(subFlagSet.Output(), "Error: %v\n\n", err)
+		subFlagSet.Usage()
+		return nil, err
+	}
+
+	options.Command = command.name
+	return options, nil
+}
+
+func newSubcommandFlagSet(command *subcommand, options *Options) *flag.FlagSet {
+	subFlagSet := flag.NewFlagSet(command.name, flag.ContinueOnError)
+	subFlagSet.SetOutput(os.Stderr)
+	subFlagSet.Usage = subcommandUsage(subFlagSet.Output(), command, subFlagSet)
+	addCommonFlags(subFlagSet, options)
+	if command.setup != nil {
+		command.setup(subFlagSet, options)
+	}
+	return subFlagSet
+}
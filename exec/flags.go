@@ -0,0 +1,58 @@
+package exec
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StringListFlag is a repeatable command line flag, each value can also be a comma separated list
+type StringListFlag []string
+
+func (list *StringListFlag) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *StringListFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*list = append(*list, item)
+		}
+	}
+	return nil
+}
+
+// TimeFlag is a command line flag of time, given as a local time like 2021-10-05 or "2021-10-05 09:00:00", a time in
+// RFC3339, or a duration before now like 30m, 24h or 7d
+type TimeFlag time.Time
+
+func (flagTime *TimeFlag) String() string {
+	if flagTime == nil || time.Time(*flagTime).IsZero() {
+		return ""
+	}
+	return time.Time(*flagTime).Format(time.RFC3339)
+}
+
+func (flagTime *TimeFlag) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if days, err := strconv.Atoi(strings.TrimSuffix(value, "d")); err == nil && strings.HasSuffix(value, "d") && days >= 0 {
+		*flagTime = TimeFlag(time.Now().AddDate(0, 0, -days))
+		return nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+		*flagTime = TimeFlag(time.Now().Add(-duration))
+		return nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04"} {
+		if parsed, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			*flagTime = TimeFlag(parsed)
+			return nil
+		}
+	}
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		*flagTime = TimeFlag(parsed)
+		return nil
+	}
+	return errors.New("invalid time, use a time like 2021-10-05 or \"2021-10-05 09:00:00\", or a duration like 24h or 7d")
+}
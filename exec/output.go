@@ -0,0 +1,248 @@
+package exec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+	"time"
+	"xjtuportal/component/app"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/http"
+)
+
+const (
+	// Output formats
+	TextOutput = "text"
+	JsonOutput = "json"
+	YamlOutput = "yaml"
+
+	// Exit codes
+	ExitSuccess = 0
+	ExitFailure = 1
+	ExitUsage   = 2
+)
+
+type sessionOutput struct {
+	Index         int `json:"index" yaml:"index"`
+	*http.Session `yaml:",inline"`
+}
+
+type sessionListOutput struct {
+	Concurrency int             `json:"concurrency" yaml:"concurrency"`
+	Sessions    []sessionOutput `json:"sessions" yaml:"sessions"`
+	Error       string          `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type logoutOutput struct {
+	Index   int           `json:"index" yaml:"index"`
+	Session *http.Session `json:"session,omitempty" yaml:"session,omitempty"`
+	Error   string        `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type logoutTargetOutput struct {
+	*http.Session `yaml:",inline"`
+	LoggedOut     bool   `json:"logged_out" yaml:"logged_out"`
+	Error         string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type selectedLogoutOutput struct {
+	DryRun   bool                 `json:"dry_run" yaml:"dry_run"`
+	Sessions []logoutTargetOutput `json:"sessions" yaml:"sessions"`
+	Error    string               `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+type configValidateOutput struct {
+	Valid  bool                 `json:"valid" yaml:"valid"`
+	Issues []*basic.ConfigIssue `json:"issues" yaml:"issues"`
+	Error  string               `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newConfigValidateOutput(issues []*basic.ConfigIssue, err error) *configValidateOutput {
+	output := &configValidateOutput{
+		Valid:  err == nil && len(basic.ConfigErrors(issues)) == 0,
+		Issues: make([]*basic.ConfigIssue, 0, len(issues)),
+	}
+	output.Issues = append(output.Issues, issues...)
+	if err != nil {
+		output.Error = err.Error()
+	}
+	return output
+}
+
+type configDumpOutput struct {
+	Values []*basic.ConfigValue `json:"values" yaml:"values"`
+	Error  string               `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newConfigDumpOutput(values []*basic.ConfigValue, err error) *configDumpOutput {
+	output := &configDumpOutput{Values: make([]*basic.ConfigValue, 0, len(values))}
+	output.Values = append(output.Values, values...)
+	if err != nil {
+		output.Error = err.Error()
+	}
+	return output
+}
+
+type configMigrateOutput struct {
+	DryRun  bool                     `json:"dry_run" yaml:"dry_run"`
+	Results []*basic.MigrationResult `json:"results" yaml:"results"`
+	Error   string                   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newConfigMigrateOutput(dryRun bool, results []*basic.MigrationResult, err error) *configMigrateOutput {
+	output := &configMigrateOutput{DryRun: dryRun, Results: results}
+	if err != nil {
+		output.Error = err.Error()
+	}
+	return output
+}
+
+// printMigrationResults prints the changes of each config file, one change per line
+func printMigrationResults(dryRun bool, results []*basic.MigrationResult) {
+	for _, result := range results {
+		switch {
+		case result.FromVersion == result.ToVersion:
+			fmt.Printf("%s: up to date (version %d)\n", result.File, result.ToVersion)
+			continue
+		case dryRun:
+			fmt.Printf("%s: would be upgraded from version %d to %d\n", result.File, result.FromVersion, result.ToVersion)
+		default:
+			fmt.Printf("%s: upgraded from version %d to %d, backup saved to %s\n",
+				result.File, result.FromVersion, result.ToVersion, result.Backup)
+		}
+		for _, change := range result.Changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+}
+
+// printConfigValues prints config values grouped by file, one value per line followed by its origin
+func printConfigValues(values []*basic.ConfigValue) {
+	file := ""
+	for _, value := range values {
+		if value.File != file {
+			file = value.File
+			fmt.Printf("# %s\n", file)
+		}
+		text := fmt.Sprint(value.Value)
+		if list, ok := value.Value.([]interface{}); ok {
+			items := make([]string, 0, len(list))
+			for _, item := range list {
+				items = append(items, fmt.Sprint(item))
+			}
+			text = fmt.Sprintf("[%s]", strings.Join(items, ", "))
+		} else if value.Value == nil {
+			text = ""
+		} else if strings.Contains(text, "\n") {
+			text = strconv.Quote(text)
+		}
+		fmt.Printf("%s: %s  # %s\n", value.Path, text, value.Origin)
+	}
+}
+
+type historyOutput struct {
+	File    string               `json:"file" yaml:"file"`
+	Records []*app.HistoryRecord `json:"records" yaml:"records"`
+	Error   string               `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newHistoryOutput(file string, records []*app.HistoryRecord, err error) *historyOutput {
+	output := &historyOutput{File: file, Records: make([]*app.HistoryRecord, 0, len(records))}
+	output.Records = append(output.Records, records...)
+	if err != nil {
+		output.Error = err.Error()
+	}
+	return output
+}
+
+// printHistoryRecords prints one record per line: time, event, MAC address, IP address and details of the event
+func printHistoryRecords(records []*app.HistoryRecord) {
+	for _, record := range records {
+		details := make([]string, 0)
+		switch record.Event {
+		case app.SessionEvent:
+			details = append(details, fmt.Sprintf("online since %s", record.StartTime))
+			if record.DeviceType != "" {
+				details = append(details, fmt.Sprintf("device type %s", record.DeviceType))
+			}
+		case app.LoginEvent:
+			details = append(details, fmt.Sprintf("%s, status code %d", record.Account, record.StatusCode))
+			if record.Message != "" {
+				details = append(details, record.Message)
+			}
+		case app.LogoutEvent:
+			details = append(details, fmt.Sprintf("reason %s", record.Reason))
+			if record.Detail != "" {
+				details = append(details, record.Detail)
+			}
+			if record.Duration > 0 {
+				details = append(details, fmt.Sprintf("online for %v", time.Duration(record.Duration)*time.Second))
+			}
+		case app.IntruderEvent:
+			details = append(details, fmt.Sprintf("unknown device using %s, online since %s", record.Account, record.StartTime))
+		}
+		if record.Error != "" {
+			details = append(details, fmt.Sprintf("failed: %s", record.Error))
+		} else if !record.Success {
+			details = append(details, "failed")
+		}
+		fmt.Printf("%s  %-8s  %-17s  %-15s  %s\n", record.Time.Local().Format("2006-01-02 15:04:05"), record.Event,
+			record.Mac, record.Ip, strings.Join(details, ", "))
+	}
+}
+
+func newSessionListOutput(concurrency int, sessions []*http.Session, err error) *sessionListOutput {
+	output := &sessionListOutput{
+		Concurrency: concurrency,
+		Sessions:    make([]sessionOutput, 0, len(sessions)),
+	}
+	for index, session := range sessions {
+		output.Sessions = append(output.Sessions, sessionOutput{Index: index, Session: session})
+	}
+	if err != nil {
+		output.Error = err.Error()
+	}
+	return output
+}
+
+func isValidOutputFormat(outputFormat string) bool {
+	switch outputFormat {
+	case TextOutput, JsonOutput, YamlOutput:
+		return true
+	default:
+		return false
+	}
+}
+
+// printOutput prints data in machine-readable format, nothing is printed in text format
+func printOutput(outputFormat string, data interface{}) (err error) {
+
+	var content []byte
+	switch outputFormat {
+	case TextOutput:
+		return nil
+	case JsonOutput:
+		content, err = json.MarshalIndent(data, "", "  ")
+	case YamlOutput:
+		content, err = yaml.Marshal(data)
+	default:
+		err = errors.New(fmt.Sprintf("exec/output: Unknown output format [%s]", outputFormat))
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(content))
+	return nil
+}
+
+// exitCodeOf maps the result of an operation to exit code
+func exitCodeOf(success bool) int {
+	if success {
+		return ExitSuccess
+	}
+	return ExitFailure
+}
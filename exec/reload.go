@@ -0,0 +1,110 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/http"
+)
+
+// reloadModules reads config files again and initializes all modules with the new config, reusing what former
+// modules can share
+func reloadModules(options *Options, former *ShellUi) (*ShellUi, error) {
+	configHelper, err := basic.InitConfigHelper(
+		fmt.Sprintf("%s/%s", options.ConfigDir, basic.UserConfigFile),
+		fmt.Sprintf("%s/%s", options.ConfigDir, basic.ProgramConfigFile),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = configHelper.UserSettings.UserOnlineSettings.UseProfile(options.Profile); err != nil {
+		return nil, err
+	}
+	return initModules(configHelper, options, former)
+}
+
+// sharedLogger returns the logger of former modules if logger settings are not changed, nil otherwise
+func (former *ShellUi) sharedLogger(configHelper *basic.ConfigHelper) *basic.LoggerHelper {
+	if former == nil || !former.loggerHelper.SameSettings(configHelper) {
+		return nil
+	}
+	return former.loggerHelper
+}
+
+// sharedRequestHelper returns the request helper of former modules if it logs to loggerHelper and request settings
+// are not changed, nil otherwise
+func (former *ShellUi) sharedRequestHelper(configHelper *basic.ConfigHelper, loggerHelper *basic.LoggerHelper) *http.RequestHelper {
+	if former == nil || former.loggerHelper != loggerHelper || !former.requestHelper.SameSettings(configHelper) {
+		return nil
+	}
+	return former.requestHelper
+}
+
+// release closes the log file and idle connections of former modules replaced by modules, those shared are kept. It
+// is called after apply returns, which waits for the running round or request using former modules.
+func (former *ShellUi) release(modules *ShellUi) {
+	if former.requestHelper != modules.requestHelper {
+		former.requestHelper.CloseIdleConnections()
+	}
+	if former.loggerHelper != modules.loggerHelper {
+		if err := former.loggerHelper.Close(); err != nil {
+			modules.loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("exec/reload: Cannot close former log file [%v]", err))
+		}
+	}
+}
+
+// watchConfig reloads config when config files are changed or SIGHUP is received, and passes modules initialized
+// with the new config to apply, which returns once the former modules are no longer used. Invalid config is logged
+// and the current one is kept. stop must be called when the long-running command exits.
+func (shellUi *ShellUi) watchConfig(apply func(newShellUi *ShellUi)) (stop func()) {
+
+	current := shellUi // Modules in use, replaced on each reload
+	loggerHelper := shellUi.loggerHelper
+	var changeChan <-chan struct{} // Only SIGHUP triggers reloading if config files cannot be watched
+	configWatcher, err := basic.InitConfigWatcher(shellUi.configHelper, loggerHelper)
+	if err != nil {
+		loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("exec/reload: Cannot watch config files [%v]", err))
+	} else {
+		configWatcher.Start()
+		changeChan = configWatcher.Changes()
+	}
+
+	hangupChan := make(chan os.Signal, 1)
+	signal.Notify(hangupChan, syscall.SIGHUP)
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+
+	go func() {
+		defer close(doneChan)
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-changeChan:
+			case sig := <-hangupChan:
+				loggerHelper.AddLog(basic.INFO, fmt.Sprintf("exec/reload: Received signal [%v]", sig))
+			}
+
+			newShellUi, err := reloadModules(shellUi.options, current)
+			if err != nil {
+				loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("exec/reload: Invalid config, keep the current one [%v]", err))
+				continue
+			}
+			apply(newShellUi)
+			current.release(newShellUi)
+			current = newShellUi
+			loggerHelper = newShellUi.loggerHelper
+		}
+	}()
+
+	return func() {
+		signal.Stop(hangupChan)
+		if configWatcher != nil {
+			configWatcher.Stop()
+		}
+		close(stopChan)
+		<-doneChan
+	}
+}
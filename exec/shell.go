@@ -2,15 +2,17 @@ package exec
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"github.com/eiannone/keyboard"
-	"gopkg.in/yaml.v3"
-	"io/ioutil"
+	"golang.org/x/term"
 	"os"
 	"os/exec"
+	"os/signal"
 	"regexp"
 	"runtime"
 	"strconv"
+	"strings"
 	"xjtuportal/component/app"
 	"xjtuportal/component/basic"
 	"xjtuportal/component/device"
@@ -32,41 +34,70 @@ var (
 	}
 )
 
+// interruptibleContext returns a context cancelled by Ctrl+C, stop must be called when the operation is done
+func interruptibleContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	interruptChan := make(chan os.Signal, 1)
+	signal.Notify(interruptChan, os.Interrupt)
+	go func() {
+		select {
+		case <-interruptChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(interruptChan)
+		cancel()
+	}
+}
+
 func pause(hint string) {
 	fmt.Println(hint)
 	_, _, _ = keyboard.GetSingleKey()
 }
 
 type ShellUi struct {
-	portal          *app.PortalShellHelper
-	diagnosis       *app.DiagnosisShellHelper
-	configHelper    *basic.ConfigHelper
-	loggerHelper    *basic.LoggerHelper
-	configDir       string
-	loginFlag       bool
-	logoutFlag      int
-	showSessionFlag bool
-	diagnosisFlag   bool
+	portal        *app.PortalShellHelper
+	diagnosis     *app.DiagnosisShellHelper
+	daemon        *app.DaemonHelper
+	api           *ApiServer
+	configHelper  *basic.ConfigHelper
+	loggerHelper  *basic.LoggerHelper
+	requestHelper *http.RequestHelper
+	configDir     string
+	options       *Options
+	outputFlag    string
 }
 
-func InitShellUi(
-	versionFlag bool,
-	configFlag string,
-	loginFlag bool,
-	logoutFlag int,
-	showSessionFlag bool,
-	diagnosisFlag bool,
-	adapterFlag bool,
-) *ShellUi {
+func InitShellUi(options *Options) (*ShellUi, int) {
+
+	configFlag := options.ConfigDir
+	outputFlag := options.OutputFormat
 
-	if versionFlag {
-		fmt.Println(app.ProgramInfo())
-		return nil
+	if !isValidOutputFormat(outputFlag) {
+		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("exec/shell: Unknown output format [%s]", outputFlag))
+		return nil, ExitFailure
+	}
+
+	if options.Command == VersionCommand {
+		if outputFlag == TextOutput {
+			fmt.Println(app.ProgramInfo())
+		} else {
+			_ = printOutput(outputFlag, app.ProgramVersion())
+		}
+		return nil, ExitSuccess
 	}
 
-	if adapterFlag {
+	if options.Command == AdaptersCommand {
 		ifList, _, _, err := device.GetLocalInterfaceInfo()
-		if err != nil {
+		if outputFlag != TextOutput {
+			if err != nil {
+				_ = printOutput(outputFlag, map[string]string{"error": err.Error()})
+			} else {
+				_ = printOutput(outputFlag, ifList)
+			}
+		} else if err != nil {
 			fmt.Println(err)
 		} else if len(ifList) == 0 {
 			fmt.Println("Cannot get any interfaces")
@@ -75,7 +106,70 @@ func InitShellUi(
 				fmt.Println(i)
 			}
 		}
-		return nil
+		return nil, exitCodeOf(err == nil && len(ifList) > 0)
+	}
+
+	if options.Command == ConfigCommand && options.ConfigAction == ConfigPathAction {
+		fmt.Println(fmt.Sprintf("%s/%s", configFlag, basic.UserConfigFile))
+		fmt.Println(fmt.Sprintf("%s/%s", configFlag, basic.ProgramConfigFile))
+		return nil, ExitSuccess
+	}
+
+	if options.Command == ConfigCommand && options.ConfigAction == ConfigValidateAction {
+		issues, err := basic.ValidateConfig(
+			fmt.Sprintf("%s/%s", configFlag, basic.UserConfigFile),
+			fmt.Sprintf("%s/%s", configFlag, basic.ProgramConfigFile),
+		)
+		if outputFlag != TextOutput {
+			_ = printOutput(outputFlag, newConfigValidateOutput(issues, err))
+		} else if err != nil {
+			fmt.Println(err)
+		} else {
+			for _, issue := range issues {
+				fmt.Println(issue)
+			}
+			if len(basic.ConfigErrors(issues)) == 0 {
+				fmt.Println("Config files are valid")
+			}
+		}
+		return nil, exitCodeOf(err == nil && len(basic.ConfigErrors(issues)) == 0)
+	}
+
+	if options.Command == ConfigCommand && options.ConfigAction == ConfigDumpAction {
+		values, err := basic.DumpConfig(
+			fmt.Sprintf("%s/%s", configFlag, basic.UserConfigFile),
+			fmt.Sprintf("%s/%s", configFlag, basic.ProgramConfigFile),
+			options.Effective,
+		)
+		if outputFlag != TextOutput {
+			_ = printOutput(outputFlag, newConfigDumpOutput(values, err))
+		} else if err != nil {
+			fmt.Println(err)
+		} else {
+			printConfigValues(values)
+		}
+		return nil, exitCodeOf(err == nil)
+	}
+
+	if options.Command == ConfigCommand && options.ConfigAction == ConfigMigrateAction {
+		results := make([]*basic.MigrationResult, 0, 2)
+		result, err := basic.MigrateUserSettings(fmt.Sprintf("%s/%s", configFlag, basic.UserConfigFile), options.DryRun)
+		if err == nil {
+			results = append(results, result)
+			result, err = basic.MigrateProgramSettings(fmt.Sprintf("%s/%s", configFlag, basic.ProgramConfigFile), options.DryRun)
+		}
+		if err == nil {
+			results = append(results, result)
+		}
+		if outputFlag != TextOutput {
+			_ = printOutput(outputFlag, newConfigMigrateOutput(options.DryRun, results, err))
+		} else {
+			printMigrationResults(options.DryRun, results)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+		return nil, exitCodeOf(err == nil)
 	}
 
 	configHelper, err := basic.InitConfigHelper(
@@ -85,35 +179,73 @@ func InitShellUi(
 	if err != nil {
 		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
 		pause("Press any key to exit...")
-		return nil
+		return nil, ExitFailure
 	}
 
-	loggerHelper, err := basic.InitLoggerHelper(configHelper)
-	if err != nil {
+	if err = configHelper.UserSettings.UserOnlineSettings.UseProfile(options.Profile); err != nil {
 		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
-		pause("Press any key to exit...")
-		return nil
+		return nil, ExitUsage
 	}
-	loggerHelper.AddLog(basic.INFO, "Basic module successfully initialized")
 
-	requestHelper, err := http.InitRequestHelper(configHelper, loggerHelper)
+	shellUi, err := initModules(configHelper, options, nil)
 	if err != nil {
 		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
-		return nil
+		return nil, ExitFailure
+	}
+	return shellUi, ExitSuccess
+
+}
+
+// initModules initializes all modules with the given config, also used to rebuild modules when config is reloaded.
+// The logger and request helper of former modules are kept if their settings are not changed, former is nil at start.
+func initModules(configHelper *basic.ConfigHelper, options *Options, former *ShellUi) (*ShellUi, error) {
+
+	loggerHelper := former.sharedLogger(configHelper)
+	if loggerHelper == nil {
+		var err error
+		loggerHelper, err = basic.InitLoggerHelper(configHelper)
+		if err != nil {
+			return nil, err
+		}
+		if options.OutputFormat != TextOutput {
+			// Keep stdout clean for machine-readable output
+			loggerHelper.SetConsoleWriter(os.Stderr)
+		}
+	}
+	loggerHelper.AddLog(basic.INFO, "Basic module successfully initialized")
+	for _, warning := range configHelper.Warnings {
+		loggerHelper.AddLog(basic.WARNING, fmt.Sprintf("exec/shell: %s", warning))
+	}
+	for file, version := range map[string]int{
+		basic.UserConfigFile:    configHelper.UserSettings.Version,
+		basic.ProgramConfigFile: configHelper.ProgramSettings.Version,
+	} {
+		if version < basic.ConfigVersion { // Outdated files are upgraded in memory
+			loggerHelper.AddLog(basic.WARNING, fmt.Sprintf(
+				"exec/shell: Config version of [%s] is [%d], run \"%s config migrate\" to upgrade it to [%d]",
+				file, version, programName, basic.ConfigVersion))
+		}
+	}
+
+	requestHelper := former.sharedRequestHelper(configHelper, loggerHelper)
+	if requestHelper == nil {
+		var err error
+		requestHelper, err = http.InitRequestHelper(configHelper, loggerHelper)
+		if err != nil {
+			return nil, err
+		}
 	}
 	loggerHelper.AddLog(basic.DEBUG, "RequestHelper successfully initialized")
 
 	dnsHelper, err := http.InitDnsHelper(configHelper, loggerHelper)
 	if err != nil {
-		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
-		return nil
+		return nil, err
 	}
 	loggerHelper.AddLog(basic.DEBUG, "DnsHelper successfully initialized")
 
 	connectivityChecker, err := http.InitConnectivityChecker(configHelper, loggerHelper, requestHelper, dnsHelper)
 	if err != nil {
-		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
-		return nil
+		return nil, err
 	}
 	loggerHelper.AddLog(basic.DEBUG, "ConnectivityChecker successfully initialized")
 
@@ -122,45 +254,66 @@ func InitShellUi(
 
 	diagnosisHelper, err := app.InitDiagnosisHelper(configHelper, loggerHelper, connectivityChecker, proxyChecker)
 	if err != nil {
-		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
-		return nil
+		return nil, err
 	}
 	loggerHelper.AddLog(basic.DEBUG, "DiagnosisShellHelper successfully initialized")
 
-	sessionListHelper, err := http.InitSessionListHelper(configHelper, loggerHelper, requestHelper)
+	portalBackend, err := http.InitHttpPortalBackend(configHelper, loggerHelper, requestHelper)
 	if err != nil {
-		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
-		return nil
+		return nil, err
+	}
+	loggerHelper.AddLog(basic.DEBUG, "HttpPortalBackend successfully initialized")
+
+	sessionListHelper, err := http.InitSessionListHelper(configHelper, loggerHelper, portalBackend)
+	if err != nil {
+		return nil, err
 	}
 	loggerHelper.AddLog(basic.DEBUG, "SessionListHelper successfully initialized")
 
 	interfaceHelper, err := device.InitInterfaceHelper(configHelper, loggerHelper)
 	if err != nil {
-		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
-		return nil
+		return nil, err
 	}
 	loggerHelper.AddLog(basic.DEBUG, "InterfaceHelper successfully initialized")
 
 	portalHelper, err := app.InitPortalShellHelper(configHelper, loggerHelper, connectivityChecker, sessionListHelper, interfaceHelper)
 	if err != nil {
-		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
-		return nil
+		return nil, err
 	}
 	loggerHelper.AddLog(basic.DEBUG, "PortalShellHelper successfully initialized")
+	diagnosisHelper.SetNotifier(portalHelper.Notifier())
+
+	if options.OutputFormat != TextOutput {
+		portalHelper.SetPrintHint(false)
+		diagnosisHelper.SetPrintHint(false)
+	}
+
+	daemonHelper, err := app.InitDaemonHelper(configHelper, loggerHelper, connectivityChecker, portalHelper)
+	if err != nil {
+		return nil, err
+	}
+	loggerHelper.AddLog(basic.DEBUG, "DaemonHelper successfully initialized")
+
+	apiServer, err := InitApiServer(configHelper, loggerHelper, portalHelper, diagnosisHelper)
+	if err != nil {
+		return nil, err
+	}
+	loggerHelper.AddLog(basic.DEBUG, "ApiServer successfully initialized")
 
 	shellUi := &ShellUi{
-		portal:          portalHelper,
-		diagnosis:       diagnosisHelper,
-		configHelper:    configHelper,
-		loggerHelper:    loggerHelper,
-		configDir:       configFlag,
-		loginFlag:       loginFlag,
-		logoutFlag:      logoutFlag,
-		showSessionFlag: showSessionFlag,
-		diagnosisFlag:   diagnosisFlag,
-	}
-	basic.LoggerTemp.AddLog(basic.INFO, "All modules successfully initialized")
-	return shellUi
+		portal:        portalHelper,
+		diagnosis:     diagnosisHelper,
+		daemon:        daemonHelper,
+		api:           apiServer,
+		configHelper:  configHelper,
+		loggerHelper:  loggerHelper,
+		requestHelper: requestHelper,
+		configDir:     options.ConfigDir,
+		options:       options,
+		outputFlag:    options.OutputFormat,
+	}
+	loggerHelper.AddLog(basic.INFO, "All modules successfully initialized")
+	return shellUi, nil
 
 }
 
@@ -182,6 +335,23 @@ func (shellUi *ShellUi) getInput() (string, error) {
 
 }
 
+// readSecret reads a line without echo if stdin is a terminal
+func (shellUi *ShellUi) readSecret() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return shellUi.getInput()
+	}
+	secret, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// quickSettingInteract asks for the default auth data and auto logout, then writes them to user settings. Only the
+// fields asked are changed in the file, other fields, comments and overrides by environment variables are left as they
+// are. The password is stored in vault if a passphrase is given, otherwise in plaintext if the user agrees.
 func (shellUi *ShellUi) quickSettingInteract() bool {
 
 	interactHint := shellUi.configHelper.ProgramSettings.ProgramUiSettings.ProgramShellSettings.InteractHint
@@ -191,52 +361,86 @@ func (shellUi *ShellUi) quickSettingInteract() bool {
 	username, _ := shellUi.getInput()
 
 	fmt.Println(interactHint.QuickSetting.Password)
-	password, _ := shellUi.getInput()
+	password, _ := shellUi.readSecret()
 
 	fmt.Println(interactHint.QuickSetting.AutoLogout)
 	autoLogout, _ := shellUi.getInput()
 
+	fmt.Println(interactHint.QuickSetting.Passphrase)
+	passphrase, _ := shellUi.readSecret()
+	if passphrase != "" {
+		fmt.Println(interactHint.QuickSetting.PassphraseConfirm)
+		passphraseConfirm, _ := shellUi.readSecret()
+		if passphraseConfirm != passphrase {
+			fmt.Println(interactHint.QuickSetting.PassphraseMismatch)
+			fmt.Println(interactHint.BasicHint.Failed)
+			return false
+		}
+	} else {
+		fmt.Println(interactHint.QuickSetting.PlainPassword)
+		if plain, _ := shellUi.getInput(); plain != "y" {
+			fmt.Println(interactHint.BasicHint.Failed)
+			return false
+		}
+	}
+
 	// Confirmation
 	fmt.Println("==========================")
 	fmt.Printf("%s\n%s\n", interactHint.QuickSetting.Username, username)
-	fmt.Printf("%s\n%s\n", interactHint.QuickSetting.Password, password)
+	fmt.Printf("%s\n%s\n", interactHint.QuickSetting.Password, strings.Repeat("*", len([]rune(password))))
 	fmt.Printf("%s\n%s\n", interactHint.QuickSetting.AutoLogout, autoLogout)
 
 	fmt.Println(interactHint.QuickSetting.Confirm)
 	confirm, _ := shellUi.getInput()
+	if confirm != "y" {
+		fmt.Println(interactHint.BasicHint.Failed)
+		return false
+	}
 
-	// Write new config to file
-	if confirm == "y" {
-		shellUi.configHelper.UserSettings.UserOnlineSettings.AuthData.Username = username
-		shellUi.configHelper.UserSettings.UserOnlineSettings.AuthData.Password = password
-		shellUi.configHelper.UserSettings.UserAppSettings.UserPortalSettings.IsAutoLogout = autoLogout == "y"
-		ret, err := yaml.Marshal(&shellUi.configHelper.UserSettings)
-		if err != nil { // Convert to yaml error
-			shellUi.loggerHelper.AddLog(basic.DEBUG, fmt.Sprintf("%v", err))
-			fmt.Println(interactHint.BasicHint.Failed)
-			return false
-		}
-		// Write to file
-		err = ioutil.WriteFile(fmt.Sprintf("%s/%s", shellUi.configDir, basic.UserConfigFile), ret, 0644)
-		if err != nil { // Write to file err
-			shellUi.loggerHelper.AddLog(basic.DEBUG, fmt.Sprintf("%v", err))
+	values := map[string]interface{}{
+		"online.auth_data.username": username,
+		"app.portal.auto_logout":    autoLogout == "y",
+	}
+	removed := []string{"online.auth_data.password_source"} // Plaintext if not given
+	if passphrase != "" {
+		// Encrypt password into vault of the default auth data instead of writing it to YAML
+		vaultPath := basic.VaultFilePath(shellUi.configHelper.ConfigDir, &shellUi.configHelper.UserSettings.UserOnlineSettings.AuthData)
+		err := basic.StoreVaultPassword(vaultPath, passphrase, username, password)
+		if err != nil {
+			shellUi.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
 			fmt.Println(interactHint.BasicHint.Failed)
 			return false
 		}
-		fmt.Println(interactHint.BasicHint.Success)
-		return true
+		values["online.auth_data.password_source"] = basic.VaultSource
+		removed = []string{"online.auth_data.password"}
 	} else {
+		values["online.auth_data.password"] = password
+	}
+
+	// Write to file, which may hold the password
+	confPath := fmt.Sprintf("%s/%s", shellUi.configDir, basic.UserConfigFile)
+	err := basic.EditConfigFile(confPath, values, removed)
+	if err == nil {
+		err = os.Chmod(confPath, 0600)
+	}
+	if err != nil {
+		shellUi.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
 		fmt.Println(interactHint.BasicHint.Failed)
 		return false
 	}
+	fmt.Println(interactHint.BasicHint.Success)
+	return true
 }
 
 func (shellUi *ShellUi) logoutInteract() {
 
 	interactHint := shellUi.configHelper.ProgramSettings.ProgramUiSettings.ProgramShellSettings.InteractHint
 
-	err := shellUi.portal.DoListSession()
-	if err != nil {
+	var listErr error
+	err := shellUi.runInterruptible(func(ctx context.Context) {
+		listErr = shellUi.portal.DoListSession(ctx)
+	})
+	if err != nil || listErr != nil {
 		return
 	}
 	fmt.Println(interactHint.BasicHint.CommandSelect)
@@ -250,7 +454,21 @@ func (shellUi *ShellUi) logoutInteract() {
 		fmt.Println(interactHint.BasicHint.SelectError)
 		return
 	}
-	shellUi.portal.DoLogout(sessionIndex)
+	_ = shellUi.runInterruptible(func(ctx context.Context) {
+		_ = shellUi.portal.DoLogout(ctx, sessionIndex)
+	})
+}
+
+// runInterruptible runs an operation of interactive mode, Ctrl+C aborts the operation instead of exiting
+func (shellUi *ShellUi) runInterruptible(operation func(ctx context.Context)) (err error) {
+	ctx, stop := interruptibleContext()
+	defer stop()
+	operation(ctx)
+	if err = ctx.Err(); err != nil {
+		shellUi.loggerHelper.AddLog(basic.WARNING, "exec/shell: Operation aborted by user")
+		fmt.Println(shellUi.configHelper.ProgramSettings.ProgramUiSettings.ProgramShellSettings.InteractHint.BasicHint.Aborted)
+	}
+	return err
 }
 
 func (shellUi *ShellUi) interactExec() (exit bool) {
@@ -258,6 +476,12 @@ func (shellUi *ShellUi) interactExec() (exit bool) {
 	exit = true
 
 	interactHint := shellUi.configHelper.ProgramSettings.ProgramUiSettings.ProgramShellSettings.InteractHint
+
+	// Ask for the vault passphrase when it is needed
+	basic.PassphraseReader = func() (string, error) {
+		fmt.Println(interactHint.BasicHint.Passphrase)
+		return shellUi.readSecret()
+	}
 	for {
 		// Clear terminal
 		shellUi.clearScreen()
@@ -291,13 +515,17 @@ func (shellUi *ShellUi) interactExec() (exit bool) {
 		case '2':
 			{
 				shellUi.clearScreen()
-				shellUi.portal.DoLogin()
+				_ = shellUi.runInterruptible(func(ctx context.Context) {
+					_ = shellUi.portal.DoLogin(ctx)
+				})
 				pause(interactHint.BasicHint.Pause)
 			}
 		case '3':
 			{
 				shellUi.clearScreen()
-				_ = shellUi.portal.DoListSession()
+				_ = shellUi.runInterruptible(func(ctx context.Context) {
+					_ = shellUi.portal.DoListSession(ctx)
+				})
 				pause(interactHint.BasicHint.Pause)
 			}
 		case '4':
@@ -309,7 +537,9 @@ func (shellUi *ShellUi) interactExec() (exit bool) {
 		case '5':
 			{
 				shellUi.clearScreen()
-				shellUi.diagnosis.DoDiagnosis()
+				_ = shellUi.runInterruptible(func(ctx context.Context) {
+					_ = shellUi.diagnosis.DoDiagnosis(ctx)
+				})
 				pause(interactHint.BasicHint.Pause)
 			}
 		case '6':
@@ -350,44 +580,142 @@ func (shellUi *ShellUi) interactExec() (exit bool) {
 	}
 }
 
-func (shellUi *ShellUi) Exec() (exit bool) {
+func (shellUi *ShellUi) doLogin(ctx context.Context) (exitCode int) {
+	result := shellUi.portal.DoLogin(ctx)
+	_ = printOutput(shellUi.outputFlag, result)
+	return exitCodeOf(result.Success())
+}
 
-	exit = true
+func (shellUi *ShellUi) doListSession(ctx context.Context) (exitCode int) {
+	if shellUi.outputFlag == TextOutput {
+		return exitCodeOf(shellUi.portal.DoListSession(ctx) == nil)
+	}
+	sessions, err := shellUi.portal.ListSession(ctx)
+	_ = printOutput(shellUi.outputFlag, newSessionListOutput(shellUi.portal.SessionConcurrency(), sessions, err))
+	return exitCodeOf(err == nil)
+}
 
-	if !shellUi.loginFlag &&
-		shellUi.logoutFlag == -1 &&
-		!shellUi.showSessionFlag &&
-		!shellUi.diagnosisFlag {
-		if shellUi.configHelper.UserSettings.UserUISettings.Mode == basic.InteractMode {
-			exit = shellUi.interactExec()
-			return exit
-		} else {
-			shellUi.portal.DoLogin()
-			return
+func (shellUi *ShellUi) doLogout(ctx context.Context, sessionIndex int) (exitCode int) {
+	output := &logoutOutput{Index: sessionIndex}
+	sessions, err := shellUi.portal.ListSession(ctx)
+	if err == nil {
+		if sessionIndex >= 0 && sessionIndex < len(sessions) {
+			output.Session = sessions[sessionIndex]
 		}
+		err = shellUi.portal.DoLogout(ctx, sessionIndex)
 	}
+	if err != nil {
+		output.Error = err.Error()
+	}
+	_ = printOutput(shellUi.outputFlag, output)
+	return exitCodeOf(err == nil)
+}
 
-	if shellUi.loginFlag {
-		shellUi.portal.DoLogin()
-		return
+func (shellUi *ShellUi) doLogoutSelected(ctx context.Context) (exitCode int) {
+	output := &selectedLogoutOutput{DryRun: shellUi.options.DryRun, Sessions: make([]logoutTargetOutput, 0)}
+	sessions, err := shellUi.portal.SelectSessions(ctx, shellUi.options.LogoutSelector)
+	if err != nil {
+		output.Error = err.Error()
+		_ = printOutput(shellUi.outputFlag, output)
+		return ExitFailure
+	}
+	if len(sessions) == 0 {
+		shellUi.loggerHelper.AddLog(basic.WARNING, "exec/shell: No session matches the logout targets")
 	}
 
-	if shellUi.logoutFlag > -1 {
-		_ = shellUi.portal.DoListSession()
-		shellUi.portal.DoLogout(shellUi.logoutFlag)
-		return
+	success := true
+	for _, session := range sessions {
+		target := logoutTargetOutput{Session: session}
+		deviceName := shellUi.portal.DeviceName(session.UserMacAddr)
+		if shellUi.options.DryRun {
+			shellUi.loggerHelper.AddLog(basic.INFO,
+				fmt.Sprintf("exec/shell: Dry run, session [%s] (IP = %s) would be logged out", deviceName, session.UserIpAddr))
+			if shellUi.outputFlag == TextOutput {
+				fmt.Printf("Would logout: %s  %s  %s\n", deviceName, session.UserIpAddr, session.StartTime)
+			}
+		} else if err = shellUi.portal.DoLogoutByMac(ctx, session.UserMacAddr); err != nil {
+			target.Error = err.Error()
+			success = false
+		} else {
+			target.LoggedOut = true
+		}
+		output.Sessions = append(output.Sessions, target)
 	}
+	_ = printOutput(shellUi.outputFlag, output)
+	return exitCodeOf(success)
+}
 
-	if shellUi.showSessionFlag {
-		_ = shellUi.portal.DoListSession()
-		return
+func (shellUi *ShellUi) doHistory() (exitCode int) {
+	records, err := shellUi.portal.History().Query(shellUi.options.HistoryQuery)
+	if shellUi.outputFlag != TextOutput {
+		_ = printOutput(shellUi.outputFlag, newHistoryOutput(shellUi.portal.History().File(), records, err))
+	} else if err != nil {
+		shellUi.loggerHelper.AddLog(basic.ERROR, fmt.Sprintf("%v", err))
+	} else if len(records) == 0 {
+		fmt.Println("No record found")
+	} else {
+		printHistoryRecords(records)
 	}
+	return exitCodeOf(err == nil)
+}
 
-	if shellUi.diagnosisFlag {
-		shellUi.diagnosis.DoDiagnosis()
-		return
+func (shellUi *ShellUi) doDiagnosis(ctx context.Context) (exitCode int) {
+	report := shellUi.diagnosis.DoDiagnosis(ctx)
+	_ = printOutput(shellUi.outputFlag, report)
+	return exitCodeOf(report.Success())
+}
+
+func (shellUi *ShellUi) Exec() (exit bool, exitCode int) {
+
+	exit = true
+
+	// Ctrl+C aborts the running operation so that results are still reported
+	ctx, stop := interruptibleContext()
+	defer stop()
+
+	switch shellUi.options.Command {
+	case DefaultCommand:
+		if shellUi.configHelper.UserSettings.UserUISettings.Mode == basic.InteractMode &&
+			shellUi.outputFlag == TextOutput {
+			stop() // Operations in interactive mode handle Ctrl+C themselves
+			exit = shellUi.interactExec()
+			return exit, ExitSuccess
+		} else {
+			return exit, shellUi.doLogin(ctx)
+		}
+	case LoginCommand:
+		return exit, shellUi.doLogin(ctx)
+	case LogoutCommand:
+		if shellUi.options.LogoutIndex > -1 {
+			return exit, shellUi.doLogout(ctx, shellUi.options.LogoutIndex)
+		}
+		return exit, shellUi.doLogoutSelected(ctx)
+	case SessionsCommand:
+		return exit, shellUi.doListSession(ctx)
+	case DiagnoseCommand:
+		return exit, shellUi.doDiagnosis(ctx)
+	case HistoryCommand:
+		return exit, shellUi.doHistory()
+	case ConfigCommand:
+		stop()
+		return exit, exitCodeOf(shellUi.quickSettingInteract())
+	case DaemonCommand:
+		stop() // Daemon and API server handle SIGINT and SIGTERM themselves
+		stopWatch := shellUi.watchConfig(func(newShellUi *ShellUi) {
+			shellUi.daemon.Reload(newShellUi.daemon)
+		})
+		defer stopWatch()
+		shellUi.daemon.Run()
+		return exit, ExitSuccess
+	case ApiCommand:
+		stop()
+		stopWatch := shellUi.watchConfig(func(newShellUi *ShellUi) {
+			shellUi.api.Reload(newShellUi.api)
+		})
+		defer stopWatch()
+		return exit, exitCodeOf(shellUi.api.Run() == nil)
 	}
 
-	return
+	return exit, ExitSuccess
 
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"os"
 	"xjtuportal/component/utils"
 	"xjtuportal/exec"
 )
@@ -11,34 +12,27 @@ func main() {
 
 	currentRunningDir := utils.GetCurrentRunningDir()
 
-	versionFlag := flag.Bool("v", false, "Show current version")
-	configFlag := flag.String("c", fmt.Sprintf("%s/%s", currentRunningDir, "config"), "The path of config folder")
-	loginFlag := flag.Bool("i", false, "Login using auth data given in config file")
-	logoutFlag := flag.Int("o", -1, "Logout with given index (shown by -s)")
-	showSessionFlag := flag.Bool("s", false, "List current sessions")
-	diagnosisFlag := flag.Bool("d", false, "Check http and DNS connectivity")
-	adapterFlag := flag.Bool("a", false, "Check network adapter information")
-
-	flag.Parse()
+	options, err := exec.ParseCommandLine(os.Args[1:], fmt.Sprintf("%s/%s", currentRunningDir, "config"))
+	if err == flag.ErrHelp {
+		os.Exit(exec.ExitSuccess)
+	} else if err != nil {
+		os.Exit(exec.ExitUsage)
+	}
 
+	exitCode := exec.ExitSuccess
 	for {
-		shellRun := exec.InitShellUi(
-			*versionFlag,
-			*configFlag,
-			*loginFlag,
-			*logoutFlag,
-			*showSessionFlag,
-			*diagnosisFlag,
-			*adapterFlag,
-		)
+		shellRun, initExitCode := exec.InitShellUi(options)
 		if shellRun != nil {
-			exit := shellRun.Exec()
+			exit, execExitCode := shellRun.Exec()
 			if exit {
+				exitCode = execExitCode
 				break
 			}
 		} else {
+			exitCode = initExitCode
 			break
 		}
 	}
 
+	os.Exit(exitCode)
 }
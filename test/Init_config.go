@@ -13,6 +13,9 @@ func readConfig() (*basic.ConfigHelper, *basic.LoggerHelper, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	// Keep session history out of config folder, tests of history set their own file
+	historyEnabled := false
+	configHelper.UserSettings.UserAppSettings.UserHistorySettings.Enabled = &historyEnabled
 
 	loggerHelper, err := basic.InitLoggerHelper(configHelper)
 	if err != nil {
@@ -0,0 +1,190 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+	"xjtuportal/component/app"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/http"
+	"xjtuportal/component/http/httpfake"
+)
+
+type apiTestResponse struct {
+	Ok    bool            `json:"ok"`
+	Error string          `json:"error"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// callApi sends request to the API, body is sent in JSON if not nil
+func callApi(t *testing.T, client *stdhttp.Client, method string, url string, token string, body interface{}) (int, *apiTestResponse) {
+	var reader *bytes.Reader
+	if body != nil {
+		content, _ := json.Marshal(body)
+		reader = bytes.NewReader(content)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	request, err := stdhttp.NewRequest(method, url, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if method == stdhttp.MethodPost {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	apiResponse := &apiTestResponse{}
+	_ = json.NewDecoder(response.Body).Decode(apiResponse)
+	return response.StatusCode, apiResponse
+}
+
+func TestApiServer(t *testing.T) {
+
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	unknownId := fake.AddSession(fakeUnknownMac, "10.181.0.2")
+	apiServer := newFixture(t, withFake(fake), withAutoLogout(true), withApi(basic.UserApiSettings{Token: "secret"})).apiServer()
+	server := httptest.NewServer(apiServer.Handler())
+	defer server.Close()
+	client := server.Client()
+
+	// Test 0: Token is required
+	if status, response := callApi(t, client, stdhttp.MethodGet, server.URL+"/api/v1/sessions", "", nil); status != 401 || response.Ok {
+		t.Errorf("Error rejecting request without token: %d %+v", status, response)
+	}
+	if status, _ := callApi(t, client, stdhttp.MethodGet, server.URL+"/api/v1/sessions", "wrong", nil); status != 401 {
+		t.Errorf("Error rejecting request with wrong token: %d", status)
+	}
+	if status, _ := callApi(t, client, stdhttp.MethodGet, server.URL+"/metrics", "", nil); status != 401 {
+		t.Errorf("Error rejecting metrics request without token: %d", status)
+	}
+
+	// Test 1: Wrong method, content type and host are rejected
+	if status, _ := callApi(t, client, stdhttp.MethodGet, server.URL+"/api/v1/login", "secret", nil); status != 405 {
+		t.Errorf("Error rejecting wrong method: %d", status)
+	}
+	request, _ := stdhttp.NewRequest(stdhttp.MethodPost, server.URL+"/api/v1/logout", bytes.NewReader([]byte(`{"index": 0}`)))
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Header.Set("Content-Type", "text/plain")
+	if response, err := client.Do(request); err != nil || response.StatusCode != 415 {
+		t.Errorf("Error rejecting form post: %v %v", response, err)
+	}
+	request, _ = stdhttp.NewRequest(stdhttp.MethodGet, server.URL+"/api/v1/sessions", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Host = "attacker.example.com"
+	if response, err := client.Do(request); err != nil || response.StatusCode != 403 {
+		t.Errorf("Error rejecting request to non-loopback host: %v %v", response, err)
+	}
+	if len(fake.Sessions) != 2 {
+		t.Fatalf("Error performing rejected requests")
+	}
+
+	// Test 2: Login with auto logout, list sessions and logout
+	status, response := callApi(t, client, stdhttp.MethodPost, server.URL+"/api/v1/login", "secret", nil)
+	result := &app.LoginResult{}
+	if status != 200 || !response.Ok || json.Unmarshal(response.Data, result) != nil || result.LoggedOutMac != fakeUnknownMac {
+		t.Errorf("Error logging in: %d %+v %+v", status, response, result)
+	}
+	if fake.LoggedOut[0] != unknownId {
+		t.Errorf("Error logging out unknown session automatically: %v", fake.LoggedOut)
+	}
+	status, response = callApi(t, client, stdhttp.MethodGet, server.URL+"/api/v1/sessions", "secret", nil)
+	sessions := make([]*http.Session, 0)
+	if status != 200 || json.Unmarshal(response.Data, &sessions) != nil || len(sessions) != 2 || !sessions[1].IsCurrentSession {
+		t.Errorf("Error listing sessions: %d %+v", status, response)
+	}
+	if status, response = callApi(t, client, stdhttp.MethodPost, server.URL+"/api/v1/logout", "secret",
+		map[string]string{"mac": fakeKnownMac}); status != 200 || !response.Ok || len(fake.Sessions) != 1 {
+		t.Errorf("Error logging out by MAC address: %d %+v", status, response)
+	}
+
+	// Test 3: Bad input is 400, unknown session is 404, backend failure is 500
+	for _, body := range []interface{}{"not an object", map[string]string{}, map[string]string{"mac": "not a mac"}} {
+		if status, response = callApi(t, client, stdhttp.MethodPost, server.URL+"/api/v1/logout", "secret", body); status != 400 || response.Error == "" {
+			t.Errorf("Error rejecting bad logout request %v: %d %+v", body, status, response)
+		}
+	}
+	for _, body := range []interface{}{map[string]int{"index": 5}, map[string]string{"mac": fakeKnownMac}, map[string]string{"unique_id": "unknown"}} {
+		if status, _ = callApi(t, client, stdhttp.MethodPost, server.URL+"/api/v1/logout", "secret", body); status != 404 {
+			t.Errorf("Error reporting unknown session %v: %d", body, status)
+		}
+	}
+	fake.PortalDown = true
+	if status, _ = callApi(t, client, stdhttp.MethodGet, server.URL+"/api/v1/sessions", "secret", nil); status != 500 {
+		t.Errorf("Error reporting backend failure: %d", status)
+	}
+}
+
+func TestApiServerUnixSocket(t *testing.T) {
+
+	if runtime.GOOS == basic.Windows {
+		t.Skip("Socket permissions are checked on unix only")
+	}
+	dir, err := ioutil.TempDir("", "xjtuportal-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "api.sock")
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+
+	// Test 0: Regular file at the socket path is kept
+	if err = ioutil.WriteFile(socketPath, []byte("keep me"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	apiServer := newFixture(t, withFake(fake), withAutoLogout(true), withApi(basic.UserApiSettings{Listen: "unix:" + socketPath})).apiServer()
+	if err = apiServer.Serve(context.Background()); err == nil {
+		t.Errorf("Error refusing to replace a regular file")
+	}
+	if content, _ := ioutil.ReadFile(socketPath); string(content) != "keep me" {
+		t.Errorf("Error keeping regular file at socket path")
+	}
+	_ = os.Remove(socketPath)
+
+	// Test 1: Socket is created with mode 0600 and serves requests of any host
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- apiServer.Serve(ctx)
+	}()
+	var info os.FileInfo
+	for i := 0; i < 50; i++ {
+		if info, err = os.Stat(socketPath); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if info == nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Fatalf("Error creating socket with mode 0600: %v %v", info, err)
+	}
+	client := &stdhttp.Client{Transport: &stdhttp.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	if status, response := callApi(t, client, stdhttp.MethodPost, "http://xjtuportal/api/v1/login", "", nil); status != 200 || !response.Ok {
+		t.Errorf("Error logging in over unix socket: %d %+v", status, response)
+	}
+
+	// Test 2: Server stops on cancel
+	cancel()
+	if err = <-stopped; err != nil {
+		t.Errorf("Error stopping API server: %v", err)
+	}
+}
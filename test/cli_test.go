@@ -0,0 +1,109 @@
+package test
+
+import (
+	"testing"
+	"time"
+	"xjtuportal/exec"
+)
+
+func TestParseCommandLine(t *testing.T) {
+
+	// Test 0: Subcommands with flags
+	options, err := exec.ParseCommandLine([]string{"-c", "/etc/xjtuportal", "logout", "-mac", "11:22:33:44:55:66,aa:bb:cc:dd:ee:ff", "-ip", "10.181.0.1", "-dry-run"}, "config")
+	if err != nil || options.Command != exec.LogoutCommand || options.ConfigDir != "/etc/xjtuportal" {
+		t.Fatalf("Error parsing logout command: %+v %v", options, err)
+	}
+	if len(options.LogoutSelector.MacList) != 2 || len(options.LogoutSelector.IpList) != 1 || !options.DryRun || options.LogoutIndex != -1 {
+		t.Errorf("Error parsing logout targets: %+v", options.LogoutSelector)
+	}
+
+	options, err = exec.ParseCommandLine([]string{"sessions", "-output", "json"}, "config")
+	if err != nil || options.Command != exec.SessionsCommand || options.OutputFormat != exec.JsonOutput {
+		t.Errorf("Error parsing sessions command: %+v %v", options, err)
+	}
+
+	options, err = exec.ParseCommandLine([]string{"config", "path"}, "config")
+	if err != nil || options.Command != exec.ConfigCommand || options.ConfigAction != exec.ConfigPathAction {
+		t.Errorf("Error parsing config command: %+v %v", options, err)
+	}
+
+	options, err = exec.ParseCommandLine([]string{"config", "validate"}, "config")
+	if err != nil || options.Command != exec.ConfigCommand || options.ConfigAction != exec.ConfigValidateAction {
+		t.Errorf("Error parsing config validate command: %+v %v", options, err)
+	}
+
+	options, err = exec.ParseCommandLine([]string{"config", "dump", "--effective", "-output", "json"}, "config")
+	if err != nil || options.ConfigAction != exec.ConfigDumpAction || !options.Effective || options.OutputFormat != exec.JsonOutput {
+		t.Errorf("Error parsing config dump command: %+v %v", options, err)
+	}
+
+	options, err = exec.ParseCommandLine([]string{"config", "migrate", "-dry-run"}, "config")
+	if err != nil || options.ConfigAction != exec.ConfigMigrateAction || !options.DryRun {
+		t.Errorf("Error parsing config migrate command: %+v %v", options, err)
+	}
+
+	options, err = exec.ParseCommandLine([]string{"history", "-mac", "11:22:33:44:55:66", "-event", "logout", "-since", "7d", "-limit", "10"}, "config")
+	if err != nil || options.Command != exec.HistoryCommand || options.HistoryQuery.Mac != "11:22:33:44:55:66" ||
+		options.HistoryQuery.Event != "logout" || options.HistoryQuery.Limit != 10 ||
+		time.Since(options.HistoryQuery.Since) < 7*24*time.Hour-time.Minute {
+		t.Errorf("Error parsing history command: %+v %v", options, err)
+	}
+
+	options, err = exec.ParseCommandLine([]string{"-profile", "backup", "login"}, "config")
+	if err != nil || options.Command != exec.LoginCommand || options.Profile != "backup" {
+		t.Errorf("Error parsing global profile flag: %+v %v", options, err)
+	}
+	options, err = exec.ParseCommandLine([]string{"sessions", "-profile", "backup"}, "config")
+	if err != nil || options.Command != exec.SessionsCommand || options.Profile != "backup" {
+		t.Errorf("Error parsing profile flag of command: %+v %v", options, err)
+	}
+
+	// Test 1: Deprecated flags are aliases of subcommands
+	legacyTests := map[string][]string{
+		exec.VersionCommand:  {"-v"},
+		exec.LoginCommand:    {"-i"},
+		exec.SessionsCommand: {"-s", "-output", "yaml"},
+		exec.DiagnoseCommand: {"-d"},
+		exec.AdaptersCommand: {"-a"},
+		exec.DaemonCommand:   {"-D"},
+		exec.ApiCommand:      {"-A"},
+		exec.DefaultCommand:  {"-c", "config"},
+	}
+	for command, args := range legacyTests {
+		options, err = exec.ParseCommandLine(args, "config")
+		if err != nil || options.Command != command {
+			t.Errorf("Error parsing deprecated flags %v: %+v %v", args, options, err)
+		}
+	}
+	options, err = exec.ParseCommandLine([]string{"-o", "1"}, "config")
+	if err != nil || options.Command != exec.LogoutCommand || options.LogoutIndex != 1 {
+		t.Errorf("Error parsing deprecated flag -o: %+v %v", options, err)
+	}
+	options, err = exec.ParseCommandLine([]string{"--logout-unknown", "--dry-run"}, "config")
+	if err != nil || options.Command != exec.LogoutCommand || !options.LogoutSelector.Unknown || !options.DryRun {
+		t.Errorf("Error parsing deprecated flag --logout-unknown: %+v %v", options, err)
+	}
+
+	// Test 2: Invalid command lines
+	invalidTests := [][]string{
+		{"-i", "-s"},
+		{"-i", "login"},
+		{"unknown"},
+		{"logout"},
+		{"logout", "-index", "1", "-mac", "11:22:33:44:55:66"},
+		{"config"},
+		{"config", "unknown"},
+		{"config", "validate", "-effective"},
+		{"config", "dump", "-dry-run"},
+		{"history", "-event", "unknown"},
+		{"history", "-since", "2024-02-01", "-until", "2024-01-01"},
+		{"login", "extra"},
+		{"--dry-run"},
+	}
+	for _, args := range invalidTests {
+		if _, err = exec.ParseCommandLine(args, "config"); err == nil {
+			t.Errorf("Error rejecting invalid command line %v", args)
+		}
+	}
+
+}
@@ -1,8 +1,16 @@
 package test
 
 import (
+	"context"
 	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 	"xjtuportal/component/app"
 	"xjtuportal/component/basic"
 	"xjtuportal/component/http"
@@ -43,6 +51,114 @@ func TestConnectivity(t *testing.T) {
 		return
 	}
 
-	diagnosisHelper.DoDiagnosis()
+	diagnosisHelper.DoDiagnosis(context.Background())
+
+}
+
+func TestDiagnosisDeadline(t *testing.T) {
+
+	slowServer := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer slowServer.Close()
+
+	configHelper, loggerHelper, err := readConfig()
+	if err != nil {
+		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
+		t.Fatal("Initialization ConfigHelper & LoggerHelper failed")
+	}
+	configHelper.UserSettings.UserUISettings.Mode = "command"
+	configHelper.ProgramSettings.ProgramConnectivitySettings.Http.Internet = slowServer.URL
+	configHelper.ProgramSettings.ProgramAppSettings.ProgramDiagnosisSettings.Timeout = 1
+
+	requestHelper, err := http.InitRequestHelper(configHelper, loggerHelper)
+	if err != nil {
+		t.Fatal("Initialization RequestHelper failed")
+	}
+	dnsHelper, err := http.InitDnsHelper(configHelper, loggerHelper)
+	if err != nil {
+		t.Fatal("Initialization DNSHelper failed")
+	}
+	connectivityChecker, err := http.InitConnectivityChecker(configHelper, loggerHelper, requestHelper, dnsHelper)
+	if err != nil {
+		t.Fatal("Initialization connectivityChecker failed")
+	}
+	diagnosisHelper, err := app.InitDiagnosisHelper(configHelper, loggerHelper, connectivityChecker,
+		http.InitProxyHelper(loggerHelper, configHelper))
+	if err != nil {
+		t.Fatal("Initialization DiagnosisShellHelper failed")
+	}
+
+	// Test 0: The whole diagnosis is aborted after the overall deadline
+	start := time.Now()
+	report := diagnosisHelper.DoDiagnosis(context.Background())
+	if report.Success() || !strings.Contains(report.Error, "aborted") {
+		t.Errorf("Error aborting diagnosis after deadline: %+v", report)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Errorf("Error applying diagnosis deadline, took %v", time.Since(start))
+	}
+
+	// Test 1: Diagnosis is aborted when the parent context is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	report = diagnosisHelper.DoDiagnosis(ctx)
+	if !strings.Contains(report.Error, "aborted") || time.Since(start) > time.Second {
+		t.Errorf("Error aborting diagnosis with cancelled context: %+v", report)
+	}
+
+}
+
+// startDnsServer starts a local DNS server answering all queries, the first query is dropped if dropFirst is true.
+// Returns its address and query counter.
+func startDnsServer(t *testing.T, dropFirst bool) (server *dns.Server, address string, queries *int32) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries = new(int32)
+	server = &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, query *dns.Msg) {
+		if atomic.AddInt32(queries, 1) == 1 && dropFirst {
+			return
+		}
+		answer := new(dns.Msg)
+		answer.SetReply(query)
+		record, _ := dns.NewRR(fmt.Sprintf("%s 60 IN A 10.6.39.2", query.Question[0].Name))
+		answer.Answer = append(answer.Answer, record)
+		_ = w.WriteMsg(answer)
+	})}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	return server, conn.LocalAddr().String(), queries
+}
+
+func TestDnsRetry(t *testing.T) {
+
+	for _, retryTimes := range []int{0, 1} {
+		server, address, queries := startDnsServer(t, true)
+
+		configHelper, loggerHelper, err := readConfig()
+		if err != nil {
+			t.Fatal("Initialization ConfigHelper & LoggerHelper failed")
+		}
+		configHelper.ProgramSettings.ProgramDnsSettings.Connect.Timeout = 1
+		configHelper.ProgramSettings.ProgramDnsSettings.Testing.RetryTimes = retryTimes
+		dnsHelper, err := http.InitDnsHelper(configHelper, loggerHelper)
+		if err != nil {
+			t.Fatal("Initialization DNSHelper failed")
+		}
+
+		// The query timed out is retried retry_times times
+		err = dnsHelper.DnsCheck(context.Background(), "p.xjtu.edu.cn", address)
+		if (err == nil) != (retryTimes > 0) || atomic.LoadInt32(queries) != int32(retryTimes+1) {
+			t.Errorf("Error retrying DNS query %d times: %d queries, %v", retryTimes, atomic.LoadInt32(queries), err)
+		}
+		_ = server.Shutdown()
+	}
 
 }
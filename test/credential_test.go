@@ -0,0 +1,147 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xjtuportal/component/basic"
+)
+
+func TestVault(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal")
+	if err != nil {
+		t.Fatal("Cannot create temp dir")
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	vaultPath := filepath.Join(dir, basic.DefaultVaultFile)
+
+	// Test 0: Store and load passwords
+	if err = basic.StoreVaultPassword(vaultPath, "passphrase", "3120123456", "zhangsan123456"); err != nil {
+		t.Fatal("Error storing password into vault")
+	}
+	if err = basic.StoreVaultPassword(vaultPath, "passphrase", "3120654321", "lisi654321"); err != nil {
+		t.Fatal("Error adding password into existing vault")
+	}
+	secrets, err := basic.LoadVault(vaultPath, "passphrase")
+	if err != nil || secrets["3120123456"] != "zhangsan123456" || secrets["3120654321"] != "lisi654321" {
+		t.Error("Error loading passwords from vault")
+	}
+
+	// Test 1: Wrong passphrase
+	if _, err = basic.LoadVault(vaultPath, "wrong"); err == nil {
+		t.Error("Vault should not be decrypted with wrong passphrase")
+	}
+
+	// Test 2: Password is not stored in plaintext
+	content, _ := ioutil.ReadFile(vaultPath)
+	if len(content) == 0 || strings.Contains(string(content), "zhangsan123456") {
+		t.Error("Password should be encrypted in vault")
+	}
+
+	// Test 3: Key derivation parameters are authenticated and cannot be weakened
+	for _, tamper := range []struct{ from, to string }{
+		{`"n": 32768`, `"n": 1024`},
+		{`"n": 32768`, `"n": 65536`},
+		{`"r": 8`, `"r": 1`},
+		{`"p": 1`, `"p": 2`},
+	} {
+		tampered := strings.Replace(string(content), tamper.from, tamper.to, 1)
+		if tampered == string(content) {
+			t.Fatalf("Error finding %s in vault", tamper.from)
+		}
+		_ = ioutil.WriteFile(vaultPath, []byte(tampered), 0600)
+		if _, err = basic.LoadVault(vaultPath, "passphrase"); err == nil {
+			t.Errorf("Vault with %s should be rejected", tamper.to)
+		}
+	}
+
+}
+
+func TestCredentialProvider(t *testing.T) {
+
+	configHelper, _, err := readConfig()
+	if err != nil {
+		t.Fatal("Initialization ConfigHelper failed")
+	}
+	authData := &configHelper.UserSettings.UserOnlineSettings.AuthData
+
+	// Test 0: Plain password
+	authData.PasswordSource = basic.PlainSource
+	authData.Password = "123456789"
+	if password := providePassword(t, configHelper); password != "123456789" {
+		t.Error("Error providing plain password")
+	}
+
+	// Test 1: Environment variable
+	authData.PasswordSource = basic.EnvSource
+	authData.PasswordEnv = "XJTUPORTAL_TEST_PASSWORD"
+	_ = os.Setenv("XJTUPORTAL_TEST_PASSWORD", "env-password")
+	defer func() {
+		_ = os.Unsetenv("XJTUPORTAL_TEST_PASSWORD")
+	}()
+	if password := providePassword(t, configHelper); password != "env-password" {
+		t.Error("Error providing password from environment variable")
+	}
+
+	// Test 2: Password file with trailing newline
+	dir, err := ioutil.TempDir("", "xjtuportal")
+	if err != nil {
+		t.Fatal("Cannot create temp dir")
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	passwordFile := filepath.Join(dir, "password")
+	_ = ioutil.WriteFile(passwordFile, []byte("file-password\n"), 0600)
+	authData.PasswordSource = basic.FileSource
+	authData.PasswordFile = passwordFile
+	if password := providePassword(t, configHelper); password != "file-password" {
+		t.Error("Error providing password from file")
+	}
+
+	// Test 3: Password command
+	authData.PasswordSource = basic.CommandSource
+	authData.PasswordCommand = "echo command-password"
+	if password := providePassword(t, configHelper); password != "command-password" {
+		t.Error("Error providing password from command")
+	}
+
+	// Test 4: Vault with passphrase from environment variable
+	configHelper.ConfigDir = dir
+	authData.PasswordSource = basic.VaultSource
+	authData.VaultFile = basic.DefaultVaultFile
+	_ = basic.StoreVaultPassword(filepath.Join(dir, basic.DefaultVaultFile), "passphrase", authData.Username, "vault-password")
+	_ = os.Setenv(basic.VaultPassphraseEnv, "passphrase")
+	defer func() {
+		_ = os.Unsetenv(basic.VaultPassphraseEnv)
+	}()
+	if password := providePassword(t, configHelper); password != "vault-password" {
+		t.Error("Error providing password from vault")
+	}
+
+	// Test 5: Unknown source
+	authData.PasswordSource = "unknown"
+	if _, err = basic.InitCredentialProvider(configHelper); err == nil {
+		t.Error("Error handling unknown password source")
+	}
+
+}
+
+func providePassword(t *testing.T, configHelper *basic.ConfigHelper) string {
+	provider, err := basic.InitCredentialProvider(configHelper)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	password, err := provider.Password(configHelper.UserSettings.UserOnlineSettings.AuthData.Username)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	return password
+}
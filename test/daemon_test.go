@@ -0,0 +1,120 @@
+package test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/http/httpfake"
+)
+
+func TestDaemon(t *testing.T) {
+
+	// Test 0: Login if the Internet check is redirected, then wait for the interval
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	daemonHelper := newFixture(t, withFake(fake), withDaemon()).daemon()
+	waits := make([]time.Duration, 0)
+	daemonHelper.SetWait(func(ctx context.Context, duration time.Duration) bool {
+		waits = append(waits, duration)
+		return len(waits) < 2
+	})
+	daemonHelper.RunContext(context.Background())
+	if fake.OnlineCount != 1 || len(fake.Sessions) != 1 || fake.Sessions[0].UserMacAddr != fakeLocalMac {
+		t.Errorf("Error logging in when offline: %d online requests, %d sessions", fake.OnlineCount, len(fake.Sessions))
+	}
+	if len(waits) != 2 || waits[0] != time.Minute || waits[1] != time.Minute {
+		t.Errorf("Error waiting for the interval when online: %v", waits)
+	}
+
+	// Test 1: Backoff doubles while the portal server is unreachable, capped by max backoff, reset after success
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.PortalDown = true
+	daemonHelper = newFixture(t, withFake(fake), withDaemon()).daemon()
+	waits = make([]time.Duration, 0)
+	daemonHelper.SetWait(func(ctx context.Context, duration time.Duration) bool {
+		waits = append(waits, duration)
+		if len(waits) == 5 {
+			fake.PortalDown = false
+		}
+		return len(waits) < 6
+	})
+	daemonHelper.RunContext(context.Background())
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute, time.Minute}
+	if len(waits) != len(expected) {
+		t.Fatalf("Error backing off: %v", waits)
+	}
+	for index := range expected {
+		if waits[index] != expected[index] {
+			t.Errorf("Error backing off: %v, expected %v", waits, expected)
+			break
+		}
+	}
+	if len(fake.Sessions) != 1 {
+		t.Errorf("Error logging in after the portal server is back")
+	}
+
+	// Test 2: Daemon stops once the context is cancelled, also while waiting
+	daemonHelper = newFixture(t, withFake(fake), withDaemon()).daemon()
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		daemonHelper.RunContext(ctx)
+		close(stopped)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Errorf("Error stopping daemon on context cancel")
+	}
+
+	// Test 3: A slow check is aborted on context cancel
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.Delay = time.Hour
+	daemonHelper = newFixture(t, withFake(fake), withDaemon()).daemon()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	daemonHelper.RunContext(ctx)
+	if elapsed := time.Since(start); elapsed > 5*time.Second || fake.OnlineCount != 0 {
+		t.Errorf("Error aborting check on context cancel: %v", elapsed)
+	}
+
+	// Test 4: Reload waits for the running round, so the former modules can be released after it
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.Delay = 50 * time.Millisecond
+	daemonHelper = newFixture(t, withFake(fake), withDaemon()).daemon()
+	daemonHelper.SetWait(func(ctx context.Context, duration time.Duration) bool {
+		return false
+	})
+	stopped = make(chan struct{})
+	go func() {
+		daemonHelper.RunContext(context.Background())
+		close(stopped)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	daemonHelper.Reload(newFixture(t, withFake(httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)), withDaemon()).daemon())
+	if fake.OnlineCount != 1 {
+		t.Errorf("Error waiting for the running round on reload")
+	}
+	<-stopped
+
+	// Test 5: DNS servers are checked for metrics in each round if metrics are served
+	dnsServer, dnsAddress, queries := startDnsServer(t, false)
+	defer dnsServer.Shutdown()
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	daemonHelper = newFixture(t, withFake(fake), withDnsCheck(), withDaemon(), withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserUISettings.UserMetricsSettings.Listen = "127.0.0.1:0"
+		configHelper.ProgramSettings.ProgramConnectivitySettings.Dns.Server.Intranet = []string{dnsAddress}
+		configHelper.ProgramSettings.ProgramConnectivitySettings.Dns.Server.Internet = nil
+	})).daemon()
+	daemonHelper.SetWait(func(ctx context.Context, duration time.Duration) bool {
+		return false
+	})
+	daemonHelper.RunContext(context.Background())
+	if value, ok := basic.Metrics.Value(basic.MetricDnsServerUp, "server", dnsAddress); !ok || value != 1 || atomic.LoadInt32(queries) == 0 {
+		t.Errorf("Error checking DNS servers for metrics: %v %v", value, ok)
+	}
+}
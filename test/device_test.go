@@ -1,11 +1,18 @@
 package test
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"os"
 	"strings"
 	"testing"
+	"xjtuportal/component/app"
 	"xjtuportal/component/basic"
 	"xjtuportal/component/device"
+	"xjtuportal/component/http/httpfake"
 )
 
 var (
@@ -141,3 +148,92 @@ func TestFindLogoutMac(t *testing.T) {
 	}
 
 }
+
+func TestKnownDevices(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Test 0: Plain MAC addresses and devices with labels are mixed in known MAC list
+	userSettingsFile := writeConfigFile(t, dir, basic.UserConfigFile, `version: 2
+device:
+    known_mac_list:
+        - "11-22-33-44-55-66"
+        - mac: "00:00:5E:00:53:01"
+          name: Phone
+          owner: Alice
+          protected: true
+        - {mac: "aa:bb:cc:dd:ee:ff", name: Laptop}
+`)
+	userSettings, err := basic.InitUserSettings(userSettingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownMacList := userSettings.UserDeviceSettings.KnownMacList
+	if len(knownMacList) != 3 || knownMacList[0].Mac != "11-22-33-44-55-66" || knownMacList[0].Label() != "" ||
+		knownMacList[1].Label() != "Phone, Alice" || !knownMacList[1].Protected || knownMacList[2].Label() != "Laptop" {
+		t.Errorf("Error decoding known devices: %+v", knownMacList)
+	}
+
+	// Test 1: Devices without labels are written as plain MAC addresses
+	content, err := yaml.Marshal(&userSettings.UserDeviceSettings)
+	if err != nil || !strings.Contains(string(content), "- 11-22-33-44-55-66\n") || !strings.Contains(string(content), "name: Phone") {
+		t.Errorf("Error encoding known devices:\n%s %v", content, err)
+	}
+
+	// Test 2: Invalid MAC addresses are reported at the mac field of mappings
+	writeConfigFile(t, dir, basic.UserConfigFile, `version: 2
+device:
+    known_mac_list:
+        - "11:22:33:44:55"
+        - mac: "aa:bb:cc:dd:ee"
+          name: Laptop
+        - name: Desktop
+          ower: Bob
+`)
+	issues, err := basic.ValidateConfig(userSettingsFile, userSettingsFile+".missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, line := range map[string]int{
+		"device.known_mac_list[0]":      4,
+		"device.known_mac_list[1].mac":  5,
+		"device.known_mac_list[2].mac":  7,
+		"device.known_mac_list[2].ower": 8,
+	} {
+		if issue := findIssue(issues, path); issue == nil || issue.Line != line {
+			t.Errorf("Error reporting issue of [%s] at line %d: %+v", path, line, issue)
+		}
+	}
+
+	// Test 3: Labels are shown in session lists and protected devices are never logged out
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	fake.AddSession(fakeUnknownMac, "10.181.0.2")
+	portalHelper := newFixture(t, withFake(fake), withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserAppSettings.UserPortalSettings.IsAutoLogout = true
+		configHelper.UserSettings.UserAppSettings.UserPortalSettings.LogoutPolicy.Rules = []string{app.UnknownMacRule, app.OldestRule}
+		configHelper.UserSettings.UserDeviceSettings.KnownMacList = knownMacList
+	})).portal()
+	sessions, err := portalHelper.ListSession(context.Background())
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("Error listing sessions: %v %v", sessions, err)
+	}
+	if sessions[0].Device == nil || sessions[0].Device.Label() != "" || sessions[1].Device == nil || sessions[1].Device.Name != "Phone" {
+		t.Errorf("Error labeling sessions: %+v %+v", sessions[0].Device, sessions[1].Device)
+	}
+	if name := portalHelper.DeviceName(fakeUnknownMac); name != fakeUnknownMac+" (Phone, Alice)" {
+		t.Errorf("Error naming device: %s", name)
+	}
+	content, _ = json.Marshal(sessions[1])
+	if !strings.Contains(string(content), `"device":{"mac":"00:00:5E:00:53:01","name":"Phone","owner":"Alice","protected":true}`) {
+		t.Errorf("Error writing labels in JSON: %s", content)
+	}
+	result := portalHelper.DoLogin(context.Background())
+	if !result.Success() || result.LoggedOutMac != fakeKnownMac {
+		t.Errorf("Error skipping protected device in auto logout: %+v", result)
+	}
+}
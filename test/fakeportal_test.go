@@ -0,0 +1,102 @@
+package test
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"xjtuportal/component/fakeportal"
+)
+
+func newFakePortal(concurrency int) *fakeportal.FakePortal {
+	// Credentials in ../config/user-settings.yaml
+	return fakeportal.InitFakePortal("zhangsan@xjtu", "123456789", fakeLocalMac, fakeLocalIp, concurrency)
+}
+
+func TestFakePortalServerLogin(t *testing.T) {
+
+	fakePortal := newFakePortal(2)
+	server := fakeportal.StartServer(fakePortal)
+	defer server.Close()
+	portalHelper := newFixture(t, withServer(server.URL), withAutoLogout(false)).portal()
+
+	// Test 0: Login with free session slot
+	result := portalHelper.DoLogin(context.Background())
+	if !result.Success() || result.StatusCode != 200 || len(fakePortal.Backend.Sessions) != 1 {
+		t.Errorf("Error logging in with free session slot: %+v", result)
+	}
+
+	// Test 1: Login when already online
+	result = portalHelper.DoLogin(context.Background())
+	if !result.Success() || !result.AlreadyOnline {
+		t.Errorf("Error handling already online: %+v", result)
+	}
+
+	// Test 2: Wrong password
+	fakePortal = newFakePortal(2)
+	fakePortal.Password = "wrong"
+	wrongServer := fakeportal.StartServer(fakePortal)
+	defer wrongServer.Close()
+	portalHelper = newFixture(t, withServer(wrongServer.URL), withAutoLogout(false)).portal()
+	result = portalHelper.DoLogin(context.Background())
+	if result.Success() || result.StatusCode != 60 {
+		t.Errorf("Error mapping wrong password: %+v", result)
+	}
+
+}
+
+func TestFakePortalServerLoginErrors(t *testing.T) {
+
+	fakePortal := newFakePortal(2)
+	server := fakeportal.StartServer(fakePortal)
+	defer server.Close()
+	portalHelper := newFixture(t, withServer(server.URL), withAutoLogout(false)).portal()
+
+	statusCodes := make([]int, 0, len(fakeportal.LoginErrorDescriptions))
+	for statusCode := range fakeportal.LoginErrorDescriptions {
+		statusCodes = append(statusCodes, statusCode)
+	}
+	sort.Ints(statusCodes)
+
+	for _, statusCode := range statusCodes {
+		fakePortal.SetLoginError(statusCode)
+		result := portalHelper.DoLogin(context.Background())
+		if result.Success() || result.StatusCode != statusCode {
+			t.Errorf("Error mapping login error %d: %+v", statusCode, result)
+		}
+	}
+
+}
+
+func TestFakePortalServerAutoLogout(t *testing.T) {
+
+	fakePortal := newFakePortal(2)
+	fakePortal.Backend.AddSession(fakeKnownMac, "10.181.0.1")
+	unknownId := fakePortal.Backend.AddSession(fakeUnknownMac, "10.181.0.2")
+	server := fakeportal.StartServer(fakePortal)
+	defer server.Close()
+
+	// Test 0: Session overload with auto logout
+	portalHelper := newFixture(t, withServer(server.URL), withAutoLogout(true)).portal()
+	result := portalHelper.DoLogin(context.Background())
+	if !result.Success() || result.LoggedOutMac != fakeUnknownMac {
+		t.Errorf("Error logging out unknown MAC address automatically: %+v", result)
+	}
+	if len(fakePortal.Backend.LoggedOut) != 1 || fakePortal.Backend.LoggedOut[0] != unknownId {
+		t.Error("Error logging out exactly the unknown session")
+	}
+
+	// Test 1: List sessions and find current session by speed test server
+	sessions, err := portalHelper.ListSession(context.Background())
+	if err != nil || len(sessions) != 2 || portalHelper.SessionConcurrency() != 2 {
+		t.Fatal("Error listing sessions")
+	}
+	if !sessions[1].IsCurrentSession || sessions[1].UserMacAddr != fakeLocalMac {
+		t.Error("Error finding current session")
+	}
+
+	// Test 2: Logout by MAC address
+	if err = portalHelper.DoLogoutByMac(context.Background(), fakeKnownMac); err != nil || len(fakePortal.Backend.Sessions) != 1 {
+		t.Error("Error logging out by MAC address")
+	}
+
+}
@@ -0,0 +1,301 @@
+package test
+
+import (
+	"fmt"
+	"testing"
+	"xjtuportal/component/app"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/device"
+	"xjtuportal/component/fakeportal"
+	"xjtuportal/component/http"
+	"xjtuportal/component/http/httpfake"
+	"xjtuportal/exec"
+)
+
+// dnsCheckingBackend is the fake portal backend checking DNS servers like the connectivity checker
+type dnsCheckingBackend struct {
+	*httpfake.FakePortalBackend
+	http.DnsChecker
+}
+
+// fixture builds the modules under test from a single ConfigHelper and LoggerHelper, so all modules of a test share
+// the same config. Options modify config before any module is built, modules are built once on first use.
+type fixture struct {
+	t            testing.TB
+	configHelper *basic.ConfigHelper
+	loggerHelper *basic.LoggerHelper
+
+	// The fake backend used as portal and connectivity checker, or nil to use the HTTP backend set by withServer
+	fake *httpfake.FakePortalBackend
+	// Check DNS servers with the connectivity checker while using the fake backend
+	dnsCheck bool
+
+	request           *http.RequestHelper
+	checker           *http.ConnectivityChecker
+	portalBackend     http.PortalBackend
+	sessionListHelper *http.SessionListHelper
+	interfaces        *device.InterfaceHelper
+	portalHelper      *app.PortalShellHelper
+}
+
+type fixtureOption func(fixture *fixture)
+
+func newFixture(t testing.TB, options ...fixtureOption) *fixture {
+
+	configHelper, loggerHelper, err := readConfig()
+	if err != nil {
+		basic.LoggerTemp.AddLog(basic.FATAL, fmt.Sprintf("%v", err))
+		t.Fatal("Initialization ConfigHelper & LoggerHelper failed")
+	}
+	configHelper.UserSettings.UserUISettings.Mode = "command"
+
+	fixture := &fixture{t: t, configHelper: configHelper, loggerHelper: loggerHelper}
+	for _, option := range options {
+		option(fixture)
+	}
+	return fixture
+}
+
+// withFake uses the fake backend as the portal and the connectivity checker
+func withFake(fake *httpfake.FakePortalBackend) fixtureOption {
+	return func(fixture *fixture) {
+		fixture.fake = fake
+	}
+}
+
+// withServer uses the HTTP backend and the connectivity checker with the fake portal server at serverUrl
+func withServer(serverUrl string) fixtureOption {
+	return func(fixture *fixture) {
+		fakeportal.PointSettingsTo(fixture.configHelper.ProgramSettings, serverUrl)
+	}
+}
+
+// withDnsCheck checks DNS servers with the connectivity checker while using the fake backend
+func withDnsCheck() fixtureOption {
+	return func(fixture *fixture) {
+		fixture.dnsCheck = true
+	}
+}
+
+// withConfig modifies config with configure
+func withConfig(configure func(configHelper *basic.ConfigHelper)) fixtureOption {
+	return func(fixture *fixture) {
+		configure(fixture.configHelper)
+	}
+}
+
+func withLogLevel(level int) fixtureOption {
+	return func(fixture *fixture) {
+		fixture.loggerHelper.SetLogLevel(level)
+	}
+}
+
+func withRequest(timeout int, retrySettings basic.ProgramRetrySettings) fixtureOption {
+	return withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.ProgramSettings.ProgramRequestSettings.Timeout = timeout
+		configHelper.ProgramSettings.ProgramRequestSettings.Retry = retrySettings
+	})
+}
+
+func withUsername(username string) fixtureOption {
+	return withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserOnlineSettings.AuthData.Username = username
+	})
+}
+
+func withAutoLogout(autoLogout bool) fixtureOption {
+	return withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserAppSettings.UserPortalSettings.IsAutoLogout = autoLogout
+	})
+}
+
+func withLogoutPolicy(policySettings basic.UserLogoutPolicySettings) fixtureOption {
+	return withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserAppSettings.UserPortalSettings.LogoutPolicy = policySettings
+	})
+}
+
+func withToken(lifetime int, stateFile string) fixtureOption {
+	return withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserAppSettings.UserPortalSettings.Token = basic.UserTokenSettings{
+			Lifetime:  lifetime,
+			StateFile: stateFile,
+		}
+	})
+}
+
+func withHistory(file string, retention int) fixtureOption {
+	return withConfig(func(configHelper *basic.ConfigHelper) {
+		historyEnabled := true
+		configHelper.UserSettings.UserAppSettings.UserHistorySettings = basic.UserHistorySettings{
+			Enabled:   &historyEnabled,
+			File:      file,
+			Retention: retention,
+		}
+	})
+}
+
+func withIntruder(autoLogout bool) fixtureOption {
+	return withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserAppSettings.UserIntruderSettings = basic.UserIntruderSettings{
+			Enabled:    true,
+			Interval:   3600,
+			AutoLogout: autoLogout,
+		}
+	})
+}
+
+// withDaemon sets an interval of a minute and a max backoff of 5 minutes, metrics are not served
+func withDaemon() fixtureOption {
+	return withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserAppSettings.UserDaemonSettings = basic.UserDaemonSettings{Interval: 60, MaxBackoff: 300}
+		configHelper.UserSettings.UserUISettings.UserMetricsSettings.Listen = ""
+	})
+}
+
+func withApi(apiSettings basic.UserApiSettings) fixtureOption {
+	return withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserUISettings.UserApiSettings = apiSettings
+	})
+}
+
+func (fixture *fixture) requestHelper() *http.RequestHelper {
+	if fixture.request == nil {
+		requestHelper, err := http.InitRequestHelper(fixture.configHelper, fixture.loggerHelper)
+		if err != nil {
+			fixture.t.Fatalf("Initialization RequestHelper failed: %v", err)
+		}
+		fixture.request = requestHelper
+	}
+	return fixture.request
+}
+
+func (fixture *fixture) connectivityChecker() *http.ConnectivityChecker {
+	if fixture.checker == nil {
+		dnsHelper, err := http.InitDnsHelper(fixture.configHelper, fixture.loggerHelper)
+		if err != nil {
+			fixture.t.Fatalf("Initialization DnsHelper failed: %v", err)
+		}
+		connectivityChecker, err := http.InitConnectivityChecker(
+			fixture.configHelper, fixture.loggerHelper, fixture.requestHelper(), dnsHelper)
+		if err != nil {
+			fixture.t.Fatalf("Initialization ConnectivityChecker failed: %v", err)
+		}
+		fixture.checker = connectivityChecker
+	}
+	return fixture.checker
+}
+
+// httpChecker returns the fake backend if it is used, or the connectivity checker otherwise
+func (fixture *fixture) httpChecker() http.HttpChecker {
+	switch {
+	case fixture.fake == nil:
+		return fixture.connectivityChecker()
+	case fixture.dnsCheck:
+		return &dnsCheckingBackend{fixture.fake, fixture.connectivityChecker()}
+	default:
+		return fixture.fake
+	}
+}
+
+func (fixture *fixture) backend() http.PortalBackend {
+	if fixture.portalBackend == nil {
+		if fixture.fake != nil {
+			fixture.portalBackend = fixture.fake
+		} else {
+			portalBackend, err := http.InitHttpPortalBackend(fixture.configHelper, fixture.loggerHelper, fixture.requestHelper())
+			if err != nil {
+				fixture.t.Fatalf("Initialization HttpPortalBackend failed: %v", err)
+			}
+			fixture.portalBackend = portalBackend
+		}
+	}
+	return fixture.portalBackend
+}
+
+func (fixture *fixture) sessionList() *http.SessionListHelper {
+	if fixture.sessionListHelper == nil {
+		sessionListHelper, err := http.InitSessionListHelper(fixture.configHelper, fixture.loggerHelper, fixture.backend())
+		if err != nil {
+			fixture.t.Fatalf("Initialization SessionListHelper failed: %v", err)
+		}
+		fixture.sessionListHelper = sessionListHelper
+	}
+	return fixture.sessionListHelper
+}
+
+func (fixture *fixture) interfaceHelper() *device.InterfaceHelper {
+	if fixture.interfaces == nil {
+		interfaceHelper, err := device.InitInterfaceHelper(fixture.configHelper, fixture.loggerHelper)
+		if err != nil {
+			fixture.t.Fatalf("Initialization InterfaceHelper failed: %v", err)
+		}
+		fixture.interfaces = interfaceHelper
+	}
+	return fixture.interfaces
+}
+
+func (fixture *fixture) portal() *app.PortalShellHelper {
+	if fixture.portalHelper == nil {
+		portalHelper, err := app.InitPortalShellHelper(
+			fixture.configHelper, fixture.loggerHelper, fixture.httpChecker(), fixture.sessionList(), fixture.interfaceHelper())
+		if err != nil {
+			fixture.t.Fatalf("Initialization PortalShellHelper failed: %v", err)
+		}
+		fixture.portalHelper = portalHelper
+	}
+	return fixture.portalHelper
+}
+
+func (fixture *fixture) tokenCache() *http.TokenCache {
+	tokenCache, err := http.InitTokenCache(fixture.configHelper, fixture.loggerHelper)
+	if err != nil {
+		fixture.t.Fatalf("Initialization TokenCache failed: %v", err)
+	}
+	return tokenCache
+}
+
+func (fixture *fixture) historyStore() *app.HistoryStore {
+	historyStore, err := app.InitHistoryStore(fixture.configHelper, fixture.loggerHelper)
+	if err != nil {
+		fixture.t.Fatalf("Initialization HistoryStore failed: %v", err)
+	}
+	return historyStore
+}
+
+func (fixture *fixture) logoutPolicy() *app.LogoutPolicyHelper {
+	policyHelper, err := app.InitLogoutPolicyHelper(fixture.configHelper, fixture.loggerHelper, fixture.interfaceHelper())
+	if err != nil {
+		fixture.t.Fatalf("Initialization LogoutPolicyHelper failed: %v", err)
+	}
+	return policyHelper
+}
+
+func (fixture *fixture) intruderDetector() *app.IntruderDetector {
+	detector, err := app.InitIntruderDetector(fixture.configHelper, fixture.loggerHelper, fixture.portal())
+	if err != nil {
+		fixture.t.Fatalf("Initialization IntruderDetector failed: %v", err)
+	}
+	return detector
+}
+
+func (fixture *fixture) daemon() *app.DaemonHelper {
+	daemonHelper, err := app.InitDaemonHelper(fixture.configHelper, fixture.loggerHelper, fixture.httpChecker(), fixture.portal())
+	if err != nil {
+		fixture.t.Fatalf("Initialization DaemonHelper failed: %v", err)
+	}
+	return daemonHelper
+}
+
+func (fixture *fixture) apiServer() *exec.ApiServer {
+	diagnosisHelper, err := app.InitDiagnosisHelper(fixture.configHelper, fixture.loggerHelper,
+		fixture.connectivityChecker(), http.InitProxyHelper(fixture.loggerHelper, fixture.configHelper))
+	if err != nil {
+		fixture.t.Fatalf("Initialization DiagnosisShellHelper failed: %v", err)
+	}
+	apiServer, err := exec.InitApiServer(fixture.configHelper, fixture.loggerHelper, fixture.portal(), diagnosisHelper)
+	if err != nil {
+		fixture.t.Fatalf("Initialization ApiServer failed: %v", err)
+	}
+	return apiServer
+}
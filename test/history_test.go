@@ -0,0 +1,216 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+	"xjtuportal/component/app"
+	"xjtuportal/component/http"
+	"xjtuportal/component/http/httpfake"
+)
+
+func findRecords(records []*app.HistoryRecord, event string, mac string) []*app.HistoryRecord {
+	found := make([]*app.HistoryRecord, 0)
+	for _, record := range records {
+		if record.Event == event && record.Mac == mac {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+func TestSessionHistory(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.jsonl")
+
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	fake.AddSession(fakeUnknownMac, "10.181.0.2")
+	portalHelper := newFixture(t, withFake(fake), withAutoLogout(true), withHistory(file, 0)).portal()
+	start := time.Now()
+
+	// Test 0: Observed sessions, auto logout and logins are recorded
+	result := portalHelper.DoLogin(context.Background())
+	if !result.Success() || result.LoggedOutMac != fakeUnknownMac {
+		t.Fatalf("Error logging in with auto logout: %+v", result)
+	}
+	records, err := portalHelper.History().Query(&app.HistoryQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findRecords(records, app.SessionEvent, fakeKnownMac)) != 1 || len(findRecords(records, app.SessionEvent, fakeUnknownMac)) != 1 {
+		t.Errorf("Error recording observed sessions: %d records", len(records))
+	}
+	logouts := findRecords(records, app.LogoutEvent, fakeUnknownMac)
+	if len(logouts) != 1 || logouts[0].Reason != app.AutoLogout || logouts[0].Detail == "" || !logouts[0].Success {
+		t.Errorf("Error recording auto logout: %+v", logouts)
+	}
+	logins := findRecords(records, app.LoginEvent, "")
+	if len(logins) != 1 || !logins[0].Success || logins[0].Account != "zhangsan@xjtu" {
+		t.Errorf("Error recording login: %+v", logins)
+	}
+
+	// Test 1: Sessions seen before are not recorded again, sessions disappeared are logged out externally
+	fake.Sessions = fake.Sessions[1:] // The known device is logged out by someone else
+	if _, err = portalHelper.ListSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	records, _ = portalHelper.History().Query(&app.HistoryQuery{Event: app.SessionEvent})
+	if len(records) != 3 { // Known, unknown and current device
+		t.Errorf("Error recording sessions only once: %d records", len(records))
+	}
+	records, _ = portalHelper.History().Query(&app.HistoryQuery{Mac: "11-22-33-44-55-66", Event: app.LogoutEvent})
+	if len(records) != 1 || records[0].Reason != app.ExternalLogout {
+		t.Errorf("Error recording external logout: %+v", records)
+	}
+
+	// Test 2: Manual logout is recorded, queries are filtered by IP and time
+	if err = portalHelper.DoLogoutByMac(context.Background(), fakeLocalMac); err != nil {
+		t.Fatal(err)
+	}
+	records, _ = portalHelper.History().Query(&app.HistoryQuery{Ip: fakeLocalIp, Event: app.LogoutEvent})
+	if len(records) != 1 || records[0].Reason != app.ManualLogout || records[0].Mac != fakeLocalMac {
+		t.Errorf("Error recording manual logout: %+v", records)
+	}
+	records, _ = portalHelper.History().Query(&app.HistoryQuery{Until: start})
+	if len(records) != 0 {
+		t.Errorf("Error filtering records by time: %+v", records)
+	}
+	records, _ = portalHelper.History().Query(&app.HistoryQuery{Since: start, Limit: 2})
+	if len(records) != 2 || records[1].Event != app.LogoutEvent || records[1].Mac != fakeLocalMac {
+		t.Errorf("Error limiting records to the latest: %+v", records)
+	}
+
+	// Test 3: History is kept between runs and invalid lines are skipped
+	content, _ := ioutil.ReadFile(file)
+	if err = ioutil.WriteFile(file, append(content, []byte("not json\n")...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	portalHelper = newFixture(t, withFake(fake), withAutoLogout(true), withHistory(file, 0)).portal()
+	records, err = portalHelper.History().Query(&app.HistoryQuery{})
+	if err != nil || len(records) != 7 {
+		t.Errorf("Error reading history of former runs: %d records %v", len(records), err)
+	}
+
+	// Test 4: Records older than retention are dropped except sessions still online
+	old := time.Now().AddDate(0, 0, -2).Format(time.RFC3339)
+	if err = ioutil.WriteFile(file, []byte(`{"time":"`+old+`","event":"login","account":"zhangsan@xjtu","success":true}
+{"time":"`+old+`","event":"session","account":"zhangsan@xjtu","mac":"`+fakeKnownMac+`","unique_id":"unique-1","success":true}
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	fake.AddSession(fakeUnknownMac, "10.181.0.2") // Records are dropped when new records are written
+	portalHelper = newFixture(t, withFake(fake), withAutoLogout(true), withHistory(file, 1)).portal()
+	if _, err = portalHelper.ListSession(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	records, _ = portalHelper.History().Query(&app.HistoryQuery{})
+	if len(records) != 2 || records[0].Event != app.SessionEvent || records[0].Mac != fakeKnownMac ||
+		records[1].Mac != fakeUnknownMac {
+		t.Errorf("Error dropping old records: %+v", records)
+	}
+}
+
+func TestSessionHistoryConcurrentCompact(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.jsonl")
+	const count = 100
+
+	// Records appended by another store, like another process, are not lost when the file is compacted
+	appended, compacted := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(compacted)
+		old := time.Now().AddDate(0, 0, -2).Format(time.RFC3339)
+		for {
+			select {
+			case <-appended:
+				return
+			default:
+			}
+			oldFile, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, _ = oldFile.WriteString(`{"time":"` + old + `","event":"login","success":true}` + "\n")
+			oldFile.Close()
+			// A new store compacts the file at its first write
+			newFixture(t, withHistory(file, 1)).historyStore().RecordIntruder("zhangsan@xjtu",
+				&http.Session{UserMacAddr: fakeUnknownMac, UniqueId: "compact"}, "")
+		}
+	}()
+	historyStore := newFixture(t, withHistory(file, 0)).historyStore()
+	for i := 0; i < count; i++ {
+		historyStore.RecordIntruder("zhangsan@xjtu", &http.Session{UserMacAddr: fakeKnownMac, UniqueId: "append"}, "")
+		time.Sleep(time.Millisecond)
+	}
+	close(appended)
+	<-compacted
+
+	records, err := historyStore.Query(&app.HistoryQuery{Mac: fakeKnownMac})
+	if err != nil || len(records) != count {
+		t.Errorf("Error keeping records appended while compacting: %d records %v", len(records), err)
+	}
+}
+
+func TestSessionHistoryConcurrentObserve(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.jsonl")
+	const rounds = 10
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4)) // Stores run in parallel like processes even on a single CPU
+	historyStores := make([]*app.HistoryStore, 16)  // Each store is like a process sharing the file
+	for index := range historyStores {
+		historyStores[index] = newFixture(t, withHistory(file, 0)).historyStore()
+	}
+	observe := func(sessions []*http.Session) {
+		waitGroup := sync.WaitGroup{}
+		for _, historyStore := range historyStores {
+			waitGroup.Add(1)
+			go func(historyStore *app.HistoryStore) {
+				defer waitGroup.Done()
+				historyStore.ObserveSessions("zhangsan@xjtu", sessions)
+			}(historyStore)
+		}
+		waitGroup.Wait()
+	}
+
+	// Sessions appeared or disappeared are recorded once when several processes observe them at the same time
+	for round := 0; round < rounds; round++ {
+		observe([]*http.Session{
+			{UserMacAddr: fakeKnownMac, UniqueId: fmt.Sprintf("known-%d", round)},
+			{UserMacAddr: fakeUnknownMac, UniqueId: fmt.Sprintf("unknown-%d", round)},
+		})
+		observe(nil)
+	}
+	records, err := historyStores[0].Query(&app.HistoryQuery{Event: app.SessionEvent})
+	if err != nil || len(records) != 2*rounds {
+		t.Errorf("Error recording sessions once: %d records %v", len(records), err)
+	}
+	records, err = historyStores[0].Query(&app.HistoryQuery{Event: app.LogoutEvent})
+	if err != nil || len(records) != 2*rounds {
+		t.Errorf("Error recording external logouts once: %d records %v", len(records), err)
+	}
+}
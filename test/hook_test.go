@@ -0,0 +1,144 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+	"xjtuportal/component/app"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/fakeportal"
+)
+
+// readHook returns the event given on stdin and the environment variables written by the hook script
+func readHook(t *testing.T, dir string, name string) (*app.HookEvent, string) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		t.Errorf("Error running hook [%s]: %v", name, err)
+		return &app.HookEvent{}, ""
+	}
+	event := &app.HookEvent{}
+	if err = json.Unmarshal(content, event); err != nil {
+		t.Errorf("Error giving event of hook [%s] on stdin: %v", name, err)
+	}
+	environ, _ := ioutil.ReadFile(filepath.Join(dir, name+".env"))
+	return event, strings.TrimSpace(string(environ))
+}
+
+func TestHooks(t *testing.T) {
+
+	if runtime.GOOS == basic.Windows {
+		t.Skip("Hook scripts of the test are written for sh")
+	}
+	dir, err := ioutil.TempDir("", "xjtuportal-hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	script := `cat > ` + dir + `/$XJTUPORTAL_HOOK.json && ` +
+		`echo "$XJTUPORTAL_STATUS_CODE $XJTUPORTAL_MAC $XJTUPORTAL_IP $XJTUPORTAL_FORMER_IP $XJTUPORTAL_REASON" > ` +
+		dir + `/$XJTUPORTAL_HOOK.env`
+	hookSettings := basic.UserHookSettings{
+		OnLoginSuccess: basic.UserHook{Command: script},
+		OnLoginFailure: basic.UserHook{Command: script},
+		OnLogout:       basic.UserHook{Command: script},
+		OnOffline:      basic.UserHook{Command: script},
+		OnOnline:       basic.UserHook{Command: script},
+		OnIpChange:     basic.UserHook{Command: script},
+	}
+
+	fakePortal := newFakePortal(1)
+	unknownId := fakePortal.Backend.AddSession(fakeUnknownMac, "10.181.0.2")
+	server := fakeportal.StartServer(fakePortal)
+	defer server.Close()
+	portalHelper := newFixture(t, withServer(server.URL), withAutoLogout(true), withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserHookSettings = hookSettings
+	})).portal()
+
+	// Test 0: Login failure is given with the mapped error code
+	fakePortal.SetLoginError(basic.AccountSuspended)
+	portalHelper.DoLogin(context.Background())
+	event, environ := readHook(t, dir, basic.HookLoginFailure)
+	if event.Hook != basic.HookLoginFailure || event.StatusCode != 27 || event.Account != "zhangsan@xjtu" ||
+		!strings.Contains(event.Message, "suspended") || environ != "27" {
+		t.Errorf("Error running login failure hook: %+v %q", event, environ)
+	}
+
+	// Test 1: Auto logout is given with session IP and MAC, login success with IP of current session
+	fakePortal.SetLoginError(0)
+	if result := portalHelper.DoLogin(context.Background()); !result.Success() {
+		t.Fatalf("Error logging in with auto logout: %+v", result)
+	}
+	event, environ = readHook(t, dir, basic.HookLogout)
+	if event.Mac != fakeUnknownMac || event.Ip != "10.181.0.2" || event.Reason != app.AutoLogout ||
+		environ != "0 "+fakeUnknownMac+" 10.181.0.2  "+app.AutoLogout || fakePortal.Backend.LoggedOut[0] != unknownId {
+		t.Errorf("Error running logout hook: %+v %q", event, environ)
+	}
+	if event, environ = readHook(t, dir, basic.HookLoginSuccess); event.Ip != fakeLocalIp || environ != "200  "+fakeLocalIp {
+		t.Errorf("Error running login success hook: %+v %q", event, environ)
+	}
+
+	// Test 2: Online state and IP address run hooks on changes only
+	configHelper, loggerHelper, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configHelper.UserSettings.UserHookSettings = hookSettings
+	hookRunner, err := app.InitHookRunner(configHelper, loggerHelper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hookRunner.ObserveOnline(true, &app.HookEvent{})
+	hookRunner.ObserveIp("10.181.0.100", &app.HookEvent{})
+	hookRunner.ObserveIp("10.181.0.100", &app.HookEvent{})
+	if _, err = os.Stat(filepath.Join(dir, basic.HookOnline+".json")); err == nil {
+		t.Errorf("Error running online hook on the first state observed")
+	}
+	if _, err = os.Stat(filepath.Join(dir, basic.HookIpChange+".json")); err == nil {
+		t.Errorf("Error running IP change hook on the first IP address observed")
+	}
+	hookRunner.ObserveOnline(false, &app.HookEvent{Message: "Portal server unreachable"})
+	if event, _ = readHook(t, dir, basic.HookOffline); event.Message != "Portal server unreachable" {
+		t.Errorf("Error running offline hook: %+v", event)
+	}
+	hookRunner.ObserveOnline(true, &app.HookEvent{})
+	readHook(t, dir, basic.HookOnline)
+	hookRunner.ObserveIp("10.181.0.200", &app.HookEvent{})
+	if event, environ = readHook(t, dir, basic.HookIpChange); event.Ip != "10.181.0.200" || event.FormerIp != "10.181.0.100" ||
+		environ != "0  10.181.0.200 10.181.0.100" {
+		t.Errorf("Error running IP change hook: %+v %q", event, environ)
+	}
+
+	// Test 3: Hooks are killed after timeout
+	configHelper.UserSettings.UserHookSettings = basic.UserHookSettings{
+		OnOffline: basic.UserHook{Command: "sleep 10", Timeout: 1},
+	}
+	if hookRunner, err = app.InitHookRunner(configHelper, loggerHelper); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	hookRunner.Run(&app.HookEvent{Hook: basic.HookOffline})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Error killing hook after timeout: %v", elapsed)
+	}
+
+	// Test 4: Invalid timeout is reported
+	userSettingsFile := writeConfigFile(t, dir, basic.UserConfigFile, `hooks:
+    on_logout:
+        command: "true"
+        timeout: -1
+`)
+	issues, err := basic.ValidateConfig(userSettingsFile, "../config/program-settings.yaml")
+	if err != nil || len(issues) != 1 {
+		t.Fatalf("Error validating hooks: %v %v", issues, err)
+	}
+	if issue := findIssue(issues, "hooks.on_logout.timeout"); issue == nil || issue.Line != 4 {
+		t.Errorf("Error reporting invalid hook timeout: %v", issue)
+	}
+}
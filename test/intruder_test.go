@@ -0,0 +1,85 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"xjtuportal/component/app"
+	"xjtuportal/component/http/httpfake"
+)
+
+func TestIntruderDetector(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-intruder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "history.jsonl")
+
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 3)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	fake.AddSession(fakeUnknownMac, "10.181.0.2")
+	fake.AddSession(fakeLocalMac, fakeLocalIp)
+
+	// Test 0: Only sessions of unknown devices are reported
+	detector := newFixture(t, withFake(fake), withHistory(file, 0), withIntruder(false)).intruderDetector()
+	if !detector.Due() {
+		t.Errorf("Error checking for the first time")
+	}
+	intruders, err := detector.Check(context.Background())
+	if err != nil || len(intruders) != 1 || intruders[0].UserMacAddr != fakeUnknownMac {
+		t.Fatalf("Error finding unknown device: %v %v", intruders, err)
+	}
+	if detector.Due() {
+		t.Errorf("Error waiting for the interval after check")
+	}
+	if len(fake.Sessions) != 3 {
+		t.Errorf("Unknown device is logged out without auto logout")
+	}
+
+	// Test 1: Sessions reported are not reported again, also after restart
+	if intruders, _ = detector.Check(context.Background()); len(intruders) != 0 {
+		t.Errorf("Error reporting unknown device again: %v", intruders)
+	}
+	detector = newFixture(t, withFake(fake), withHistory(file, 0), withIntruder(false)).intruderDetector()
+	if intruders, _ = detector.Check(context.Background()); len(intruders) != 0 {
+		t.Errorf("Error reporting unknown device again after restart: %v", intruders)
+	}
+
+	// Test 2: New sessions of unknown devices are logged out with auto logout and recorded in history
+	fake.AddSession("00:00:5e:00:53:02", "10.181.0.3")
+	detector = newFixture(t, withFake(fake), withHistory(file, 0), withIntruder(true)).intruderDetector()
+	intruders, err = detector.Check(context.Background())
+	if err != nil || len(intruders) != 1 || intruders[0].UserMacAddr != "00:00:5e:00:53:02" {
+		t.Fatalf("Error finding new unknown device: %v %v", intruders, err)
+	}
+	for _, session := range fake.Sessions {
+		if session.UserMacAddr == "00:00:5e:00:53:02" {
+			t.Errorf("Error logging out unknown device")
+		}
+	}
+	portalHelper := newFixture(t, withFake(fake), withAutoLogout(true), withHistory(file, 0)).portal()
+	records, _ := portalHelper.History().Query(&app.HistoryQuery{Event: app.IntruderEvent})
+	if len(records) != 2 || records[0].Mac != fakeUnknownMac || records[1].Mac != "00:00:5e:00:53:02" {
+		t.Errorf("Error recording unknown devices: %+v", records)
+	}
+	records, _ = portalHelper.History().Query(&app.HistoryQuery{Mac: "00:00:5e:00:53:02", Event: app.LogoutEvent})
+	if len(records) != 1 || records[0].Reason != app.IntruderLogout || !records[0].Success {
+		t.Errorf("Error recording logout of unknown device: %+v", records)
+	}
+
+	// Test 3: Unknown devices are not logged out if the current session cannot be identified, e.g. behind a router
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, "10.181.0.99", 2)
+	fake.AddSession("00:00:5e:00:53:10", "10.181.0.9")
+	detector = newFixture(t, withFake(fake), withHistory(filepath.Join(dir, "router.jsonl"), 0), withIntruder(true)).intruderDetector()
+	intruders, err = detector.Check(context.Background())
+	if err != nil || len(intruders) != 1 || intruders[0].IsCurrentSession {
+		t.Fatalf("Error reporting unknown device without current session: %v %v", intruders, err)
+	}
+	if len(fake.Sessions) != 1 {
+		t.Errorf("Error keeping sessions without current session identified")
+	}
+}
@@ -0,0 +1,115 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"xjtuportal/component/basic"
+)
+
+func findValue(values []*basic.ConfigValue, path string) *basic.ConfigValue {
+	for _, value := range values {
+		if value.Path == path {
+			return value
+		}
+	}
+	return nil
+}
+
+func TestConfigLayers(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Test 0: Embedded defaults are used without program settings file
+	programSettingsFile := filepath.Join(dir, basic.ProgramConfigFile)
+	programSettings, err := basic.InitProgramSettings(programSettingsFile)
+	if err != nil || programSettings.ProgramRequestSettings.Timeout != 10 ||
+		programSettings.ProgramConnectivitySettings.Http.Intranet != "http://10.184.6.32/" {
+		t.Fatalf("Error using embedded defaults: %v", err)
+	}
+
+	// Test 1: Program settings file is deep-merged over defaults
+	writeConfigFile(t, dir, basic.ProgramConfigFile, `request:
+  timeout: 20
+app:
+  portal:
+    error_handle:
+      login_errors:
+        27: {log_level: FATAL}
+`)
+	programSettings, err = basic.InitProgramSettings(programSettingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if programSettings.ProgramRequestSettings.Timeout != 20 || programSettings.ProgramRequestSettings.Retry.Attempts != 3 {
+		t.Errorf("Error merging request settings: %+v", programSettings.ProgramRequestSettings)
+	}
+	loginErrors := programSettings.ProgramAppSettings.ProgramPortalSettings.ErrorHandle[basic.LoginErrors]
+	if loginErrors[27].LogLevel != "FATAL" || loginErrors[27].LogMessage != "Error 27: Account suspended" || len(loginErrors) < 2 {
+		t.Errorf("Error merging error handlers: %+v", loginErrors[27])
+	}
+
+	// Test 2: Environment variables override user settings
+	userSettingsFile := writeConfigFile(t, dir, basic.UserConfigFile, `online:
+    auth_data:
+        domain: xjtu
+        username: zhangsan
+        password: "123456789"
+`)
+	for name, value := range map[string]string{
+		"XJTUPORTAL_ONLINE_AUTH_DATA_USERNAME": "lisi",
+		"XJTUPORTAL_DEVICE_KNOWN_MAC_LIST":     "11:22:33:44:55:66, aa:bb:cc:dd:ee:ff",
+		"XJTUPORTAL_APP_PORTAL_AUTO_LOGOUT":    "true",
+	} {
+		_ = os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	userSettings, err := basic.InitUserSettings(userSettingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userSettings.UserOnlineSettings.AuthData.Username != "lisi" || userSettings.UserOnlineSettings.AuthData.Password != "123456789" ||
+		len(userSettings.UserDeviceSettings.KnownMacList) != 2 || !userSettings.UserAppSettings.UserPortalSettings.IsAutoLogout {
+		t.Errorf("Error overriding user settings by environment variables: %+v", userSettings)
+	}
+
+	// Test 3: Origins of values are dumped, passwords are masked
+	values, err := basic.DumpConfig(userSettingsFile, programSettingsFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, origin := range map[string]string{
+		"online.auth_data.domain":   userSettingsFile + ":3",
+		"online.auth_data.username": "$XJTUPORTAL_ONLINE_AUTH_DATA_USERNAME",
+		"device.known_mac_list":     "$XJTUPORTAL_DEVICE_KNOWN_MAC_LIST",
+		"request.timeout":           programSettingsFile + ":2",
+	} {
+		if value := findValue(values, path); value == nil || value.Origin != origin {
+			t.Errorf("Error dumping origin of [%s]: %+v", path, value)
+		}
+	}
+	if value := findValue(values, "online.auth_data.password"); value == nil || value.Value == "123456789" {
+		t.Errorf("Error masking password: %+v", value)
+	}
+	if value := findValue(values, "request.retry.attempts"); value != nil {
+		t.Errorf("Error dumping default value without --effective: %+v", value)
+	}
+	values, err = basic.DumpConfig(userSettingsFile, programSettingsFile, true)
+	if value := findValue(values, "request.retry.attempts"); err != nil || value == nil || value.Origin != basic.DefaultOrigin {
+		t.Errorf("Error dumping effective default value: %+v %v", value, err)
+	}
+
+	// Test 4: Invalid environment variables are reported
+	_ = os.Setenv("XJTUPORTAL_APP_DAEMON_INTERVAL", "soon")
+	defer os.Unsetenv("XJTUPORTAL_APP_DAEMON_INTERVAL")
+	issues, err := basic.ValidateConfig(userSettingsFile, programSettingsFile)
+	if err != nil || len(issues) != 1 || issues[0].File != "$XJTUPORTAL_APP_DAEMON_INTERVAL" {
+		t.Errorf("Error reporting invalid environment variable: %v %v", issues, err)
+	}
+
+}
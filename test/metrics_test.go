@@ -0,0 +1,46 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"xjtuportal/component/basic"
+)
+
+func TestMetricsExpose(t *testing.T) {
+
+	metricsHelper := basic.InitMetricsHelper()
+
+	metricsHelper.SetGauge(basic.MetricHttpCheckUp, 1, "target", "internet")
+	metricsHelper.SetGauge(basic.MetricHttpCheckUp, 0, "target", "intranet")
+	metricsHelper.AddCounter(basic.MetricLoginAttempts, 1, "status_code", "39")
+	metricsHelper.AddCounter(basic.MetricLoginAttempts, 1, "status_code", "39")
+	metricsHelper.AddCounter(basic.MetricAutoLogouts, 1)
+
+	// Test 0: Counter accumulation
+	if value, ok := metricsHelper.Value(basic.MetricLoginAttempts, "status_code", "39"); !ok || value != 2 {
+		t.Error("Error accumulating counter")
+	}
+
+	// Test 1: Exposition format
+	exposed := metricsHelper.Expose()
+	expectedLines := []string{
+		"# TYPE xjtuportal_http_check_up gauge",
+		`xjtuportal_http_check_up{target="internet"} 1`,
+		`xjtuportal_http_check_up{target="intranet"} 0`,
+		"# TYPE xjtuportal_login_attempts_total counter",
+		`xjtuportal_login_attempts_total{status_code="39"} 2`,
+		"xjtuportal_auto_logouts_total 1",
+	}
+	for _, line := range expectedLines {
+		if !strings.Contains(exposed, line+"\n") {
+			t.Error("Missing line in exposed metrics: " + line)
+		}
+	}
+
+	// Test 2: Unknown metric is ignored
+	metricsHelper.SetGauge("unknown_metric", 1)
+	if strings.Contains(metricsHelper.Expose(), "unknown_metric") {
+		t.Error("Unknown metric should not be exposed")
+	}
+
+}
@@ -0,0 +1,185 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xjtuportal/component/basic"
+)
+
+const oldUserSettings = `# Your authentication information
+online:
+    auth_data:
+        domain: xjtu
+        username: zhangsan
+        password: "123456789"
+device:
+    known_mac_list: ['11:22:33:44:55:66']
+
+session:
+    portal:
+        # Auto logout device if device number is overload
+        auto_logout: true
+ui:
+    mode: command
+`
+
+func TestConfigMigration(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	userSettingsFile := writeConfigFile(t, dir, basic.UserConfigFile, oldUserSettings)
+	programSettingsFile := filepath.Join(dir, basic.ProgramConfigFile)
+
+	// Test 0: Old layout is upgraded in memory when read
+	userSettings, err := basic.InitUserSettings(userSettingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userSettings.Version != 1 || !userSettings.UserAppSettings.UserPortalSettings.IsAutoLogout {
+		t.Errorf("Error reading old layout: version %d, auto logout %v", userSettings.Version,
+			userSettings.UserAppSettings.UserPortalSettings.IsAutoLogout)
+	}
+
+	// Test 1: Nothing is written in dry run
+	result, err := basic.MigrateUserSettings(userSettingsFile, true)
+	if err != nil || result.FromVersion != 1 || result.ToVersion != basic.ConfigVersion || result.Backup != "" {
+		t.Fatalf("Error migrating in dry run: %+v %v", result, err)
+	}
+	if content, _ := ioutil.ReadFile(userSettingsFile); string(content) != oldUserSettings {
+		t.Errorf("Config file is written in dry run")
+	}
+
+	// Test 2: File is rewritten with comments after backup
+	result, err = basic.MigrateUserSettings(userSettingsFile, false)
+	if err != nil || len(result.Changes) != 2 || result.Changes[0] != "moved session to app" {
+		t.Fatalf("Error migrating user settings: %+v %v", result, err)
+	}
+	if backup, _ := ioutil.ReadFile(result.Backup); string(backup) != oldUserSettings {
+		t.Errorf("Error backing up user settings to [%s]", result.Backup)
+	}
+	content, _ := ioutil.ReadFile(userSettingsFile)
+	for _, expected := range []string{"version: 2", "# Your authentication information", "\napp:\n    portal:\n",
+		"# Auto logout device", "['11:22:33:44:55:66']", `password: "123456789"`} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Migrated user settings does not contain [%s]:\n%s", expected, content)
+		}
+	}
+	issues, err := basic.ValidateConfig(userSettingsFile, programSettingsFile)
+	if err != nil || len(issues) != 0 {
+		t.Errorf("Error validating migrated config: %v %v", issues, err)
+	}
+	userSettings, err = basic.InitUserSettings(userSettingsFile)
+	if err != nil || userSettings.Version != basic.ConfigVersion || !userSettings.UserAppSettings.UserPortalSettings.IsAutoLogout {
+		t.Errorf("Error reading migrated user settings: %+v %v", userSettings, err)
+	}
+
+	// Test 3: Up-to-date file is not rewritten again
+	result, err = basic.MigrateUserSettings(userSettingsFile, false)
+	if err != nil || result.FromVersion != basic.ConfigVersion || len(result.Changes) != 0 || result.Backup != "" {
+		t.Errorf("Error migrating up-to-date user settings: %+v %v", result, err)
+	}
+
+	// Test 4: Fields given in both session and app are kept in app
+	writeConfigFile(t, dir, basic.UserConfigFile, strings.Replace(oldUserSettings, "ui:", `app:
+    portal:
+        auto_logout: false
+ui:`, 1))
+	result, err = basic.MigrateUserSettings(userSettingsFile, false)
+	if err != nil || !strings.HasPrefix(result.Changes[0], "removed session.portal.auto_logout") ||
+		result.Backup != userSettingsFile+".v1.bak.1" {
+		t.Fatalf("Error migrating user settings with both session and app: %+v %v", result, err)
+	}
+	userSettings, err = basic.InitUserSettings(userSettingsFile)
+	if err != nil || userSettings.UserAppSettings.UserPortalSettings.IsAutoLogout {
+		t.Errorf("Error keeping settings in app: %+v %v", userSettings, err)
+	}
+
+	// Test 5: Removed program settings are dropped, missing file is up to date. Version of program settings is given by
+	// embedded defaults if it is not in the file.
+	result, err = basic.MigrateProgramSettings(programSettingsFile, false)
+	if err != nil || result.FromVersion != basic.ConfigVersion || len(result.Changes) != 0 {
+		t.Errorf("Error migrating missing program settings: %+v %v", result, err)
+	}
+	writeConfigFile(t, dir, basic.ProgramConfigFile, `dns:
+  testing:
+    # Not in used currently
+    retry_times: 10
+ui:
+  shell:
+    interact_hint:
+      update_check:
+        latest_version: "最新版本："
+`)
+	programSettings, err := basic.InitProgramSettings(programSettingsFile)
+	if err != nil || programSettings.Version != basic.ConfigVersion || programSettings.ProgramDnsSettings.Testing.RetryTimes != 2 {
+		t.Errorf("Error reading old program settings: %+v %v", programSettings, err)
+	}
+	result, err = basic.MigrateProgramSettings(programSettingsFile, false)
+	if err != nil || len(result.Changes) != 3 {
+		t.Fatalf("Error migrating program settings: %+v %v", result, err)
+	}
+	content, _ = ioutil.ReadFile(programSettingsFile)
+	if strings.Contains(string(content), "update_check") || !strings.Contains(string(content), "retry_times: 2") {
+		t.Errorf("Error migrating program settings:\n%s", content)
+	}
+
+	// Test 6: Newer versions are rejected
+	writeConfigFile(t, dir, basic.UserConfigFile, "version: 99\n"+oldUserSettings)
+	if _, err = basic.MigrateUserSettings(userSettingsFile, false); err == nil {
+		t.Errorf("Error rejecting newer version in migration")
+	}
+	issues, _ = basic.ValidateConfig(userSettingsFile, programSettingsFile)
+	if issue := findIssue(issues, "version"); issue == nil || issue.Line != 1 {
+		t.Errorf("Error reporting newer version: %v", issues)
+	}
+}
+
+func TestEditConfigFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-edit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	userSettingsFile := writeConfigFile(t, dir, basic.UserConfigFile, oldUserSettings)
+	_ = os.Setenv("XJTUPORTAL_ONLINE_AUTH_DATA_PASSWORD", "env-password")
+	defer os.Unsetenv("XJTUPORTAL_ONLINE_AUTH_DATA_PASSWORD")
+
+	// Test 0: Only given fields are changed, legacy fields and comments are kept
+	err = basic.EditConfigFile(userSettingsFile, map[string]interface{}{
+		"online.auth_data.username":        "lisi",
+		"online.auth_data.password_source": basic.VaultSource,
+		"app.portal.auto_logout":           false,
+	}, []string{"online.auth_data.password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(userSettingsFile)
+	for _, expected := range []string{"# Your authentication information", "username: lisi", "password_source: vault",
+		"session:", "# Auto logout device", "known_mac_list: ['11:22:33:44:55:66']"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Error keeping or setting [%s] in:\n%s", expected, content)
+		}
+	}
+	if strings.Contains(string(content), "password:") || strings.Contains(string(content), "env-password") ||
+		strings.Contains(string(content), "version") {
+		t.Errorf("Error removing password or writing fields not given:\n%s", content)
+	}
+
+	// Test 1: Fields set in app take precedence over the legacy session block when read
+	userSettings, err := basic.InitUserSettings(userSettingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authData := userSettings.UserOnlineSettings.AuthData
+	if authData.Username != "lisi" || authData.PasswordSource != basic.VaultSource ||
+		userSettings.UserAppSettings.UserPortalSettings.IsAutoLogout {
+		t.Errorf("Error reading edited file: %+v %v", authData, userSettings.UserAppSettings.UserPortalSettings.IsAutoLogout)
+	}
+}
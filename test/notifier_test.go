@@ -0,0 +1,195 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"xjtuportal/component/app"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/fakeportal"
+)
+
+func TestNotifier(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-notifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	commandOutput := filepath.Join(dir, "command.txt")
+
+	fakePortal := newFakePortal(2)
+	server := fakeportal.StartServer(fakePortal)
+	defer server.Close()
+	smtpServer, err := fakeportal.StartSmtpServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smtpServer.Close()
+
+	notifiers := []basic.UserNotifierSettings{
+		{
+			Type:   basic.WebhookNotifier,
+			Events: []string{basic.NotifyLoginFailed, basic.NotifyRecovered},
+			Url:    server.URL + fakeportal.NotifyPath,
+			Body:   `{"text": {{json (printf "%s: %s" .Title .Message)}}, "code": {{.StatusCode}}}`,
+		},
+		{
+			Type:   basic.SmtpNotifier,
+			Events: []string{basic.NotifyLoginFailed},
+			Host:   smtpServer.Addr(),
+			From:   "xjtuportal@localhost",
+			To:     []string{"admin@localhost"},
+		},
+	}
+	if runtime.GOOS != basic.Windows {
+		notifiers = append(notifiers, basic.UserNotifierSettings{
+			Type:    basic.CommandNotifier,
+			Command: `echo "$XJTUPORTAL_EVENT $XJTUPORTAL_STATUS_CODE $XJTUPORTAL_ACCOUNT" >> ` + commandOutput,
+		})
+	}
+	portalHelper := newFixture(t, withServer(server.URL), withAutoLogout(false), withConfig(func(configHelper *basic.ConfigHelper) {
+		configHelper.UserSettings.UserAppSettings.UserNotifySettings = basic.UserNotifySettings{
+			GracePeriod: 3600,
+			Notifiers:   notifiers,
+		}
+	})).portal()
+
+	// Test 0: Account suspended is notified immediately through all notifiers
+	fakePortal.SetLoginError(basic.AccountSuspended)
+	if result := portalHelper.DoLogin(context.Background()); result.Success() {
+		t.Fatalf("Error failing login with account suspended: %+v", result)
+	}
+	webhooks := fakePortal.Webhooks()
+	if len(webhooks) != 1 || !strings.Contains(webhooks[0], `"code": 27`) || !strings.Contains(webhooks[0], "suspended") {
+		t.Errorf("Error posting webhook with body template: %v", webhooks)
+	}
+	messages := smtpServer.Messages()
+	if len(messages) != 1 || messages[0].To[0] != "admin@localhost" || !strings.Contains(messages[0].Data, "Login failed") {
+		t.Errorf("Error sending mail: %+v", messages)
+	}
+
+	// Test 1: Failures are notified once in an outage, recovery is notified after
+	fakePortal.SetLoginError(basic.AccountFrozen)
+	portalHelper.DoLogin(context.Background())
+	if webhooks = fakePortal.Webhooks(); len(webhooks) != 1 {
+		t.Errorf("Error notifying login failure again in an outage: %v", webhooks)
+	}
+	fakePortal.SetLoginError(0)
+	if result := portalHelper.DoLogin(context.Background()); !result.Success() {
+		t.Fatalf("Error logging in: %+v", result)
+	}
+	if webhooks = fakePortal.Webhooks(); len(webhooks) != 2 || !strings.Contains(webhooks[1], "Network recovered") {
+		t.Errorf("Error notifying recovery: %v", webhooks)
+	}
+	if messages = smtpServer.Messages(); len(messages) != 1 {
+		t.Errorf("Error sending mail of events not subscribed: %+v", messages)
+	}
+	if runtime.GOOS != basic.Windows {
+		content, _ := ioutil.ReadFile(commandOutput)
+		if string(content) != "login_failed 27 zhangsan@xjtu\nrecovered 0 \n" {
+			t.Errorf("Error running command with notification: %q", content)
+		}
+	}
+
+	// Test 2: Transient outages are notified only after the grace period
+	configHelper, loggerHelper, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook := notifiers[0]
+	webhook.Events = nil
+	configHelper.UserSettings.UserAppSettings.UserNotifySettings = basic.UserNotifySettings{
+		GracePeriod: 3600,
+		Notifiers:   []basic.UserNotifierSettings{webhook},
+	}
+	notifierHelper, err := app.InitNotifierHelper(configHelper, loggerHelper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifierHelper.Outage(&app.Notification{Event: basic.NotifyOutage, Message: "Portal server unreachable"}, false)
+	notifierHelper.Recovered()
+	if webhooks = fakePortal.Webhooks(); len(webhooks) != 2 {
+		t.Errorf("Error notifying outage within grace period: %v", webhooks)
+	}
+	configHelper.UserSettings.UserAppSettings.UserNotifySettings.GracePeriod = 0
+	if notifierHelper, err = app.InitNotifierHelper(configHelper, loggerHelper); err != nil {
+		t.Fatal(err)
+	}
+	notifierHelper.Outage(&app.Notification{Event: basic.NotifyOutage, Message: "Portal server unreachable"}, false)
+	notifierHelper.Recovered()
+	if webhooks = fakePortal.Webhooks(); len(webhooks) != 4 || !strings.Contains(webhooks[2], "Network outage") {
+		t.Errorf("Error notifying outage after grace period: %v", webhooks)
+	}
+
+	// Test 3: Mails are sent after STARTTLS if the relay supports it, the certificate of relay is verified
+	tlsSmtpServer, err := fakeportal.StartSmtpServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsSmtpServer.Close()
+	certificate, err := tlsSmtpServer.EnableStartTls()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "ca.pem")
+	if err = ioutil.WriteFile(caFile, certificate, 0600); err != nil {
+		t.Fatal(err)
+	}
+	mail := notifiers[1]
+	mail.Host = tlsSmtpServer.Addr()
+	configHelper.UserSettings.UserAppSettings.UserNotifySettings.Notifiers = []basic.UserNotifierSettings{mail}
+	if notifierHelper, err = app.InitNotifierHelper(configHelper, loggerHelper); err != nil {
+		t.Fatal(err)
+	}
+	notifierHelper.Notify(&app.Notification{Event: basic.NotifyLoginFailed, Message: "Account suspended"})
+	if messages = tlsSmtpServer.Messages(); len(messages) != 0 {
+		t.Errorf("Error verifying certificate of SMTP relay: %+v", messages)
+	}
+	mail.CaFile = caFile
+	configHelper.UserSettings.UserAppSettings.UserNotifySettings.Notifiers = []basic.UserNotifierSettings{mail}
+	if notifierHelper, err = app.InitNotifierHelper(configHelper, loggerHelper); err != nil {
+		t.Fatal(err)
+	}
+	notifierHelper.Notify(&app.Notification{Event: basic.NotifyLoginFailed, Message: "Account suspended"})
+	if messages = tlsSmtpServer.Messages(); len(messages) != 1 || !messages[0].Tls ||
+		!strings.Contains(messages[0].Data, "Account suspended") {
+		t.Errorf("Error sending mail after STARTTLS: %+v", messages)
+	}
+
+	// Test 4: Invalid notifiers are reported
+	userSettingsFile := writeConfigFile(t, dir, basic.UserConfigFile, `app:
+    notify:
+        grace_period: -1
+        notifiers:
+          - type: webhook
+            events: [login_failed, offline]
+            url: example.com/notify
+            body: '{"text": {{.Message}'
+          - type: smtp
+            host: smtp.example.com:0
+          - type: pager
+`)
+	issues, err := basic.ValidateConfig(userSettingsFile, "../config/program-settings.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		"app.notify.grace_period",
+		"app.notify.notifiers[0].events[1]",
+		"app.notify.notifiers[0].url",
+		"app.notify.notifiers[0].body",
+		"app.notify.notifiers[1].host",
+		"app.notify.notifiers[1].from",
+		"app.notify.notifiers[1].to",
+		"app.notify.notifiers[2].type",
+	} {
+		if issue := findIssue(issues, path); issue == nil || issue.Suggestion == "" {
+			t.Errorf("Error reporting invalid notifier setting [%s]: %v", path, issue)
+		}
+	}
+}
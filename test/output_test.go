@@ -0,0 +1,186 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/fakeportal"
+	"xjtuportal/exec"
+
+	"gopkg.in/yaml.v3"
+)
+
+// writeCliConfig writes config files pointing to the fake portal server at serverUrl into a temporary folder
+func writeCliConfig(t *testing.T, serverUrl string) string {
+
+	configHelper, _, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeportal.PointSettingsTo(configHelper.ProgramSettings, serverUrl)
+	programSettings, err := yaml.Marshal(configHelper.ProgramSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "xjtuportal-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeConfigFile(t, dir, basic.ProgramConfigFile, string(programSettings))
+	writeConfigFile(t, dir, basic.UserConfigFile, `version: 2
+online:
+    auth_data:
+        domain: xjtu
+        username: zhangsan
+        password: "123456789"
+device:
+    known_mac_list: ['11:22:33:44:55:66']
+app:
+    portal:
+        auto_logout: true
+    history:
+        enabled: false
+logger:
+    output_writer: [stdout]
+    level: ERROR
+ui:
+    mode: command
+`)
+	return dir
+}
+
+// runCli runs the command line like main does and returns the exit code and everything printed to stdout
+func runCli(t *testing.T, args ...string) (int, []byte) {
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	outputChan := make(chan []byte)
+	go func() {
+		output, _ := ioutil.ReadAll(reader)
+		outputChan <- output
+	}()
+
+	exitCode := exec.ExitUsage
+	options, err := exec.ParseCommandLine(args, "config")
+	if err == nil {
+		shellUi, initExitCode := exec.InitShellUi(options)
+		exitCode = initExitCode
+		if shellUi != nil {
+			_, exitCode = shellUi.Exec()
+		}
+	}
+
+	os.Stdout = stdout
+	_ = writer.Close()
+	return exitCode, <-outputChan
+}
+
+func TestCliOutput(t *testing.T) {
+
+	fakePortal := newFakePortal(2)
+	fakePortal.Backend.AddSession(fakeKnownMac, "10.181.0.1")
+	fakePortal.Backend.AddSession(fakeUnknownMac, "10.181.0.2")
+	server := fakeportal.StartServer(fakePortal)
+	defer server.Close()
+	dir := writeCliConfig(t, server.URL)
+	defer os.RemoveAll(dir)
+
+	// Test 0: Login in JSON with auto logout
+	exitCode, output := runCli(t, "-c", dir, "login", "-output", "json")
+	loginResult := make(map[string]interface{})
+	if err := json.Unmarshal(output, &loginResult); err != nil || exitCode != exec.ExitSuccess {
+		t.Fatalf("Error logging in with JSON output: %d %v %s", exitCode, err, output)
+	}
+	if loginResult["status_code"] != float64(200) || loginResult["logged_out_mac"] != fakeUnknownMac {
+		t.Errorf("Error reporting login result: %v", loginResult)
+	}
+	if _, ok := loginResult["error"]; ok {
+		t.Errorf("Error omitting empty error: %v", loginResult)
+	}
+
+	// Test 1: Sessions in JSON and YAML
+	sessionList := &struct {
+		Concurrency int `json:"concurrency" yaml:"concurrency"`
+		Sessions    []struct {
+			Index            int    `json:"index" yaml:"index"`
+			UserMacAddr      string `json:"user_mac_addr" yaml:"user_mac_addr"`
+			UniqueId         string `json:"unique_id" yaml:"unique_id"`
+			IsCurrentSession bool   `json:"is_current_session" yaml:"is_current_session"`
+		} `json:"sessions" yaml:"sessions"`
+		Error string `json:"error" yaml:"error"`
+	}{}
+	for _, format := range []string{exec.JsonOutput, exec.YamlOutput} {
+		exitCode, output = runCli(t, "-c", dir, "sessions", "-output", format)
+		var err error
+		if format == exec.JsonOutput {
+			err = json.Unmarshal(output, sessionList)
+		} else {
+			err = yaml.Unmarshal(output, sessionList)
+		}
+		if err != nil || exitCode != exec.ExitSuccess || sessionList.Concurrency != 2 || len(sessionList.Sessions) != 2 {
+			t.Fatalf("Error listing sessions in %s: %d %v %s", format, exitCode, err, output)
+		}
+		current := sessionList.Sessions[1]
+		if current.Index != 1 || current.UserMacAddr != fakeLocalMac || !current.IsCurrentSession ||
+			current.UniqueId != fakePortal.Backend.Sessions[1].UniqueId || sessionList.Sessions[0].IsCurrentSession {
+			t.Errorf("Error reporting sessions in %s: %+v", format, sessionList.Sessions)
+		}
+	}
+
+	// Test 2: Failures are reported in error with exit code 1
+	fakePortal.SetLoginError(60)
+	fakePortal.Backend.Sessions = fakePortal.Backend.Sessions[:1]
+	exitCode, output = runCli(t, "-c", dir, "login", "-output", "yaml")
+	loginResult = make(map[string]interface{})
+	if err := yaml.Unmarshal(output, &loginResult); err != nil || exitCode != exec.ExitFailure || loginResult["status_code"] != 60 {
+		t.Errorf("Error reporting failed login: %d %v %s", exitCode, err, output)
+	}
+	exitCode, output = runCli(t, "-c", dir, "logout", "-index", "5", "-output", "json")
+	logoutResult := make(map[string]interface{})
+	if err := json.Unmarshal(output, &logoutResult); err != nil || exitCode != exec.ExitFailure || logoutResult["error"] == "" {
+		t.Errorf("Error reporting failed logout: %d %v %s", exitCode, err, output)
+	}
+
+	// Test 3: Usage errors
+	if exitCode, output = runCli(t, "-c", dir, "-profile", "missing", "login", "-output", "json"); exitCode != exec.ExitUsage {
+		t.Errorf("Error rejecting unknown profile: %d %s", exitCode, output)
+	}
+	if exitCode, _ = runCli(t, "-c", dir, "login", "-unknown"); exitCode != exec.ExitUsage {
+		t.Errorf("Error rejecting unknown flag: %d", exitCode)
+	}
+	if exitCode, output = runCli(t, "-c", dir, "login", "-output", "xml"); exitCode != exec.ExitFailure || len(output) != 0 {
+		t.Errorf("Error rejecting unknown output format: %d %s", exitCode, output)
+	}
+}
+
+func TestCliOutputDiagnosis(t *testing.T) {
+
+	server := fakeportal.StartServer(newFakePortal(2))
+	dir := writeCliConfig(t, server.URL)
+	defer os.RemoveAll(dir)
+	server.Close() // Every check of the portal fails
+
+	exitCode, output := runCli(t, "-c", dir, "diagnose", "-output", "json")
+	report := &struct {
+		InternetHttp *struct {
+			Error string `json:"error"`
+		} `json:"internet_http"`
+		IntranetHttp *struct {
+			Error string `json:"error"`
+		} `json:"intranet_http"`
+	}{}
+	if err := json.Unmarshal(output, report); err != nil || exitCode != exec.ExitFailure {
+		t.Fatalf("Error reporting diagnosis: %d %v %s", exitCode, err, output)
+	}
+	if report.InternetHttp == nil || report.InternetHttp.Error == "" ||
+		report.IntranetHttp == nil || !strings.Contains(report.IntranetHttp.Error, "refused") {
+		t.Errorf("Error reporting failed checks: %s", output)
+	}
+}
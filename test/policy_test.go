@@ -0,0 +1,75 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"xjtuportal/component/app"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/http"
+	"xjtuportal/component/http/httpfake"
+)
+
+func TestLogoutPolicy(t *testing.T) {
+
+	sessions := []*http.Session{
+		{UserMacAddr: fakeKnownMac, UserIpAddr: "10.181.0.1", StartTime: "2021-10-05 09:00:00", DeviceType: "PC"},
+		{UserMacAddr: fakeUnknownMac, UserIpAddr: "10.182.0.2", StartTime: "2021-10-05 08:00:00", DeviceType: "Mobile"},
+		{UserMacAddr: "aa:bb:cc:dd:ee:ff", UserIpAddr: "10.183.0.3", StartTime: "2021-10-05 10:00:00", DeviceType: "PC"},
+	}
+
+	testCases := []struct {
+		name     string
+		settings basic.UserLogoutPolicySettings
+		expected string
+	}{
+		{"default", basic.UserLogoutPolicySettings{}, fakeUnknownMac},
+		{"oldest", basic.UserLogoutPolicySettings{Rules: []string{app.OldestRule}}, fakeUnknownMac},
+		{"newest", basic.UserLogoutPolicySettings{Rules: []string{app.NewestRule}}, "aa:bb:cc:dd:ee:ff"},
+		{"device type", basic.UserLogoutPolicySettings{Rules: []string{app.DeviceTypeRule}, DeviceTypeList: []string{"mobile"}}, fakeUnknownMac},
+		{"cidr", basic.UserLogoutPolicySettings{Rules: []string{app.CidrRule}, CidrList: []string{"10.183.0.0/16"}}, "aa:bb:cc:dd:ee:ff"},
+		{"fallback", basic.UserLogoutPolicySettings{Rules: []string{app.CidrRule, app.OldestRule}, CidrList: []string{"10.200.0.0/16"}}, fakeUnknownMac},
+		{"protected", basic.UserLogoutPolicySettings{Rules: []string{app.OldestRule}, ProtectedMacList: []string{"00-00-5E-00-53-01"}}, fakeKnownMac},
+		{"all protected", basic.UserLogoutPolicySettings{ProtectedMacList: []string{fakeKnownMac, fakeUnknownMac, "aa:bb:cc:dd:ee:ff"}}, ""},
+		{"report only", basic.UserLogoutPolicySettings{Rules: []string{app.NewestRule}, ReportOnly: true}, ""},
+	}
+
+	for _, testCase := range testCases {
+		policyHelper := newFixture(t, withLogoutPolicy(testCase.settings)).logoutPolicy()
+		selected, reason := policyHelper.SelectLogoutSession(sessions)
+		mac := ""
+		if selected != nil {
+			mac = selected.UserMacAddr
+		}
+		if mac != testCase.expected {
+			t.Errorf("Error selecting session with policy [%s]: got [%s], expected [%s]", testCase.name, mac, testCase.expected)
+		}
+		if selected != nil && reason == "" {
+			t.Errorf("Error explaining decision with policy [%s]", testCase.name)
+		}
+	}
+
+}
+
+func TestFakePortalLogoutPolicy(t *testing.T) {
+
+	// Test 0: Report only, nothing is logged out
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	fake.AddSession(fakeUnknownMac, "10.181.0.2")
+	portalHelper := newFixture(t, withFake(fake), withAutoLogout(true),
+		withLogoutPolicy(basic.UserLogoutPolicySettings{Rules: []string{app.OldestRule}, ReportOnly: true})).portal()
+	result := portalHelper.DoLogin(context.Background())
+	if result.Success() || len(fake.LoggedOut) != 0 || result.LogoutReason == "" {
+		t.Errorf("Error reporting session to logout: %+v", result)
+	}
+
+	// Test 1: Logout the session with the given device type
+	fake.Sessions[0].DeviceType = "Mobile"
+	portalHelper = newFixture(t, withFake(fake), withAutoLogout(true),
+		withLogoutPolicy(basic.UserLogoutPolicySettings{Rules: []string{app.DeviceTypeRule}, DeviceTypeList: []string{"Mobile"}})).portal()
+	result = portalHelper.DoLogin(context.Background())
+	if !result.Success() || result.LoggedOutMac != fakeKnownMac {
+		t.Errorf("Error logging out session by device type: %+v", result)
+	}
+
+}
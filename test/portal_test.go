@@ -0,0 +1,286 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"xjtuportal/component/app"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/http"
+	"xjtuportal/component/http/httpfake"
+)
+
+const (
+	fakeLocalMac   = "02:00:5e:00:53:ff"
+	fakeLocalIp    = "10.181.0.100"
+	fakeUnknownMac = "00:00:5e:00:53:01"
+	fakeKnownMac   = "11:22:33:44:55:66"
+)
+
+func TestFakePortalLogin(t *testing.T) {
+
+	// Test 0: Login with free session slot
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	portalHelper := newFixture(t, withFake(fake), withAutoLogout(false)).portal()
+	result := portalHelper.DoLogin(context.Background())
+	if !result.Success() || result.StatusCode != 200 || len(fake.Sessions) != 1 {
+		t.Errorf("Error logging in with free session slot: %+v", result)
+	}
+
+	// Test 1: Login when already online
+	result = portalHelper.DoLogin(context.Background())
+	if !result.Success() || !result.AlreadyOnline || fake.OnlineCount != 1 {
+		t.Errorf("Error handling already online: %+v", result)
+	}
+
+	// Test 2: Invalid username or password
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.OnlineError = &http.OnlineResponse{ErrorCode: 81, Description: "invalid username or password"}
+	portalHelper = newFixture(t, withFake(fake), withAutoLogout(false)).portal()
+	result = portalHelper.DoLogin(context.Background())
+	if result.Success() || result.StatusCode != 60 {
+		t.Errorf("Error mapping invalid username or password: %+v", result)
+	}
+
+	// Test 3: Portal server is unreachable
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.PortalDown = true
+	portalHelper = newFixture(t, withFake(fake), withAutoLogout(false)).portal()
+	result = portalHelper.DoLogin(context.Background())
+	if result.Success() || result.Error == "" {
+		t.Errorf("Error handling unreachable portal server: %+v", result)
+	}
+
+}
+
+func TestFakePortalAutoLogout(t *testing.T) {
+
+	// Test 0: Session overload without auto logout
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	fake.AddSession(fakeUnknownMac, "10.181.0.2")
+	portalHelper := newFixture(t, withFake(fake), withAutoLogout(false)).portal()
+	result := portalHelper.DoLogin(context.Background())
+	if result.Success() || result.StatusCode != basic.SessionOverload || len(fake.LoggedOut) != 0 {
+		t.Errorf("Error handling session overload: %+v", result)
+	}
+
+	// Test 1: Session overload with auto logout, the unknown MAC address is logged out
+	portalHelper = newFixture(t, withFake(fake), withAutoLogout(true)).portal()
+	result = portalHelper.DoLogin(context.Background())
+	if !result.Success() || result.LoggedOutMac != fakeUnknownMac {
+		t.Errorf("Error logging out unknown MAC address automatically: %+v", result)
+	}
+	if len(fake.LoggedOut) != 1 || len(fake.Sessions) != 2 {
+		t.Error("Error logging out exactly one session")
+	}
+
+}
+
+func TestFakePortalSession(t *testing.T) {
+
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 3)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	fake.AddSession(fakeLocalMac, fakeLocalIp)
+	unknownId := fake.AddSession(fakeUnknownMac, "10.181.0.2")
+	portalHelper := newFixture(t, withFake(fake), withAutoLogout(false)).portal()
+
+	// Test 0: List sessions and find current session
+	sessions, err := portalHelper.ListSession(context.Background())
+	if err != nil || len(sessions) != 3 {
+		t.Fatal("Error listing sessions")
+	}
+	if !sessions[1].IsCurrentSession || sessions[0].IsCurrentSession || sessions[2].IsCurrentSession {
+		t.Error("Error finding current session")
+	}
+	if portalHelper.SessionConcurrency() != 3 {
+		t.Error("Error getting concurrency")
+	}
+
+	// Test 1: Logout by unique id
+	if err = portalHelper.DoLogoutByUniqueId(context.Background(), unknownId); err != nil || len(fake.Sessions) != 2 {
+		t.Error("Error logging out by unique id")
+	}
+
+	// Test 2: Logout by MAC address in non-standard format
+	if err = portalHelper.DoLogoutByMac(context.Background(), "11-22-33-44-55-66"); err != nil || len(fake.Sessions) != 1 {
+		t.Error("Error logging out by MAC address")
+	}
+
+	// Test 3: Logout by invalid index
+	if err = portalHelper.DoLogout(context.Background(), 5); err == nil {
+		t.Error("Error handling invalid session index")
+	}
+
+}
+
+func TestFakePortalSelectSessions(t *testing.T) {
+
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 4)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	fake.AddSession(fakeLocalMac, fakeLocalIp)
+	unknownId := fake.AddSession(fakeUnknownMac, "10.181.0.2")
+	portalHelper := newFixture(t, withFake(fake), withAutoLogout(false)).portal()
+
+	testCases := []struct {
+		name     string
+		selector *app.LogoutSelector
+		expected []string
+	}{
+		{"mac", &app.LogoutSelector{MacList: []string{"11-22-33-44-55-66"}}, []string{fakeKnownMac}},
+		{"ip", &app.LogoutSelector{IpList: []string{"10.181.0.2"}}, []string{fakeUnknownMac}},
+		{"id", &app.LogoutSelector{UniqueIdList: []string{unknownId}}, []string{fakeUnknownMac}},
+		{"multiple", &app.LogoutSelector{MacList: []string{fakeUnknownMac}, IpList: []string{"10.181.0.1"}}, []string{fakeKnownMac, fakeUnknownMac}},
+		{"all except current", &app.LogoutSelector{AllExceptCurrent: true}, []string{fakeKnownMac, fakeUnknownMac}},
+		{"unknown", &app.LogoutSelector{Unknown: true}, []string{fakeUnknownMac}},
+	}
+
+	for _, testCase := range testCases {
+		sessions, err := portalHelper.SelectSessions(context.Background(), testCase.selector)
+		if err != nil {
+			t.Errorf("Error selecting sessions by [%s]: %v", testCase.name, err)
+			continue
+		}
+		macList := make([]string, 0, len(sessions))
+		for _, session := range sessions {
+			macList = append(macList, session.UserMacAddr)
+		}
+		if fmt.Sprint(macList) != fmt.Sprint(testCase.expected) {
+			t.Errorf("Error selecting sessions by [%s]: got %v, expected %v", testCase.name, macList, testCase.expected)
+		}
+	}
+
+	// Missing target is an error, nothing is selected
+	if _, err := portalHelper.SelectSessions(context.Background(), &app.LogoutSelector{IpList: []string{"10.181.0.1", "10.181.0.200"}}); err == nil {
+		t.Error("Error handling missing logout target")
+	}
+	if len(fake.LoggedOut) != 0 {
+		t.Error("Error selecting sessions without logging out")
+	}
+
+}
+
+func TestFakePortalCancel(t *testing.T) {
+
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.Delay = 5 * time.Second
+	portalHelper := newFixture(t, withFake(fake), withAutoLogout(false)).portal()
+
+	// The login is aborted when the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	result := portalHelper.DoLogin(ctx)
+	if result.Success() || result.Error == "" {
+		t.Errorf("Error aborting login: %+v", result)
+	}
+	if time.Since(start) > time.Second || fake.OnlineCount != 0 {
+		t.Error("Error aborting login immediately")
+	}
+
+}
+
+func TestFakePortalRetry(t *testing.T) {
+
+	// Test 0: Online request is not resent after a transient failure
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.TransientFailures = 1
+	portalHelper := newFixture(t, withFake(fake), withAutoLogout(false)).portal()
+	result := portalHelper.DoLogin(context.Background())
+	if result.Success() || result.StatusCode != 503 || fake.OnlineCount != 1 {
+		t.Errorf("Error sending online request once: %+v", result)
+	}
+
+	// Test 1: Next login succeeds
+	result = portalHelper.DoLogin(context.Background())
+	if !result.Success() || fake.OnlineCount != 2 {
+		t.Errorf("Error logging in after transient failure: %+v", result)
+	}
+
+	// Test 2: Login errors returned by the portal are never retried
+	for _, loginError := range []*http.OnlineResponse{
+		{ErrorCode: 81, Description: "invalid username or password"},
+		{ErrorCode: 81, Description: "You account has been suspended"},
+	} {
+		fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+		fake.OnlineError = loginError
+		portalHelper = newFixture(t, withFake(fake), withAutoLogout(false)).portal()
+		result = portalHelper.DoLogin(context.Background())
+		if result.Success() || fake.OnlineCount != 1 {
+			t.Errorf("Error retrying login error [%s]: %+v", loginError.Description, result)
+		}
+	}
+
+}
+
+func TestFakePortalFailover(t *testing.T) {
+
+	configure := func(failover ...string) func(configHelper *basic.ConfigHelper) {
+		return func(configHelper *basic.ConfigHelper) {
+			onlineSettings := &configHelper.UserSettings.UserOnlineSettings
+			onlineSettings.Profiles = []basic.UserProfile{
+				{Name: "backup", AuthData: basic.UserAuthData{Domain: "xjtu", Username: "lisi", Password: "abc"}},
+				{Name: "spare", AuthData: basic.UserAuthData{Domain: "xjtu", Username: "wangwu", Password: "def"}},
+			}
+			onlineSettings.Failover = failover
+		}
+	}
+	suspended := &http.OnlineResponse{ErrorCode: 81, Description: "You account has been suspended"}
+	frozen := &http.OnlineResponse{ErrorCode: 81, Description: "You account has been froze, please contact service support"}
+
+	// Test 0: Fail over to the next profiles in order on account errors
+	fake := httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.Account = "zhangsan@xjtu"
+	fake.AccountErrors = map[string]*http.OnlineResponse{"zhangsan@xjtu": suspended, "lisi@xjtu": frozen}
+	portalHelper := newFixture(t, withFake(fake), withConfig(configure("backup", "spare"))).portal()
+	result := portalHelper.DoLogin(context.Background())
+	if !result.Success() || result.Profile != "spare" || fake.Account != "wangwu@xjtu" || fake.OnlineCount != 3 {
+		t.Errorf("Error failing over to other profiles: %+v", result)
+	}
+
+	// Test 1: No failover without failover order
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.Account = "zhangsan@xjtu"
+	fake.AccountErrors = map[string]*http.OnlineResponse{"zhangsan@xjtu": suspended}
+	portalHelper = newFixture(t, withFake(fake), withConfig(configure())).portal()
+	result = portalHelper.DoLogin(context.Background())
+	if result.Success() || result.StatusCode != basic.AccountSuspended || result.Profile != basic.DefaultProfile {
+		t.Errorf("Error logging in without failover: %+v", result)
+	}
+
+	// Test 2: No failover on errors not specific to the account
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	fake.OnlineError = &http.OnlineResponse{ErrorCode: 81, Description: "the account can only be used in student zone"}
+	portalHelper = newFixture(t, withFake(fake), withConfig(configure("backup"))).portal()
+	result = portalHelper.DoLogin(context.Background())
+	if result.Success() || result.StatusCode != 43 || fake.OnlineCount != 1 {
+		t.Errorf("Error failing over on zone error: %+v", result)
+	}
+
+	// Test 3: Fail over when sessions are overloaded and auto logout is disabled
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 1)
+	fake.AddSession(fakeKnownMac, "10.181.0.1")
+	portalHelper = newFixture(t, withFake(fake), withConfig(func(configHelper *basic.ConfigHelper) {
+		configure("backup")(configHelper)
+		configHelper.UserSettings.UserAppSettings.UserPortalSettings.IsAutoLogout = false
+	})).portal()
+	result = portalHelper.DoLogin(context.Background())
+	if result.Profile != "backup" || fake.Account != "lisi@xjtu" {
+		t.Errorf("Error failing over on session overload: %+v", result)
+	}
+
+	// Test 4: Select profile directly
+	fake = httpfake.InitFakePortalBackend(fakeLocalMac, fakeLocalIp, 2)
+	portalHelper = newFixture(t, withFake(fake), withConfig(configure())).portal()
+	if err := portalHelper.UseProfile("unknown"); err == nil {
+		t.Error("Error rejecting unknown profile")
+	}
+	if err := portalHelper.UseProfile("spare"); err != nil || fake.Account != "wangwu@xjtu" {
+		t.Errorf("Error selecting profile: %v", err)
+	}
+	if result = portalHelper.DoLogin(context.Background()); !result.Success() || result.Profile != "spare" {
+		t.Errorf("Error logging in with selected profile: %+v", result)
+	}
+
+}
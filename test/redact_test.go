@@ -0,0 +1,48 @@
+package test
+
+import (
+	"strings"
+	"testing"
+	"xjtuportal/component/basic"
+)
+
+func TestRedactor(t *testing.T) {
+
+	// Test 0: Default settings mask passwords, authorization headers and tokens
+	redactor := basic.InitRedactor(nil)
+	secretTests := map[string]string{
+		`{"deviceType":"PC","webAuthUser":"3120123456@xjtu","webAuthPassword":"zhangsan123456"}`: "zhangsan123456",
+		`{"statusCode":200,"error":0,"token":"eyJhbGciOiJIUzI1NiJ9.abc"}`:                         "eyJhbGciOiJIUzI1NiJ9.abc",
+		`Header:map[Authorization:[eyJhbGciOiJIUzI1NiJ9.abc] Accept:[*/*]]`:                        "eyJhbGciOiJIUzI1NiJ9.abc",
+		`Header:map[Set-Cookie:[token=eyJhbGciOiJIUzI1NiJ9.abc; Path=/]]`:                          "eyJhbGciOiJIUzI1NiJ9.abc",
+		`http/online: Successfully get token: [eyJhbGciOiJIUzI1NiJ9.abc]`:                          "eyJhbGciOiJIUzI1NiJ9.abc",
+	}
+	for record, secret := range secretTests {
+		redacted := redactor.Redact(record)
+		if strings.Contains(redacted, secret) {
+			t.Error("Secret is not masked: " + redacted)
+		}
+	}
+
+	// Test 1: MAC and IP addresses are kept by default
+	record := "MAC = aa:bb:cc:dd:ee:ff, IP = 10.181.0.100"
+	if redactor.Redact(record) != record {
+		t.Error("MAC and IP addresses should not be masked by default")
+	}
+
+	// Test 2: Mask MAC and IP addresses, keep passwords
+	enabled, disabled := true, false
+	redactor = basic.InitRedactor(&basic.UserRedactSettings{
+		Password: &disabled,
+		Mac:      &enabled,
+		Ip:       &enabled,
+	})
+	if redacted := redactor.Redact(record); redacted != "MAC = aa:bb:cc:**:**:**, IP = 10.181.*.*" {
+		t.Error("Error masking MAC and IP addresses: " + redacted)
+	}
+	record = `{"webAuthPassword":"zhangsan123456"}`
+	if redactor.Redact(record) != record {
+		t.Error("Password should not be masked when disabled")
+	}
+
+}
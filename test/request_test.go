@@ -0,0 +1,240 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"xjtuportal/component/basic"
+	xhttp "xjtuportal/component/http"
+)
+
+// startCountingServer starts a local server counting the TCP connections accepted
+func startCountingServer() (server *httptest.Server, connections *int64) {
+	connections = new(int64)
+	server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/set-cookie" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/"})
+		}
+		if r.URL.Path == "/slow" {
+			time.Sleep(2 * time.Second)
+		}
+		cookie, err := r.Cookie("session")
+		if err == nil {
+			_, _ = fmt.Fprintf(w, "session=%s", cookie.Value)
+		}
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(connections, 1)
+		}
+	}
+	server.Start()
+	return server, connections
+}
+
+func TestRequestHelper(t *testing.T) {
+
+	server, connections := startCountingServer()
+	defer server.Close()
+	requestHelper := newFixture(t, withLogLevel(basic.FATAL), withRequest(1, basic.ProgramRetrySettings{Attempts: 1})).requestHelper()
+
+	// Test 0: Connections are reused between requests
+	for i := 0; i < 10; i++ {
+		if _, _, statusCode, err := requestHelper.SendRequest(context.Background(), server.URL, "GET", nil, nil, nil); err != nil || statusCode != 200 {
+			t.Fatalf("Error sending request: %v", err)
+		}
+	}
+	if atomic.LoadInt64(connections) != 1 {
+		t.Errorf("Error reusing connection, %d connections opened", atomic.LoadInt64(connections))
+	}
+
+	// Test 1: Cookies set by server are kept in cookie jar
+	_, _, _, _ = requestHelper.SendRequest(context.Background(), server.URL+"/set-cookie", "GET", nil, nil, nil)
+	_, body, _, err := requestHelper.SendRequest(context.Background(), server.URL+"/other", "GET", nil, nil, nil)
+	if err != nil || string(body) != "session=abc" {
+		t.Errorf("Error keeping cookies: %s %v", body, err)
+	}
+
+	// Test 2: Given cookies replace the cookies with the same name
+	_, body, _, err = requestHelper.SendRequest(context.Background(), server.URL, "GET", nil, nil, []*http.Cookie{{Name: "session", Value: "def"}})
+	if err != nil || string(body) != "session=def" {
+		t.Errorf("Error replacing cookies: %s %v", body, err)
+	}
+
+	// Test 3: Request is cancelled after deadline
+	start := time.Now()
+	if _, _, _, err = requestHelper.SendRequest(context.Background(), server.URL+"/slow", "GET", nil, nil, nil); err == nil {
+		t.Error("Error cancelling request after deadline")
+	}
+	if time.Since(start) >= 2*time.Second {
+		t.Error("Error applying request deadline")
+	}
+
+}
+
+func TestRequestRetry(t *testing.T) {
+
+	hits := make(map[string]int)
+	mutex := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		hits[r.URL.Path]++
+		hit := hits[r.URL.Path]
+		mutex.Unlock()
+		switch r.URL.Path {
+		case "/flaky":
+			if hit <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			_, _ = w.Write(data)
+		case "/reset":
+			if hit == 1 {
+				conn, _, _ := w.(http.Hijacker).Hijack()
+				_ = conn.Close()
+				return
+			}
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/down":
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	requestHelper := newFixture(t, withLogLevel(basic.FATAL),
+		withRequest(1, basic.ProgramRetrySettings{Attempts: 3, Backoff: 10, MaxBackoff: 40, Jitter: 0.2})).requestHelper()
+
+	// Test 0: POST is sent once by default
+	_, _, statusCode, err := requestHelper.SendRequest(context.Background(), server.URL+"/flaky", "POST",
+		strings.NewReader("data"), nil, nil)
+	if err == nil || statusCode != 503 || hits["/flaky"] != 1 {
+		t.Errorf("Error sending POST request once: %d %v", statusCode, err)
+	}
+
+	// Test 1: 5xx responses of GET requests are retried
+	_, _, statusCode, err = requestHelper.SendRequest(context.Background(), server.URL+"/flaky", "GET", nil, nil, nil)
+	if err != nil || statusCode != 200 || hits["/flaky"] != 3 {
+		t.Errorf("Error retrying 5xx response: %d %v", statusCode, err)
+	}
+
+	// Test 2: Connection reset is retried
+	if _, _, _, err = requestHelper.SendRequest(context.Background(), server.URL+"/reset", "GET", nil, nil, nil); err != nil {
+		t.Errorf("Error retrying connection reset: %v", err)
+	}
+
+	// Test 3: 4xx responses are not retried
+	if _, _, statusCode, err = requestHelper.SendRequest(context.Background(), server.URL+"/missing", "GET", nil, nil, nil); err == nil ||
+		statusCode != 404 || hits["/missing"] != 1 {
+		t.Errorf("Error retrying 4xx response: %d %v", statusCode, err)
+	}
+
+	// Test 4: Give up after all attempts
+	if _, _, statusCode, err = requestHelper.SendRequest(context.Background(), server.URL+"/down", "GET", nil, nil, nil); err == nil ||
+		statusCode != 502 || hits["/down"] != 3 {
+		t.Errorf("Error giving up after all attempts: %d %v", statusCode, err)
+	}
+
+	// Test 5: POST is retried if no connection is made, with request data sent again
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+	refusedServer := httptest.NewUnstartedServer(server.Config.Handler)
+	started := make(chan bool, 1)
+	go func() {
+		time.Sleep(5 * time.Millisecond) // The first attempt is refused, the server is up before the retry
+		listener, err := net.Listen("tcp", address)
+		if err == nil {
+			refusedServer.Listener = listener
+			refusedServer.Start()
+		}
+		started <- err == nil
+	}()
+	_, body, statusCode, err := requestHelper.SendRequest(context.Background(), "http://"+address+"/flaky", "POST",
+		strings.NewReader("data"), nil, nil)
+	if err != nil || statusCode != 200 || string(body) != "data" || hits["/flaky"] != 4 {
+		t.Errorf("Error retrying POST request failed to connect: %d %s %v", statusCode, body, err)
+	}
+	if <-started {
+		refusedServer.Close()
+	}
+
+	// Test 6: Stop waiting for retry when ctx is done
+	requestHelper = newFixture(t, withLogLevel(basic.FATAL), withRequest(1, basic.ProgramRetrySettings{Attempts: 3, Backoff: 5000})).requestHelper()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, _, err = requestHelper.SendRequest(ctx, server.URL+"/down", "GET", nil, nil, nil); err == nil || time.Since(start) > time.Second {
+		t.Errorf("Error stopping retry when ctx is done: %v", err)
+	}
+
+}
+
+func TestRetryBackoff(t *testing.T) {
+
+	_, loggerHelper, err := readConfig()
+	if err != nil {
+		t.Fatal("Initialization ConfigHelper & LoggerHelper failed")
+	}
+	retryPolicy, err := xhttp.InitRetryPolicy(loggerHelper, &basic.ProgramRetrySettings{Attempts: 5, Backoff: 100, MaxBackoff: 400, Jitter: 0.5})
+	if err != nil {
+		t.Fatal("Initialization RetryPolicy failed")
+	}
+
+	cases := []struct {
+		retry int
+		min   time.Duration
+	}{{1, 100 * time.Millisecond}, {2, 200 * time.Millisecond}, {3, 400 * time.Millisecond}, {10, 400 * time.Millisecond}}
+	for _, c := range cases {
+		if backoff := retryPolicy.Backoff(c.retry); backoff < c.min || backoff > c.min*3/2 {
+			t.Errorf("Error calculating backoff of retry %d: %v", c.retry, backoff)
+		}
+	}
+
+}
+
+// BenchmarkSharedClient sends requests with the long-lived client of RequestHelper
+func BenchmarkSharedClient(b *testing.B) {
+	server, connections := startCountingServer()
+	defer server.Close()
+	requestHelper := newFixture(b, withLogLevel(basic.FATAL), withRequest(0, basic.ProgramRetrySettings{Attempts: 1})).requestHelper()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := requestHelper.SendRequest(context.Background(), server.URL, "GET", nil, nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(connections))/float64(b.N), "conns/op")
+}
+
+// BenchmarkClientPerRequest sends requests with a new client per request as RequestHelper used to do
+func BenchmarkClientPerRequest(b *testing.B) {
+	server, connections := startCountingServer()
+	defer server.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		transport := &http.Transport{Proxy: nil, DialContext: (&net.Dialer{Timeout: 5 * time.Second}).DialContext}
+		client := &http.Client{Transport: transport}
+		response, err := client.Get(server.URL)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, _ = ioutil.ReadAll(response.Body)
+		_ = response.Body.Close()
+		transport.CloseIdleConnections()
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(connections))/float64(b.N), "conns/op")
+}
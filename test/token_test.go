@@ -0,0 +1,148 @@
+package test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"xjtuportal/component/fakeportal"
+	"xjtuportal/component/http"
+)
+
+func TestTokenCache(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "token.json")
+
+	// Test 0: Token is cached until it expires
+	tokenCache := newFixture(t, withUsername("3120123456"), withToken(1, "")).tokenCache()
+	if _, ok := tokenCache.Get(); ok {
+		t.Error("Error getting token from empty cache")
+	}
+	tokenCache.Set("token-0", time.Time{})
+	if token, ok := tokenCache.Get(); !ok || token != "token-0" {
+		t.Error("Error getting cached token")
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, ok := tokenCache.Get(); ok {
+		t.Error("Error expiring cached token")
+	}
+
+	// Test 1: Token is shared through the state file
+	tokenCache = newFixture(t, withUsername("3120123456"), withToken(60, stateFile)).tokenCache()
+	tokenCache.Set("token-1", time.Time{})
+	if info, err := os.Stat(stateFile); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Error writing state file: %v", err)
+	}
+	if token, ok := newFixture(t, withUsername("3120123456"), withToken(60, stateFile)).tokenCache().Get(); !ok || token != "token-1" {
+		t.Error("Error reusing token in state file")
+	}
+
+	// Test 2: Token of another account is ignored
+	if _, ok := newFixture(t, withUsername("3120654321"), withToken(60, stateFile)).tokenCache().Get(); ok {
+		t.Error("Error ignoring token of another account")
+	}
+
+	// Test 3: Invalidated token is removed from the state file
+	tokenCache.Invalidate()
+	if _, ok := newFixture(t, withUsername("3120123456"), withToken(60, stateFile)).tokenCache().Get(); ok {
+		t.Error("Error invalidating token in state file")
+	}
+
+	// Test 4: Broken state file is ignored
+	if err = ioutil.WriteFile(stateFile, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := newFixture(t, withUsername("3120123456"), withToken(60, stateFile)).tokenCache().Get(); ok {
+		t.Error("Error ignoring broken state file")
+	}
+
+	// Test 5: Token expires by the time the portal created it
+	tokenCache = newFixture(t, withUsername("3120123456"), withToken(60, stateFile)).tokenCache()
+	tokenCache.Set("token-5", time.Now().Add(-2*time.Minute))
+	if _, ok := tokenCache.Get(); ok {
+		t.Error("Error expiring token created by the portal before lifetime")
+	}
+	tokenCache.Set("token-5", time.Now().Add(time.Hour))
+	if token, ok := tokenCache.Get(); !ok || token != "token-5" {
+		t.Error("Error caching token created ahead of the local clock")
+	}
+
+	// Test 6: Creation time of the portal is given in seconds or milliseconds
+	createdAt := time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC)
+	for _, createdTs := range []int64{createdAt.Unix(), createdAt.UnixNano() / int64(time.Millisecond)} {
+		response := &http.OnlineResponse{CreatedTs: createdTs}
+		if !response.CreatedTime().Equal(createdAt) {
+			t.Errorf("Error parsing createdAt %d: %v", createdTs, response.CreatedTime())
+		}
+	}
+	if !(&http.OnlineResponse{}).CreatedTime().IsZero() {
+		t.Error("Error parsing missing createdAt")
+	}
+
+}
+
+func TestFakePortalServerToken(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stateFile := filepath.Join(dir, "token.json")
+
+	fakePortal := newFakePortal(3)
+	fakePortal.Backend.AddSession(fakeKnownMac, "10.181.0.1")
+	unknownId := fakePortal.Backend.AddSession(fakeUnknownMac, "10.181.0.2")
+	server := fakeportal.StartServer(fakePortal)
+	defer server.Close()
+
+	// Test 0: Token is requested once for listing and logging out sessions
+	sessionListHelper := newFixture(t, withServer(server.URL), withToken(0, stateFile)).sessionList()
+	if _, err = sessionListHelper.InitSessionListByPortal(context.Background()); err != nil {
+		t.Fatalf("Error listing sessions: %v", err)
+	}
+	if _, err = sessionListHelper.LogoutDelete(context.Background(), unknownId); err != nil {
+		t.Errorf("Error logging out session: %v", err)
+	}
+	if fakePortal.TokenCount() != 1 {
+		t.Errorf("Error reusing token, %d tokens issued", fakePortal.TokenCount())
+	}
+
+	// Test 1: Token in state file is reused by the next invocation
+	sessionListHelper = newFixture(t, withServer(server.URL), withToken(0, stateFile)).sessionList()
+	if _, err = sessionListHelper.InitSessionListByPortal(context.Background()); err != nil {
+		t.Fatalf("Error listing sessions: %v", err)
+	}
+	if fakePortal.TokenCount() != 1 {
+		t.Errorf("Error reusing token in state file, %d tokens issued", fakePortal.TokenCount())
+	}
+
+	// Test 2: Authenticate again transparently after the token is rejected
+	fakePortal.RevokeTokens()
+	if _, err = sessionListHelper.InitSessionListByPortal(context.Background()); err != nil {
+		t.Errorf("Error authenticating again after token is rejected: %v", err)
+	}
+	if fakePortal.TokenCount() != 2 {
+		t.Errorf("Error refreshing token, %d tokens issued", fakePortal.TokenCount())
+	}
+
+	// Test 3: Token created by the portal before lifetime is not reused
+	fakePortal.SetTokenAge(time.Hour)
+	fakePortal.RevokeTokens()
+	for i := 0; i < 2; i++ {
+		if _, err = sessionListHelper.InitSessionListByPortal(context.Background()); err != nil {
+			t.Errorf("Error listing sessions: %v", err)
+		}
+	}
+	if fakePortal.TokenCount() != 4 {
+		t.Errorf("Error expiring token by the portal time, %d tokens issued", fakePortal.TokenCount())
+	}
+
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xjtuportal/component/basic"
+)
+
+func writeConfigFile(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func findIssue(issues []*basic.ConfigIssue, path string) *basic.ConfigIssue {
+	for _, issue := range issues {
+		if issue.Path == path {
+			return issue
+		}
+	}
+	return nil
+}
+
+func TestValidateConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "xjtuportal-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Test 0: Config files in repository are valid
+	issues, err := basic.ValidateConfig("../config/user-settings.yaml", "../config/program-settings.yaml")
+	if err != nil || len(issues) != 0 {
+		t.Errorf("Error validating config files in repository: %v %v", issues, err)
+	}
+	issues, err = basic.ValidateConfig("../config/user-settings.full.yaml", "../config/program-settings.yaml")
+	if err != nil || len(issues) != 0 {
+		t.Errorf("Error validating full user settings: %v %v", issues, err)
+	}
+
+	// Test 1: Unknown and misplaced fields are reported with line and suggestion, old layouts are migrated in
+	// files without version, so version is given here
+	userSettingsFile := writeConfigFile(t, dir, basic.UserConfigFile, `version: 2
+online:
+    auth_data:
+        domain: xjtu
+        username: zhangsan
+        pasword: "123456789"
+session:
+    portal:
+        auto_logout: true
+`)
+	issues, err = basic.ValidateConfig(userSettingsFile, "../config/program-settings.yaml")
+	if err != nil || len(issues) != 2 {
+		t.Fatalf("Error reporting unknown fields: %v %v", issues, err)
+	}
+	if issue := findIssue(issues, "online.auth_data.pasword"); issue == nil || issue.Line != 6 ||
+		!strings.Contains(issue.Suggestion, "[password]") {
+		t.Errorf("Error reporting misspelled field: %v", issue)
+	}
+	if issue := findIssue(issues, "session"); issue == nil || issue.Line != 7 || !issue.Warning ||
+		!strings.Contains(issue.Suggestion, "[app.portal.auto_logout]") {
+		t.Errorf("Error warning about field of former versions: %v", issue)
+	}
+	if _, err = basic.InitUserSettings(userSettingsFile); err == nil ||
+		!strings.Contains(err.Error(), userSettingsFile+":6") || strings.Contains(err.Error(), userSettingsFile+":7") {
+		t.Errorf("Error rejecting invalid user settings: %v", err)
+	}
+	content, _ := ioutil.ReadFile(userSettingsFile)
+	writeConfigFile(t, dir, basic.UserConfigFile, strings.Replace(string(content), "pasword", "password", 1))
+	configHelper, err := basic.InitConfigHelper(userSettingsFile, "../config/program-settings.yaml")
+	if err != nil || len(configHelper.Warnings) != 1 || configHelper.Warnings[0].Path != "session" {
+		t.Errorf("Error reading user settings with warnings: %v", err)
+	}
+
+	// Test 2: Invalid values are reported
+	userSettingsFile = writeConfigFile(t, dir, basic.UserConfigFile, `online:
+    auth_data:
+        domain: xjtu
+        username: zhangsan
+        password_source: vualt
+    profiles:
+      - name: backup
+        auth_data: {domain: xjtu, username: lisi}
+    failover: [backup, spare]
+device:
+    known_mac_list: ['11:22:33:44:55', 'aa:bb:cc:dd:ee:ff']
+app:
+    portal:
+        logout_policy:
+            rules: [unknown_mac, newest]
+            cidr_list: [10.181.0.0/33]
+        token:
+            lifetime: -1
+logger:
+    output_writer: [stdout, fil]
+    level: WARN
+ui:
+    mode: command
+    api:
+        listen: 0.0.0.0:8350
+    metrics:
+        listen: 127.0.0.1:99999
+`)
+	issues, err = basic.ValidateConfig(userSettingsFile, "../config/program-settings.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, line := range map[string]int{
+		"online.auth_data.password_source":      5,
+		"online.failover[1]":                    9,
+		"device.known_mac_list[0]":              11,
+		"app.portal.logout_policy.cidr_list[0]": 16,
+		"app.portal.token.lifetime":             18,
+		"logger.output_writer[1]":               20,
+		"logger.level":                          21,
+		"ui.api.listen":                         25,
+		"ui.metrics.listen":                     27,
+	} {
+		if issue := findIssue(issues, path); issue == nil || issue.Line != line || issue.Suggestion == "" {
+			t.Errorf("Error reporting invalid value of [%s]: %v", path, issue)
+		}
+	}
+	if len(issues) != 9 {
+		t.Errorf("Error reporting invalid values: %v", issues)
+	}
+
+	// Test 3: Type errors and invalid program settings are reported
+	programSettings, err := ioutil.ReadFile("../config/program-settings.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	programSettingsFile := writeConfigFile(t, dir, basic.ProgramConfigFile, strings.NewReplacer(
+		`internet: "http://202.108.22.5/"`, `internet: "202.108.22.5"`,
+		"jitter: 0.2", "jitter: 2",
+		"timeout: 10", "timeout: soon",
+	).Replace(string(programSettings)))
+	issues, err = basic.ValidateConfig("../config/user-settings.yaml", programSettingsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if issue := findIssue(issues, "connectivity.http.internet"); issue == nil || issue.Line == 0 {
+		t.Errorf("Error reporting invalid URL: %v", issues)
+	}
+	if issue := findIssue(issues, "request.retry.jitter"); issue == nil {
+		t.Errorf("Error reporting out of range value: %v", issues)
+	}
+	if issue := findIssue(issues, "request.timeout"); issue == nil || issue.Line == 0 || !strings.Contains(issue.Message, "cannot unmarshal") {
+		t.Errorf("Error reporting type error: %v", issues)
+	}
+
+	// Test 4: Missing file is an error rather than an issue
+	if _, err = basic.ValidateConfig(filepath.Join(dir, "missing.yaml"), programSettingsFile); err == nil {
+		t.Error("Error reporting missing config file")
+	}
+
+}
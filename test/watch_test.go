@@ -0,0 +1,102 @@
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+	"xjtuportal/component/basic"
+	"xjtuportal/component/http"
+)
+
+func TestConfigWatcher(t *testing.T) {
+
+	configHelper, loggerHelper, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "xjtuportal-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	configHelper.ConfigDir = dir
+	configHelper.ProgramSettings.ProgramAppSettings.ProgramReloadSettings.PollInterval = 1
+	configHelper.ProgramSettings.ProgramAppSettings.ProgramReloadSettings.Debounce = 100
+	configWatcher, err := basic.InitConfigWatcher(configHelper, loggerHelper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configWatcher.Start()
+	defer configWatcher.Stop()
+	time.Sleep(100 * time.Millisecond)
+
+	// Test 0: Changes of other files are ignored
+	writeConfigFile(t, dir, "other.yaml", "a: 1\n")
+	select {
+	case <-configWatcher.Changes():
+		t.Error("Change of other file is notified")
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	// Test 1: Writes in a row are notified once
+	for index := 0; index < 3; index++ {
+		writeConfigFile(t, dir, basic.UserConfigFile, "ui:\n    mode: command\n"+string(rune('a'+index))+": 1\n")
+	}
+	select {
+	case <-configWatcher.Changes():
+	case <-time.After(3 * time.Second):
+		t.Fatal("Change of user settings is not notified")
+	}
+	select {
+	case <-configWatcher.Changes():
+		t.Error("Writes in a row are notified more than once")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	// Test 2: Creating program settings is notified
+	writeConfigFile(t, dir, basic.ProgramConfigFile, "request:\n  timeout: 20\n")
+	select {
+	case <-configWatcher.Changes():
+	case <-time.After(3 * time.Second):
+		t.Fatal("Creation of program settings is not notified")
+	}
+}
+
+func TestReloadSharedModules(t *testing.T) {
+
+	configHelper, loggerHelper, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestHelper, err := http.InitRequestHelper(configHelper, loggerHelper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newConfigHelper, _, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Test 0: Logger and request helper are kept if their settings are not changed
+	newConfigHelper.UserSettings.UserOnlineSettings.AuthData.Username = "lisi"
+	if !loggerHelper.SameSettings(newConfigHelper) || !requestHelper.SameSettings(newConfigHelper) {
+		t.Error("Error keeping logger and request helper with the same settings")
+	}
+
+	// Test 1: Changed settings are detected
+	newConfigHelper.UserSettings.UserLoggerSettings.Level = "DEBUG"
+	newConfigHelper.ProgramSettings.ProgramRequestSettings.Timeout++
+	if loggerHelper.SameSettings(newConfigHelper) || requestHelper.SameSettings(newConfigHelper) {
+		t.Error("Error detecting changed settings of logger and request helper")
+	}
+
+	// Test 2: Log file of the replaced logger is closed
+	if err = loggerHelper.Close(); err != nil {
+		t.Errorf("Error closing log file: %v", err)
+	}
+	if err = loggerHelper.Close(); err == nil {
+		t.Error("Log file is not closed")
+	}
+}